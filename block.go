@@ -0,0 +1,279 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+
+/* Key types a client can block on, same key name is a different key in every type map */
+const (
+	blockZset = iota
+	blockList
+	blockStream
+)
+
+
+/*
+  Blocking operations (BZPOPMIN ...)
+
+  blockedEntry map[type key] value is FIFO queue of clients blocked on the key
+  blockedLock is lock on blockedEntry and on done of every blockedClient
+
+  A blocked client registers a serve function, which performs the operation
+  on behalf of the client (ie, the pop) and returns the reply. The routine
+  making a key ready (ie, ZADD) calls signalKeyReady after releasing its data
+  locks, which runs serve of the blocked clients in FIFO order and hands the
+  reply over to the blocked client on its reply channel.
+
+  readyKeys is the FIFO of keys signaled ready and not yet served, a serve
+  function pushing to a key (ie, BLMOVE) marks that key ready as well.
+
+  Synchronization
+  1. serve is run with blockedLock held, so a client is served at most once
+  2. blockOn tries serve once with blockedLock held before queueing the client,
+     so a push between the first try of the client and the queueing is not lost
+  3. Lock order is blockedLock followed by data locks, data locks are never
+     held while taking blockedLock
+  4. While EXEC runs a transaction, ready keys are only queued and get served
+     once the transaction is done, blocked clients never see a partial transaction
+*/
+
+type blockingKey struct {
+	keyType int
+	key string
+}
+
+type blockedClient struct {
+	keys []blockingKey
+	serve func() ([]string, bool)
+	reply chan []string
+	done bool
+}
+
+
+/* Runs serve once, queues the client on keys if nothing got served */
+func (store *db) blockOn(keyType int, keys []string, serve func() ([]string, bool)) (*blockedClient, []string, bool) {
+	store.blockedLock.Lock()
+	defer store.blockedLock.Unlock()
+
+	reply, ok := serve()
+	if ok == true {
+		store.serveReadyKeys()
+		return nil, reply, true
+	}
+
+	w := &blockedClient{
+		serve: serve,
+		reply: make(chan []string, 1),
+	}
+
+	for key := range uniqueKeys(keys) {
+		bkey := blockingKey{keyType: keyType, key: key}
+		w.keys = append(w.keys, bkey)
+		store.blockedEntry[bkey] = append(store.blockedEntry[bkey], w)
+	}
+
+	return w, nil, false
+}
+
+
+/* Removes the client from the blocked queues, returns the reply if it got served in the meantime */
+func (store *db) unblock(w *blockedClient) ([]string, bool) {
+	store.blockedLock.Lock()
+	defer store.blockedLock.Unlock()
+
+	if w.done == true {
+		return <-w.reply, true
+	}
+
+	w.done = true
+	store.removeBlocked(w)
+
+	return nil, false
+}
+
+
+/* Removes the client from all its key queues, caller holds blockedLock */
+func (store *db) removeBlocked(w *blockedClient) {
+	for _, bkey := range w.keys {
+		queue := store.blockedEntry[bkey]
+
+		for i, waiter := range queue {
+			if waiter == w {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+
+		if len(queue) == 0 {
+			delete(store.blockedEntry, bkey)
+		} else {
+			store.blockedEntry[bkey] = queue
+		}
+	}
+}
+
+
+/* Mark key ready and serve the clients blocked on it */
+func (store *db) signalKeyReady(keyType int, key string) {
+	if store == nil {
+		return
+	}
+
+	store.blockedLock.Lock()
+	defer store.blockedLock.Unlock()
+
+	store.markKeyReady(keyType, key)
+	store.serveReadyKeys()
+}
+
+
+/* Queue key as ready to serve, caller holds blockedLock */
+func (store *db) markKeyReady(keyType int, key string) {
+	store.readyKeys = append(store.readyKeys, blockingKey{keyType: keyType, key: key})
+}
+
+
+/*
+  Serve the clients blocked on every ready key in FIFO order. A pop stops at the
+  first client which can not be served, the key is empty. A stream read consumes
+  nothing, so a client reading past the new entries does not hold up the ones
+  after it. Caller holds blockedLock
+*/
+func (store *db) serveReadyKeys() {
+	/* Inside EXEC the blocked clients are served once the transaction is done */
+	if store.execRunning == true {
+		return
+	}
+
+	for len(store.readyKeys) > 0 {
+		bkey := store.readyKeys[0]
+		store.readyKeys = store.readyKeys[1:]
+
+		if bkey.keyType == blockStream {
+			/* Copy of the queue, serving a client removes it from the queue */
+			for _, w := range append([]*blockedClient{}, store.blockedEntry[bkey]...) {
+				if reply, ok := w.serve(); ok == true {
+					store.serveBlocked(w, reply)
+				}
+			}
+			continue
+		}
+
+		for len(store.blockedEntry[bkey]) > 0 {
+			w := store.blockedEntry[bkey][0]
+
+			reply, ok := w.serve()
+			if ok == false {
+				break
+			}
+
+			store.serveBlocked(w, reply)
+		}
+	}
+}
+
+
+/* Hand the reply over to the client and remove it from its key queues, caller holds blockedLock */
+func (store *db) serveBlocked(w *blockedClient, reply []string) {
+	w.done = true
+	store.removeBlocked(w)
+	w.reply <- reply
+}
+
+
+/* Parse blocking timeout in seconds, decimal allowed, 0 is block forever */
+func parseBlockTimeout(s string) (time.Duration, error) {
+	timeout, err := strconv.ParseFloat(s, 64)
+
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return 0, errors.New("timeout is not a float or out of range")
+	}
+
+	if timeout < 0 {
+		return 0, errors.New("timeout is negative")
+	}
+
+	return time.Duration(timeout * float64(time.Second)), nil
+}
+
+
+/*
+  Block the client on keys until served or timeout, timeout 0 is block forever
+  Returns the reply and true if served, false on timeout or connection close
+*/
+func (client *client) block(keyType int, keys []string, timeout time.Duration, serve func() ([]string, bool)) ([]string, bool) {
+	/* Inside a transaction the command never blocks, it behaves as timed out if nothing to serve */
+	if client.inExec == true {
+		client.store.blockedLock.Lock()
+		defer client.store.blockedLock.Unlock()
+		return serve()
+	}
+
+	w, reply, ok := client.store.blockOn(keyType, keys, serve)
+	if ok == true {
+		return reply, true
+	}
+
+	/* Release the command read lock while blocked, so EXEC of other clients is not held up */
+	client.store.execLock.RUnlock()
+	defer client.store.execLock.RLock()
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	/* Watch the connection while blocked, a closed connection unblocks the client */
+	watch := make(chan error, 1)
+	go func() {
+		_, err := client.reader.Peek(1)
+		watch <- err
+	}()
+
+	watching := true
+	closed := false
+
+	for {
+		select {
+		case reply = <-w.reply:
+			ok = true
+		case <-timer:
+			reply, ok = client.store.unblock(w)
+		case err := <-watch:
+			watching = false
+			if err == nil {
+				/* Next command already arrived, it is read once this one is served */
+				continue
+			}
+			closed = true
+			reply, ok = client.store.unblock(w)
+		}
+		break
+	}
+
+	/* Stop watching the connection, Peek returns on the read deadline */
+	if watching == true {
+		client.conn.SetReadDeadline(time.Now())
+		<-watch
+		client.conn.SetReadDeadline(time.Time{})
+	}
+
+	if closed == true {
+		client.log("Disconnected while blocked")
+		return nil, false
+	}
+
+	return reply, ok
+}
+
@@ -0,0 +1,307 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+
+/*
+  Scalable bloom filter, same commands as the redis bloom module
+
+  A filter is a list of bloom filter layers. Items are added to the last
+  layer, once it holds capacity items a new layer with expansion times the
+  capacity and half the error rate is added, so the error rates of all the
+  layers add up to at most twice the asked error rate. A non scaling filter
+  fails when full.
+
+  An item is hashed once, the k bit positions are h1 + i*h2 (double hashing).
+*/
+
+const (
+	// Defaults of a filter created by BF.ADD
+	bloomDefaultErrorRate = 0.01
+	bloomDefaultCapacity = 100
+	bloomDefaultExpansion = 2
+
+	// Error rate of every new layer is this ratio of the previous layer
+	bloomErrorTightening = 0.5
+
+	// Max capacity of BF.RESERVE and max bits of a layer, a layer takes at most 1GB
+	bloomMaxCapacity = 1 << 30
+	bloomMaxBits = 1 << 33
+)
+
+type bloomLayer struct {
+	bits []byte
+	nbits uint64
+	hashes int
+	capacity int64
+	count int64
+}
+
+type bloomFilter struct {
+	layers []*bloomLayer
+	errorRate float64
+	expansion int64
+	nonScaling bool
+}
+
+type bfmapData struct {
+	bf *bloomFilter
+	lock *sync.RWMutex
+}
+
+
+/* Murmur3 finalizer, the low bits of fnv are poorly mixed */
+func hashMix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+
+/* Two 64 bit hashes of item */
+func bloomHash(item string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(item))
+	sum := h.Sum(nil)
+
+	/* Odd h2, so the positions cover the layer */
+	return hashMix(binary.BigEndian.Uint64(sum[:8])), hashMix(binary.BigEndian.Uint64(sum[8:])) | 1
+}
+
+
+/* Bits per item of the error rate, -ln(p) / ln(2)^2 */
+func bloomBitsPerItem(errorRate float64) float64 {
+	return -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+}
+
+
+/* Returns true if a layer for capacity items with the error rate is at most bloomMaxBits */
+func bloomLayerFits(capacity int64, errorRate float64) bool {
+	return capacity <= bloomMaxCapacity && float64(capacity) * bloomBitsPerItem(errorRate) <= bloomMaxBits
+}
+
+
+/* Layer for capacity items with the error rate */
+func newBloomLayer(capacity int64, errorRate float64) *bloomLayer {
+	bpe := bloomBitsPerItem(errorRate)
+
+	nbits := uint64(math.Ceil(float64(capacity) * bpe))
+	if nbits < 64 {
+		nbits = 64
+	}
+
+	return &bloomLayer{
+		bits: make([]byte, (nbits + 7) / 8),
+		nbits: nbits,
+		hashes: int(math.Ceil(math.Ln2 * bpe)),
+		capacity: capacity,
+	}
+}
+
+
+func (layer *bloomLayer) contains(h1 uint64, h2 uint64) bool {
+	for i := 0; i < layer.hashes; i++ {
+		pos := (h1 + uint64(i) * h2) % layer.nbits
+		if layer.bits[pos / 8] & (1 << (pos % 8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+
+func (layer *bloomLayer) add(h1 uint64, h2 uint64) {
+	for i := 0; i < layer.hashes; i++ {
+		pos := (h1 + uint64(i) * h2) % layer.nbits
+		layer.bits[pos / 8] |= 1 << (pos % 8)
+	}
+	layer.count++
+}
+
+
+func newBloomFilter(errorRate float64, capacity int64, expansion int64, nonScaling bool) *bloomFilter {
+	return &bloomFilter{
+		layers: []*bloomLayer{newBloomLayer(capacity, errorRate)},
+		errorRate: errorRate,
+		expansion: expansion,
+		nonScaling: nonScaling,
+	}
+}
+
+
+func (bf *bloomFilter) contains(item string) bool {
+	h1, h2 := bloomHash(item)
+
+	for _, layer := range bf.layers {
+		if layer.contains(h1, h2) == true {
+			return true
+		}
+	}
+	return false
+}
+
+
+/* Add item, returns false if the item may already be in the filter */
+func (bf *bloomFilter) add(item string) (bool, error) {
+	h1, h2 := bloomHash(item)
+
+	for _, layer := range bf.layers {
+		if layer.contains(h1, h2) == true {
+			return false, nil
+		}
+	}
+
+	last := bf.layers[len(bf.layers) - 1]
+
+	if last.count >= last.capacity {
+		if bf.nonScaling == true {
+			return false, errors.New("non scaling filter is full")
+		}
+
+		/* A new layer past the size limit is never allocated, the filter is full */
+		errorRate := bf.errorRate * math.Pow(bloomErrorTightening, float64(len(bf.layers)))
+		if last.capacity > bloomMaxCapacity / bf.expansion || bloomLayerFits(last.capacity * bf.expansion, errorRate) == false {
+			return false, errors.New("filter is full")
+		}
+
+		last = newBloomLayer(last.capacity * bf.expansion, errorRate)
+		bf.layers = append(bf.layers, last)
+	}
+
+	last.add(h1, h2)
+	return true, nil
+}
+
+
+/* Create filter key with the error rate and capacity, fails if the key exists */
+func (store *db) BFRESERVE(key string, errorRate float64, capacity int64, expansion int64, nonScaling bool) error {
+	if store == nil {
+		fmt.Println("BFRESERVE : store is nil")
+		return errors.New(fmt.Sprint("BFRESERVE : store is nil"))
+	}
+
+	/* Take Global write lock, to ensure only one entry gets created */
+	store.bfmapDBLock.Lock()
+	defer store.bfmapDBLock.Unlock()
+
+	if _, ok := store.bfmapEntry[key]; ok == true {
+		return errors.New("item exists")
+	}
+
+	if bloomLayerFits(capacity, errorRate) == false {
+		return errors.New("capacity too large for the error rate")
+	}
+
+	store.bfmapEntry[key] = &bfmapData{
+		bf: newBloomFilter(errorRate, capacity, expansion, nonScaling),
+		lock: &sync.RWMutex{},
+	}
+
+	return nil
+}
+
+
+/* Add items, the key is created with the default error rate and capacity if absent. added is false for the items which may already be in */
+func (store *db) BFADD(key string, items []string) ([]bool, error) {
+	if store == nil {
+		fmt.Println("BFADD : store is nil")
+		return nil, errors.New(fmt.Sprint("BFADD : store is nil"))
+	}
+
+	var entry *bfmapData
+	var ok bool
+
+	store.bfmapDBLock.RLock()
+
+	/* Check if db has the key entry */
+	entry, ok = store.bfmapEntry[key]
+
+	/* If entry not present */
+	if ok == false {
+		/* Take DB lock before creating entry for this key, to ensure only one entry gets created */
+		store.bfmapDBLock.RUnlock()
+		store.bfmapDBLock.Lock()
+
+		/* Again Check if db has the key entry, between above if & db lock it is possible other routine has created this entry */
+		var okrecheck bool
+		entry, okrecheck = store.bfmapEntry[key]
+
+		if okrecheck == false {
+			entry = &bfmapData{
+				bf: newBloomFilter(bloomDefaultErrorRate, bloomDefaultCapacity, bloomDefaultExpansion, false),
+				lock: &sync.RWMutex{},
+			}
+			store.bfmapEntry[key] = entry
+		}
+	}
+
+	/* Take DB entry lock before update, this is lock per key entry */
+	entry.lock.Lock()
+
+	added := make([]bool, len(items))
+	var err error
+
+	for i, item := range items {
+		added[i], err = entry.bf.add(item)
+		if err != nil {
+			break
+		}
+	}
+
+	entry.lock.Unlock()
+
+	/* Both locks got released in LIFO order, entry lock followed by db lock */
+	if ok == false {
+		store.bfmapDBLock.Unlock()
+	} else {
+		store.bfmapDBLock.RUnlock()
+	}
+
+	return added, err
+}
+
+
+/* Check items, found is false for the items surely not added */
+func (store *db) BFEXISTS(key string, items []string) ([]bool, error) {
+	if store == nil {
+		fmt.Println("BFEXISTS : store is nil")
+		return nil, errors.New(fmt.Sprint("BFEXISTS : store is nil"))
+	}
+
+	found := make([]bool, len(items))
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.bfmapDBLock.RLock()
+	defer store.bfmapDBLock.RUnlock()
+
+	entry, ok := store.bfmapEntry[key]
+
+	if ok == false {
+		return found, nil
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	for i, item := range items {
+		found[i] = entry.bf.contains(item)
+	}
+
+	return found, nil
+}
@@ -0,0 +1,159 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+
+/* Items i0 to i(n-1) with prefix */
+func filterItems(prefix string, n int) []string {
+	items := make([]string, n)
+	for i := range items {
+		items[i] = prefix + strconv.Itoa(i)
+	}
+	return items
+}
+
+
+/* Ratio of items found */
+func foundRatio(found []bool) float64 {
+	n := 0
+	for _, f := range found {
+		if f == true {
+			n++
+		}
+	}
+	return float64(n) / float64(len(found))
+}
+
+
+/* A layer too large to allocate is rejected, on reserve and on scaling */
+func TestBloomBounds(t *testing.T) {
+	store := newDB()
+
+	if err := store.BFRESERVE("bf", 1e-300, bloomMaxCapacity, 2, false); err == nil {
+		t.Fatalf("BF.RESERVE accepted %v bits", float64(bloomMaxCapacity) * bloomBitsPerItem(1e-300))
+	}
+	if err := store.BFRESERVE("bf", 0.01, bloomMaxCapacity + 1, 2, false); err == nil {
+		t.Fatalf("BF.RESERVE accepted capacity %v", bloomMaxCapacity + 1)
+	}
+
+	bf := newBloomFilter(0.01, 1, math.MaxInt64, false)
+	if _, err := bf.add("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bf.add("b"); err == nil || len(bf.layers) != 1 {
+		t.Fatalf("layer of %v items added", int64(math.MaxInt64))
+	}
+}
+
+
+
+func TestBloomAddExists(t *testing.T) {
+	store := newDB()
+
+	added, err := store.BFADD("bf", []string{"a", "b", "a"})
+	must(t, err)
+	if added[0] == false || added[1] == false || added[2] == true {
+		t.Fatalf("BF.ADD a b a gave %v", added)
+	}
+
+	found, err := store.BFEXISTS("bf", []string{"a", "b", "c"})
+	must(t, err)
+	if found[0] == false || found[1] == false || found[2] == true {
+		t.Fatalf("BF.EXISTS a b c gave %v", found)
+	}
+
+	if found, _ = store.BFEXISTS("none", []string{"a"}); found[0] == true {
+		t.Fatalf("BF.EXISTS of missing key found a")
+	}
+	if err := store.BFRESERVE("bf", 0.01, 10, 2, false); err == nil {
+		t.Fatalf("BF.RESERVE of existing key")
+	}
+}
+
+
+/* Scaling keeps every item and the false positive rate within twice the error rate */
+func TestBloomScaling(t *testing.T) {
+	store := newDB()
+	must(t, store.BFRESERVE("bf", 0.01, 100, 2, false))
+
+	items := filterItems("in", 3000)
+	_, err := store.BFADD("bf", items)
+	must(t, err)
+
+	layers := store.bfmapEntry["bf"].bf.layers
+	if len(layers) != 5 || layers[1].capacity != 200 || layers[4].capacity != 1600 {
+		t.Fatalf("3000 items in %d layers", len(layers))
+	}
+	if layers[4].hashes <= layers[0].hashes {
+		t.Fatalf("hashes of the last layer %d, of the first %d", layers[4].hashes, layers[0].hashes)
+	}
+
+	found, _ := store.BFEXISTS("bf", items)
+	if foundRatio(found) != 1 {
+		t.Fatalf("added items found at %v", foundRatio(found))
+	}
+
+	found, _ = store.BFEXISTS("bf", filterItems("out", 20000))
+	if rate := foundRatio(found); rate > 0.02 {
+		t.Fatalf("false positive rate %v", rate)
+	}
+}
+
+
+func TestBloomNonScaling(t *testing.T) {
+	store := newDB()
+	must(t, store.BFRESERVE("bf", 0.001, 10, 2, true))
+
+	added, err := store.BFADD("bf", filterItems("in", 20))
+	if err == nil {
+		t.Fatalf("BF.ADD of 20 items to non scaling filter of 10")
+	}
+	if added[9] == false || added[19] == true || len(store.bfmapEntry["bf"].bf.layers) != 1 {
+		t.Fatalf("BF.ADD to full non scaling filter gave %v", added)
+	}
+
+	/* Items already in are not added again, full or not */
+	if added, err = store.BFADD("bf", []string{"in0"}); err != nil || added[0] == true {
+		t.Fatalf("BF.ADD of item in full filter gave %v, %v", added, err)
+	}
+}
+
+
+func TestBloomSaveLoad(t *testing.T) {
+	store := newDB()
+	must(t, store.BFRESERVE("bf", 0.01, 50, 3, false))
+	store.BFADD("bf", filterItems("in", 500))
+	must(t, store.BFRESERVE("fixed", 0.05, 10, 2, true))
+	store.BFADD("fixed", filterItems("in", 10))
+
+	loaded := saveLoad(t, store)
+
+	for _, key := range []string{"bf", "fixed"} {
+		got, want := loaded.bfmapEntry[key].bf, store.bfmapEntry[key].bf
+		if len(got.layers) != len(want.layers) || got.expansion != want.expansion || got.nonScaling != want.nonScaling || got.errorRate != want.errorRate {
+			t.Fatalf("loaded %s with %d layers, want %d", key, len(got.layers), len(want.layers))
+		}
+		for i := range want.layers {
+			if got.layers[i].count != want.layers[i].count || string(got.layers[i].bits) != string(want.layers[i].bits) {
+				t.Fatalf("loaded %s layer %d differs", key, i)
+			}
+		}
+	}
+
+	found, _ := loaded.BFEXISTS("bf", filterItems("in", 500))
+	if foundRatio(found) != 1 {
+		t.Fatalf("loaded filter found items at %v", foundRatio(found))
+	}
+	if _, err := loaded.BFADD("fixed", []string{"new"}); err == nil {
+		t.Fatalf("loaded non scaling filter took more items")
+	}
+}
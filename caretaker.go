@@ -38,6 +38,8 @@ func (c *caretaker) Run(store *db) {
 		select {
 		case <-ticker.C:
 			store.DeleteExpired()
+			store.HashDeleteExpired()
+			store.TSDeleteExpired()
 		case <-c.stop:
 			ticker.Stop()
 			return
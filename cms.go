@@ -0,0 +1,258 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+
+/*
+  Count-min sketch, same commands as the redis bloom module
+
+  depth rows of width counters, an item increments one counter per row and
+  its count is the smallest of its counters. The count is never below the
+  real count, collisions only make it larger.
+*/
+
+const (
+	// Max value of a counter and of the total count, counts are replied as signed 64 bit integers
+	cmsMaxCount = math.MaxInt64
+
+	// Limits of CMS.INITBYDIM, the counters of a sketch take at most 512MB
+	cmsMaxWidth = 1 << 26
+	cmsMaxDepth = 64
+	cmsMaxCounters = 1 << 26
+)
+
+type countMinSketch struct {
+	width uint64
+	depth uint64
+	counters []uint64
+	count uint64
+}
+
+type cmsmapData struct {
+	cms *countMinSketch
+	lock *sync.RWMutex
+}
+
+
+func newCountMinSketch(width uint64, depth uint64) *countMinSketch {
+	return &countMinSketch{
+		width: width,
+		depth: depth,
+		counters: make([]uint64, width * depth),
+	}
+}
+
+
+/* Counter index of item in every row */
+func (cms *countMinSketch) indexes(item string) []uint64 {
+	h1, h2 := bloomHash(item)
+
+	idx := make([]uint64, cms.depth)
+	for row := uint64(0); row < cms.depth; row++ {
+		idx[row] = row * cms.width + (h1 + row * h2) % cms.width
+	}
+	return idx
+}
+
+
+func (cms *countMinSketch) query(item string) uint64 {
+	min := uint64(math.MaxUint64)
+	for _, i := range cms.indexes(item) {
+		if cms.counters[i] < min {
+			min = cms.counters[i]
+		}
+	}
+	return min
+}
+
+
+/* Returns true if incrementing items by incrs takes a counter or the total count past cmsMaxCount */
+func (cms *countMinSketch) overflows(items []string, incrs []uint64) bool {
+	added := make(map[uint64]uint64)
+	var total uint64
+
+	for k, item := range items {
+		if incrs[k] > cmsMaxCount - cms.count - total {
+			return true
+		}
+		total += incrs[k]
+
+		/* Items may share counters, every increment adds up */
+		for _, i := range cms.indexes(item) {
+			if incrs[k] > cmsMaxCount - cms.counters[i] - added[i] {
+				return true
+			}
+			added[i] += incrs[k]
+		}
+	}
+
+	return false
+}
+
+
+/* Increment item by incr, returns the new count of item. The caller checks overflows first */
+func (cms *countMinSketch) incrBy(item string, incr uint64) uint64 {
+	for _, i := range cms.indexes(item) {
+		cms.counters[i] += incr
+	}
+	cms.count += incr
+
+	return cms.query(item)
+}
+
+
+/* Create sketch key, fails if the key exists */
+func (store *db) CMSINITBYDIM(key string, width uint64, depth uint64) error {
+	if store == nil {
+		fmt.Println("CMSINITBYDIM : store is nil")
+		return errors.New(fmt.Sprint("CMSINITBYDIM : store is nil"))
+	}
+
+	/* Take Global write lock, to ensure only one entry gets created */
+	store.cmsmapDBLock.Lock()
+	defer store.cmsmapDBLock.Unlock()
+
+	if _, ok := store.cmsmapEntry[key]; ok == true {
+		return errors.New("CMS: key already exists")
+	}
+
+	/* width * depth must not wrap, the sketch is allocated at once */
+	if width == 0 || depth == 0 || width > cmsMaxWidth || depth > cmsMaxDepth ||
+		width > math.MaxUint64 / depth || width * depth > cmsMaxCounters {
+		return errors.New("CMS: invalid width or depth")
+	}
+
+	store.cmsmapEntry[key] = &cmsmapData{
+		cms: newCountMinSketch(width, depth),
+		lock: &sync.RWMutex{},
+	}
+
+	return nil
+}
+
+
+/* Increment items by incrs, returns the new counts */
+func (store *db) CMSINCRBY(key string, items []string, incrs []uint64) ([]uint64, error) {
+	if store == nil {
+		fmt.Println("CMSINCRBY : store is nil")
+		return nil, errors.New(fmt.Sprint("CMSINCRBY : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.cmsmapDBLock.RLock()
+	defer store.cmsmapDBLock.RUnlock()
+
+	entry, ok := store.cmsmapEntry[key]
+
+	if ok == false {
+		return nil, errors.New("CMS: key does not exist")
+	}
+
+	/* Take DB entry lock before update, this is lock per key entry */
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	/* All or none of the increments */
+	if entry.cms.overflows(items, incrs) == true {
+		return nil, errors.New("CMS: INCRBY overflow")
+	}
+
+	counts := make([]uint64, len(items))
+	for i, item := range items {
+		counts[i] = entry.cms.incrBy(item, incrs[i])
+	}
+
+	return counts, nil
+}
+
+
+/* Counts of items */
+func (store *db) CMSQUERY(key string, items []string) ([]uint64, error) {
+	if store == nil {
+		fmt.Println("CMSQUERY : store is nil")
+		return nil, errors.New(fmt.Sprint("CMSQUERY : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.cmsmapDBLock.RLock()
+	defer store.cmsmapDBLock.RUnlock()
+
+	entry, ok := store.cmsmapEntry[key]
+
+	if ok == false {
+		return nil, errors.New("CMS: key does not exist")
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	counts := make([]uint64, len(items))
+	for i, item := range items {
+		counts[i] = entry.cms.query(item)
+	}
+
+	return counts, nil
+}
+
+
+/* Set dst to the sum of the sketches of keys times their weights, all the sketches must have the same width and depth */
+func (store *db) CMSMERGE(dst string, keys []string, weights []uint64) error {
+	if store == nil {
+		fmt.Println("CMSMERGE : store is nil")
+		return errors.New(fmt.Sprint("CMSMERGE : store is nil"))
+	}
+
+	/* Take Global write lock, dst may be one of the sources and gets replaced */
+	store.cmsmapDBLock.Lock()
+	defer store.cmsmapDBLock.Unlock()
+
+	dstEntry, ok := store.cmsmapEntry[dst]
+	if ok == false {
+		return errors.New("CMS: key does not exist")
+	}
+
+	srcEntries := make([]*cmsmapData, len(keys))
+	for i, key := range keys {
+		entry, ok := store.cmsmapEntry[key]
+		if ok == false {
+			return errors.New("CMS: key does not exist")
+		}
+		if entry.cms.width != dstEntry.cms.width || entry.cms.depth != dstEntry.cms.depth {
+			return errors.New("CMS: width/depth is not equal")
+		}
+		srcEntries[i] = entry
+	}
+
+	counters := make([]uint64, len(dstEntry.cms.counters))
+	var count uint64
+
+	for k, entry := range srcEntries {
+		for i, c := range entry.cms.counters {
+			if c != 0 && weights[k] > (cmsMaxCount - counters[i]) / c {
+				return errors.New("CMS: MERGE overflow")
+			}
+			counters[i] += c * weights[k]
+		}
+
+		if entry.cms.count != 0 && weights[k] > (cmsMaxCount - count) / entry.cms.count {
+			return errors.New("CMS: MERGE overflow")
+		}
+		count += entry.cms.count * weights[k]
+	}
+
+	dstEntry.cms.counters = counters
+	dstEntry.cms.count = count
+
+	return nil
+}
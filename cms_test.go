@@ -0,0 +1,165 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+
+/* A width * depth that wraps or is too large to allocate is rejected */
+func TestCMSInitByDimBounds(t *testing.T) {
+	store := newDB()
+
+	for _, dim := range [][2]uint64{{1 << 62, 4}, {1 << 32, 1 << 32}, {cmsMaxWidth + 1, 1}, {1, cmsMaxDepth + 1}, {cmsMaxWidth, 2}, {0, 1}} {
+		if err := store.CMSINITBYDIM("cms", dim[0], dim[1]); err == nil {
+			t.Fatalf("CMS.INITBYDIM accepted %v x %v", dim[0], dim[1])
+		}
+	}
+
+	must(t, store.CMSINITBYDIM("cms", 1000, 5))
+	if counts, err := store.CMSINCRBY("cms", []string{"a"}, []uint64{3}); err != nil || counts[0] != 3 {
+		t.Fatalf("CMS.INCRBY %v %v", counts, err)
+	}
+}
+
+
+/* Counts are never below the real counts, equal when the sketch is wide enough */
+func TestCMSIncrByQuery(t *testing.T) {
+	store := newDB()
+	must(t, store.CMSINITBYDIM("wide", 10000, 5))
+	must(t, store.CMSINITBYDIM("narrow", 8, 2))
+
+	real := make(map[string]uint64)
+	for i := 0; i < 200; i++ {
+		item := "i" + strconv.Itoa(i % 50)
+		real[item] += uint64(i)
+		for _, key := range []string{"wide", "narrow"} {
+			_, err := store.CMSINCRBY(key, []string{item}, []uint64{uint64(i)})
+			must(t, err)
+		}
+	}
+
+	for item, want := range real {
+		wide, _ := store.CMSQUERY("wide", []string{item})
+		narrow, _ := store.CMSQUERY("narrow", []string{item})
+		if wide[0] != want || narrow[0] < want {
+			t.Fatalf("count of %s %d wide %d narrow, want %d", item, wide[0], narrow[0], want)
+		}
+	}
+
+	/* Same item twice in one call counts twice */
+	counts, err := store.CMSINCRBY("wide", []string{"x", "x", "y"}, []uint64{2, 3, 0})
+	must(t, err)
+	if counts[0] != 2 || counts[1] != 5 || counts[2] != 0 {
+		t.Fatalf("CMS.INCRBY x x y gave %v", counts)
+	}
+	if store.cmsmapEntry["wide"].cms.count != 19900 + 5 {
+		t.Fatalf("total count %d", store.cmsmapEntry["wide"].cms.count)
+	}
+
+	if _, err := store.CMSINCRBY("none", []string{"a"}, []uint64{1}); err == nil {
+		t.Fatalf("CMS.INCRBY of missing key")
+	}
+	if _, err := store.CMSQUERY("none", []string{"a"}); err == nil {
+		t.Fatalf("CMS.QUERY of missing key")
+	}
+	if err := store.CMSINITBYDIM("wide", 10, 1); err == nil {
+		t.Fatalf("CMS.INITBYDIM of existing key")
+	}
+}
+
+
+/* An increment past the max count changes nothing */
+func TestCMSOverflow(t *testing.T) {
+	store := newDB()
+	must(t, store.CMSINITBYDIM("cms", 100, 3))
+
+	_, err := store.CMSINCRBY("cms", []string{"a"}, []uint64{cmsMaxCount - 10})
+	must(t, err)
+
+	for _, incrs := range [][]uint64{{11, 0}, {5, 6}, {0, math.MaxUint64}} {
+		if _, err := store.CMSINCRBY("cms", []string{"a", "b"}, incrs); err == nil {
+			t.Fatalf("CMS.INCRBY a b by %v past the max", incrs)
+		}
+	}
+
+	if counts, _ := store.CMSQUERY("cms", []string{"a", "b"}); counts[0] != cmsMaxCount - 10 || counts[1] != 0 {
+		t.Fatalf("failed CMS.INCRBY left %v", counts)
+	}
+	if counts, err := store.CMSINCRBY("cms", []string{"a"}, []uint64{10}); err != nil || counts[0] != cmsMaxCount {
+		t.Fatalf("CMS.INCRBY up to the max gave %v, %v", counts, err)
+	}
+}
+
+
+func TestCMSMerge(t *testing.T) {
+	store := newDB()
+	for _, key := range []string{"a", "b", "dst"} {
+		must(t, store.CMSINITBYDIM(key, 1000, 4))
+	}
+	must(t, store.CMSINITBYDIM("other", 500, 4))
+
+	store.CMSINCRBY("a", []string{"x", "y"}, []uint64{1, 2})
+	store.CMSINCRBY("b", []string{"x", "z"}, []uint64{10, 20})
+
+	must(t, store.CMSMERGE("dst", []string{"a", "b"}, []uint64{3, 1}))
+	if counts, _ := store.CMSQUERY("dst", []string{"x", "y", "z"}); counts[0] != 13 || counts[1] != 6 || counts[2] != 20 {
+		t.Fatalf("CMS.MERGE gave %v", counts)
+	}
+	if store.cmsmapEntry["dst"].cms.count != 39 {
+		t.Fatalf("merged total count %d", store.cmsmapEntry["dst"].cms.count)
+	}
+
+	/* A source as destination, dst is replaced */
+	must(t, store.CMSMERGE("a", []string{"a", "a"}, []uint64{1, 1}))
+	if counts, _ := store.CMSQUERY("a", []string{"x", "y"}); counts[0] != 2 || counts[1] != 4 {
+		t.Fatalf("CMS.MERGE a a into a gave %v", counts)
+	}
+
+	if err := store.CMSMERGE("dst", []string{"a", "other"}, []uint64{1, 1}); err == nil {
+		t.Fatalf("CMS.MERGE of different width")
+	}
+	if err := store.CMSMERGE("dst", []string{"none"}, []uint64{1}); err == nil {
+		t.Fatalf("CMS.MERGE of missing key")
+	}
+	if err := store.CMSMERGE("none", []string{"a"}, []uint64{1}); err == nil {
+		t.Fatalf("CMS.MERGE into missing key")
+	}
+
+	/* An overflowing merge leaves dst as it was */
+	if err := store.CMSMERGE("dst", []string{"b"}, []uint64{cmsMaxCount / 10}); err == nil {
+		t.Fatalf("CMS.MERGE past the max")
+	}
+	if counts, _ := store.CMSQUERY("dst", []string{"x"}); counts[0] != 13 {
+		t.Fatalf("failed CMS.MERGE left %v", counts)
+	}
+}
+
+
+func TestCMSSaveLoad(t *testing.T) {
+	store := newDB()
+	must(t, store.CMSINITBYDIM("cms", 100, 3))
+	store.CMSINCRBY("cms", []string{"a", "b", "c"}, []uint64{1, 1 << 40, 7})
+
+	loaded := saveLoad(t, store)
+
+	got, want := loaded.cmsmapEntry["cms"].cms, store.cmsmapEntry["cms"].cms
+	if got.width != want.width || got.depth != want.depth || got.count != want.count || len(got.counters) != len(want.counters) {
+		t.Fatalf("loaded sketch %v x %v count %v", got.width, got.depth, got.count)
+	}
+	for i := range want.counters {
+		if got.counters[i] != want.counters[i] {
+			t.Fatalf("loaded counter %d is %d, want %d", i, got.counters[i], want.counters[i])
+		}
+	}
+
+	if counts, _ := loaded.CMSINCRBY("cms", []string{"b"}, []uint64{1}); counts[0] != 1 << 40 + 1 {
+		t.Fatalf("CMS.INCRBY after load gave %v", counts)
+	}
+}
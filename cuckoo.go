@@ -0,0 +1,368 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+
+/*
+  Cuckoo filter, same commands as the redis bloom module
+
+  An item is kept as an 8 bit fingerprint in one of its two buckets, i1 from
+  the item hash and i2 = i1 xor hash(fingerprint), so either bucket is found
+  from the other and the fingerprint. An item is deleted by removing one copy
+  of its fingerprint. When both buckets are full a random fingerprint is
+  kicked to its other bucket, up to maxIterations times. If that fails the
+  kicks are undone and a new sub filter with expansion times the buckets is
+  added, with expansion 0 the filter is full.
+
+  The number of buckets is a power of 2, so the xor stays within the filter.
+*/
+
+const (
+	// Defaults of a filter created by CF.ADD
+	cuckooDefaultCapacity = 1024
+	cuckooDefaultBucketSize = 2
+	cuckooDefaultMaxIterations = 20
+	cuckooDefaultExpansion = 1
+
+	// Max capacity of CF.RESERVE and max bytes of a new sub filter
+	cuckooMaxCapacity = 1 << 30
+	cuckooMaxBytes = 1 << 31
+)
+
+type cuckooSub struct {
+	buckets []byte
+	numBuckets uint64
+	count int64
+}
+
+type cuckooFilter struct {
+	subs []*cuckooSub
+	bucketSize int
+	maxIterations int
+	expansion int64
+}
+
+type cfmapData struct {
+	cf *cuckooFilter
+	lock *sync.RWMutex
+}
+
+
+/* Hash and non zero fingerprint of item, 0 marks an empty slot. The bucket comes from the low bits, the fingerprint from the high bits */
+func cuckooHash(item string) (uint64, byte) {
+	h, _ := bloomHash(item)
+	return h, byte((h >> 32) % 255 + 1)
+}
+
+
+func newCuckooSub(numBuckets uint64, bucketSize int) *cuckooSub {
+	return &cuckooSub{
+		buckets: make([]byte, numBuckets * uint64(bucketSize)),
+		numBuckets: numBuckets,
+	}
+}
+
+
+/* Other bucket of fingerprint fp in bucket i */
+func (sub *cuckooSub) altIndex(i uint64, fp byte) uint64 {
+	return (i ^ (uint64(fp) * 0x5bd1e995)) & (sub.numBuckets - 1)
+}
+
+
+/* Slots of bucket i */
+func (sub *cuckooSub) bucket(i uint64, bucketSize int) []byte {
+	return sub.buckets[i * uint64(bucketSize) : (i + 1) * uint64(bucketSize)]
+}
+
+
+/* Put fp in a free slot of bucket i */
+func (sub *cuckooSub) insertAt(i uint64, fp byte, bucketSize int) bool {
+	bucket := sub.bucket(i, bucketSize)
+	for s := range bucket {
+		if bucket[s] == 0 {
+			bucket[s] = fp
+			sub.count++
+			return true
+		}
+	}
+	return false
+}
+
+
+func (sub *cuckooSub) find(i uint64, fp byte, bucketSize int) int {
+	bucket := sub.bucket(i, bucketSize)
+	for s := range bucket {
+		if bucket[s] == fp {
+			return s
+		}
+	}
+	return -1
+}
+
+
+/* Smallest power of 2 not below n, 1<<63 for larger n */
+func nextPow2(n uint64) uint64 {
+	p := uint64(1)
+	for p < n && p < 1 << 63 {
+		p <<= 1
+	}
+	return p
+}
+
+
+func newCuckooFilter(capacity int64, bucketSize int, maxIterations int, expansion int64) *cuckooFilter {
+	numBuckets := nextPow2(uint64((capacity + int64(bucketSize) - 1) / int64(bucketSize)))
+
+	return &cuckooFilter{
+		subs: []*cuckooSub{newCuckooSub(numBuckets, bucketSize)},
+		bucketSize: bucketSize,
+		maxIterations: maxIterations,
+		expansion: expansion,
+	}
+}
+
+
+/* Insert into sub by kicking fingerprints around, the kicks are undone on failure */
+func (cf *cuckooFilter) insert(sub *cuckooSub, h uint64, fp byte) bool {
+	i1 := h & (sub.numBuckets - 1)
+	i2 := sub.altIndex(i1, fp)
+
+	if sub.insertAt(i1, fp, cf.bucketSize) == true || sub.insertAt(i2, fp, cf.bucketSize) == true {
+		return true
+	}
+
+	type kick struct {
+		bucket uint64
+		slot int
+		fp byte
+	}
+	kicks := []kick{}
+
+	i := i1
+	if rand.Intn(2) == 1 {
+		i = i2
+	}
+
+	for n := 0; n < cf.maxIterations; n++ {
+		slot := rand.Intn(cf.bucketSize)
+		bucket := sub.bucket(i, cf.bucketSize)
+
+		kicks = append(kicks, kick{bucket: i, slot: slot, fp: bucket[slot]})
+		fp, bucket[slot] = bucket[slot], fp
+
+		i = sub.altIndex(i, fp)
+		if sub.insertAt(i, fp, cf.bucketSize) == true {
+			return true
+		}
+	}
+
+	for k := len(kicks) - 1; k >= 0; k-- {
+		sub.bucket(kicks[k].bucket, cf.bucketSize)[kicks[k].slot] = kicks[k].fp
+	}
+
+	return false
+}
+
+
+/* Add item, duplicates are added again */
+func (cf *cuckooFilter) add(item string) error {
+	h, fp := cuckooHash(item)
+
+	last := cf.subs[len(cf.subs) - 1]
+	if cf.insert(last, h, fp) == true {
+		return nil
+	}
+
+	if cf.expansion == 0 {
+		return errors.New("Filter is full")
+	}
+
+	/* A new sub filter past the size limit is never allocated */
+	numBuckets := nextPow2(last.numBuckets * uint64(cf.expansion))
+	if numBuckets * uint64(cf.bucketSize) > cuckooMaxBytes {
+		return errors.New("Filter is full")
+	}
+
+	last = newCuckooSub(numBuckets, cf.bucketSize)
+	cf.subs = append(cf.subs, last)
+
+	if cf.insert(last, h, fp) == false {
+		return errors.New("Filter is full")
+	}
+	return nil
+}
+
+
+func (cf *cuckooFilter) contains(item string) bool {
+	h, fp := cuckooHash(item)
+
+	for _, sub := range cf.subs {
+		i1 := h & (sub.numBuckets - 1)
+		if sub.find(i1, fp, cf.bucketSize) >= 0 || sub.find(sub.altIndex(i1, fp), fp, cf.bucketSize) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+
+/* Delete one copy of item, newest sub filter first. Returns false if not found */
+func (cf *cuckooFilter) del(item string) bool {
+	h, fp := cuckooHash(item)
+
+	for k := len(cf.subs) - 1; k >= 0; k-- {
+		sub := cf.subs[k]
+		i1 := h & (sub.numBuckets - 1)
+
+		for _, i := range []uint64{i1, sub.altIndex(i1, fp)} {
+			if s := sub.find(i, fp, cf.bucketSize); s >= 0 {
+				sub.bucket(i, cf.bucketSize)[s] = 0
+				sub.count--
+				return true
+			}
+		}
+	}
+	return false
+}
+
+
+/* Create filter key for capacity items, fails if the key exists */
+func (store *db) CFRESERVE(key string, capacity int64, bucketSize int, maxIterations int, expansion int64) error {
+	if store == nil {
+		fmt.Println("CFRESERVE : store is nil")
+		return errors.New(fmt.Sprint("CFRESERVE : store is nil"))
+	}
+
+	/* Take Global write lock, to ensure only one entry gets created */
+	store.cfmapDBLock.Lock()
+	defer store.cfmapDBLock.Unlock()
+
+	if _, ok := store.cfmapEntry[key]; ok == true {
+		return errors.New("item exists")
+	}
+
+	store.cfmapEntry[key] = &cfmapData{
+		cf: newCuckooFilter(capacity, bucketSize, maxIterations, expansion),
+		lock: &sync.RWMutex{},
+	}
+
+	return nil
+}
+
+
+/* Add item, the key is created with the default capacity if absent */
+func (store *db) CFADD(key string, item string) error {
+	if store == nil {
+		fmt.Println("CFADD : store is nil")
+		return errors.New(fmt.Sprint("CFADD : store is nil"))
+	}
+
+	var entry *cfmapData
+	var ok bool
+
+	store.cfmapDBLock.RLock()
+
+	/* Check if db has the key entry */
+	entry, ok = store.cfmapEntry[key]
+
+	/* If entry not present */
+	if ok == false {
+		/* Take DB lock before creating entry for this key, to ensure only one entry gets created */
+		store.cfmapDBLock.RUnlock()
+		store.cfmapDBLock.Lock()
+
+		/* Again Check if db has the key entry, between above if & db lock it is possible other routine has created this entry */
+		var okrecheck bool
+		entry, okrecheck = store.cfmapEntry[key]
+
+		if okrecheck == false {
+			entry = &cfmapData{
+				cf: newCuckooFilter(cuckooDefaultCapacity, cuckooDefaultBucketSize, cuckooDefaultMaxIterations, cuckooDefaultExpansion),
+				lock: &sync.RWMutex{},
+			}
+			store.cfmapEntry[key] = entry
+		}
+	}
+
+	/* Take DB entry lock before update, this is lock per key entry */
+	entry.lock.Lock()
+
+	err := entry.cf.add(item)
+
+	entry.lock.Unlock()
+
+	/* Both locks got released in LIFO order, entry lock followed by db lock */
+	if ok == false {
+		store.cfmapDBLock.Unlock()
+	} else {
+		store.cfmapDBLock.RUnlock()
+	}
+
+	return err
+}
+
+
+/* Delete one copy of item, returns false if not found. The key is kept when empty */
+func (store *db) CFDEL(key string, item string) (bool, error) {
+	if store == nil {
+		fmt.Println("CFDEL : store is nil")
+		return false, errors.New(fmt.Sprint("CFDEL : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.cfmapDBLock.RLock()
+	defer store.cfmapDBLock.RUnlock()
+
+	entry, ok := store.cfmapEntry[key]
+
+	if ok == false {
+		return false, errors.New("Not found")
+	}
+
+	/* Take DB entry lock before update, this is lock per key entry */
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	return entry.cf.del(item), nil
+}
+
+
+/* Check items, found is false for the items surely not in the filter */
+func (store *db) CFEXISTS(key string, items []string) ([]bool, error) {
+	if store == nil {
+		fmt.Println("CFEXISTS : store is nil")
+		return nil, errors.New(fmt.Sprint("CFEXISTS : store is nil"))
+	}
+
+	found := make([]bool, len(items))
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.cfmapDBLock.RLock()
+	defer store.cfmapDBLock.RUnlock()
+
+	entry, ok := store.cfmapEntry[key]
+
+	if ok == false {
+		return found, nil
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	for i, item := range items {
+		found[i] = entry.cf.contains(item)
+	}
+
+	return found, nil
+}
@@ -0,0 +1,152 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+
+func TestNextPow2(t *testing.T) {
+	for _, c := range [][2]uint64{{0, 1}, {1, 1}, {3, 4}, {1024, 1024}, {1 << 63, 1 << 63}, {1 << 63 + 1, 1 << 63}, {math.MaxUint64, 1 << 63}} {
+		if p := nextPow2(c[0]); p != c[1] {
+			t.Fatalf("nextPow2(%v) is %v, want %v", c[0], p, c[1])
+		}
+	}
+}
+
+
+
+func TestCuckooAddDel(t *testing.T) {
+	store := newDB()
+
+	must(t, store.CFADD("cf", "a"))
+	must(t, store.CFADD("cf", "a"))
+
+	found, err := store.CFEXISTS("cf", []string{"a", "b"})
+	must(t, err)
+	if found[0] == false || found[1] == true {
+		t.Fatalf("CF.EXISTS a b gave %v", found)
+	}
+
+	/* Every copy is deleted once */
+	for i, want := range []bool{true, true, false} {
+		if deleted, err := store.CFDEL("cf", "a"); err != nil || deleted != want {
+			t.Fatalf("CF.DEL %d of a gave %v, %v", i, deleted, err)
+		}
+	}
+	if found, _ = store.CFEXISTS("cf", []string{"a"}); found[0] == true {
+		t.Fatalf("CF.EXISTS of deleted item")
+	}
+	if _, ok := store.cfmapEntry["cf"]; ok == false {
+		t.Fatalf("CF.DEL of the last item deleted the key")
+	}
+
+	if _, err := store.CFDEL("none", "a"); err == nil {
+		t.Fatalf("CF.DEL of missing key")
+	}
+	if found, _ = store.CFEXISTS("none", []string{"a"}); found[0] == true {
+		t.Fatalf("CF.EXISTS of missing key found a")
+	}
+	if err := store.CFRESERVE("cf", 10, 2, 20, 1); err == nil {
+		t.Fatalf("CF.RESERVE of existing key")
+	}
+}
+
+
+func TestCuckooScaling(t *testing.T) {
+	store := newDB()
+	must(t, store.CFRESERVE("cf", 100, 2, 20, 2))
+
+	if numBuckets := store.cfmapEntry["cf"].cf.subs[0].numBuckets; numBuckets != 64 {
+		t.Fatalf("capacity 100 in %d buckets", numBuckets)
+	}
+
+	items := filterItems("in", 2000)
+	for _, item := range items {
+		must(t, store.CFADD("cf", item))
+	}
+
+	subs := store.cfmapEntry["cf"].cf.subs
+	if len(subs) < 2 || subs[1].numBuckets != 128 {
+		t.Fatalf("2000 items in %d sub filters", len(subs))
+	}
+
+	found, _ := store.CFEXISTS("cf", items)
+	if foundRatio(found) != 1 {
+		t.Fatalf("added items found at %v", foundRatio(found))
+	}
+
+	found, _ = store.CFEXISTS("cf", filterItems("out", 20000))
+	if rate := foundRatio(found); rate > 0.1 {
+		t.Fatalf("false positive rate %v", rate)
+	}
+
+	/* Deleting every item empties every sub filter */
+	for _, item := range items {
+		if deleted, _ := store.CFDEL("cf", item); deleted == false {
+			t.Fatalf("CF.DEL of %s", item)
+		}
+	}
+	for i, sub := range store.cfmapEntry["cf"].cf.subs {
+		if sub.count != 0 {
+			t.Fatalf("sub filter %d left with %d items", i, sub.count)
+		}
+	}
+}
+
+
+/* Expansion 0 fails once the sub filter is full */
+func TestCuckooFull(t *testing.T) {
+	store := newDB()
+	must(t, store.CFRESERVE("cf", 8, 1, 10, 0))
+
+	var err error
+	added := 0
+	for _, item := range filterItems("in", 100) {
+		if err = store.CFADD("cf", item); err != nil {
+			break
+		}
+		added++
+	}
+
+	if err == nil || added > 8 || len(store.cfmapEntry["cf"].cf.subs) != 1 {
+		t.Fatalf("filter of 8 took %d items, %v", added, err)
+	}
+
+	/* A failed add leaves the items in after the kicks are undone */
+	found, _ := store.CFEXISTS("cf", filterItems("in", added))
+	if foundRatio(found) != 1 {
+		t.Fatalf("items found at %v after a failed add", foundRatio(found))
+	}
+}
+
+
+func TestCuckooSaveLoad(t *testing.T) {
+	store := newDB()
+	must(t, store.CFRESERVE("cf", 64, 4, 50, 1))
+	for _, item := range filterItems("in", 500) {
+		must(t, store.CFADD("cf", item))
+	}
+	store.CFDEL("cf", "in7")
+
+	loaded := saveLoad(t, store)
+
+	got, want := loaded.cfmapEntry["cf"].cf, store.cfmapEntry["cf"].cf
+	if len(got.subs) != len(want.subs) || got.bucketSize != want.bucketSize || got.maxIterations != want.maxIterations || got.expansion != want.expansion {
+		t.Fatalf("loaded filter with %d sub filters, want %d", len(got.subs), len(want.subs))
+	}
+	for i := range want.subs {
+		if got.subs[i].count != want.subs[i].count || got.subs[i].numBuckets != want.subs[i].numBuckets || string(got.subs[i].buckets) != string(want.subs[i].buckets) {
+			t.Fatalf("loaded sub filter %d differs", i)
+		}
+	}
+
+	if deleted, _ := loaded.CFDEL("cf", "in8"); deleted == false {
+		t.Fatalf("CF.DEL after load")
+	}
+}
@@ -7,11 +7,13 @@ package main
 
 import (
 	"sync"
-	"github.com/emirpasic/gods/sets/treeset"
 	"fmt"
 	"time"
 	"errors"
 	"bytes"
+	"math"
+	"math/rand"
+	"sort"
 )
 
 
@@ -19,12 +21,14 @@ import (
 /*
   Storing data of form ZADD key score1 member1 member 2 [score3 member3]
   setmapEntry map[key] value is pointer to setmapData
-  setmapData map[score] value is set - member1 member 2
+  setmapData dict map[member] value is score
+  setmapData zsl is skiplist of score member pairs ordered by score, then member
 
 */
 
 type setmapData struct {
-	setEntry map[int]*treeset.Set
+	dict map[string]float64
+	zsl *zskiplist
 	lock *sync.RWMutex
 }
 
@@ -52,6 +56,65 @@ type mapData struct {
   setmapDBLock is glocal RW lock on setmapEntry
   setmapData.lock is RW lock per key of setmapEntry
 
+  listmapEntry is holding key-data pair
+  listmapData is holding elements of the list for a key, see list.go
+  listmapDBLock is glocal RW lock on listmapEntry
+  listmapData.lock is RW lock per key of listmapEntry
+
+  hashmapEntry is holding key-data pair
+  hashmapData is holding field value pairs of the hash for a key, see hash.go
+  hashmapDBLock is glocal RW lock on hashmapEntry
+  hashmapData.lock is RW lock per key of hashmapEntry
+
+  smapEntry is holding key-data pair of the set type, setmapEntry is the sorted set
+  smapData is holding members of the set for a key, see set.go
+  smapDBLock is glocal RW lock on smapEntry
+  smapData.lock is RW lock per key of smapEntry
+
+  streammapEntry is holding key-data pair
+  streammapData is holding entries of the stream for a key, see stream.go
+  streammapDBLock is glocal RW lock on streammapEntry
+  streammapData.lock is RW lock per key of streammapEntry
+
+  jsonmapEntry is holding key-data pair
+  jsonmapData is holding the parsed JSON document for a key, see json.go
+  jsonmapDBLock is glocal RW lock on jsonmapEntry
+  jsonmapData.lock is RW lock per key of jsonmapEntry
+
+  bfmapEntry is holding key-data pair of bloom filters, see bloom.go
+  bfmapDBLock is glocal RW lock on bfmapEntry
+  bfmapData.lock is RW lock per key of bfmapEntry
+
+  cfmapEntry is holding key-data pair of cuckoo filters, see cuckoo.go
+  cfmapDBLock is glocal RW lock on cfmapEntry
+  cfmapData.lock is RW lock per key of cfmapEntry
+
+  cmsmapEntry is holding key-data pair of count-min sketches, see cms.go
+  cmsmapDBLock is glocal RW lock on cmsmapEntry
+  cmsmapData.lock is RW lock per key of cmsmapEntry
+
+  topkmapEntry is holding key-data pair of top k lists, see topk.go
+  topkmapDBLock is glocal RW lock on topkmapEntry
+  topkmapData.lock is RW lock per key of topkmapEntry
+
+  tdigestmapEntry is holding key-data pair of t-digests, see tdigest.go
+  tdigestmapDBLock is glocal RW lock on tdigestmapEntry
+  tdigestmapData.lock is RW lock per key of tdigestmapEntry
+
+  tsmapEntry is holding key-data pair of time series, see timeseries.go
+  tsmapDBLock is glocal RW lock on tsmapEntry
+  tsmapData.lock is RW lock per key of tsmapEntry
+
+  ftmapEntry is holding name-index pair of search indexes over hashes, see search.go
+  ftmapDBLock is glocal RW lock on ftmapEntry
+  ftIndex.lock is RW lock per index of ftmapEntry
+
+  blockedEntry is holding clients blocked on a key, see block.go
+  blockedLock is lock on blockedEntry and readyKeys
+
+  execLock is RW lock on command execution, see multi.go
+  execRunning is set while EXEC runs a transaction
+
   Synchrinization
   1. mapEnry
      a. GET - Holds global read lock and key read lock for operation
@@ -66,14 +129,105 @@ type mapData struct {
      iii. SAVE\LOAD exclusive operation
 
   2. setmapEntry
-     a. ZRANGE\ZCOUNT\ZCARD - Holds key read lock for operation
-     b. ZADD - Holds global read lock and key write lock for operation, iff key present
+     a. ZRANGE\ZCOUNT\ZCARD\GEOSEARCH\GEOPOS\GEODIST - Holds global read lock and key read lock for operation
+     b. ZADD\GEOADD - Holds global read lock and key write lock for operation, iff key present
                Holds global write lock and key write lock for operation, iff key absent
-     c. DB SAVE\LOAD - Holds global write lock for operation
+     c. ZREM\ZREMRANGEBY* - Holds global read lock and key write lock for operation
+                            Holds global write lock to delete the key, iff last member removed
+     d. ZRANGESTORE\ZUNIONSTORE\ZINTERSTORE\ZDIFFSTORE\GEOSEARCHSTORE - Holds global write lock for operation
+     e. ZUNION\ZINTER\ZDIFF\ZINTERCARD - Holds global read lock and key read lock of every source key,
+                                         taken in sorted key order for operation
+     f. DB SAVE\LOAD - Holds global write lock for operation
 
      The scheme ensures 
-     i. ZRANGE\ZCOUNT\ZCARD\ZADD concurrent operations
-     ii. SAVE\LOAD exclusive operation
+     i. ZRANGE\ZCOUNT\ZCARD\ZADD\ZREM\ZUNION concurrent operations
+     ii. Empty key delete and *STORE exclusive operation
+     iii. SAVE\LOAD exclusive operation
+
+  3. listmapEntry
+     a. LRANGE\LINDEX\LLEN\LPOS - Holds global read lock and key read lock for operation
+     b. LPUSH\RPUSH - Holds global read lock and key write lock for operation, iff key present
+                     Holds global write lock and key write lock for operation, iff key absent
+     c. LPOP\RPOP\LSET\LINSERT\LREM\LTRIM - Holds global read lock and key write lock for operation
+                                           Holds global write lock to delete the key, iff last element removed
+     d. LMOVE - Holds global write lock for operation
+     e. DB SAVE\LOAD - Holds global write lock for operation
+
+  4. hashmapEntry
+     a. HGET\HMGET\HGETALL\HLEN\HSCAN - Holds global read lock and key read lock for operation
+     b. HSET\HINCRBY - Holds global read lock and key write lock for operation, iff key present
+                      Holds global write lock and key write lock for operation, iff key absent
+     c. HDEL\HEXPIRE\HPERSIST - Holds global read lock and key write lock for operation
+                              Holds global write lock to delete the key, iff last field removed
+     d. Caretaker field expiration - Holds global write lock for operation
+     e. DB SAVE\LOAD - Holds global write lock for operation
+
+  5. smapEntry
+     a. SMEMBERS\SISMEMBER\SCARD\SRANDMEMBER\SSCAN - Holds global read lock and key read lock for operation
+     b. SADD - Holds global read lock and key write lock for operation, iff key present
+               Holds global write lock and key write lock for operation, iff key absent
+     c. SREM\SPOP - Holds global read lock and key write lock for operation
+                   Holds global write lock to delete the key, iff last member removed
+     d. SMOVE\SINTERSTORE\SUNIONSTORE\SDIFFSTORE - Holds global write lock for operation
+     e. SINTER\SUNION\SDIFF\SINTERCARD - Holds global read lock and key read lock of every source key,
+                                        taken in sorted key order for operation
+     f. DB SAVE\LOAD - Holds global write lock for operation
+
+  6. streammapEntry
+     a. XRANGE\XREVRANGE\XLEN - Holds global read lock and key read lock for operation
+     b. XADD - Holds global read lock and key write lock for operation, iff key present
+               Holds global write lock and key write lock for operation, iff key absent
+     c. XTRIM\XDEL - Holds global read lock and key write lock for operation, an empty stream is kept
+     d. XREAD - Holds global read lock and key read lock of one key at a time for operation
+     e. XGROUP\XREADGROUP\XACK\XCLAIM\XAUTOCLAIM - Holds global read lock and key write lock for operation, groups live in the stream
+        XGROUP CREATE MKSTREAM holds global write lock and key write lock, iff key absent
+     f. XPENDING\XINFO - Holds global read lock and key read lock for operation
+     g. DB SAVE\LOAD - Holds global write lock for operation
+
+  7. jsonmapEntry
+     a. JSON.GET\JSON.TYPE - Holds global read lock and key read lock for operation
+     b. JSON.SET - Holds global read lock and key write lock for operation, iff key present
+                   Holds global write lock and key write lock for operation, iff key absent
+     c. JSON.DEL\JSON.NUMINCRBY\JSON.ARRAPPEND - Holds global read lock and key write lock for operation
+                                                Holds global write lock to delete the key, iff root deleted
+     d. DB SAVE\LOAD - Holds global write lock for operation
+
+  8. bfmapEntry\cfmapEntry
+     a. BF.EXISTS\BF.MEXISTS\CF.EXISTS\CF.MEXISTS - Holds global read lock and key read lock for operation
+     b. BF.ADD\BF.MADD\CF.ADD - Holds global read lock and key write lock for operation, iff key present
+                               Holds global write lock and key write lock for operation, iff key absent
+     c. BF.RESERVE\CF.RESERVE - Holds global write lock for operation
+     d. CF.DEL - Holds global read lock and key write lock for operation, an empty filter is kept
+     e. DB SAVE\LOAD - Holds global write lock for operation
+
+  9. cmsmapEntry\topkmapEntry
+     a. CMS.QUERY\TOPK.QUERY\TOPK.LIST - Holds global read lock and key read lock for operation
+     b. CMS.INCRBY\TOPK.ADD - Holds global read lock and key write lock for operation
+     c. CMS.INITBYDIM\TOPK.RESERVE\CMS.MERGE - Holds global write lock for operation
+     d. DB SAVE\LOAD - Holds global write lock for operation
+
+  10. tdigestmapEntry
+     a. TDIGEST.QUANTILE\TDIGEST.CDF\TDIGEST.MIN\TDIGEST.MAX - Holds global read lock and key read lock for operation
+     b. TDIGEST.ADD\TDIGEST.RESET - Holds global read lock and key write lock for operation
+     c. TDIGEST.CREATE\TDIGEST.MERGE - Holds global write lock for operation
+     d. DB SAVE\LOAD - Holds global write lock for operation
+
+  11. tsmapEntry
+     a. TS.RANGE\TS.MRANGE - Holds global read lock and key read lock for operation
+     b. TS.ADD\TS.MADD - Holds global read lock and key write lock for operation, the key write lock of the rule destinations is taken under the key write lock of the source
+     c. TS.CREATE\TS.CREATERULE - Holds global write lock for operation
+     d. Retention - Caretaker holds global read lock and key write lock for operation
+     e. DB SAVE\LOAD - Holds global write lock for operation
+
+  12. ftmapEntry
+     a. FT.SEARCH - Holds global read lock and index read lock to find the matches, the hashes are read after both are released
+     b. Hash writes - Hold hash key write lock, then global read lock and index write lock to index the hash again
+     c. FT.CREATE - Holds hash global write lock and global write lock for operation
+     d. DB SAVE\LOAD - Holds global write lock for operation
+
+  13. Transaction
+     a. Every command - Holds execLock read lock for operation, released while blocked
+     b. EXEC - Holds execLock write lock for the queued commands
 */
 
 type db struct {
@@ -84,6 +238,49 @@ type db struct {
 	setmapEntry map[string]*setmapData
 	setmapDBLock *sync.RWMutex
 	caretaker *caretaker
+
+	listmapEntry map[string]*listmapData
+	listmapDBLock *sync.RWMutex
+
+	hashmapEntry map[string]*hashmapData
+	hashmapDBLock *sync.RWMutex
+
+	smapEntry map[string]*smapData
+	smapDBLock *sync.RWMutex
+
+	streammapEntry map[string]*streammapData
+	streammapDBLock *sync.RWMutex
+
+	jsonmapEntry map[string]*jsonmapData
+	jsonmapDBLock *sync.RWMutex
+
+	bfmapEntry map[string]*bfmapData
+	bfmapDBLock *sync.RWMutex
+
+	cfmapEntry map[string]*cfmapData
+	cfmapDBLock *sync.RWMutex
+
+	cmsmapEntry map[string]*cmsmapData
+	cmsmapDBLock *sync.RWMutex
+
+	topkmapEntry map[string]*topkmapData
+	topkmapDBLock *sync.RWMutex
+
+	tdigestmapEntry map[string]*tdigestmapData
+	tdigestmapDBLock *sync.RWMutex
+
+	tsmapEntry map[string]*tsmapData
+	tsmapDBLock *sync.RWMutex
+
+	ftmapEntry map[string]*ftIndex
+	ftmapDBLock *sync.RWMutex
+
+	blockedEntry map[blockingKey][]*blockedClient
+	blockedLock *sync.Mutex
+	readyKeys []blockingKey
+
+	execLock *sync.RWMutex
+	execRunning bool
 }
 
 
@@ -446,7 +643,7 @@ func (store *db) SetNX(key string, val string, d time.Duration) (bool, error) {
 	/* If entry not present */
 	if ok == true {
 		store.mapDBLock.RUnlock()
-		return false, errors.New(fmt.Sprintf("SET NX : err store.setmapEntry key", key, "present"))
+		return false, errors.New(fmt.Sprint("SET NX : err store.mapEntry key ", key, " present"))
 	} else {
 		/* Take DB lock before creating entry for this key, to ensure only one entry gets created */
 		store.mapDBLock.RUnlock()
@@ -514,18 +711,25 @@ func (store *db) SetXX(key string, val string, d time.Duration) (bool, error){
 
 
 
-/* You may assume single [score member] inserts.  */
-func (store *db) ZADD(key string, zaddMap *map[string]int) (int, error) {
+/*
+  Add score member pairs in order, flags are the zadd* options
+  Returns the number of members added, or added and updated with zaddCH
+  With zaddINCR returns 1 and the new score, or 0 if the update was not performed due to NX\XX\GT\LT
+*/
+func (store *db) ZADD(key string, items []zsetItem, flags int) (int, float64, error) {
 	var memberAdded int = 0
+	var memberUpdated int = 0
+	var memberProcessed int = 0
+	var newScore float64 = 0
 
 	if store == nil {
 		fmt.Println("ZADD : store is nil")
-		return 0, errors.New(fmt.Sprint("ZADD : store is nil"))
+		return 0, 0, errors.New(fmt.Sprint("ZADD : store is nil"))
 	}
 
-	if zaddMap == nil {
-		fmt.Println("ZADD : argument zaddMap is nil")
-		return 0, errors.New(fmt.Sprint("ZADD : Internal error"))
+	if items == nil {
+		fmt.Println("ZADD : argument items is nil")
+		return 0, 0, errors.New(fmt.Sprint("ZADD : Internal error"))
 	}
 
 	var entry *setmapData
@@ -538,6 +742,12 @@ func (store *db) ZADD(key string, zaddMap *map[string]int) (int, error) {
 
 	/* If entry not present */
 	if ok == false {
+		/* XX never creates the key */
+		if flags & zaddXX != 0 {
+			store.setmapDBLock.RUnlock()
+			return 0, 0, nil
+		}
+
 		/* Take DB lock before creating entry for this key, to ensure only one entry gets created */
 		store.setmapDBLock.RUnlock()
 		store.setmapDBLock.Lock()
@@ -548,7 +758,8 @@ func (store *db) ZADD(key string, zaddMap *map[string]int) (int, error) {
 
 		if okrecheck == false {
 			entry = &setmapData{
-				setEntry: make(map[int]*treeset.Set),
+				dict: make(map[string]float64),
+				zsl: newZskiplist(),
 				lock: &sync.RWMutex{},
 				}
 
@@ -560,26 +771,56 @@ func (store *db) ZADD(key string, zaddMap *map[string]int) (int, error) {
 	/* Take DB entry lock before set, this is lock per key entry */
 	entry.lock.Lock()
 
-	for member, score := range *zaddMap {
+	var err error
+	for _, item := range items {
+		score := item.score
+		curScore, found := entry.dict[item.member]
 
-		/* Remove if same member exist at any score */
-		for _,value := range entry.setEntry {
-			if true == value.Contains(member) {
-				memberAdded = memberAdded - 1
-				value.Remove(member)
+		if found == true {
+			if flags & zaddNX != 0 {
+				continue
 			}
+
+			if flags & zaddINCR != 0 {
+				score = curScore + score
+				if math.IsNaN(score) {
+					err = errors.New("resulting score is not a number (NaN)")
+					break
+				}
+			}
+
+			if (flags & zaddLT != 0 && score >= curScore) || (flags & zaddGT != 0 && score <= curScore) {
+				continue
+			}
+
+			newScore = score
+			memberProcessed = memberProcessed + 1
+
+			/* Move the member if same member exist at other score */
+			if curScore != score {
+				entry.zsl.updateScore(curScore, item.member, score)
+				entry.dict[item.member] = score
+				memberUpdated = memberUpdated + 1
+			}
+			continue
 		}
 
-		/* Insert the score member pair */
-		if entry.setEntry[score] == nil {
-			entry.setEntry[score] = treeset.NewWithStringComparator()
+		if flags & zaddXX != 0 {
+			continue
 		}
 
-		entry.setEntry[score].Add(member)	
+		/* Insert the score member pair */
+		entry.zsl.insert(score, item.member)
+		entry.dict[item.member] = score
+		newScore = score
 		memberAdded = memberAdded + 1
+		memberProcessed = memberProcessed + 1
 	}
 	
-	store.setmapEntry[key] = entry
+	/* A new key is stored only if it holds members, all pairs may be skipped */
+	if ok == false && entry.zsl.length > 0 {
+		store.setmapEntry[key] = entry
+	}
 
 	entry.lock.Unlock()
 
@@ -589,8 +830,25 @@ func (store *db) ZADD(key string, zaddMap *map[string]int) (int, error) {
 	} else {
 		store.setmapDBLock.RUnlock()
 	}
+
+	/* Serve clients blocked on the key, after data locks are released */
+	if memberAdded > 0 {
+		store.signalKeyReady(blockZset, key)
+	}
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if flags & zaddINCR != 0 {
+		return memberProcessed, newScore, nil
+	}
+
+	if flags & zaddCH != 0 {
+		return memberAdded + memberUpdated, 0, nil
+	}
 	
-	return memberAdded, nil
+	return memberAdded, 0, nil
 
 }
 
@@ -600,60 +858,98 @@ func (store *db) ZCARD(key string) (int, error) {
 		return 0, errors.New(fmt.Sprint("ZCARD : store is nil"))
 	}
 
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.setmapDBLock.RLock()
+	defer store.setmapDBLock.RUnlock()
+
+	entry, ok := store.setmapEntry[key]
+
+	if ok == false {
+		return 0, errors.New(fmt.Sprint("ZCARD : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+	
+	return entry.zsl.length, nil
+}
+
+/* min and max are inclusive unless marked exclusive in spec with the '(' notation */
+func (store *db) ZCOUNT(key string, spec *zrangespec) (int, error) {  
+	if store == nil {
+		fmt.Println("ZCOUNT : store is nil")
+		return 0, errors.New(fmt.Sprint("ZCOUNT : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete or ZRANGESTORE replacing the key until operation is finished */
+	store.setmapDBLock.RLock()
+	defer store.setmapDBLock.RUnlock()
+
 	entry, ok := store.setmapEntry[key]
 	var count int = 0
 
 	if ok == false {
-		return count, errors.New(fmt.Sprint("ZCARD : key ", key, " not found"))
+		return count, errors.New(fmt.Sprint("ZCOUNT : key ", key, " not found"))
 	}
 
 	/* Take DB entry Rlock before get, this is lock per key entry */
 	entry.lock.RLock()
 	defer entry.lock.RUnlock()
 	
-	for _,value := range entry.setEntry {
-		count = count + value.Size() 
+	/* Count is difference of ranks of the first and last element in range, O(log n) */
+	first := entry.zsl.firstInRange(spec)
+	if first != nil {
+		last := entry.zsl.lastInRange(spec)
+		count = entry.zsl.getRank(last.score, last.member) - entry.zsl.getRank(first.score, first.member) + 1
 	}
 
 	return count, nil
 }
 
-/* Assume min and max are always inclusive. ie, You do not need to support the '(' notation for exclusive ranges */
-func (store *db) ZCOUNT(key string, min int, max int) (int, error) {  
+/* Count of members in lex range, min and max use the '[' '(' '-' '+' notation */
+func (store *db) ZLEXCOUNT(key string, spec *zlexrangespec) (int, error) {
 	if store == nil {
-		fmt.Println("ZCARD : store is nil")
-		return 0, errors.New(fmt.Sprint("ZCOUNT : store is nil"))
+		fmt.Println("ZLEXCOUNT : store is nil")
+		return 0, errors.New(fmt.Sprint("ZLEXCOUNT : store is nil"))
 	}
 
+	/* Take Global Read lock to hold delete or ZRANGESTORE replacing the key until operation is finished */
+	store.setmapDBLock.RLock()
+	defer store.setmapDBLock.RUnlock()
+
 	entry, ok := store.setmapEntry[key]
 	var count int = 0
 
 	if ok == false {
-		return count, errors.New(fmt.Sprint("ZCOUNT : key ", key, "not found"))
+		return count, errors.New(fmt.Sprint("ZLEXCOUNT : key ", key, " not found"))
 	}
 
 	/* Take DB entry Rlock before get, this is lock per key entry */
 	entry.lock.RLock()
 	defer entry.lock.RUnlock()
-	
-	for key,value := range entry.setEntry {
-		if key >= min && key <= max {
-			count = count + value.Size() 
-			fmt.Println("set : ", value.String())
-		}
+
+	/* Count is difference of ranks of the first and last element in range, O(log n) */
+	first := entry.zsl.firstInLexRange(spec)
+	if first != nil {
+		last := entry.zsl.lastInLexRange(spec)
+		count = entry.zsl.getRank(last.score, last.member) - entry.zsl.getRank(first.score, first.member) + 1
 	}
 
 	return count, nil
 }
 
-func (store *db) ZRANGE(key string, start int, stop int) (*map[string]int, error) {
+func (store *db) ZRANGE(key string, args *zrangeArgs) ([]zsetItem, error) {
 	if store == nil {
 		fmt.Println("ZRANGE : store is nil")
-		return nil, errors.New(fmt.Sprint("ZADD : store is nil"))
+		return nil, errors.New(fmt.Sprint("ZRANGE : store is nil"))
 	}
 
+	/* Take Global Read lock to hold delete or ZRANGESTORE replacing the key until operation is finished */
+	store.setmapDBLock.RLock()
+	defer store.setmapDBLock.RUnlock()
+
 	entry, ok := store.setmapEntry[key]
-	retMap := make(map[string]int)
 
 	if ok == false {
 		return nil, errors.New(fmt.Sprint("ZRANGE : key ", key, " not found"))
@@ -662,24 +958,519 @@ func (store *db) ZRANGE(key string, start int, stop int) (*map[string]int, error
 	/* Take DB entry Rlock before get, this is lock per key entry */
 	entry.lock.RLock()
 	defer entry.lock.RUnlock()
-	
-	for key,value := range entry.setEntry {
-		if key >= start && key <= stop {
 
-			items := []string{}
-			for _, v := range value.Values() {
-				items = append(items, fmt.Sprintf("%v", v))
-			}
+	return entry.zsl.rangeByArgs(args), nil
+}
 
 
-			for _, elementString := range items {
-				retMap[elementString] = key
-			}
+/* Stores the range of src at dst, returns the number of elements in dst */
+func (store *db) ZRANGESTORE(dst string, src string, args *zrangeArgs) (int, error) {
+	if store == nil {
+		fmt.Println("ZRANGESTORE : store is nil")
+		return 0, errors.New(fmt.Sprint("ZRANGESTORE : store is nil"))
+	}
+
+	/* Take Global write lock, src and dst can be same key and dst gets replaced */
+	store.setmapDBLock.Lock()
+
+	items := []zsetItem{}
+
+	entry, ok := store.setmapEntry[src]
+	if ok == true {
+		items = entry.zsl.rangeByArgs(args)
+	}
+
+	store.zsetReplace(dst, items)
+
+	store.setmapDBLock.Unlock()
+
+	/* Serve clients blocked on dst, after data locks are released */
+	if len(items) > 0 {
+		store.signalKeyReady(blockZset, dst)
+	}
+
+	return len(items), nil
+}
+
+
+/* Replace key with a new sorted set of items, key is deleted if items is empty. Caller holds global write lock */
+func (store *db) zsetReplace(key string, items []zsetItem) {
+	if len(items) == 0 {
+		delete(store.setmapEntry, key)
+		return
+	}
+
+	entry := &setmapData{
+		dict: make(map[string]float64),
+		zsl: newZskiplist(),
+		lock: &sync.RWMutex{},
+	}
+
+	for _, item := range items {
+		if curScore, found := entry.dict[item.member]; found == true {
+			entry.zsl.updateScore(curScore, item.member, item.score)
+		} else {
+			entry.zsl.insert(item.score, item.member)
+		}
+		entry.dict[item.member] = item.score
+	}
+
+	store.setmapEntry[key] = entry
+}
+
+
+/* Returns the scores of members, found is false for the members not in the sorted set */
+func (store *db) ZMSCORE(key string, members []string) ([]float64, []bool, error) {
+	if store == nil {
+		fmt.Println("ZMSCORE : store is nil")
+		return nil, nil, errors.New(fmt.Sprint("ZMSCORE : store is nil"))
+	}
+
+	scores := make([]float64, len(members))
+	found := make([]bool, len(members))
+
+	/* Take Global Read lock to hold delete or ZRANGESTORE replacing the key until operation is finished */
+	store.setmapDBLock.RLock()
+	defer store.setmapDBLock.RUnlock()
+
+	entry, ok := store.setmapEntry[key]
+
+	if ok == false {
+		return scores, found, errors.New(fmt.Sprint("ZMSCORE : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	for i, member := range members {
+		scores[i], found[i] = entry.dict[member]
+	}
+
+	return scores, found, nil
+}
+
+
+/* Returns the 0-based rank of member ordered from low to high score, or high to low with rev, and its score */
+func (store *db) ZRANK(key string, member string, rev bool) (int, float64, error) {
+	if store == nil {
+		fmt.Println("ZRANK : store is nil")
+		return 0, 0, errors.New(fmt.Sprint("ZRANK : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete or ZRANGESTORE replacing the key until operation is finished */
+	store.setmapDBLock.RLock()
+	defer store.setmapDBLock.RUnlock()
+
+	entry, ok := store.setmapEntry[key]
+
+	if ok == false {
+		return 0, 0, errors.New(fmt.Sprint("ZRANK : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	score, found := entry.dict[member]
+
+	if found == false {
+		return 0, 0, errors.New(fmt.Sprint("ZRANK : key ", key, " member ", member, " not found"))
+	}
+
+	/* Skiplist rank lookup is O(log n) */
+	rank := entry.zsl.getRank(score, member)
+
+	if rev == true {
+		return entry.zsl.length - rank, score, nil
+	}
+
+	return rank - 1, score, nil
+}
+
+
+/* Removes the members from sorted set, returns the number of members removed */
+func (store *db) ZREM(key string, members []string) (int, error) {
+	if store == nil {
+		fmt.Println("ZREM : store is nil")
+		return 0, errors.New(fmt.Sprint("ZREM : store is nil"))
+	}
+
+	var removed int = 0
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.setmapDBLock.RLock()
+
+	entry, ok := store.setmapEntry[key]
+
+	if ok == false {
+		store.setmapDBLock.RUnlock()
+		return 0, errors.New(fmt.Sprint("ZREM : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before remove, this is lock per key entry */
+	entry.lock.Lock()
+
+	for _, member := range members {
+		score, found := entry.dict[member]
+		if found == true {
+			entry.zsl.delete(score, member)
+			delete(entry.dict, member)
+			removed = removed + 1
 		}
 	}
 
-	return &retMap, nil
+	empty := entry.zsl.length == 0
+
+	entry.lock.Unlock()
+	store.setmapDBLock.RUnlock()
+
+	if empty == true {
+		store.zsetDeleteIfEmpty(key, entry)
+	}
+
+	return removed, nil
+}
+
+
+/* Removes the members in rank, score or lex range given by args, returns the number of members removed */
+func (store *db) ZREMRANGE(key string, args *zrangeArgs) (int, error) {
+	if store == nil {
+		fmt.Println("ZREMRANGE : store is nil")
+		return 0, errors.New(fmt.Sprint("ZREMRANGE : store is nil"))
+	}
+
+	var removed int = 0
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.setmapDBLock.RLock()
+
+	entry, ok := store.setmapEntry[key]
+
+	if ok == false {
+		store.setmapDBLock.RUnlock()
+		return 0, errors.New(fmt.Sprint("ZREMRANGE : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before remove, this is lock per key entry */
+	entry.lock.Lock()
+
+	switch args.rangeType {
+	case zrangeRank:
+		llen := entry.zsl.length
+		start, end := args.start, args.stop
+
+		/* Sanitize indexes, negative index is counted from the end */
+		if start < 0 {
+			start = llen + start
+		}
+		if end < 0 {
+			end = llen + end
+		}
+		if start < 0 {
+			start = 0
+		}
+		if end >= llen {
+			end = llen - 1
+		}
+
+		if start <= end && start < llen {
+			removed = entry.zsl.deleteRangeByRank(start + 1, end + 1, entry.dict)
+		}
+	case zrangeScore:
+		removed = entry.zsl.deleteRangeByScore(args.score, entry.dict)
+	case zrangeLex:
+		removed = entry.zsl.deleteRangeByLex(args.lex, entry.dict)
+	}
+
+	empty := entry.zsl.length == 0
+
+	entry.lock.Unlock()
+	store.setmapDBLock.RUnlock()
+
+	if empty == true {
+		store.zsetDeleteIfEmpty(key, entry)
+	}
+
+	return removed, nil
+}
+
+
+/* Delete the key if the sorted set is still empty, other routine may have added members since the check */
+func (store *db) zsetDeleteIfEmpty(key string, entry *setmapData) {
+	store.setmapDBLock.Lock()
+	defer store.setmapDBLock.Unlock()
+
+	if store.setmapEntry[key] == entry && entry.zsl.length == 0 {
+		delete(store.setmapEntry, key)
+	}
 }
 
 
+/* Union, inter or diff of the sorted sets at args.keys, used by ZUNION\ZINTER\ZDIFF\ZINTERCARD */
+func (store *db) ZSETOP(args *zsetOpArgs) ([]zsetItem, error) {
+	if store == nil {
+		fmt.Println("ZSETOP : store is nil")
+		return nil, errors.New(fmt.Sprint("ZSETOP : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete or replace of the source keys until operation is finished */
+	store.setmapDBLock.RLock()
+	defer store.setmapDBLock.RUnlock()
+
+	sets := make([]*setmapData, len(args.keys))
+	for i, key := range args.keys {
+		sets[i] = store.setmapEntry[key]
+	}
+
+	/* Take DB entry Rlock of every source key, in key order and once per key to be consistent with other multi key readers */
+	keys := make([]string, 0, len(args.keys))
+	for key := range uniqueKeys(args.keys) {
+		if _, ok := store.setmapEntry[key]; ok == true {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		entry := store.setmapEntry[key]
+		entry.lock.RLock()
+		defer entry.lock.RUnlock()
+	}
+
+	return zsetOperation(args, sets), nil
+}
+
+
+/* Stores the union, inter or diff of the sorted sets at dst, returns the number of elements in dst */
+func (store *db) ZSETOPSTORE(dst string, args *zsetOpArgs) (int, error) {
+	if store == nil {
+		fmt.Println("ZSETOPSTORE : store is nil")
+		return 0, errors.New(fmt.Sprint("ZSETOPSTORE : store is nil"))
+	}
+
+	/* Take Global write lock, no other routine is accessing any source key and dst can be a source key */
+	store.setmapDBLock.Lock()
+
+	sets := make([]*setmapData, len(args.keys))
+	for i, key := range args.keys {
+		sets[i] = store.setmapEntry[key]
+	}
+
+	items := zsetOperation(args, sets)
+
+	store.zsetReplace(dst, items)
+
+	store.setmapDBLock.Unlock()
+
+	/* Serve clients blocked on dst, after data locks are released */
+	if len(items) > 0 {
+		store.signalKeyReady(blockZset, dst)
+	}
+
+	return len(items), nil
+}
+
+
+/* Returns the set of distinct keys */
+func uniqueKeys(keys []string) map[string]bool {
+	unique := make(map[string]bool)
+	for _, key := range keys {
+		unique[key] = true
+	}
+	return unique
+}
+
+
+/* Pops up to count members with the lowest scores, or highest scores with max */
+func (store *db) ZPOP(key string, count int, max bool) ([]zsetItem, error) {
+	if store == nil {
+		fmt.Println("ZPOP : store is nil")
+		return nil, errors.New(fmt.Sprint("ZPOP : store is nil"))
+	}
+
+	items := []zsetItem{}
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.setmapDBLock.RLock()
+
+	entry, ok := store.setmapEntry[key]
+
+	if ok == false {
+		store.setmapDBLock.RUnlock()
+		return items, errors.New(fmt.Sprint("ZPOP : key ", key, " not found"))
+	}
 
+	/* Take DB entry lock before remove, this is lock per key entry */
+	entry.lock.Lock()
+
+	for ; count > 0 && entry.zsl.length > 0; count-- {
+		x := entry.zsl.first()
+		if max == true {
+			x = entry.zsl.tail
+		}
+
+		items = append(items, zsetItem{member: x.member, score: x.score})
+		entry.zsl.delete(x.score, x.member)
+		delete(entry.dict, x.member)
+	}
+
+	empty := entry.zsl.length == 0
+
+	entry.lock.Unlock()
+	store.setmapDBLock.RUnlock()
+
+	if empty == true {
+		store.zsetDeleteIfEmpty(key, entry)
+	}
+
+	return items, nil
+}
+
+
+/* Pops up to count members from the first non empty sorted set of keys, returns the key popped from */
+func (store *db) ZMPOP(keys []string, count int, max bool) (string, []zsetItem, error) {
+	if store == nil {
+		fmt.Println("ZMPOP : store is nil")
+		return "", nil, errors.New(fmt.Sprint("ZMPOP : store is nil"))
+	}
+
+	for _, key := range keys {
+		items, errRet := store.ZPOP(key, count, max)
+
+		if errRet == nil && len(items) > 0 {
+			return key, items, nil
+		}
+	}
+
+	return "", []zsetItem{}, errors.New(fmt.Sprint("ZMPOP : keys ", keys, " empty"))
+}
+
+
+/*
+  Scan the sorted set from cursor, returns the next cursor and up to count members
+  The cursor is the rank of the next member in score order, 0 starts and ends the iteration.
+  Members are returned in score order, members removed behind the cursor during the
+  iteration shift the ranks and may cause members to be skipped.
+*/
+func (store *db) ZSCAN(key string, cursor int, pattern string, count int) (int, []zsetItem, error) {
+	if store == nil {
+		fmt.Println("ZSCAN : store is nil")
+		return 0, nil, errors.New(fmt.Sprint("ZSCAN : store is nil"))
+	}
+
+	items := []zsetItem{}
+
+	/* Take Global Read lock to hold delete or ZRANGESTORE replacing the key until operation is finished */
+	store.setmapDBLock.RLock()
+	defer store.setmapDBLock.RUnlock()
+
+	entry, ok := store.setmapEntry[key]
+
+	if ok == false {
+		return 0, items, errors.New(fmt.Sprint("ZSCAN : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	/* Rank lookup of the cursor is O(log n), then walk count members */
+	x := entry.zsl.getElementByRank(cursor + 1)
+
+	for ; x != nil && count > 0; count-- {
+		if pattern == "" || stringMatch(pattern, x.member) {
+			items = append(items, zsetItem{member: x.member, score: x.score})
+		}
+		cursor++
+		x = x.level[0].forward
+	}
+
+	if x == nil {
+		cursor = 0
+	}
+
+	return cursor, items, nil
+}
+
+
+/*
+  Random members of sorted set
+  count > 0 returns up to count distinct members, count < 0 returns -count members which may repeat
+*/
+func (store *db) ZRANDMEMBER(key string, count int) ([]zsetItem, error) {
+	if store == nil {
+		fmt.Println("ZRANDMEMBER : store is nil")
+		return nil, errors.New(fmt.Sprint("ZRANDMEMBER : store is nil"))
+	}
+
+	items := []zsetItem{}
+
+	/* Take Global Read lock to hold delete or ZRANGESTORE replacing the key until operation is finished */
+	store.setmapDBLock.RLock()
+	defer store.setmapDBLock.RUnlock()
+
+	entry, ok := store.setmapEntry[key]
+
+	if ok == false {
+		return items, errors.New(fmt.Sprint("ZRANDMEMBER : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	size := entry.zsl.length
+
+	/* Emptied by other routine which has not deleted the key yet */
+	if size == 0 {
+		return items, nil
+	}
+
+	/* Repetition allowed, every member is a random rank lookup */
+	if count < 0 {
+		for i := 0; i < -count; i++ {
+			x := entry.zsl.getElementByRank(rand.Intn(size) + 1)
+			items = append(items, zsetItem{member: x.member, score: x.score})
+		}
+		return items, nil
+	}
+
+	/* Distinct members, count covers the whole set */
+	if count >= size {
+		for x := entry.zsl.first(); x != nil; x = x.level[0].forward {
+			items = append(items, zsetItem{member: x.member, score: x.score})
+		}
+		return items, nil
+	}
+
+	/* Distinct members, pick random ranks, or random ranks to leave out when count is close to size */
+	if count * 3 > size {
+		left := make(map[int]bool)
+		for len(left) < size - count {
+			left[rand.Intn(size) + 1] = true
+		}
+
+		rank := 1
+		for x := entry.zsl.first(); x != nil; x = x.level[0].forward {
+			if left[rank] == false {
+				items = append(items, zsetItem{member: x.member, score: x.score})
+			}
+			rank++
+		}
+
+		return items, nil
+	}
+
+	picked := make(map[int]bool)
+	for len(picked) < count {
+		picked[rand.Intn(size) + 1] = true
+	}
+
+	for rank := range picked {
+		x := entry.zsl.getElementByRank(rank)
+		items = append(items, zsetItem{member: x.member, score: x.score})
+	}
+
+	return items, nil
+}
@@ -11,17 +11,42 @@ import (
 	 "fmt"
 	 "errors"
 	 "bytes"
+	 "encoding/hex"
+	 "encoding/binary"
 	 "sync"
-	 "github.com/emirpasic/gods/sets/treeset"
 )
 
 func (store *db) Save(filename string) (bool){
 
 	 store.mapDBLock.Lock()
 	 store.setmapDBLock.Lock()
+	 store.listmapDBLock.Lock()
+	 store.hashmapDBLock.Lock()
+	 store.smapDBLock.Lock()
+	 store.streammapDBLock.Lock()
+	 store.jsonmapDBLock.Lock()
+	 store.bfmapDBLock.Lock()
+	 store.cfmapDBLock.Lock()
+	 store.cmsmapDBLock.Lock()
+	 store.topkmapDBLock.Lock()
+	 store.tdigestmapDBLock.Lock()
+	 store.tsmapDBLock.Lock()
+	 store.ftmapDBLock.Lock()
 
 	 defer store.mapDBLock.Unlock()
 	 defer store.setmapDBLock.Unlock()
+	 defer store.listmapDBLock.Unlock()
+	 defer store.hashmapDBLock.Unlock()
+	 defer store.smapDBLock.Unlock()
+	 defer store.streammapDBLock.Unlock()
+	 defer store.jsonmapDBLock.Unlock()
+	 defer store.bfmapDBLock.Unlock()
+	 defer store.cfmapDBLock.Unlock()
+	 defer store.cmsmapDBLock.Unlock()
+	 defer store.topkmapDBLock.Unlock()
+	 defer store.tdigestmapDBLock.Unlock()
+	 defer store.tsmapDBLock.Unlock()
+	 defer store.ftmapDBLock.Unlock()
 	 
          // create a file
          dataFile, err := os.Create(filename)
@@ -34,7 +59,7 @@ func (store *db) Save(filename string) (bool){
          enc := gob.NewEncoder(dataFile)
          err = enc.Encode(store)
 	 if err != nil {
-		fmt.Printf("Save Encode error : ", err)
+		fmt.Println("Save Encode error : ", err)
 		return false
 	}
 
@@ -47,9 +72,33 @@ func (store *db) Load(filename string) (bool){
          
 	 store.mapDBLock.Lock()
 	 store.setmapDBLock.Lock()
+	 store.listmapDBLock.Lock()
+	 store.hashmapDBLock.Lock()
+	 store.smapDBLock.Lock()
+	 store.streammapDBLock.Lock()
+	 store.jsonmapDBLock.Lock()
+	 store.bfmapDBLock.Lock()
+	 store.cfmapDBLock.Lock()
+	 store.cmsmapDBLock.Lock()
+	 store.topkmapDBLock.Lock()
+	 store.tdigestmapDBLock.Lock()
+	 store.tsmapDBLock.Lock()
+	 store.ftmapDBLock.Lock()
 
 	 defer store.mapDBLock.Unlock()
 	 defer store.setmapDBLock.Unlock()
+	 defer store.listmapDBLock.Unlock()
+	 defer store.hashmapDBLock.Unlock()
+	 defer store.smapDBLock.Unlock()
+	 defer store.streammapDBLock.Unlock()
+	 defer store.jsonmapDBLock.Unlock()
+	 defer store.bfmapDBLock.Unlock()
+	 defer store.cfmapDBLock.Unlock()
+	 defer store.cmsmapDBLock.Unlock()
+	 defer store.topkmapDBLock.Unlock()
+	 defer store.tdigestmapDBLock.Unlock()
+	 defer store.tsmapDBLock.Unlock()
+	 defer store.ftmapDBLock.Unlock()
 	 
 	 // open data file
          dataFile, err := os.Open(filename)
@@ -89,66 +138,435 @@ func (store *db) MarshalBinary() ([]byte, error) {
 		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.setmapEntry nil"))
 	}
 
+	if store.listmapEntry == nil {
+		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.listmapEntry nil"))
+	}
+
+	if store.hashmapEntry == nil {
+		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.hashmapEntry nil"))
+	}
+
+	if store.smapEntry == nil {
+		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.smapEntry nil"))
+	}
+
+	if store.streammapEntry == nil {
+		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.streammapEntry nil"))
+	}
+
+	if store.jsonmapEntry == nil {
+		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.jsonmapEntry nil"))
+	}
+
+	if store.bfmapEntry == nil {
+		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.bfmapEntry nil"))
+	}
+
+	if store.cfmapEntry == nil {
+		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.cfmapEntry nil"))
+	}
+
+	if store.cmsmapEntry == nil {
+		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.cmsmapEntry nil"))
+	}
+
+	if store.topkmapEntry == nil {
+		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.topkmapEntry nil"))
+	}
+
+	if store.tdigestmapEntry == nil {
+		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.tdigestmapEntry nil"))
+	}
+
+	if store.tsmapEntry == nil {
+		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.tsmapEntry nil"))
+	}
+
+	if store.ftmapEntry == nil {
+		return nil, errors.New(fmt.Sprintf("MarshalBinary : store.ftmapEntry nil"))
+	}
+
 	var b bytes.Buffer
 	
 	//Marshal mapEntry
-	if len(store.mapEntry) != 0 {
-		fmt.Fprintln(&b, len(store.mapEntry))
+	fmt.Fprintln(&b, len(store.mapEntry))
 		
-		for key,value := range store.mapEntry {
-			fmt.Fprintln(&b, key)
+	for key,value := range store.mapEntry {
+		fmt.Fprintln(&b, key)
 
-			//Marshal mapData.val
-			if value != nil {
-				fmt.Fprintln(&b, value.val)
-				fmt.Fprintln(&b, value.Expiration)
-				
-				//Marshal mapData.lock skipped - not required
-			} else {
-				return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.mapEntry key :  %v value : nil", key))
-			}
+		//Marshal mapData.val
+		if value != nil {
+			fmt.Fprintln(&b, value.val)
+			fmt.Fprintln(&b, value.Expiration)
+			
+			//Marshal mapData.lock skipped - not required
+		} else {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.mapEntry key :  %v value : nil", key))
 		}
-
-		
 	}
 
 	//Marshal mapDBLock skipped - not required
 	fmt.Fprintln(&b, len(store.setmapEntry))
 
 	//Marshal setmapEntry
-	if len(store.setmapEntry) != 0 {
-		
-		
-		for key,value := range store.setmapEntry {
-			fmt.Fprintln(&b, key)
+	for key,value := range store.setmapEntry {
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.setmapEntry key :  %v value : nill", key))
+		}
+
+		fmt.Fprintln(&b, key)
 			
-			//Marshal setmapData.setEntry
-				fmt.Fprintln(&b, len(value.setEntry))
+		//Marshal setmapData.zsl as member score pairs in score order, score in shortest lossless form
+		fmt.Fprintln(&b, value.zsl.length)
 
-				if value != nil {
+		for x := value.zsl.first(); x != nil; x = x.level[0].forward {
+			fmt.Fprintln(&b, x.member)
+			fmt.Fprintln(&b, formatScore(x.score))
+		}
 
-					for key2,value2 := range value.setEntry {
-						fmt.Fprintln(&b, key2)
+		//Marshal setmapData.dict skipped - rebuilt from zsl
+		//Marshal setmapData.lock skipped - not required
+	}
 
-						if value2 != nil {
+	//Marshal setmapLock skipped - not required
 
-							fmt.Fprintln(&b, value2.Size())
-							for _, v := range value2.Values() {
-								fmt.Fprintln(&b, fmt.Sprintf("%v",v))
-							}
-						} else {
-							return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.setmapEntry key : %v setEntry key2 : %v value2 : nill", key, key2))
-						}
-					}
-				} else {
-					return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.setmapEntry key :  %v value : nill", key))
+	//Marshal listmapEntry
+	fmt.Fprintln(&b, len(store.listmapEntry))
+
+	for key,value := range store.listmapEntry {
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.listmapEntry key :  %v value : nill", key))
+		}
+
+		fmt.Fprintln(&b, key)
+
+		//Marshal listmapData.ql as elements from head to tail
+		fmt.Fprintln(&b, value.ql.length)
+
+		for _, v := range value.ql.values() {
+			fmt.Fprintln(&b, v)
+		}
+
+		//Marshal listmapData.lock skipped - not required
+	}
+
+	//Marshal listmapDBLock skipped - not required
+
+	//Marshal hashmapEntry
+	fmt.Fprintln(&b, len(store.hashmapEntry))
+
+	for key,value := range store.hashmapEntry {
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.hashmapEntry key :  %v value : nill", key))
+		}
+
+		fmt.Fprintln(&b, key)
+
+		//Marshal hashmapData as field value expiration triples, encoding is rebuilt on load
+		pairs := value.pairs()
+		fmt.Fprintln(&b, len(pairs) / 2)
+
+		for k := 0; k < len(pairs); k = k + 2 {
+			fmt.Fprintln(&b, pairs[k])
+			fmt.Fprintln(&b, pairs[k+1])
+			fmt.Fprintln(&b, value.expires[pairs[k]])
+		}
+
+		//Marshal hashmapData.lock skipped - not required
+	}
+
+	//Marshal hashmapDBLock skipped - not required
+
+	//Marshal smapEntry
+	fmt.Fprintln(&b, len(store.smapEntry))
+
+	for key,value := range store.smapEntry {
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.smapEntry key :  %v value : nill", key))
+		}
+
+		fmt.Fprintln(&b, key)
+
+		//Marshal smapData as members, encoding is rebuilt on load
+		fmt.Fprintln(&b, value.length())
+
+		for _, v := range value.members() {
+			fmt.Fprintln(&b, v)
+		}
+
+		//Marshal smapData.lock skipped - not required
+	}
+
+	//Marshal smapDBLock skipped - not required
+
+	//Marshal streammapEntry
+	fmt.Fprintln(&b, len(store.streammapEntry))
+
+	for key,value := range store.streammapEntry {
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.streammapEntry key :  %v value : nill", key))
+		}
+
+		fmt.Fprintln(&b, key)
+
+		//Marshal stream.lastID, IDs never go back after load even if the last entries got deleted
+		fmt.Fprintln(&b, value.st.lastID)
+
+		//Marshal stream entries as ID, number of fields and the field value pairs, nodes are rebuilt on load
+		fmt.Fprintln(&b, value.st.length)
+
+		for _, entry := range value.st.rangeEntries(streamID{}, value.st.lastID, 0, false) {
+			fmt.Fprintln(&b, entry.id)
+			fmt.Fprintln(&b, len(entry.fields))
+
+			for _, f := range entry.fields {
+				fmt.Fprintln(&b, f)
+			}
+		}
+
+		//Marshal stream groups as name, lastID and consumers, the group pel is the union of the consumer pels
+		fmt.Fprintln(&b, len(value.st.groups))
+
+		for name, g := range value.st.groups {
+			fmt.Fprintln(&b, name)
+			fmt.Fprintln(&b, g.lastID)
+			fmt.Fprintln(&b, len(g.consumers))
+
+			for cname, c := range g.consumers {
+				fmt.Fprintln(&b, cname)
+				fmt.Fprintln(&b, c.seenTime)
+				fmt.Fprintln(&b, c.activeTime)
+				fmt.Fprintln(&b, len(c.pel))
+
+				for id, nack := range c.pel {
+					fmt.Fprintln(&b, id)
+					fmt.Fprintln(&b, nack.deliveryTime)
+					fmt.Fprintln(&b, nack.deliveryCount)
 				}
+			}
+		}
+
+		//Marshal streammapData.lock skipped - not required
+	}
+
+	//Marshal streammapDBLock skipped - not required
 
-			//Marshal setmapData.lock skipped - not required
+	//Marshal jsonmapEntry
+	fmt.Fprintln(&b, len(store.jsonmapEntry))
+
+	for key,value := range store.jsonmapEntry {
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.jsonmapEntry key :  %v value : nill", key))
 		}
+
+		fmt.Fprintln(&b, key)
+
+		//Marshal jsonmapData.doc as quoted JSON text, the text may have spaces
+		fmt.Fprintf(&b, "%q\n", jsonMarshal(value.doc))
+
+		//Marshal jsonmapData.lock skipped - not required
 	}
 
-	//Marshal setmapLock skipped - not required
+	//Marshal jsonmapDBLock skipped - not required
+
+	//Marshal bfmapEntry
+	fmt.Fprintln(&b, len(store.bfmapEntry))
+
+	for key,value := range store.bfmapEntry {
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.bfmapEntry key :  %v value : nill", key))
+		}
+
+		fmt.Fprintln(&b, key)
+
+		//Marshal bloomFilter settings, error rate expansion nonScaling and the number of layers
+		fmt.Fprintln(&b, value.bf.errorRate, value.bf.expansion, value.bf.nonScaling, len(value.bf.layers))
+
+		//Marshal bloomLayer as nbits hashes capacity count and the bits as hex
+		for _, layer := range value.bf.layers {
+			fmt.Fprintln(&b, layer.nbits, layer.hashes, layer.capacity, layer.count)
+			fmt.Fprintln(&b, hex.EncodeToString(layer.bits))
+		}
+
+		//Marshal bfmapData.lock skipped - not required
+	}
+
+	//Marshal bfmapDBLock skipped - not required
+
+	//Marshal cfmapEntry
+	fmt.Fprintln(&b, len(store.cfmapEntry))
+
+	for key,value := range store.cfmapEntry {
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.cfmapEntry key :  %v value : nill", key))
+		}
+
+		fmt.Fprintln(&b, key)
+
+		//Marshal cuckooFilter settings, bucket size max iterations expansion and the number of sub filters
+		fmt.Fprintln(&b, value.cf.bucketSize, value.cf.maxIterations, value.cf.expansion, len(value.cf.subs))
+
+		//Marshal cuckooSub as numBuckets count and the buckets as hex
+		for _, sub := range value.cf.subs {
+			fmt.Fprintln(&b, sub.numBuckets, sub.count)
+			fmt.Fprintln(&b, hex.EncodeToString(sub.buckets))
+		}
+
+		//Marshal cfmapData.lock skipped - not required
+	}
+
+	//Marshal cfmapDBLock skipped - not required
+
+	//Marshal cmsmapEntry
+	fmt.Fprintln(&b, len(store.cmsmapEntry))
+
+	for key,value := range store.cmsmapEntry {
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.cmsmapEntry key :  %v value : nill", key))
+		}
+
+		fmt.Fprintln(&b, key)
+		fmt.Fprintln(&b, value.cms.width, value.cms.depth, value.cms.count)
+
+		//Marshal countMinSketch.counters as hex of 8 byte big endian counters
+		counters := make([]byte, 8 * len(value.cms.counters))
+		for i, c := range value.cms.counters {
+			binary.BigEndian.PutUint64(counters[8*i:], c)
+		}
+		fmt.Fprintln(&b, hex.EncodeToString(counters))
+
+		//Marshal cmsmapData.lock skipped - not required
+	}
+
+	//Marshal cmsmapDBLock skipped - not required
+
+	//Marshal topkmapEntry
+	fmt.Fprintln(&b, len(store.topkmapEntry))
+
+	for key,value := range store.topkmapEntry {
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.topkmapEntry key :  %v value : nill", key))
+		}
+
+		fmt.Fprintln(&b, key)
+		fmt.Fprintln(&b, value.tk.k, value.tk.width, value.tk.depth, value.tk.decay)
+
+		//Marshal topK.buckets as hex of 4 byte big endian fingerprint and count
+		buckets := make([]byte, 8 * len(value.tk.buckets))
+		for i, bucket := range value.tk.buckets {
+			binary.BigEndian.PutUint32(buckets[8*i:], bucket.fp)
+			binary.BigEndian.PutUint32(buckets[8*i+4:], bucket.count)
+		}
+		fmt.Fprintln(&b, hex.EncodeToString(buckets))
+
+		//Marshal topK.list as item and count
+		fmt.Fprintln(&b, len(value.tk.list))
+
+		for _, item := range value.tk.list {
+			fmt.Fprintln(&b, item.item)
+			fmt.Fprintln(&b, item.count)
+		}
+
+		//Marshal topkmapData.lock skipped - not required
+	}
+
+	//Marshal topkmapDBLock skipped - not required
+
+	//Marshal tdigestmapEntry
+	fmt.Fprintln(&b, len(store.tdigestmapEntry))
+
+	for key,value := range store.tdigestmapEntry {
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.tdigestmapEntry key :  %v value : nill", key))
+		}
+
+		fmt.Fprintln(&b, key)
+		fmt.Fprintln(&b, value.td.compression, value.td.count, value.td.min, value.td.max)
+
+		//Marshal tDigest.centroids as mean and weight
+		fmt.Fprintln(&b, len(value.td.centroids))
+
+		for _, c := range value.td.centroids {
+			fmt.Fprintln(&b, c.mean, c.weight)
+		}
+
+		//Marshal tdigestmapData.lock skipped - not required
+	}
+
+	//Marshal tdigestmapDBLock skipped - not required
+
+	//Marshal tsmapEntry
+	fmt.Fprintln(&b, len(store.tsmapEntry))
+
+	for key,value := range store.tsmapEntry {
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.tsmapEntry key :  %v value : nill", key))
+		}
+
+		series := value.ts
+
+		fmt.Fprintln(&b, key)
+		fmt.Fprintln(&b, series.retention, series.chunkSize, series.duplicatePolicy)
+
+		//Marshal timeSeries.labels as label and value
+		fmt.Fprintln(&b, len(series.labels))
+
+		for l, v := range series.labels {
+			fmt.Fprintln(&b, l, v)
+		}
+
+		//Marshal timeSeries.chunks as sample count and hex of the compressed samples
+		fmt.Fprintln(&b, len(series.chunks))
+
+		for _, c := range series.chunks {
+			fmt.Fprintln(&b, c.count)
+			fmt.Fprintln(&b, hex.EncodeToString(c.data))
+		}
+
+		//Marshal timeSeries.rules with the open bucket
+		fmt.Fprintln(&b, len(series.rules))
+
+		for _, rule := range series.rules {
+			fmt.Fprintln(&b, rule.dst, rule.agg, rule.bucket, rule.align, rule.open, rule.bucketStart)
+			fmt.Fprintln(&b, rule.acc.count, rule.acc.sum, rule.acc.min, rule.acc.max, rule.acc.first, rule.acc.last, rule.acc.mean, rule.acc.m2)
+		}
+
+		//Marshal timeSeries.srcKey skipped - set from the rules of the source on load
+
+		//Marshal tsmapData.lock skipped - not required
+	}
+
+	//Marshal tsmapDBLock skipped - not required
+
+	//Marshal ftmapEntry, index definitions only
+	fmt.Fprintln(&b, len(store.ftmapEntry))
+
+	for name,idx := range store.ftmapEntry {
+		if idx == nil {
+			return nil, errors.New(fmt.Sprintf("MarshalBinary : err store.ftmapEntry name :  %v value : nill", name))
+		}
+
+		fmt.Fprintln(&b, name)
+
+		fmt.Fprintln(&b, len(idx.prefixes))
+		for _, prefix := range idx.prefixes {
+			fmt.Fprintln(&b, prefix)
+		}
+
+		fmt.Fprintln(&b, len(idx.fields))
+		for _, f := range idx.fields {
+			fmt.Fprintln(&b, f.name, f.kind, f.weight, f.separator, f.sortable)
+			if f.kind == "VECTOR" {
+				fmt.Fprintln(&b, f.algorithm, f.vecType, f.dim, f.metric, f.m, f.efConstruction, f.efRuntime)
+			}
+		}
+
+		//Marshal ftIndex documents skipped - built again from the hashes on load
+
+		//Marshal ftIndex.lock skipped - not required
+	}
+
+	//Marshal ftmapDBLock skipped - not required
 
 	return b.Bytes(), nil
 }
@@ -176,6 +594,54 @@ func (store *db) UnmarshalBinary(data []byte) error {
 		return errors.New(fmt.Sprintf("UnmarshalBinary : store.setmapEntry nil"))
 	}
 
+	if store.listmapEntry == nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : store.listmapEntry nil"))
+	}
+
+	if store.hashmapEntry == nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : store.hashmapEntry nil"))
+	}
+
+	if store.smapEntry == nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : store.smapEntry nil"))
+	}
+
+	if store.streammapEntry == nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : store.streammapEntry nil"))
+	}
+
+	if store.jsonmapEntry == nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : store.jsonmapEntry nil"))
+	}
+
+	if store.bfmapEntry == nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : store.bfmapEntry nil"))
+	}
+
+	if store.cfmapEntry == nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : store.cfmapEntry nil"))
+	}
+
+	if store.cmsmapEntry == nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : store.cmsmapEntry nil"))
+	}
+
+	if store.topkmapEntry == nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : store.topkmapEntry nil"))
+	}
+
+	if store.tdigestmapEntry == nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : store.tdigestmapEntry nil"))
+	}
+
+	if store.tsmapEntry == nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : store.tsmapEntry nil"))
+	}
+
+	if store.ftmapEntry == nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : store.ftmapEntry nil"))
+	}
+
 	b := bytes.NewBuffer(data)
 
 	//UnMarshal mapEntry
@@ -226,7 +692,6 @@ func (store *db) UnmarshalBinary(data []byte) error {
 	for i:= 0 ; i<len; i++ {
 		var key string
 		var len2 int
-		var key2 int
 		
 		_, err = fmt.Fscanln(b, &key)
 		if err != nil {
@@ -235,41 +700,36 @@ func (store *db) UnmarshalBinary(data []byte) error {
 
 		_, err = fmt.Fscanln(b, &len2)
 		if err != nil {
-			return errors.New(fmt.Sprintf("UnmarshalBinary : setmapEntry key : %v setEntry len2 nil", key))
+			return errors.New(fmt.Sprintf("UnmarshalBinary : setmapEntry key : %v zsl len2 nil", key))
 		}
 
 		setmapEntry := &setmapData{
-					setEntry: make(map[int]*treeset.Set),
+					dict: make(map[string]float64),
+					zsl: newZskiplist(),
 					lock: &sync.RWMutex{},
 					}
 
 		for k:=0; k<len2; k++ {
+			var member string
+			var scoreStr string
 
-			_, err = fmt.Fscanln(b, &key2)
+			_, err = fmt.Fscanln(b, &member)
 			if err != nil {
-				return errors.New(fmt.Sprintf("UnmarshalBinary : setmapEntry key : %v setEntry len2 : %v key nil", key, len2))
+				return errors.New(fmt.Sprintf("UnmarshalBinary : setmapEntry key : %v zsl len2 : %v member nil for cursetIndex : %v", key, len2, k))
 			}
 
-			
-
-			setmapEntry.setEntry[key2] = treeset.NewWithStringComparator()
-
-			var setlen int = 0
-			_, err = fmt.Fscanln(b, &setlen)
+			_, err = fmt.Fscanln(b, &scoreStr)
 			if err != nil {
-				return errors.New(fmt.Sprintf("UnmarshalBinary : setmapEntry key : %v setEntry len nil", key))
+				return errors.New(fmt.Sprintf("UnmarshalBinary : setmapEntry key : %v member : %v score nil", key, member))
 			}
-			
-			for j:=0; j< setlen; j++ {
-				var val string
-				_, err = fmt.Fscanln(b, &val)
-				if err != nil {
-					return errors.New(fmt.Sprintf("UnmarshalBinary : setmapEntry key : %v setEntry len : %v key2 : %v value nil for cursetIndex : %v ", key, setlen, key2, j))
-				}
 
-				setmapEntry.setEntry[key2].Add(val)
+			score, errScore := parseScore(scoreStr)
+			if errScore != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : setmapEntry key : %v member : %v score %v invalid", key, member, scoreStr))
 			}
-					
+
+			setmapEntry.zsl.insert(score, member)
+			setmapEntry.dict[member] = score
 		}
 		//UnMarshal setmapData.lock skipped - not required
 
@@ -279,5 +739,802 @@ func (store *db) UnmarshalBinary(data []byte) error {
 
 	//UnMarshal setmapDBLock skipped - not required
 
+	//UnMarshal listmapEntry
+	len = 0
+	_, err = fmt.Fscanln(b, &len)
+	if err != nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : listmapEntry len nil"))
+	}
+
+	for i:= 0 ; i<len; i++ {
+		var key string
+		var len2 int
+
+		_, err = fmt.Fscanln(b, &key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : listmapEntry key nil"))
+		}
+
+		_, err = fmt.Fscanln(b, &len2)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : listmapEntry key : %v ql len2 nil", key))
+		}
+
+		listmapEntry := &listmapData{
+					ql: newQuicklist(),
+					lock: &sync.RWMutex{},
+					}
+
+		for k:=0; k<len2; k++ {
+			var val string
+
+			_, err = fmt.Fscanln(b, &val)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : listmapEntry key : %v ql len2 : %v value nil for curIndex : %v", key, len2, k))
+			}
+
+			listmapEntry.ql.pushTail(val)
+		}
+		//UnMarshal listmapData.lock skipped - not required
+
+		store.listmapEntry[key] = listmapEntry
+	}
+
+	//UnMarshal listmapDBLock skipped - not required
+
+	//UnMarshal hashmapEntry
+	len = 0
+	_, err = fmt.Fscanln(b, &len)
+	if err != nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : hashmapEntry len nil"))
+	}
+
+	for i:= 0 ; i<len; i++ {
+		var key string
+		var len2 int
+
+		_, err = fmt.Fscanln(b, &key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : hashmapEntry key nil"))
+		}
+
+		_, err = fmt.Fscanln(b, &len2)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : hashmapEntry key : %v len2 nil", key))
+		}
+
+		hashmapEntry := newHashmapData()
+
+		for k:=0; k<len2; k++ {
+			var field string
+			var val string
+			var e int64
+
+			_, err = fmt.Fscanln(b, &field)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : hashmapEntry key : %v len2 : %v field nil for curIndex : %v", key, len2, k))
+			}
+
+			_, err = fmt.Fscanln(b, &val)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : hashmapEntry key : %v field : %v value nil", key, field))
+			}
+
+			_, err = fmt.Fscanln(b, &e)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : hashmapEntry key : %v field : %v expiration nil", key, field))
+			}
+
+			hashmapEntry.set(field, val)
+
+			//Expired fields are loaded and removed by the caretaker, same as mapEntry
+			if e > 0 {
+				hashmapEntry.expire(field, e)
+			}
+		}
+		//UnMarshal hashmapData.lock skipped - not required
+
+		store.hashmapEntry[key] = hashmapEntry
+	}
+
+	//UnMarshal hashmapDBLock skipped - not required
+
+	//UnMarshal smapEntry
+	len = 0
+	_, err = fmt.Fscanln(b, &len)
+	if err != nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : smapEntry len nil"))
+	}
+
+	for i:= 0 ; i<len; i++ {
+		var key string
+		var len2 int
+
+		_, err = fmt.Fscanln(b, &key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : smapEntry key nil"))
+		}
+
+		_, err = fmt.Fscanln(b, &len2)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : smapEntry key : %v len2 nil", key))
+		}
+
+		smapEntry := newSmapData()
+
+		for k:=0; k<len2; k++ {
+			var member string
+
+			_, err = fmt.Fscanln(b, &member)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : smapEntry key : %v len2 : %v member nil for curIndex : %v", key, len2, k))
+			}
+
+			smapEntry.add(member)
+		}
+		//UnMarshal smapData.lock skipped - not required
+
+		store.smapEntry[key] = smapEntry
+	}
+
+	//UnMarshal smapDBLock skipped - not required
+
+	//UnMarshal streammapEntry
+	len = 0
+	_, err = fmt.Fscanln(b, &len)
+	if err != nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry len nil"))
+	}
+
+	for i:= 0 ; i<len; i++ {
+		var key string
+		var lastID string
+		var len2 int
+
+		_, err = fmt.Fscanln(b, &key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key nil"))
+		}
+
+		_, err = fmt.Fscanln(b, &lastID)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v lastID nil", key))
+		}
+
+		_, err = fmt.Fscanln(b, &len2)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v len2 nil", key))
+		}
+
+		streammapEntry := &streammapData{
+					st: newStream(),
+					lock: &sync.RWMutex{},
+					}
+
+		for k:=0; k<len2; k++ {
+			var idStr string
+			var num int
+
+			_, err = fmt.Fscanln(b, &idStr)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v len2 : %v id nil for curIndex : %v", key, len2, k))
+			}
+
+			id, errID := parseStreamID(idStr, 0)
+			if errID != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v id %v invalid", key, idStr))
+			}
+
+			_, err = fmt.Fscanln(b, &num)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v id : %v fields len nil", key, idStr))
+			}
+
+			fields := make([]string, num)
+			for f:=0; f<num; f++ {
+				_, err = fmt.Fscanln(b, &fields[f])
+				if err != nil {
+					return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v id : %v field nil", key, idStr))
+				}
+			}
+
+			streammapEntry.st.append(id, fields)
+		}
+
+		streammapEntry.st.lastID, err = parseStreamID(lastID, 0)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v lastID %v invalid", key, lastID))
+		}
+
+		var numGroups int
+		_, err = fmt.Fscanln(b, &numGroups)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v groups len nil", key))
+		}
+
+		if numGroups > 0 {
+			streammapEntry.st.groups = make(map[string]*streamGroup)
+		}
+
+		for k:=0; k<numGroups; k++ {
+			var name, groupID string
+			var numConsumers int
+
+			_, err = fmt.Fscanln(b, &name)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v group name nil", key))
+			}
+
+			_, err = fmt.Fscanln(b, &groupID)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v group : %v lastID nil", key, name))
+			}
+
+			id, errID := parseStreamID(groupID, 0)
+			if errID != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v group : %v lastID %v invalid", key, name, groupID))
+			}
+
+			_, err = fmt.Fscanln(b, &numConsumers)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v group : %v consumers len nil", key, name))
+			}
+
+			g := newStreamGroup(name, id)
+
+			for c:=0; c<numConsumers; c++ {
+				var cname string
+				var numPending int
+
+				_, err = fmt.Fscanln(b, &cname)
+				if err != nil {
+					return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v group : %v consumer name nil", key, name))
+				}
+
+				consumer := g.consumer(cname, true)
+
+				_, err = fmt.Fscanln(b, &consumer.seenTime)
+				if err != nil {
+					return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v group : %v consumer : %v seenTime nil", key, name, cname))
+				}
+
+				_, err = fmt.Fscanln(b, &consumer.activeTime)
+				if err != nil {
+					return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v group : %v consumer : %v activeTime nil", key, name, cname))
+				}
+
+				_, err = fmt.Fscanln(b, &numPending)
+				if err != nil {
+					return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v group : %v consumer : %v pel len nil", key, name, cname))
+				}
+
+				for p:=0; p<numPending; p++ {
+					var idStr string
+					nack := &streamNACK{consumer: consumer}
+
+					_, err = fmt.Fscanln(b, &idStr)
+					if err != nil {
+						return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v group : %v consumer : %v pending id nil", key, name, cname))
+					}
+
+					pid, errID := parseStreamID(idStr, 0)
+					if errID != nil {
+						return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v group : %v pending id %v invalid", key, name, idStr))
+					}
+
+					_, err = fmt.Fscanln(b, &nack.deliveryTime)
+					if err != nil {
+						return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v group : %v pending id : %v deliveryTime nil", key, name, idStr))
+					}
+
+					_, err = fmt.Fscanln(b, &nack.deliveryCount)
+					if err != nil {
+						return errors.New(fmt.Sprintf("UnmarshalBinary : streammapEntry key : %v group : %v pending id : %v deliveryCount nil", key, name, idStr))
+					}
+
+					g.pel[pid] = nack
+					consumer.pel[pid] = nack
+				}
+			}
+
+			streammapEntry.st.groups[name] = g
+		}
+
+		//UnMarshal streammapData.lock skipped - not required
+
+		store.streammapEntry[key] = streammapEntry
+	}
+
+	//UnMarshal streammapDBLock skipped - not required
+
+	//UnMarshal jsonmapEntry
+	len = 0
+	_, err = fmt.Fscanln(b, &len)
+	if err != nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : jsonmapEntry len nil"))
+	}
+
+	for i:= 0 ; i<len; i++ {
+		var key string
+		var text string
+
+		_, err = fmt.Fscanln(b, &key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : jsonmapEntry key nil"))
+		}
+
+		_, err = fmt.Fscanf(b, "%q\n", &text)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : jsonmapEntry key : %v doc nil", key))
+		}
+
+		doc, errDoc := jsonParse(text)
+		if errDoc != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : jsonmapEntry key : %v doc invalid", key))
+		}
+
+		//UnMarshal jsonmapData.lock skipped - not required
+
+		store.jsonmapEntry[key] = &jsonmapData{
+					doc: doc,
+					lock: &sync.RWMutex{},
+					}
+	}
+
+	//UnMarshal jsonmapDBLock skipped - not required
+
+	//UnMarshal bfmapEntry
+	len = 0
+	_, err = fmt.Fscanln(b, &len)
+	if err != nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : bfmapEntry len nil"))
+	}
+
+	for i:= 0 ; i<len; i++ {
+		var key string
+		var numLayers int
+		bf := &bloomFilter{}
+
+		_, err = fmt.Fscanln(b, &key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : bfmapEntry key nil"))
+		}
+
+		_, err = fmt.Fscanln(b, &bf.errorRate, &bf.expansion, &bf.nonScaling, &numLayers)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : bfmapEntry key : %v settings nil", key))
+		}
+
+		for k:=0; k<numLayers; k++ {
+			var bits string
+			layer := &bloomLayer{}
+
+			_, err = fmt.Fscanln(b, &layer.nbits, &layer.hashes, &layer.capacity, &layer.count)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : bfmapEntry key : %v layer nil for curIndex : %v", key, k))
+			}
+
+			_, err = fmt.Fscanln(b, &bits)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : bfmapEntry key : %v layer bits nil for curIndex : %v", key, k))
+			}
+
+			layer.bits, err = hex.DecodeString(bits)
+			if err != nil || uint64(cap(layer.bits)) * 8 < layer.nbits {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : bfmapEntry key : %v layer bits invalid for curIndex : %v", key, k))
+			}
+
+			bf.layers = append(bf.layers, layer)
+		}
+
+		if numLayers == 0 {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : bfmapEntry key : %v layers nil", key))
+		}
+
+		//UnMarshal bfmapData.lock skipped - not required
+
+		store.bfmapEntry[key] = &bfmapData{
+					bf: bf,
+					lock: &sync.RWMutex{},
+					}
+	}
+
+	//UnMarshal bfmapDBLock skipped - not required
+
+	//UnMarshal cfmapEntry
+	len = 0
+	_, err = fmt.Fscanln(b, &len)
+	if err != nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : cfmapEntry len nil"))
+	}
+
+	for i:= 0 ; i<len; i++ {
+		var key string
+		var numSubs int
+		cf := &cuckooFilter{}
+
+		_, err = fmt.Fscanln(b, &key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : cfmapEntry key nil"))
+		}
+
+		_, err = fmt.Fscanln(b, &cf.bucketSize, &cf.maxIterations, &cf.expansion, &numSubs)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : cfmapEntry key : %v settings nil", key))
+		}
+
+		for k:=0; k<numSubs; k++ {
+			var buckets string
+			sub := &cuckooSub{}
+
+			_, err = fmt.Fscanln(b, &sub.numBuckets, &sub.count)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : cfmapEntry key : %v sub filter nil for curIndex : %v", key, k))
+			}
+
+			_, err = fmt.Fscanln(b, &buckets)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : cfmapEntry key : %v buckets nil for curIndex : %v", key, k))
+			}
+
+			sub.buckets, err = hex.DecodeString(buckets)
+			if err != nil || uint64(cap(sub.buckets)) != sub.numBuckets * uint64(cf.bucketSize) {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : cfmapEntry key : %v buckets invalid for curIndex : %v", key, k))
+			}
+
+			cf.subs = append(cf.subs, sub)
+		}
+
+		if numSubs == 0 {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : cfmapEntry key : %v sub filters nil", key))
+		}
+
+		//UnMarshal cfmapData.lock skipped - not required
+
+		store.cfmapEntry[key] = &cfmapData{
+					cf: cf,
+					lock: &sync.RWMutex{},
+					}
+	}
+
+	//UnMarshal cfmapDBLock skipped - not required
+
+	//UnMarshal cmsmapEntry
+	len = 0
+	_, err = fmt.Fscanln(b, &len)
+	if err != nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : cmsmapEntry len nil"))
+	}
+
+	for i:= 0 ; i<len; i++ {
+		var key string
+		var counters string
+		cms := &countMinSketch{}
+
+		_, err = fmt.Fscanln(b, &key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : cmsmapEntry key nil"))
+		}
+
+		_, err = fmt.Fscanln(b, &cms.width, &cms.depth, &cms.count)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : cmsmapEntry key : %v dimensions nil", key))
+		}
+
+		_, err = fmt.Fscanln(b, &counters)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : cmsmapEntry key : %v counters nil", key))
+		}
+
+		data, errHex := hex.DecodeString(counters)
+		if errHex != nil || uint64(cap(data)) != 8 * cms.width * cms.depth {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : cmsmapEntry key : %v counters invalid", key))
+		}
+
+		cms.counters = make([]uint64, cms.width * cms.depth)
+		for k := range cms.counters {
+			cms.counters[k] = binary.BigEndian.Uint64(data[8*k:])
+		}
+
+		//UnMarshal cmsmapData.lock skipped - not required
+
+		store.cmsmapEntry[key] = &cmsmapData{
+					cms: cms,
+					lock: &sync.RWMutex{},
+					}
+	}
+
+	//UnMarshal cmsmapDBLock skipped - not required
+
+	//UnMarshal topkmapEntry
+	len = 0
+	_, err = fmt.Fscanln(b, &len)
+	if err != nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : topkmapEntry len nil"))
+	}
+
+	for i:= 0 ; i<len; i++ {
+		var key string
+		var buckets string
+		var numItems int
+		tk := &topK{}
+
+		_, err = fmt.Fscanln(b, &key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : topkmapEntry key nil"))
+		}
+
+		_, err = fmt.Fscanln(b, &tk.k, &tk.width, &tk.depth, &tk.decay)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : topkmapEntry key : %v settings nil", key))
+		}
+
+		_, err = fmt.Fscanln(b, &buckets)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : topkmapEntry key : %v buckets nil", key))
+		}
+
+		data, errHex := hex.DecodeString(buckets)
+		if errHex != nil || uint64(cap(data)) != 8 * tk.width * tk.depth {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : topkmapEntry key : %v buckets invalid", key))
+		}
+
+		tk.buckets = make([]topkBucket, tk.width * tk.depth)
+		for k := range tk.buckets {
+			tk.buckets[k].fp = binary.BigEndian.Uint32(data[8*k:])
+			tk.buckets[k].count = binary.BigEndian.Uint32(data[8*k+4:])
+		}
+
+		_, err = fmt.Fscanln(b, &numItems)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : topkmapEntry key : %v list len nil", key))
+		}
+
+		for k:=0; k<numItems; k++ {
+			var item topkItem
+
+			_, err = fmt.Fscanln(b, &item.item)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : topkmapEntry key : %v item nil for curIndex : %v", key, k))
+			}
+
+			_, err = fmt.Fscanln(b, &item.count)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : topkmapEntry key : %v item : %v count nil", key, item.item))
+			}
+
+			tk.list = append(tk.list, item)
+		}
+
+		//UnMarshal topkmapData.lock skipped - not required
+
+		store.topkmapEntry[key] = &topkmapData{
+					tk: tk,
+					lock: &sync.RWMutex{},
+					}
+	}
+
+	//UnMarshal topkmapDBLock skipped - not required
+
+	//UnMarshal tdigestmapEntry
+	len = 0
+	_, err = fmt.Fscanln(b, &len)
+	if err != nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : tdigestmapEntry len nil"))
+	}
+
+	for i:= 0 ; i<len; i++ {
+		var key string
+		var numCentroids int
+		td := &tDigest{}
+
+		_, err = fmt.Fscanln(b, &key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : tdigestmapEntry key nil"))
+		}
+
+		_, err = fmt.Fscanln(b, &td.compression, &td.count, &td.min, &td.max)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : tdigestmapEntry key : %v settings nil", key))
+		}
+
+		_, err = fmt.Fscanln(b, &numCentroids)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : tdigestmapEntry key : %v centroids len nil", key))
+		}
+
+		for k:=0; k<numCentroids; k++ {
+			var c tdigestCentroid
+
+			_, err = fmt.Fscanln(b, &c.mean, &c.weight)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : tdigestmapEntry key : %v centroid nil for curIndex : %v", key, k))
+			}
+
+			td.centroids = append(td.centroids, c)
+		}
+
+		//UnMarshal tdigestmapData.lock skipped - not required
+
+		store.tdigestmapEntry[key] = &tdigestmapData{
+					td: td,
+					lock: &sync.RWMutex{},
+					}
+	}
+
+	//UnMarshal tdigestmapDBLock skipped - not required
+
+	//UnMarshal tsmapEntry
+	len = 0
+	_, err = fmt.Fscanln(b, &len)
+	if err != nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : tsmapEntry len nil"))
+	}
+
+	for i:= 0 ; i<len; i++ {
+		var key string
+		var numLabels, numChunks, numRules int
+		series := &timeSeries{labels: make(map[string]string)}
+
+		_, err = fmt.Fscanln(b, &key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : tsmapEntry key nil"))
+		}
+
+		_, err = fmt.Fscanln(b, &series.retention, &series.chunkSize, &series.duplicatePolicy)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : tsmapEntry key : %v settings nil", key))
+		}
+
+		_, err = fmt.Fscanln(b, &numLabels)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : tsmapEntry key : %v labels len nil", key))
+		}
+
+		for k:=0; k<numLabels; k++ {
+			var l, v string
+
+			_, err = fmt.Fscanln(b, &l, &v)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : tsmapEntry key : %v label nil for curIndex : %v", key, k))
+			}
+
+			series.labels[l] = v
+		}
+
+		_, err = fmt.Fscanln(b, &numChunks)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : tsmapEntry key : %v chunks len nil", key))
+		}
+
+		for k:=0; k<numChunks; k++ {
+			var data string
+			c := &tsChunk{}
+
+			_, err = fmt.Fscanln(b, &c.count)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : tsmapEntry key : %v chunk count nil for curIndex : %v", key, k))
+			}
+
+			_, err = fmt.Fscanln(b, &data)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : tsmapEntry key : %v chunk nil for curIndex : %v", key, k))
+			}
+
+			c.data, err = hex.DecodeString(data)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : tsmapEntry key : %v chunk invalid for curIndex : %v", key, k))
+			}
+
+			//Encoder state of the chunk is rebuilt by encoding its samples again
+			series.chunks = append(series.chunks, newTSChunk(c.samples()))
+		}
+
+		_, err = fmt.Fscanln(b, &numRules)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : tsmapEntry key : %v rules len nil", key))
+		}
+
+		for k:=0; k<numRules; k++ {
+			rule := &tsRule{}
+
+			_, err = fmt.Fscanln(b, &rule.dst, &rule.agg, &rule.bucket, &rule.align, &rule.open, &rule.bucketStart)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : tsmapEntry key : %v rule nil for curIndex : %v", key, k))
+			}
+
+			_, err = fmt.Fscanln(b, &rule.acc.count, &rule.acc.sum, &rule.acc.min, &rule.acc.max, &rule.acc.first, &rule.acc.last, &rule.acc.mean, &rule.acc.m2)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : tsmapEntry key : %v rule : %v bucket nil", key, rule.dst))
+			}
+
+			series.rules = append(series.rules, rule)
+		}
+
+		//UnMarshal tsmapData.lock skipped - not required
+
+		store.tsmapEntry[key] = &tsmapData{
+					ts: series,
+					lock: &sync.RWMutex{},
+					}
+	}
+
+	//UnMarshal timeSeries.srcKey from the rules of the sources
+	for key, value := range store.tsmapEntry {
+		for _, rule := range value.ts.rules {
+			if dst, ok := store.tsmapEntry[rule.dst]; ok == true {
+				dst.ts.srcKey = key
+			}
+		}
+	}
+
+	//UnMarshal tsmapDBLock skipped - not required
+
+	//UnMarshal ftmapEntry
+	len = 0
+	_, err = fmt.Fscanln(b, &len)
+	if err != nil {
+		return errors.New(fmt.Sprintf("UnmarshalBinary : ftmapEntry len nil"))
+	}
+
+	for i:= 0 ; i<len; i++ {
+		var name string
+		var numPrefixes, numFields int
+		prefixes := []string{}
+		fields := []ftField{}
+
+		_, err = fmt.Fscanln(b, &name)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : ftmapEntry name nil"))
+		}
+
+		_, err = fmt.Fscanln(b, &numPrefixes)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : ftmapEntry name : %v prefixes len nil", name))
+		}
+
+		for k:=0; k<numPrefixes; k++ {
+			var prefix string
+
+			_, err = fmt.Fscanln(b, &prefix)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : ftmapEntry name : %v prefix nil for curIndex : %v", name, k))
+			}
+
+			prefixes = append(prefixes, prefix)
+		}
+
+		_, err = fmt.Fscanln(b, &numFields)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnmarshalBinary : ftmapEntry name : %v fields len nil", name))
+		}
+
+		for k:=0; k<numFields; k++ {
+			var f ftField
+
+			_, err = fmt.Fscanln(b, &f.name, &f.kind, &f.weight, &f.separator, &f.sortable)
+			if err != nil {
+				return errors.New(fmt.Sprintf("UnmarshalBinary : ftmapEntry name : %v field nil for curIndex : %v", name, k))
+			}
+
+			if f.kind == "VECTOR" {
+				_, err = fmt.Fscanln(b, &f.algorithm, &f.vecType, &f.dim, &f.metric, &f.m, &f.efConstruction, &f.efRuntime)
+				if err != nil {
+					return errors.New(fmt.Sprintf("UnmarshalBinary : ftmapEntry name : %v vector field nil for curIndex : %v", name, k))
+				}
+			}
+
+			fields = append(fields, f)
+		}
+
+		//UnMarshal ftIndex documents from the hashes loaded above
+		idx := newFTIndex(name, prefixes, fields)
+		idx.build(store)
+
+		store.ftmapEntry[name] = idx
+	}
+
+	//UnMarshal ftmapDBLock skipped - not required
+
 	return err
 }
@@ -0,0 +1,45 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+
+/* Fail if err is not nil */
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+
+/* Save store and load it in a new db */
+func saveLoad(t *testing.T, store *db) *db {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "db.gob")
+	if store.Save(file) == false {
+		t.Fatalf("Save failed")
+	}
+
+	loaded := newDB()
+	if loaded.Load(file) == false {
+		t.Fatalf("Load failed")
+	}
+	return loaded
+}
+
+
+func TestSaveLoadEmpty(t *testing.T) {
+	loaded := saveLoad(t, newDB())
+	if len(loaded.listmapEntry) != 0 || len(loaded.hashmapEntry) != 0 || len(loaded.ftmapEntry) != 0 {
+		t.Fatalf("empty db loaded with data")
+	}
+}
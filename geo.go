@@ -0,0 +1,576 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+
+/*
+  Geo commands, same as the redis geo commands
+
+  A geo set is a sorted set, the score of a member is the 52 bit geohash of
+  its position. The hash interleaves 26 bits of latitude and 26 bits of
+  longitude, so the members close to each other have close scores and the
+  members within a geohash cell are one score range of the skiplist.
+
+  A search covers the search area with the cell of the center and its 8
+  neighbours, at the smallest cell size which still contains the area, and
+  checks the distance of every member within the score ranges of the cells.
+*/
+
+const (
+	// Bits per coordinate of the geohash score
+	geoStepMax = 26
+
+	// Limits of the coordinates, latitude limits are the limits of EPSG:3857
+	geoLongMin = -180.0
+	geoLongMax = 180.0
+	geoLatMin = -85.05112878
+	geoLatMax = 85.05112878
+
+	// Earth radius used for distances, same as redis
+	geoEarthRadius = 6372797.560856
+)
+
+/* Sort order of search results */
+const (
+	geoSortNone = iota
+	geoSortAsc
+	geoSortDesc
+)
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+type geoPoint struct {
+	member string
+	score float64
+	lon float64
+	lat float64
+	dist float64
+}
+
+type geoSearchArgs struct {
+	fromMember string
+	fromLonLat bool
+	lon float64
+	lat float64
+	byRadius bool
+	radius float64
+	width float64
+	height float64
+	unit float64
+	unitName string
+	sort int
+	count int
+	any bool
+	withCoord bool
+	withDist bool
+	withHash bool
+	storeDist bool
+}
+
+
+/* Spread the low 32 bits of x to the even bits */
+func geoSpread(x uint64) uint64 {
+	x &= 0xFFFFFFFF
+	x = (x | (x << 16)) & 0x0000FFFF0000FFFF
+	x = (x | (x << 8)) & 0x00FF00FF00FF00FF
+	x = (x | (x << 4)) & 0x0F0F0F0F0F0F0F0F
+	x = (x | (x << 2)) & 0x3333333333333333
+	x = (x | (x << 1)) & 0x5555555555555555
+	return x
+}
+
+
+/* Collect the even bits of x, reverse of geoSpread */
+func geoSqueeze(x uint64) uint64 {
+	x &= 0x5555555555555555
+	x = (x | (x >> 1)) & 0x3333333333333333
+	x = (x | (x >> 2)) & 0x0F0F0F0F0F0F0F0F
+	x = (x | (x >> 4)) & 0x00FF00FF00FF00FF
+	x = (x | (x >> 8)) & 0x0000FFFF0000FFFF
+	x = (x | (x >> 16)) & 0x00000000FFFFFFFF
+	return x
+}
+
+
+/* Latitude bits on the even bits, longitude bits on the odd bits */
+func geoInterleave(ilat uint64, ilon uint64) uint64 {
+	return geoSpread(ilat) | (geoSpread(ilon) << 1)
+}
+
+
+func geoDeinterleave(bits uint64) (uint64, uint64) {
+	return geoSqueeze(bits), geoSqueeze(bits >> 1)
+}
+
+
+/* Returns false if the coordinates can not be geohash encoded */
+func geoValid(lon float64, lat float64) bool {
+	return lon >= geoLongMin && lon <= geoLongMax && lat >= geoLatMin && lat <= geoLatMax
+}
+
+
+/* Geohash of the position with step bits per coordinate, within the given latitude limits */
+func geohashEncodeRange(lon float64, lat float64, latMin float64, latMax float64, step uint) uint64 {
+	cells := float64(uint64(1) << step)
+
+	ilat := uint64((lat - latMin) / (latMax - latMin) * cells)
+	ilon := uint64((lon - geoLongMin) / (geoLongMax - geoLongMin) * cells)
+
+	/* The upper limit belongs to the last cell */
+	if ilat >= uint64(cells) {
+		ilat = uint64(cells) - 1
+	}
+	if ilon >= uint64(cells) {
+		ilon = uint64(cells) - 1
+	}
+
+	return geoInterleave(ilat, ilon)
+}
+
+
+func geohashEncode(lon float64, lat float64, step uint) uint64 {
+	return geohashEncodeRange(lon, lat, geoLatMin, geoLatMax, step)
+}
+
+
+/* Center of the geohash cell of the score */
+func geohashDecode(score float64) (float64, float64) {
+	ilat, ilon := geoDeinterleave(uint64(score))
+	cells := float64(uint64(1) << geoStepMax)
+
+	latMin := geoLatMin + float64(ilat) / cells * (geoLatMax - geoLatMin)
+	latMax := geoLatMin + float64(ilat + 1) / cells * (geoLatMax - geoLatMin)
+	lonMin := geoLongMin + float64(ilon) / cells * (geoLongMax - geoLongMin)
+	lonMax := geoLongMin + float64(ilon + 1) / cells * (geoLongMax - geoLongMin)
+
+	lon := math.Max(geoLongMin, math.Min(geoLongMax, (lonMin + lonMax) / 2))
+	lat := math.Max(geoLatMin, math.Min(geoLatMax, (latMin + latMax) / 2))
+
+	return lon, lat
+}
+
+
+/* Standard 11 characters geohash string of the score, encoded with latitude limits -90 90 */
+func geohashString(score float64) string {
+	lon, lat := geohashDecode(score)
+	bits := geohashEncodeRange(lon, lat, -90, 90, geoStepMax)
+
+	buf := make([]byte, 11)
+	for i := 0; i < 11; i++ {
+		idx := 0
+		if i < 10 {
+			idx = int((bits >> uint(52 - (i + 1) * 5)) & 0x1f)
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+
+	return string(buf)
+}
+
+
+func geoRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+
+func geoDegrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+
+/* Haversine distance in meters */
+func geoDistance(lon1 float64, lat1 float64, lon2 float64, lat2 float64) float64 {
+	lat1r, lat2r := geoRadians(lat1), geoRadians(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(geoRadians(lon2 - lon1) / 2)
+
+	a := u * u + math.Cos(lat1r) * math.Cos(lat2r) * v * v
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(a))
+}
+
+
+/* Meters per unit, m km ft mi */
+func geoParseUnit(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+
+	return 0, errors.New("unsupported unit provided. please use M, KM, FT, MI")
+}
+
+
+/* Distance reply with 4 decimals */
+func geoFormatDistance(meters float64, unit float64) string {
+	return strconv.FormatFloat(meters / unit, 'f', 4, 64)
+}
+
+
+func geoFormatCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+
+/* Parse longitude latitude pair */
+func geoParseLonLat(lonArg string, latArg string) (float64, float64, error) {
+	lon, errLon := strconv.ParseFloat(lonArg, 64)
+	lat, errLat := strconv.ParseFloat(latArg, 64)
+
+	if errLon != nil || errLat != nil {
+		return 0, 0, errors.New("value is not a valid float")
+	}
+
+	if geoValid(lon, lat) == false {
+		return 0, 0, errors.New(fmt.Sprintf("invalid longitude,latitude pair %s,%s", lonArg, latArg))
+	}
+
+	return lon, lat, nil
+}
+
+
+/*
+  Parse GEOSEARCH arguments after the key
+  FROMMEMBER member | FROMLONLAT lon lat, BYRADIUS radius unit | BYBOX width height unit
+  [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH], GEOSEARCHSTORE takes [STOREDIST] instead of WITH
+*/
+func parseGeoSearchArgs(cmdName string, args []string, store bool) (*geoSearchArgs, error) {
+	var err error
+	search := &geoSearchArgs{}
+	from := false
+	by := false
+
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		left := len(args) - i - 1
+
+		if opt == "FROMMEMBER" && left >= 1 && from == false {
+			search.fromMember = args[i+1]
+			from = true
+			i++
+		} else if opt == "FROMLONLAT" && left >= 2 && from == false {
+			search.lon, search.lat, err = geoParseLonLat(args[i+1], args[i+2])
+			if err != nil {
+				return nil, err
+			}
+			search.fromLonLat = true
+			from = true
+			i = i + 2
+		} else if opt == "BYRADIUS" && left >= 2 && by == false {
+			search.radius, err = strconv.ParseFloat(args[i+1], 64)
+			if err != nil || search.radius < 0 {
+				return nil, errors.New("radius cannot be negative")
+			}
+			search.unit, err = geoParseUnit(args[i+2])
+			if err != nil {
+				return nil, err
+			}
+			search.unitName = strings.ToLower(args[i+2])
+			search.byRadius = true
+			by = true
+			i = i + 2
+		} else if opt == "BYBOX" && left >= 3 && by == false {
+			search.width, err = strconv.ParseFloat(args[i+1], 64)
+			if err == nil {
+				search.height, err = strconv.ParseFloat(args[i+2], 64)
+			}
+			if err != nil || search.width < 0 || search.height < 0 {
+				return nil, errors.New("height or width cannot be negative")
+			}
+			search.unit, err = geoParseUnit(args[i+3])
+			if err != nil {
+				return nil, err
+			}
+			search.unitName = strings.ToLower(args[i+3])
+			by = true
+			i = i + 3
+		} else if opt == "ASC" {
+			search.sort = geoSortAsc
+		} else if opt == "DESC" {
+			search.sort = geoSortDesc
+		} else if opt == "COUNT" && left >= 1 {
+			search.count, err = strconv.Atoi(args[i+1])
+			if err != nil || search.count <= 0 {
+				return nil, errors.New("COUNT must be > 0")
+			}
+			i++
+			if i + 1 < len(args) && strings.ToUpper(args[i+1]) == "ANY" {
+				search.any = true
+				i++
+			}
+		} else if opt == "WITHCOORD" && store == false {
+			search.withCoord = true
+		} else if opt == "WITHDIST" && store == false {
+			search.withDist = true
+		} else if opt == "WITHHASH" && store == false {
+			search.withHash = true
+		} else if opt == "STOREDIST" && store == true {
+			search.storeDist = true
+		} else {
+			return nil, errors.New("syntax error")
+		}
+	}
+
+	if from == false {
+		return nil, errors.New(fmt.Sprintf("exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", cmdName))
+	}
+
+	if by == false {
+		return nil, errors.New(fmt.Sprintf("exactly one of BYRADIUS and BYBOX can be specified for %s", cmdName))
+	}
+
+	/* Scale to meters */
+	search.radius *= search.unit
+	search.width *= search.unit
+	search.height *= search.unit
+
+	return search, nil
+}
+
+
+/* Distance of the point from the search center, false if the point is outside the search area */
+func (search *geoSearchArgs) within(lon float64, lat float64) (float64, bool) {
+	dist := geoDistance(search.lon, search.lat, lon, lat)
+
+	if search.byRadius == true {
+		return dist, dist <= search.radius
+	}
+
+	/* Box, the latitude distance along the center meridian and the longitude distance along the latitude of the point */
+	if geoDistance(search.lon, search.lat, search.lon, lat) > search.height / 2 {
+		return dist, false
+	}
+	if geoDistance(search.lon, lat, lon, lat) > search.width / 2 {
+		return dist, false
+	}
+
+	return dist, true
+}
+
+
+/* Score ranges of the cells covering the search area, the cell of the center and its 8 neighbours */
+func (search *geoSearchArgs) scoreRanges() []*zrangespec {
+	halfHeight, halfWidth := search.radius, search.radius
+	if search.byRadius == false {
+		halfHeight, halfWidth = search.height / 2, search.width / 2
+	}
+
+	/* Extent of the area in degrees, the longitude extent grows towards the poles */
+	latDelta := geoDegrees(halfHeight / geoEarthRadius)
+	edgeLat := math.Min(math.Abs(search.lat) + latDelta, 89.99)
+	lonDelta := geoDegrees(halfWidth / geoEarthRadius / math.Cos(geoRadians(edgeLat)))
+
+	/* Smallest cells still as large as the extent, at step 1 the 9 cells cover the world */
+	step := uint(geoStepMax)
+	for step > 1 {
+		cells := float64(uint64(1) << step)
+		if (geoLongMax - geoLongMin) / cells >= lonDelta && (geoLatMax - geoLatMin) / cells >= latDelta {
+			break
+		}
+		step--
+	}
+
+	cells := int64(1) << step
+	ilat, ilon := geoDeinterleave(geohashEncode(search.lon, search.lat, step))
+	shift := uint(2 * (geoStepMax - step))
+
+	seen := make(map[uint64]bool)
+	ranges := []*zrangespec{}
+
+	for dlat := int64(-1); dlat <= 1; dlat++ {
+		for dlon := int64(-1); dlon <= 1; dlon++ {
+			lat := int64(ilat) + dlat
+			if lat < 0 || lat >= cells {
+				continue
+			}
+
+			/* Longitude wraps around */
+			lon := (int64(ilon) + dlon + cells) % cells
+
+			hash := geoInterleave(uint64(lat), uint64(lon))
+			if seen[hash] == true {
+				continue
+			}
+			seen[hash] = true
+
+			ranges = append(ranges, &zrangespec{
+				min: float64(hash << shift),
+				max: float64((hash + 1) << shift),
+				maxex: true,
+			})
+		}
+	}
+
+	return ranges
+}
+
+
+/* Members of the sorted set within the search area, sorted and limited as asked */
+func (search *geoSearchArgs) search(zsl *zskiplist) []geoPoint {
+	points := []geoPoint{}
+
+	for _, spec := range search.scoreRanges() {
+		for x := zsl.firstInRange(spec); x != nil && spec.lteMax(x.score); x = x.level[0].forward {
+			lon, lat := geohashDecode(x.score)
+
+			dist, ok := search.within(lon, lat)
+			if ok == false {
+				continue
+			}
+
+			points = append(points, geoPoint{member: x.member, score: x.score, lon: lon, lat: lat, dist: dist})
+
+			/* ANY returns the first count members found */
+			if search.any == true && len(points) >= search.count {
+				break
+			}
+		}
+
+		if search.any == true && len(points) >= search.count {
+			break
+		}
+	}
+
+	/* COUNT without ANY returns the closest members */
+	order := search.sort
+	if order == geoSortNone && search.count > 0 && search.any == false {
+		order = geoSortAsc
+	}
+
+	if order == geoSortAsc {
+		sort.SliceStable(points, func(i, j int) bool { return points[i].dist < points[j].dist })
+	} else if order == geoSortDesc {
+		sort.SliceStable(points, func(i, j int) bool { return points[i].dist > points[j].dist })
+	}
+
+	if search.count > 0 && len(points) > search.count {
+		points = points[:search.count]
+	}
+
+	return points
+}
+
+
+/* Resolve FROMMEMBER to the position of the member */
+func (search *geoSearchArgs) resolveCenter(entry *setmapData) error {
+	if search.fromLonLat == true {
+		return nil
+	}
+
+	score, ok := entry.dict[search.fromMember]
+	if ok == false {
+		return errors.New("could not decode requested zset member")
+	}
+
+	search.lon, search.lat = geohashDecode(score)
+	return nil
+}
+
+
+/* Reply lines of search results, member followed by the distance, hash and coordinates asked for */
+func geoSearchReply(search *geoSearchArgs, points []geoPoint) []string {
+	vals := []string{}
+
+	for _, p := range points {
+		vals = append(vals, p.member)
+
+		if search.withDist == true {
+			vals = append(vals, geoFormatDistance(p.dist, search.unit))
+		}
+		if search.withHash == true {
+			vals = append(vals, strconv.FormatUint(uint64(p.score), 10))
+		}
+		if search.withCoord == true {
+			vals = append(vals, geoFormatCoord(p.lon), geoFormatCoord(p.lat))
+		}
+	}
+
+	return vals
+}
+
+
+/* Members of the geo set key within the search area */
+func (store *db) GEOSEARCH(key string, search *geoSearchArgs) ([]geoPoint, error) {
+	if store == nil {
+		fmt.Println("GEOSEARCH : store is nil")
+		return nil, errors.New(fmt.Sprint("GEOSEARCH : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete or ZRANGESTORE replacing the key until operation is finished */
+	store.setmapDBLock.RLock()
+	defer store.setmapDBLock.RUnlock()
+
+	entry, ok := store.setmapEntry[key]
+
+	if ok == false {
+		return []geoPoint{}, nil
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	if err := search.resolveCenter(entry); err != nil {
+		return nil, err
+	}
+
+	return search.search(entry.zsl), nil
+}
+
+
+/* Store the members of the geo set src within the search area in dst, with their geohash or with STOREDIST their distance as score */
+func (store *db) GEOSEARCHSTORE(dst string, src string, search *geoSearchArgs) (int, error) {
+	if store == nil {
+		fmt.Println("GEOSEARCHSTORE : store is nil")
+		return 0, errors.New(fmt.Sprint("GEOSEARCHSTORE : store is nil"))
+	}
+
+	/* Take Global write lock, src and dst can be same key and dst gets replaced */
+	store.setmapDBLock.Lock()
+
+	items := []zsetItem{}
+
+	entry, ok := store.setmapEntry[src]
+	if ok == true {
+		if err := search.resolveCenter(entry); err != nil {
+			store.setmapDBLock.Unlock()
+			return 0, err
+		}
+
+		for _, p := range search.search(entry.zsl) {
+			score := p.score
+			if search.storeDist == true {
+				score = p.dist / search.unit
+			}
+			items = append(items, zsetItem{member: p.member, score: score})
+		}
+	}
+
+	store.zsetReplace(dst, items)
+
+	store.setmapDBLock.Unlock()
+
+	/* Serve clients blocked on dst, after data locks are released */
+	if len(items) > 0 {
+		store.signalKeyReady(blockZset, dst)
+	}
+
+	return len(items), nil
+}
@@ -0,0 +1,236 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+
+/* GEOADD of longitude latitude member triples */
+func geoadd(t *testing.T, store *db, key string, args ...string) {
+	t.Helper()
+
+	items := []zsetItem{}
+	for i := 0; i + 2 < len(args); i = i + 3 {
+		lon, lat, err := geoParseLonLat(args[i], args[i+1])
+		must(t, err)
+		items = append(items, zsetItem{member: args[i+2], score: float64(geohashEncode(lon, lat, geoStepMax))})
+	}
+
+	_, _, err := store.ZADD(key, items, 0)
+	must(t, err)
+}
+
+
+/* Reply of GEOSEARCH key with the args joined with space */
+func geosearch(t *testing.T, store *db, key string, args string) string {
+	t.Helper()
+
+	search, err := parseGeoSearchArgs("GEOSEARCH", strings.Fields(args), false)
+	must(t, err)
+
+	points, err := store.GEOSEARCH(key, search)
+	must(t, err)
+	return strings.Join(geoSearchReply(search, points), " ")
+}
+
+
+/* Score of member, fails if member is not in key */
+func geoscore(t *testing.T, store *db, key string, member string) float64 {
+	t.Helper()
+
+	scores, found, _ := store.ZMSCORE(key, []string{member})
+	if len(found) == 0 || found[0] == false {
+		t.Fatalf("%s not in %s", member, key)
+	}
+	return scores[0]
+}
+
+
+/* Position of member decoded from its score */
+func geopos(t *testing.T, store *db, key string, member string) (float64, float64) {
+	t.Helper()
+	return geohashDecode(geoscore(t, store, key, member))
+}
+
+
+func TestGeoParse(t *testing.T) {
+	for _, pair := range [][2]string{{"180", "85.05112878"}, {"-180", "-85.05112878"}, {"0", "0"}} {
+		if _, _, err := geoParseLonLat(pair[0], pair[1]); err != nil {
+			t.Fatalf("lon lat %v: %v", pair, err)
+		}
+	}
+	for _, pair := range [][2]string{{"180.1", "0"}, {"0", "85.06"}, {"x", "0"}, {"0", "nan"}} {
+		if _, _, err := geoParseLonLat(pair[0], pair[1]); err == nil {
+			t.Fatalf("lon lat %v parsed", pair)
+		}
+	}
+
+	for unit, meters := range map[string]float64{"m": 1, "KM": 1000, "ft": 0.3048, "Mi": 1609.34} {
+		if got, err := geoParseUnit(unit); err != nil || got != meters {
+			t.Fatalf("unit %s gave %v, %v", unit, got, err)
+		}
+	}
+	if _, err := geoParseUnit("yd"); err == nil {
+		t.Fatalf("unit yd parsed")
+	}
+
+	for _, args := range []string{
+		"BYRADIUS 1 km",
+		"FROMLONLAT 0 0",
+		"FROMLONLAT 0 0 FROMMEMBER a BYRADIUS 1 km",
+		"FROMLONLAT 0 0 BYRADIUS 1 km BYBOX 1 1 km",
+		"FROMLONLAT 0 0 BYRADIUS -1 km",
+		"FROMLONLAT 0 0 BYBOX 1 -1 km",
+		"FROMLONLAT 0 0 BYRADIUS 1 yd",
+		"FROMLONLAT 0 0 BYRADIUS 1 km COUNT 0",
+		"FROMLONLAT 0 0 BYRADIUS 1 km STOREDIST",
+	} {
+		if _, err := parseGeoSearchArgs("GEOSEARCH", strings.Fields(args), false); err == nil {
+			t.Fatalf("GEOSEARCH %s parsed", args)
+		}
+	}
+	if _, err := parseGeoSearchArgs("GEOSEARCHSTORE", strings.Fields("FROMLONLAT 0 0 BYRADIUS 1 km WITHDIST"), true); err == nil {
+		t.Fatalf("GEOSEARCHSTORE WITHDIST parsed")
+	}
+}
+
+
+/* Positions, distances and hashes of the redis documentation examples */
+func TestGeoPosDistHash(t *testing.T) {
+	store := newDB()
+	geoadd(t, store, "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+
+	lon, lat := geopos(t, store, "Sicily", "Palermo")
+	if geoFormatCoord(lon)[:10] != "13.3613893" || geoFormatCoord(lat)[:10] != "38.1155563" {
+		t.Fatalf("GEOPOS Palermo %v %v", lon, lat)
+	}
+
+	lon2, lat2 := geopos(t, store, "Sicily", "Catania")
+	dist := geoDistance(lon, lat, lon2, lat2)
+	if got := geoFormatDistance(dist, 1); got != "166274.1516" {
+		t.Fatalf("GEODIST gave %s m", got)
+	}
+	if got := geoFormatDistance(dist, 1000); got != "166.2742" {
+		t.Fatalf("GEODIST gave %s km", got)
+	}
+
+	for member, want := range map[string]string{"Palermo": "sqc8b49rny0", "Catania": "sqdtr74hyu0"} {
+		if got := geohashString(geoscore(t, store, "Sicily", member)); got != want {
+			t.Fatalf("GEOHASH %s gave %s, want %s", member, got, want)
+		}
+	}
+}
+
+
+func TestGeoSearch(t *testing.T) {
+	store := newDB()
+	geoadd(t, store, "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania",
+		"12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+
+	tests := []struct {
+		args string
+		want string
+	}{
+		{"FROMLONLAT 15 37 BYRADIUS 200 km ASC", "Catania Palermo"},
+		{"FROMLONLAT 15 37 BYRADIUS 200 km DESC WITHDIST", "Palermo 190.4424 Catania 56.4413"},
+		{"FROMLONLAT 15 37 BYRADIUS 100 km", "Catania"},
+		{"FROMLONLAT 15 37 BYRADIUS 0 km", ""},
+		{"FROMLONLAT 15 37 BYBOX 400 400 km ASC", "Catania Palermo edge2 edge1"},
+		{"FROMLONLAT 15 37 BYBOX 400 400 km ASC COUNT 3", "Catania Palermo edge2"},
+		{"FROMLONLAT 15 37 BYBOX 400 400 km DESC COUNT 1", "edge1"},
+		{"FROMLONLAT 15 37 BYBOX 100 400 km ASC", "Catania"},
+		{"FROMMEMBER Palermo BYRADIUS 200 km ASC", "Palermo edge1 Catania"},
+		{"FROMMEMBER Palermo BYRADIUS 100 km ASC WITHDIST", "Palermo 0.0000 edge1 91.4007"},
+		{"FROMMEMBER Catania BYRADIUS 1 m WITHHASH", "Catania 3479447370796909"},
+		{"FROMLONLAT 15 37 BYRADIUS 100 km WITHCOORD", "Catania 15.087267458438873 37.50266842333162"},
+		{"FROMLONLAT 15 37 BYRADIUS 124 mi ASC", "Catania Palermo"},
+	}
+
+	for _, test := range tests {
+		if got := geosearch(t, store, "Sicily", test.args); got != test.want {
+			t.Fatalf("GEOSEARCH %s gave %q, want %q", test.args, got, test.want)
+		}
+	}
+
+	/* COUNT ANY stops at the first count members found, in any order */
+	if got := strings.Fields(geosearch(t, store, "Sicily", "FROMLONLAT 15 37 BYBOX 400 400 km COUNT 2 ANY")); len(got) != 2 {
+		t.Fatalf("GEOSEARCH COUNT 2 ANY gave %v", got)
+	}
+
+	search, _ := parseGeoSearchArgs("GEOSEARCH", strings.Fields("FROMMEMBER Rome BYRADIUS 1 km"), false)
+	if _, err := store.GEOSEARCH("Sicily", search); err == nil {
+		t.Fatalf("GEOSEARCH FROMMEMBER of missing member")
+	}
+	if got := geosearch(t, store, "none", "FROMLONLAT 15 37 BYRADIUS 1 km"); got != "" {
+		t.Fatalf("GEOSEARCH of missing key gave %q", got)
+	}
+}
+
+
+/* Searches across the 180th meridian and around the poles */
+func TestGeoSearchEdges(t *testing.T) {
+	store := newDB()
+	geoadd(t, store, "g", "179.95", "0", "east", "-179.95", "0", "west", "0", "85", "north", "180", "85", "far")
+
+	if got := geosearch(t, store, "g", "FROMLONLAT 179.99 0 BYRADIUS 20 km ASC"); got != "east west" {
+		t.Fatalf("GEOSEARCH across 180 gave %q", got)
+	}
+	if got := geosearch(t, store, "g", "FROMLONLAT -180 0 BYBOX 20 20 km ASC"); got != "west east" {
+		t.Fatalf("GEOSEARCH box across 180 gave %q", got)
+	}
+	if got := geosearch(t, store, "g", "FROMLONLAT 90 85 BYRADIUS 2000 km ASC"); got != "north far" {
+		t.Fatalf("GEOSEARCH near the pole gave %q", got)
+	}
+	if got := geosearch(t, store, "g", "FROMLONLAT 0 0 BYRADIUS 30000 km ASC"); got != "north far west east" {
+		t.Fatalf("GEOSEARCH of the world gave %q", got)
+	}
+}
+
+
+func TestGeoSearchStore(t *testing.T) {
+	store := newDB()
+	geoadd(t, store, "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+
+	search, err := parseGeoSearchArgs("GEOSEARCHSTORE", strings.Fields("FROMLONLAT 15 37 BYRADIUS 200 km ASC COUNT 1"), true)
+	must(t, err)
+	stored, err := store.GEOSEARCHSTORE("dst", "Sicily", search)
+	must(t, err)
+	if stored != 1 {
+		t.Fatalf("GEOSEARCHSTORE stored %d", stored)
+	}
+
+	/* The stored score is the geohash, so dst is a geo set */
+	want := geoscore(t, store, "Sicily", "Catania")
+	if got := geoscore(t, store, "dst", "Catania"); got != want {
+		t.Fatalf("GEOSEARCHSTORE score %v, want %v", got, want)
+	}
+
+	search, err = parseGeoSearchArgs("GEOSEARCHSTORE", strings.Fields("FROMLONLAT 15 37 BYRADIUS 200 km STOREDIST"), true)
+	must(t, err)
+	store.GEOSEARCHSTORE("dst", "Sicily", search)
+	for member, want := range map[string]string{"Catania": "56.4413", "Palermo": "190.4424"} {
+		score := geoscore(t, store, "dst", member)
+		if geoFormatDistance(score, 1) != want {
+			t.Fatalf("GEOSEARCHSTORE STOREDIST score of %s %v, want %s", member, score, want)
+		}
+	}
+
+	/* Source as destination, an empty result deletes dst */
+	search, _ = parseGeoSearchArgs("GEOSEARCHSTORE", strings.Fields("FROMMEMBER Palermo BYRADIUS 10 km"), true)
+	if stored, _ = store.GEOSEARCHSTORE("Sicily", "Sicily", search); stored != 1 {
+		t.Fatalf("GEOSEARCHSTORE to the source stored %d", stored)
+	}
+	search, _ = parseGeoSearchArgs("GEOSEARCHSTORE", strings.Fields("FROMLONLAT 0 0 BYRADIUS 10 km"), true)
+	if stored, _ = store.GEOSEARCHSTORE("dst", "Sicily", search); stored != 0 {
+		t.Fatalf("GEOSEARCHSTORE of nothing stored %d", stored)
+	}
+	if _, ok := store.setmapEntry["dst"]; ok == true {
+		t.Fatalf("GEOSEARCHSTORE of nothing kept dst")
+	}
+}
@@ -0,0 +1,838 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+
+/*
+  Stream consumer groups, same idea as the redis consumer groups
+
+  A group delivers every entry of the stream once, to one of its consumers.
+  lastID is the ID of the last entry delivered by the group. A delivered entry
+  is kept in the pending entries list (pel) of the group and of the consumer
+  until XACK, so an entry of a consumer which went away can be claimed by
+  another consumer (XCLAIM, XAUTOCLAIM) once it is idle long enough.
+
+  Times are in unix ms. deliveryTime is the time of the last delivery of an
+  entry, seenTime the last time a consumer did anything and activeTime the last
+  time a consumer got entries delivered.
+*/
+
+type streamNACK struct {
+	consumer *streamConsumer
+	deliveryTime int64
+	deliveryCount int64
+}
+
+type streamConsumer struct {
+	name string
+	seenTime int64
+	activeTime int64
+	pel map[streamID]*streamNACK
+}
+
+type streamGroup struct {
+	name string
+	lastID streamID
+	pel map[streamID]*streamNACK
+	consumers map[string]*streamConsumer
+}
+
+type xclaimArgs struct {
+	minIdle int64
+	ids []streamID
+	idle int64
+	time int64
+	retryCount int64
+	force bool
+	justID bool
+	lastID *streamID
+}
+
+
+/* Current time in unix ms */
+func mstime() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+
+func newStreamGroup(name string, lastID streamID) *streamGroup {
+	return &streamGroup{
+		name: name,
+		lastID: lastID,
+		pel: make(map[streamID]*streamNACK),
+		consumers: make(map[string]*streamConsumer),
+	}
+}
+
+
+/* Consumer of the group, created if absent and create is set */
+func (g *streamGroup) consumer(name string, create bool) *streamConsumer {
+	c, ok := g.consumers[name]
+
+	if ok == false && create == true {
+		now := mstime()
+		c = &streamConsumer{
+			name: name,
+			seenTime: now,
+			activeTime: -1,
+			pel: make(map[streamID]*streamNACK),
+		}
+		g.consumers[name] = c
+	}
+
+	return c
+}
+
+
+/* IDs of a pending entries list in ID order */
+func sortedPEL(pel map[streamID]*streamNACK) []streamID {
+	ids := make([]streamID, 0, len(pel))
+	for id := range pel {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+	return ids
+}
+
+
+/* Deliver up to count entries after the group last ID to consumer c, the entries are added to the pel unless noack */
+func (g *streamGroup) readNew(st *stream, c *streamConsumer, count int, noack bool) []streamEntry {
+	start, ok := g.lastID.incr()
+	if ok == false {
+		return []streamEntry{}
+	}
+
+	entries := st.rangeEntries(start, streamID{math.MaxUint64, math.MaxUint64}, count, false)
+	now := mstime()
+
+	for _, entry := range entries {
+		g.lastID = entry.id
+
+		if noack == true {
+			continue
+		}
+
+		/* An entry delivered again after XGROUP SETID moves to c */
+		if nack, ok := g.pel[entry.id]; ok == true {
+			delete(nack.consumer.pel, entry.id)
+		}
+
+		nack := &streamNACK{consumer: c, deliveryTime: now, deliveryCount: 1}
+		g.pel[entry.id] = nack
+		c.pel[entry.id] = nack
+	}
+
+	if len(entries) > 0 {
+		c.activeTime = now
+	}
+
+	return entries
+}
+
+
+/* Entries of the consumer pel with ID greater than id, an entry deleted from the stream has nil fields */
+func (g *streamGroup) readHistory(st *stream, c *streamConsumer, id streamID, count int) []streamEntry {
+	entries := []streamEntry{}
+
+	for _, pid := range sortedPEL(c.pel) {
+		if pid.less(id) || pid == id {
+			continue
+		}
+
+		if count > 0 && len(entries) >= count {
+			break
+		}
+
+		entry, ok := st.lookup(pid)
+		if ok == false {
+			entry = streamEntry{id: pid}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+
+/* Move pending entry id to consumer c */
+func (g *streamGroup) claim(id streamID, c *streamConsumer, deliveryTime int64, incrCount bool) {
+	nack := g.pel[id]
+
+	delete(nack.consumer.pel, id)
+	nack.consumer = c
+	nack.deliveryTime = deliveryTime
+	if incrCount == true {
+		nack.deliveryCount++
+	}
+	c.pel[id] = nack
+}
+
+
+/* Single entry of the stream */
+func (st *stream) lookup(id streamID) (streamEntry, bool) {
+	entries := st.rangeEntries(id, id, 1, false)
+	if len(entries) == 0 {
+		return streamEntry{}, false
+	}
+	return entries[0], true
+}
+
+
+/* Run fn with the key write lock held, the key is created if absent and create is set */
+func (store *db) streamUpdate(key string, create bool, fn func(entry *streammapData) error) error {
+	var entry *streammapData
+	var ok bool
+
+	store.streammapDBLock.RLock()
+
+	/* Check if db has the key entry */
+	entry, ok = store.streammapEntry[key]
+
+	/* If entry not present */
+	if ok == false {
+		if create == false {
+			store.streammapDBLock.RUnlock()
+			return errors.New(fmt.Sprint("key ", key, " not found"))
+		}
+
+		/* Take DB lock before creating entry for this key, to ensure only one entry gets created */
+		store.streammapDBLock.RUnlock()
+		store.streammapDBLock.Lock()
+
+		/* Again Check if db has the key entry, between above if & db lock it is possible other routine has created this entry */
+		var okrecheck bool
+		entry, okrecheck = store.streammapEntry[key]
+
+		if okrecheck == false {
+			entry = &streammapData{
+				st: newStream(),
+				lock: &sync.RWMutex{},
+			}
+		}
+	}
+
+	/* Take DB entry lock before update, this is lock per key entry */
+	entry.lock.Lock()
+
+	err := fn(entry)
+
+	if ok == false && err == nil {
+		store.streammapEntry[key] = entry
+	}
+
+	entry.lock.Unlock()
+
+	/* Both locks got released in LIFO order, entry lock followed by db lock */
+	if ok == false {
+		store.streammapDBLock.Unlock()
+	} else {
+		store.streammapDBLock.RUnlock()
+	}
+
+	return err
+}
+
+
+/* Run fn with the key read lock held */
+func (store *db) streamRead(key string, fn func(entry *streammapData) error) error {
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.streammapDBLock.RLock()
+	defer store.streammapDBLock.RUnlock()
+
+	entry, ok := store.streammapEntry[key]
+
+	if ok == false {
+		return errors.New(fmt.Sprint("key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	return fn(entry)
+}
+
+
+/* Group of the stream, NOGROUP error if absent */
+func (st *stream) group(key string, name string) (*streamGroup, error) {
+	g, ok := st.groups[name]
+	if ok == false {
+		return nil, errors.New(fmt.Sprint("NOGROUP No such key '", key, "' or consumer group '", name, "'"))
+	}
+	return g, nil
+}
+
+
+/* Create group with last delivered ID, $ is the last ID of the stream */
+func (store *db) XGROUPCREATE(key string, name string, idArg string, mkStream bool) error {
+	if store == nil {
+		fmt.Println("XGROUPCREATE : store is nil")
+		return errors.New(fmt.Sprint("XGROUPCREATE : store is nil"))
+	}
+
+	var id streamID
+	var err error
+
+	if idArg != "$" {
+		id, err = parseStreamID(idArg, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = store.streamUpdate(key, mkStream, func(entry *streammapData) error {
+		if _, ok := entry.st.groups[name]; ok == true {
+			return errors.New("BUSYGROUP Consumer Group name already exists")
+		}
+
+		if idArg == "$" {
+			id = entry.st.lastID
+		}
+
+		if entry.st.groups == nil {
+			entry.st.groups = make(map[string]*streamGroup)
+		}
+		entry.st.groups[name] = newStreamGroup(name, id)
+		return nil
+	})
+
+	if err != nil && mkStream == false && err.Error() == fmt.Sprint("key ", key, " not found") {
+		return errors.New("The XGROUP subcommand requires the key to exist, use MKSTREAM to create an empty stream")
+	}
+
+	return err
+}
+
+
+/* Set the last delivered ID of group, $ is the last ID of the stream */
+func (store *db) XGROUPSETID(key string, name string, idArg string) error {
+	if store == nil {
+		fmt.Println("XGROUPSETID : store is nil")
+		return errors.New(fmt.Sprint("XGROUPSETID : store is nil"))
+	}
+
+	var id streamID
+	var err error
+
+	if idArg != "$" {
+		id, err = parseStreamID(idArg, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return store.streamUpdate(key, false, func(entry *streammapData) error {
+		g, err := entry.st.group(key, name)
+		if err != nil {
+			return err
+		}
+
+		if idArg == "$" {
+			id = entry.st.lastID
+		}
+		g.lastID = id
+		return nil
+	})
+}
+
+
+/* Destroy group with its consumers and pel, returns true if the group existed */
+func (store *db) XGROUPDESTROY(key string, name string) (bool, error) {
+	if store == nil {
+		fmt.Println("XGROUPDESTROY : store is nil")
+		return false, errors.New(fmt.Sprint("XGROUPDESTROY : store is nil"))
+	}
+
+	destroyed := false
+
+	err := store.streamUpdate(key, false, func(entry *streammapData) error {
+		_, destroyed = entry.st.groups[name]
+		delete(entry.st.groups, name)
+		return nil
+	})
+
+	return destroyed, err
+}
+
+
+/* Create consumer in group, returns true if the consumer is new */
+func (store *db) XGROUPCREATECONSUMER(key string, name string, consumer string) (bool, error) {
+	if store == nil {
+		fmt.Println("XGROUPCREATECONSUMER : store is nil")
+		return false, errors.New(fmt.Sprint("XGROUPCREATECONSUMER : store is nil"))
+	}
+
+	created := false
+
+	err := store.streamUpdate(key, false, func(entry *streammapData) error {
+		g, err := entry.st.group(key, name)
+		if err != nil {
+			return err
+		}
+
+		created = g.consumer(consumer, false) == nil
+		g.consumer(consumer, true)
+		return nil
+	})
+
+	return created, err
+}
+
+
+/* Delete consumer from group with its pending entries, returns the number of pending entries it had */
+func (store *db) XGROUPDELCONSUMER(key string, name string, consumer string) (int, error) {
+	if store == nil {
+		fmt.Println("XGROUPDELCONSUMER : store is nil")
+		return 0, errors.New(fmt.Sprint("XGROUPDELCONSUMER : store is nil"))
+	}
+
+	pending := 0
+
+	err := store.streamUpdate(key, false, func(entry *streammapData) error {
+		g, err := entry.st.group(key, name)
+		if err != nil {
+			return err
+		}
+
+		c := g.consumer(consumer, false)
+		if c == nil {
+			return nil
+		}
+
+		pending = len(c.pel)
+		for id := range c.pel {
+			delete(g.pel, id)
+		}
+		delete(g.consumers, consumer)
+		return nil
+	})
+
+	return pending, err
+}
+
+
+/*
+  Read entries of keys for consumer of group, up to count entries per key if count > 0
+  newOnly[i] reads entries never delivered to the group (>), else the consumer pel after ids[i]
+*/
+func (store *db) XREADGROUP(name string, consumer string, keys []string, ids []streamID, newOnly []bool, count int, noack bool) ([][]streamEntry, error) {
+	if store == nil {
+		fmt.Println("XREADGROUP : store is nil")
+		return nil, errors.New(fmt.Sprint("XREADGROUP : store is nil"))
+	}
+
+	result := make([][]streamEntry, len(keys))
+
+	for i, key := range keys {
+		err := store.streamUpdate(key, false, func(entry *streammapData) error {
+			g, err := entry.st.group(key, name)
+			if err != nil {
+				return err
+			}
+
+			c := g.consumer(consumer, true)
+			c.seenTime = mstime()
+
+			if newOnly[i] == true {
+				result[i] = g.readNew(entry.st, c, count, noack)
+			} else {
+				result[i] = g.readHistory(entry.st, c, ids[i], count)
+			}
+			return nil
+		})
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprint("NOGROUP No such key '", key, "' or consumer group '", name, "' in XREADGROUP with GROUP option"))
+		}
+	}
+
+	return result, nil
+}
+
+
+/* Acknowledge pending entries of group, returns the number of entries removed from the pel */
+func (store *db) XACK(key string, name string, ids []streamID) (int, error) {
+	if store == nil {
+		fmt.Println("XACK : store is nil")
+		return 0, errors.New(fmt.Sprint("XACK : store is nil"))
+	}
+
+	acked := 0
+
+	err := store.streamUpdate(key, false, func(entry *streammapData) error {
+		g, ok := entry.st.groups[name]
+		if ok == false {
+			return nil
+		}
+
+		for _, id := range ids {
+			if nack, ok := g.pel[id]; ok == true {
+				delete(nack.consumer.pel, id)
+				delete(g.pel, id)
+				acked++
+			}
+		}
+		return nil
+	})
+
+	return acked, err
+}
+
+
+/* Summary of the group pel, number of entries, lowest and highest ID and entries per consumer in name order */
+func (store *db) XPENDINGSUMMARY(key string, name string) (int, []streamID, []string, []int, error) {
+	if store == nil {
+		fmt.Println("XPENDING : store is nil")
+		return 0, nil, nil, nil, errors.New(fmt.Sprint("XPENDING : store is nil"))
+	}
+
+	var count int
+	var bounds []streamID
+	var consumers []string
+	var counts []int
+
+	err := store.streamRead(key, func(entry *streammapData) error {
+		g, err := entry.st.group(key, name)
+		if err != nil {
+			return err
+		}
+
+		ids := sortedPEL(g.pel)
+		count = len(ids)
+		if count > 0 {
+			bounds = []streamID{ids[0], ids[count-1]}
+		}
+
+		for cname, c := range g.consumers {
+			if len(c.pel) > 0 {
+				consumers = append(consumers, cname)
+			}
+		}
+		sort.Strings(consumers)
+
+		for _, cname := range consumers {
+			counts = append(counts, len(g.consumers[cname].pel))
+		}
+		return nil
+	})
+
+	return count, bounds, consumers, counts, err
+}
+
+
+/* Pending entries of group within start and end, of consumer if not empty and idle at least minIdle ms, up to count entries */
+func (store *db) XPENDING(key string, name string, start streamID, end streamID, count int, consumer string, minIdle int64) ([]streamID, []*streamNACK, error) {
+	if store == nil {
+		fmt.Println("XPENDING : store is nil")
+		return nil, nil, errors.New(fmt.Sprint("XPENDING : store is nil"))
+	}
+
+	ids := []streamID{}
+	nacks := []*streamNACK{}
+
+	err := store.streamRead(key, func(entry *streammapData) error {
+		g, err := entry.st.group(key, name)
+		if err != nil {
+			return err
+		}
+
+		pel := g.pel
+		if consumer != "" {
+			c := g.consumer(consumer, false)
+			if c == nil {
+				return nil
+			}
+			pel = c.pel
+		}
+
+		now := mstime()
+
+		for _, id := range sortedPEL(pel) {
+			if len(ids) >= count {
+				break
+			}
+			if id.less(start) || end.less(id) {
+				continue
+			}
+
+			nack := pel[id]
+			if now - nack.deliveryTime < minIdle {
+				continue
+			}
+
+			/* Copy, the entry may change once the lock is released */
+			ids = append(ids, id)
+			nacks = append(nacks, &streamNACK{consumer: nack.consumer, deliveryTime: nack.deliveryTime, deliveryCount: nack.deliveryCount})
+		}
+		return nil
+	})
+
+	return ids, nacks, err
+}
+
+
+/* Claim pending entries idle at least minIdle ms for consumer, returns the entries claimed. Entries deleted from the stream leave the pel */
+func (store *db) XCLAIM(key string, name string, consumer string, args *xclaimArgs) ([]streamEntry, error) {
+	if store == nil {
+		fmt.Println("XCLAIM : store is nil")
+		return nil, errors.New(fmt.Sprint("XCLAIM : store is nil"))
+	}
+
+	claimed := []streamEntry{}
+
+	err := store.streamUpdate(key, false, func(entry *streammapData) error {
+		g, err := entry.st.group(key, name)
+		if err != nil {
+			return err
+		}
+
+		now := mstime()
+
+		deliveryTime := now
+		if args.idle >= 0 {
+			deliveryTime = now - args.idle
+		} else if args.time >= 0 {
+			deliveryTime = args.time
+		}
+
+		if args.lastID != nil && g.lastID.less(*args.lastID) {
+			g.lastID = *args.lastID
+		}
+
+		c := g.consumer(consumer, true)
+		c.seenTime = now
+
+		for _, id := range args.ids {
+			se, inStream := entry.st.lookup(id)
+			nack, pending := g.pel[id]
+
+			/* FORCE creates the pending entry of an entry in the stream */
+			if pending == false && args.force == true && inStream == true {
+				nack = &streamNACK{consumer: c}
+				g.pel[id] = nack
+				c.pel[id] = nack
+				pending = true
+			}
+
+			if pending == false {
+				continue
+			}
+
+			if inStream == false {
+				delete(nack.consumer.pel, id)
+				delete(g.pel, id)
+				continue
+			}
+
+			if args.minIdle > 0 && now - nack.deliveryTime < args.minIdle {
+				continue
+			}
+
+			g.claim(id, c, deliveryTime, args.justID == false)
+
+			if args.retryCount >= 0 {
+				nack.deliveryCount = args.retryCount
+			}
+
+			claimed = append(claimed, se)
+		}
+
+		if len(claimed) > 0 {
+			c.activeTime = now
+		}
+		return nil
+	})
+
+	return claimed, err
+}
+
+
+/*
+  Scan the group pel from start and claim up to count entries idle at least minIdle ms for consumer
+  Returns the next start ID (0-0 when the scan is done), the entries claimed and the IDs deleted from the stream
+*/
+func (store *db) XAUTOCLAIM(key string, name string, consumer string, minIdle int64, start streamID, count int, justID bool) (streamID, []streamEntry, []streamID, error) {
+	if store == nil {
+		fmt.Println("XAUTOCLAIM : store is nil")
+		return streamID{}, nil, nil, errors.New(fmt.Sprint("XAUTOCLAIM : store is nil"))
+	}
+
+	var next streamID
+	claimed := []streamEntry{}
+	deleted := []streamID{}
+
+	err := store.streamUpdate(key, false, func(entry *streammapData) error {
+		g, err := entry.st.group(key, name)
+		if err != nil {
+			return err
+		}
+
+		now := mstime()
+
+		c := g.consumer(consumer, true)
+		c.seenTime = now
+
+		/* Scan at most 10 times count pending entries per call */
+		scanned := 0
+		ids := sortedPEL(g.pel)
+		i := sort.Search(len(ids), func(k int) bool { return ids[k].less(start) == false })
+
+		for ; i < len(ids) && len(claimed) < count && scanned < 10 * count; i++ {
+			id := ids[i]
+			nack := g.pel[id]
+			scanned++
+
+			se, inStream := entry.st.lookup(id)
+			if inStream == false {
+				delete(nack.consumer.pel, id)
+				delete(g.pel, id)
+				deleted = append(deleted, id)
+				continue
+			}
+
+			if now - nack.deliveryTime < minIdle {
+				continue
+			}
+
+			g.claim(id, c, now, justID == false)
+			claimed = append(claimed, se)
+		}
+
+		if i < len(ids) {
+			next = ids[i]
+		}
+
+		if len(claimed) > 0 {
+			c.activeTime = now
+		}
+		return nil
+	})
+
+	return next, claimed, deleted, err
+}
+
+
+/* XINFO STREAM, field value lines */
+func (store *db) XINFOSTREAM(key string) ([]string, error) {
+	if store == nil {
+		fmt.Println("XINFO : store is nil")
+		return nil, errors.New(fmt.Sprint("XINFO : store is nil"))
+	}
+
+	vals := []string{}
+
+	err := store.streamRead(key, func(entry *streammapData) error {
+		st := entry.st
+
+		vals = append(vals, "length", fmt.Sprint(st.length))
+		vals = append(vals, "nodes", fmt.Sprint(len(st.nodes)))
+		vals = append(vals, "last-generated-id", st.lastID.String())
+		vals = append(vals, "groups", fmt.Sprint(len(st.groups)))
+
+		max := streamID{math.MaxUint64, math.MaxUint64}
+
+		vals = append(vals, "first-entry")
+		if first := st.rangeEntries(streamID{}, max, 1, false); len(first) > 0 {
+			vals = append(vals, streamReply(first)...)
+		} else {
+			vals = append(vals, "(nil)")
+		}
+
+		vals = append(vals, "last-entry")
+		if last := st.rangeEntries(streamID{}, max, 1, true); len(last) > 0 {
+			vals = append(vals, streamReply(last)...)
+		} else {
+			vals = append(vals, "(nil)")
+		}
+		return nil
+	})
+
+	return vals, err
+}
+
+
+/* XINFO GROUPS, field value lines of every group in name order */
+func (store *db) XINFOGROUPS(key string) ([]string, error) {
+	if store == nil {
+		fmt.Println("XINFO : store is nil")
+		return nil, errors.New(fmt.Sprint("XINFO : store is nil"))
+	}
+
+	vals := []string{}
+
+	err := store.streamRead(key, func(entry *streammapData) error {
+		names := make([]string, 0, len(entry.st.groups))
+		for name := range entry.st.groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			g := entry.st.groups[name]
+
+			vals = append(vals, "name", name)
+			vals = append(vals, "consumers", fmt.Sprint(len(g.consumers)))
+			vals = append(vals, "pending", fmt.Sprint(len(g.pel)))
+			vals = append(vals, "last-delivered-id", g.lastID.String())
+		}
+		return nil
+	})
+
+	return vals, err
+}
+
+
+/* XINFO CONSUMERS, field value lines of every consumer of group in name order */
+func (store *db) XINFOCONSUMERS(key string, name string) ([]string, error) {
+	if store == nil {
+		fmt.Println("XINFO : store is nil")
+		return nil, errors.New(fmt.Sprint("XINFO : store is nil"))
+	}
+
+	vals := []string{}
+
+	err := store.streamRead(key, func(entry *streammapData) error {
+		g, err := entry.st.group(key, name)
+		if err != nil {
+			return err
+		}
+
+		names := make([]string, 0, len(g.consumers))
+		for cname := range g.consumers {
+			names = append(names, cname)
+		}
+		sort.Strings(names)
+
+		now := mstime()
+
+		for _, cname := range names {
+			c := g.consumers[cname]
+
+			inactive := int64(-1)
+			if c.activeTime >= 0 {
+				inactive = now - c.activeTime
+			}
+
+			vals = append(vals, "name", cname)
+			vals = append(vals, "pending", fmt.Sprint(len(c.pel)))
+			vals = append(vals, "idle", fmt.Sprint(now - c.seenTime))
+			vals = append(vals, "inactive", fmt.Sprint(inactive))
+		}
+		return nil
+	})
+
+	return vals, err
+}
@@ -0,0 +1,340 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+
+/* Stream s with entries 1-1 to n-1 and group g from 0 */
+func newGroupStore(t *testing.T, n int) *db {
+	t.Helper()
+
+	store := newDB()
+	xaddN(t, store, "s", n)
+	must(t, store.XGROUPCREATE("s", "g", "0", false))
+	return store
+}
+
+
+/* XREADGROUP of a single key, > when id is nil */
+func xreadgroup(t *testing.T, store *db, consumer string, id *streamID, count int, noack bool) []streamEntry {
+	t.Helper()
+
+	var ids = []streamID{{}}
+	newOnly := []bool{id == nil}
+	if id != nil {
+		ids[0] = *id
+	}
+
+	result, err := store.XREADGROUP("g", consumer, []string{"s"}, ids, newOnly, count, noack)
+	must(t, err)
+	return result[0]
+}
+
+
+/* Pending IDs of the group, of consumer if not empty */
+func xpendingIDs(t *testing.T, store *db, consumer string) string {
+	t.Helper()
+
+	ids, _, err := store.XPENDING("s", "g", streamID{}, streamID{math.MaxUint64, math.MaxUint64}, math.MaxInt32, consumer, 0)
+	must(t, err)
+
+	entries := []streamEntry{}
+	for _, id := range ids {
+		entries = append(entries, streamEntry{id: id})
+	}
+	return streamIDs(entries)
+}
+
+
+func TestXgroup(t *testing.T) {
+	store := newGroupStore(t, 3)
+
+	if err := store.XGROUPCREATE("s", "g", "$", false); err == nil || strings.HasPrefix(err.Error(), "BUSYGROUP") == false {
+		t.Fatalf("XGROUP CREATE of existing group gave %v", err)
+	}
+	if err := store.XGROUPCREATE("none", "g", "$", false); err == nil {
+		t.Fatalf("XGROUP CREATE without MKSTREAM created the key")
+	}
+	must(t, store.XGROUPCREATE("new", "g", "$", true))
+	if length, err := store.XLEN("new"); err != nil || length != 0 {
+		t.Fatalf("XGROUP CREATE MKSTREAM gave XLEN %d, %v", length, err)
+	}
+
+	/* $ is the last ID of the stream */
+	must(t, store.XGROUPCREATE("s", "tail", "$", false))
+	if g := store.streammapEntry["s"].st.groups["tail"]; g.lastID != (streamID{3, 1}) {
+		t.Fatalf("XGROUP CREATE $ last ID %v", g.lastID)
+	}
+
+	must(t, store.XGROUPSETID("s", "g", "2-1"))
+	if entries := xreadgroup(t, store, "c", nil, 0, false); streamIDs(entries) != "3-1" {
+		t.Fatalf("XREADGROUP after SETID 2-1 gave %s", streamIDs(entries))
+	}
+	if err := store.XGROUPSETID("s", "none", "$"); err == nil || strings.HasPrefix(err.Error(), "NOGROUP") == false {
+		t.Fatalf("XGROUP SETID of missing group gave %v", err)
+	}
+
+	created, err := store.XGROUPCREATECONSUMER("s", "g", "d")
+	must(t, err)
+	if created == false {
+		t.Fatalf("XGROUP CREATECONSUMER of new consumer")
+	}
+	if created, _ = store.XGROUPCREATECONSUMER("s", "g", "d"); created == true {
+		t.Fatalf("XGROUP CREATECONSUMER of existing consumer")
+	}
+
+	/* Deleting a consumer drops its pending entries */
+	pending, err := store.XGROUPDELCONSUMER("s", "g", "c")
+	must(t, err)
+	if pending != 1 || xpendingIDs(t, store, "") != "" {
+		t.Fatalf("XGROUP DELCONSUMER gave %d, left pel %s", pending, xpendingIDs(t, store, ""))
+	}
+	if pending, _ = store.XGROUPDELCONSUMER("s", "g", "c"); pending != 0 {
+		t.Fatalf("XGROUP DELCONSUMER of deleted consumer gave %d", pending)
+	}
+
+	destroyed, err := store.XGROUPDESTROY("s", "g")
+	must(t, err)
+	if destroyed == false {
+		t.Fatalf("XGROUP DESTROY of existing group")
+	}
+	if destroyed, _ = store.XGROUPDESTROY("s", "g"); destroyed == true {
+		t.Fatalf("XGROUP DESTROY of destroyed group")
+	}
+	if _, err := store.XREADGROUP("g", "c", []string{"s"}, []streamID{{}}, []bool{true}, 0, false); err == nil {
+		t.Fatalf("XREADGROUP of destroyed group")
+	}
+}
+
+
+func TestXreadgroupPEL(t *testing.T) {
+	store := newGroupStore(t, 5)
+
+	if entries := xreadgroup(t, store, "a", nil, 2, false); streamIDs(entries) != "1-1,2-1" {
+		t.Fatalf("XREADGROUP > COUNT 2 gave %s", streamIDs(entries))
+	}
+	if entries := xreadgroup(t, store, "b", nil, 0, false); streamIDs(entries) != "3-1,4-1,5-1" {
+		t.Fatalf("XREADGROUP > gave %s", streamIDs(entries))
+	}
+	if entries := xreadgroup(t, store, "b", nil, 0, false); len(entries) != 0 {
+		t.Fatalf("XREADGROUP > with nothing new gave %s", streamIDs(entries))
+	}
+
+	/* An explicit ID reads the history of the consumer only */
+	if entries := xreadgroup(t, store, "a", &streamID{}, 0, false); streamIDs(entries) != "1-1,2-1" {
+		t.Fatalf("XREADGROUP 0 of a gave %s", streamIDs(entries))
+	}
+	if entries := xreadgroup(t, store, "b", &streamID{3, 1}, 1, false); streamIDs(entries) != "4-1" {
+		t.Fatalf("XREADGROUP 3-1 COUNT 1 of b gave %s", streamIDs(entries))
+	}
+
+	count, bounds, consumers, counts, err := store.XPENDINGSUMMARY("s", "g")
+	must(t, err)
+	if count != 5 || bounds[0] != (streamID{1, 1}) || bounds[1] != (streamID{5, 1}) ||
+		strings.Join(consumers, ",") != "a,b" || counts[0] != 2 || counts[1] != 3 {
+		t.Fatalf("XPENDING summary %d %v %v %v", count, bounds, consumers, counts)
+	}
+
+	ids, nacks, err := store.XPENDING("s", "g", streamID{2, 0}, streamID{4, math.MaxUint64}, 10, "", 0)
+	must(t, err)
+	if len(ids) != 3 || ids[0] != (streamID{2, 1}) || nacks[0].consumer.name != "a" || nacks[1].consumer.name != "b" || nacks[0].deliveryCount != 1 {
+		t.Fatalf("XPENDING 2 4 gave %v", ids)
+	}
+	if got := xpendingIDs(t, store, "b"); got != "3-1,4-1,5-1" {
+		t.Fatalf("XPENDING of b gave %s", got)
+	}
+	if ids, _, _ = store.XPENDING("s", "g", streamID{}, streamID{math.MaxUint64, math.MaxUint64}, 10, "", math.MaxInt32); len(ids) != 0 {
+		t.Fatalf("XPENDING IDLE gave %v", ids)
+	}
+
+	/* XACK removes from the group and the consumer pel, twice counts once */
+	acked, err := store.XACK("s", "g", []streamID{{1, 1}, {4, 1}, {4, 1}, {9, 9}})
+	must(t, err)
+	if acked != 2 || xpendingIDs(t, store, "") != "2-1,3-1,5-1" || xpendingIDs(t, store, "b") != "3-1,5-1" {
+		t.Fatalf("XACK gave %d, left pel %s", acked, xpendingIDs(t, store, ""))
+	}
+	if acked, err = store.XACK("s", "none", []streamID{{2, 1}}); err != nil || acked != 0 {
+		t.Fatalf("XACK of missing group gave %d, %v", acked, err)
+	}
+
+	/* NOACK delivers without adding to the pel */
+	store.XADD("s", "6-1", []string{"f", "v"}, &streamTrim{}, false)
+	if entries := xreadgroup(t, store, "a", nil, 0, true); streamIDs(entries) != "6-1" || xpendingIDs(t, store, "a") != "2-1" {
+		t.Fatalf("XREADGROUP NOACK gave %s, pel of a %s", streamIDs(entries), xpendingIDs(t, store, "a"))
+	}
+
+	/* A pending entry deleted from the stream is read back with nil fields */
+	store.XDEL("s", []streamID{{3, 1}})
+	entries := xreadgroup(t, store, "b", &streamID{}, 0, false)
+	if streamIDs(entries) != "3-1,5-1" || entries[0].fields != nil || len(entries[1].fields) != 2 {
+		t.Fatalf("XREADGROUP history after XDEL gave %v", entries)
+	}
+
+	/* Delivered again after SETID, the entry moves to the new consumer */
+	must(t, store.XGROUPSETID("s", "g", "4-1"))
+	xreadgroup(t, store, "a", nil, 1, false)
+	if xpendingIDs(t, store, "a") != "2-1,5-1" || xpendingIDs(t, store, "b") != "3-1" {
+		t.Fatalf("redelivery left pel of a %s and of b %s", xpendingIDs(t, store, "a"), xpendingIDs(t, store, "b"))
+	}
+}
+
+
+func TestXclaim(t *testing.T) {
+	store := newGroupStore(t, 4)
+	xreadgroup(t, store, "a", nil, 0, false)
+
+	/* Not idle long enough */
+	claimed, err := store.XCLAIM("s", "g", "b", &xclaimArgs{minIdle: math.MaxInt32, ids: []streamID{{1, 1}}, idle: -1, time: -1, retryCount: -1})
+	must(t, err)
+	if len(claimed) != 0 {
+		t.Fatalf("XCLAIM of busy entry gave %s", streamIDs(claimed))
+	}
+
+	claimed, err = store.XCLAIM("s", "g", "b", &xclaimArgs{ids: []streamID{{1, 1}, {2, 1}, {9, 9}}, idle: -1, time: -1, retryCount: -1})
+	must(t, err)
+	if streamIDs(claimed) != "1-1,2-1" || xpendingIDs(t, store, "b") != "1-1,2-1" || xpendingIDs(t, store, "a") != "3-1,4-1" {
+		t.Fatalf("XCLAIM gave %s, pel of b %s", streamIDs(claimed), xpendingIDs(t, store, "b"))
+	}
+
+	ids, nacks, _ := store.XPENDING("s", "g", streamID{1, 1}, streamID{1, 1}, 1, "", 0)
+	if len(ids) != 1 || nacks[0].deliveryCount != 2 {
+		t.Fatalf("XCLAIM delivery count %v", nacks)
+	}
+
+	/* JUSTID keeps the delivery count, RETRYCOUNT sets it */
+	store.XCLAIM("s", "g", "a", &xclaimArgs{ids: []streamID{{1, 1}}, idle: -1, time: -1, retryCount: -1, justID: true})
+	store.XCLAIM("s", "g", "a", &xclaimArgs{ids: []streamID{{2, 1}}, idle: -1, time: -1, retryCount: 7})
+	_, nacks, _ = store.XPENDING("s", "g", streamID{}, streamID{2, 1}, 2, "", 0)
+	if nacks[0].deliveryCount != 2 || nacks[1].deliveryCount != 7 {
+		t.Fatalf("XCLAIM JUSTID and RETRYCOUNT counts %d %d", nacks[0].deliveryCount, nacks[1].deliveryCount)
+	}
+
+	/* FORCE claims an entry never delivered, not one missing from the stream */
+	store.XADD("s", "5-1", []string{"f", "v"}, &streamTrim{}, false)
+	claimed, _ = store.XCLAIM("s", "g", "b", &xclaimArgs{ids: []streamID{{5, 1}, {6, 1}}, idle: -1, time: -1, retryCount: -1, force: true})
+	if streamIDs(claimed) != "5-1" || xpendingIDs(t, store, "b") != "5-1" {
+		t.Fatalf("XCLAIM FORCE gave %s", streamIDs(claimed))
+	}
+
+	/* A pending entry deleted from the stream leaves the pel */
+	store.XDEL("s", []streamID{{3, 1}})
+	claimed, _ = store.XCLAIM("s", "g", "b", &xclaimArgs{ids: []streamID{{3, 1}}, idle: -1, time: -1, retryCount: -1})
+	if len(claimed) != 0 || xpendingIDs(t, store, "") != "1-1,2-1,4-1,5-1" {
+		t.Fatalf("XCLAIM of deleted entry gave %s, left pel %s", streamIDs(claimed), xpendingIDs(t, store, ""))
+	}
+
+	/* LASTID moves the group last ID forward only */
+	store.XCLAIM("s", "g", "b", &xclaimArgs{idle: -1, time: -1, retryCount: -1, lastID: &streamID{9, 0}})
+	store.XCLAIM("s", "g", "b", &xclaimArgs{idle: -1, time: -1, retryCount: -1, lastID: &streamID{1, 0}})
+	if g := store.streammapEntry["s"].st.groups["g"]; g.lastID != (streamID{9, 0}) {
+		t.Fatalf("XCLAIM LASTID left %v", g.lastID)
+	}
+}
+
+
+func TestXautoclaim(t *testing.T) {
+	store := newGroupStore(t, 30)
+	xreadgroup(t, store, "a", nil, 0, false)
+	store.XDEL("s", []streamID{{2, 1}})
+
+	next, claimed, deleted, err := store.XAUTOCLAIM("s", "g", "b", 0, streamID{}, 3, false)
+	must(t, err)
+	if next != (streamID{5, 1}) || streamIDs(claimed) != "1-1,3-1,4-1" || len(deleted) != 1 || deleted[0] != (streamID{2, 1}) {
+		t.Fatalf("XAUTOCLAIM gave next %v claimed %s deleted %v", next, streamIDs(claimed), deleted)
+	}
+	if xpendingIDs(t, store, "b") != "1-1,3-1,4-1" {
+		t.Fatalf("XAUTOCLAIM left pel of b %s", xpendingIDs(t, store, "b"))
+	}
+
+	/* Nothing idle enough, the scan stops after 10 times count entries */
+	next, claimed, _, err = store.XAUTOCLAIM("s", "g", "b", math.MaxInt32, streamID{5, 1}, 2, false)
+	must(t, err)
+	if next != (streamID{25, 1}) || len(claimed) != 0 {
+		t.Fatalf("XAUTOCLAIM of busy entries gave next %v claimed %s", next, streamIDs(claimed))
+	}
+
+	/* A scan reaching the end gives 0-0 */
+	next, claimed, _, err = store.XAUTOCLAIM("s", "g", "b", 0, streamID{25, 1}, 100, true)
+	must(t, err)
+	if next != (streamID{}) || len(claimed) != 6 {
+		t.Fatalf("XAUTOCLAIM to the end gave next %v claimed %d", next, len(claimed))
+	}
+
+	if _, _, _, err := store.XAUTOCLAIM("s", "none", "b", 0, streamID{}, 1, false); err == nil {
+		t.Fatalf("XAUTOCLAIM of missing group")
+	}
+}
+
+
+func TestXinfo(t *testing.T) {
+	store := newGroupStore(t, 3)
+	must(t, store.XGROUPCREATE("s", "f", "$", false))
+	xreadgroup(t, store, "b", nil, 1, false)
+	store.XGROUPCREATECONSUMER("s", "g", "a")
+
+	vals, err := store.XINFOSTREAM("s")
+	must(t, err)
+	if got := strings.Join(vals, " "); got != "length 3 nodes 1 last-generated-id 3-1 groups 2 first-entry 1-1 f 1 last-entry 3-1 f 3" {
+		t.Fatalf("XINFO STREAM %s", got)
+	}
+
+	vals, err = store.XINFOGROUPS("s")
+	must(t, err)
+	if got := strings.Join(vals, " "); got != "name f consumers 0 pending 0 last-delivered-id 3-1 name g consumers 2 pending 1 last-delivered-id 1-1" {
+		t.Fatalf("XINFO GROUPS %s", got)
+	}
+
+	vals, err = store.XINFOCONSUMERS("s", "g")
+	must(t, err)
+	if len(vals) != 16 || vals[1] != "a" || vals[3] != "0" || vals[7] != "-1" || vals[9] != "b" || vals[11] != "1" {
+		t.Fatalf("XINFO CONSUMERS %v", vals)
+	}
+
+	store.XTRIM("s", &streamTrim{strategy: streamTrimMaxLen})
+	vals, _ = store.XINFOSTREAM("s")
+	if got := strings.Join(vals, " "); got != "length 0 nodes 0 last-generated-id 3-1 groups 2 first-entry (nil) last-entry (nil)" {
+		t.Fatalf("XINFO STREAM of emptied stream %s", got)
+	}
+}
+
+
+func TestStreamGroupSaveLoad(t *testing.T) {
+	store := newGroupStore(t, 4)
+	xreadgroup(t, store, "a", nil, 2, false)
+	xreadgroup(t, store, "b", nil, 1, false)
+	store.XGROUPCREATECONSUMER("s", "g", "idle")
+	store.XCLAIM("s", "g", "b", &xclaimArgs{ids: []streamID{{2, 1}}, idle: -1, time: -1, retryCount: 5})
+	must(t, store.XGROUPCREATE("s", "empty", "$", false))
+
+	loaded := saveLoad(t, store)
+
+	for _, consumer := range []string{"", "a", "b", "idle"} {
+		if got, want := xpendingIDs(t, loaded, consumer), xpendingIDs(t, store, consumer); got != want {
+			t.Fatalf("loaded pel of %q %s, want %s", consumer, got, want)
+		}
+	}
+
+	_, nacks, _ := loaded.XPENDING("s", "g", streamID{2, 1}, streamID{2, 1}, 1, "", 0)
+	if len(nacks) != 1 || nacks[0].consumer.name != "b" || nacks[0].deliveryCount != 5 {
+		t.Fatalf("loaded pending entry 2-1 %v", nacks)
+	}
+
+	want, _ := store.XINFOGROUPS("s")
+	got, _ := loaded.XINFOGROUPS("s")
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("loaded groups %v, want %v", got, want)
+	}
+
+	/* Loaded group reads on from its last ID */
+	if entries := xreadgroup(t, loaded, "a", nil, 0, false); streamIDs(entries) != "4-1" {
+		t.Fatalf("XREADGROUP > after load gave %s", streamIDs(entries))
+	}
+}
@@ -0,0 +1,983 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+
+const (
+	// Max number of fields of a hash in listpack encoding
+	hashMaxListpackEntries = 128
+
+	// Max bytes of a field or value of a hash in listpack encoding
+	hashMaxListpackValue = 64
+
+	// Max -count of HRANDFIELD, the fields repeat past the size of the hash
+	hashMaxRandCount = 1 << 20
+)
+
+
+/*
+  Hash storage, same idea as the redis hash encodings
+
+  A small hash is stored as field value pairs in a single listpack (see list.go),
+  lookups walk the pairs which is cheap for few short entries and saves a map
+  per key. Once the hash grows past hashMaxListpackEntries fields or stores a
+  field or value longer than hashMaxListpackValue, it is converted to a map
+  and stays a map. In map encoding the fields and values are kept in slices,
+  dict maps a field to its index, a deleted field takes the place of the last
+  one. The HSCAN cursor is an index in the encoding.
+
+  Field expiration
+  expires map[field] value is the expiration time of the field in unix nano,
+  nil until a field gets a TTL. An expired field is hidden from the readers
+  right away, and removed by the next write on the key (lazy) or by the
+  caretaker (active), whichever comes first.
+*/
+
+type hashmapData struct {
+	lp *listpack
+	dict map[string]int
+	fields []string
+	vals []string
+	expires map[string]int64
+	lock *sync.RWMutex
+}
+
+
+func newHashmapData() *hashmapData {
+	return &hashmapData{
+		lp: &listpack{},
+		lock: &sync.RWMutex{},
+	}
+}
+
+
+/* Number of fields, expired fields not counted */
+func (h *hashmapData) length() int {
+	var length int
+	if h.lp != nil {
+		length = h.lp.count / 2
+	} else {
+		length = len(h.fields)
+	}
+
+	if h.expires != nil {
+		now := time.Now().UnixNano()
+		for _, e := range h.expires {
+			if now > e {
+				length--
+			}
+		}
+	}
+
+	return length
+}
+
+
+/* Returns true if the field has a TTL which is over */
+func (h *hashmapData) expired(field string, now int64) bool {
+	if h.expires == nil {
+		return false
+	}
+
+	e, ok := h.expires[field]
+	return ok == true && now > e
+}
+
+
+/* Returns the pair index of field in the listpack and its value */
+func (h *hashmapData) find(field string) (int, string, bool) {
+	off := 0
+	for i := 0; i < h.lp.count / 2; i++ {
+		var f, val string
+		f, off = h.lp.entry(off)
+		val, off = h.lp.entry(off)
+
+		if f == field {
+			return i, val, true
+		}
+	}
+	return 0, "", false
+}
+
+
+/* Value of field, an expired field is not found */
+func (h *hashmapData) get(field string) (string, bool) {
+	if h.expired(field, time.Now().UnixNano()) == true {
+		return "", false
+	}
+
+	if h.lp != nil {
+		_, val, ok := h.find(field)
+		return val, ok
+	}
+
+	i, ok := h.dict[field]
+	if ok == false {
+		return "", false
+	}
+	return h.vals[i], true
+}
+
+
+/* Set field to val keeping its TTL, returns true if field is new */
+func (h *hashmapData) set(field string, val string) bool {
+	if h.lp != nil && (len(field) > hashMaxListpackValue || len(val) > hashMaxListpackValue) {
+		h.convert()
+	}
+
+	if h.lp != nil {
+		i, _, ok := h.find(field)
+		if ok == true {
+			h.lp.replace(2*i + 1, val)
+			return false
+		}
+
+		if h.lp.count / 2 >= hashMaxListpackEntries {
+			h.convert()
+		} else {
+			h.lp.insert(h.lp.count, field)
+			h.lp.insert(h.lp.count, val)
+			return true
+		}
+	}
+
+	if i, ok := h.dict[field]; ok == true {
+		h.vals[i] = val
+		return false
+	}
+
+	h.dict[field] = len(h.fields)
+	h.fields = append(h.fields, field)
+	h.vals = append(h.vals, val)
+	return true
+}
+
+
+/* Delete field and its TTL, returns true if it was present */
+func (h *hashmapData) del(field string) bool {
+	h.persist(field)
+
+	if h.lp != nil {
+		i, _, ok := h.find(field)
+		if ok == true {
+			h.lp.delete(2*i, 2)
+		}
+		return ok
+	}
+
+	i, ok := h.dict[field]
+	if ok == false {
+		return false
+	}
+
+	/* Last field takes the place of the deleted one */
+	last := len(h.fields) - 1
+	h.fields[i], h.vals[i] = h.fields[last], h.vals[last]
+	h.dict[h.fields[i]] = i
+	h.fields[last], h.vals[last] = "", ""
+	h.fields, h.vals = h.fields[:last], h.vals[:last]
+
+	delete(h.dict, field)
+	return true
+}
+
+
+/* Convert listpack encoding to map encoding, fields keep their order */
+func (h *hashmapData) convert() {
+	pairs := h.lp.values()
+
+	h.dict = make(map[string]int, len(pairs) / 2)
+	h.fields = make([]string, 0, len(pairs) / 2)
+	h.vals = make([]string, 0, len(pairs) / 2)
+	for i := 0; i < len(pairs); i = i + 2 {
+		h.dict[pairs[i]] = len(h.fields)
+		h.fields = append(h.fields, pairs[i])
+		h.vals = append(h.vals, pairs[i+1])
+	}
+
+	h.lp = nil
+}
+
+
+/* Number of fields in the encoding, expired fields counted */
+func (h *hashmapData) size() int {
+	if h.lp != nil {
+		return h.lp.count / 2
+	}
+	return len(h.fields)
+}
+
+
+/* Field value pairs from index start of the encoding, up to count of them. Expired fields counted and not left out */
+func (h *hashmapData) rawPairs(start int, count int) []string {
+	vals := []string{}
+
+	if h.lp != nil {
+		off := 0
+		for i := 0; i < h.lp.count / 2 && len(vals) < 2 * count; i++ {
+			var field, val string
+			field, off = h.lp.entry(off)
+			val, off = h.lp.entry(off)
+
+			if i >= start {
+				vals = append(vals, field, val)
+			}
+		}
+		return vals
+	}
+
+	for i := start; i < len(h.fields) && len(vals) < 2 * count; i++ {
+		vals = append(vals, h.fields[i], h.vals[i])
+	}
+	return vals
+}
+
+
+/* All field value pairs in the order of the encoding. Expired fields are left out */
+func (h *hashmapData) pairs() []string {
+	var vals []string
+
+	if h.lp != nil {
+		vals = h.lp.values()
+	} else {
+		vals = make([]string, 0, 2 * len(h.fields))
+		for i := range h.fields {
+			vals = append(vals, h.fields[i], h.vals[i])
+		}
+	}
+
+	if h.expires == nil {
+		return vals
+	}
+
+	now := time.Now().UnixNano()
+	live := vals[:0]
+	for i := 0; i < len(vals); i = i + 2 {
+		if h.expired(vals[i], now) == false {
+			live = append(live, vals[i], vals[i+1])
+		}
+	}
+	return live
+}
+
+
+/* Set the expiration time of field in unix nano */
+func (h *hashmapData) expire(field string, e int64) {
+	if h.expires == nil {
+		h.expires = make(map[string]int64)
+	}
+	h.expires[field] = e
+}
+
+
+/* Remove the TTL of field, returns true if it had one */
+func (h *hashmapData) persist(field string) bool {
+	if h.expires == nil {
+		return false
+	}
+
+	_, ok := h.expires[field]
+	delete(h.expires, field)
+
+	if len(h.expires) == 0 {
+		h.expires = nil
+	}
+	return ok
+}
+
+
+/* Delete the expired fields, returns the number of fields deleted */
+func (h *hashmapData) removeExpired(now int64) int {
+	removed := 0
+
+	for field, e := range h.expires {
+		if now > e {
+			h.del(field)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+
+/* Run update with the key write lock held, the key is created if absent and kept if update leaves fields */
+func (store *db) hashUpdate(key string, update func(entry *hashmapData) error) error {
+	var entry *hashmapData
+	var ok bool
+
+	store.hashmapDBLock.RLock()
+
+	/* Check if db has the key entry */
+	entry, ok = store.hashmapEntry[key]
+
+	/* If entry not present */
+	if ok == false {
+		/* Take DB lock before creating entry for this key, to ensure only one entry gets created */
+		store.hashmapDBLock.RUnlock()
+		store.hashmapDBLock.Lock()
+
+		/* Again Check if db has the key entry, between above if & db lock it is possible other routine has created this entry */
+		var okrecheck bool
+		entry, okrecheck = store.hashmapEntry[key]
+
+		if okrecheck == false {
+			entry = newHashmapData()
+		}
+	}
+
+	/* Take DB entry lock before update, this is lock per key entry */
+	entry.lock.Lock()
+
+	/* Lazy expiration, the update never sees expired fields */
+	entry.removeExpired(time.Now().UnixNano())
+
+	err := update(entry)
+
+	/* Keep the search indexes in sync, under the key lock so indexing follows the write order */
+	store.searchIndexHash(key, entry)
+
+	empty := entry.length() == 0
+
+	if ok == false && empty == false {
+		store.hashmapEntry[key] = entry
+	} else if ok == false && empty == true {
+		/* The recheck found a key other routine created, update emptied it and the write lock is held */
+		delete(store.hashmapEntry, key)
+	}
+
+	entry.lock.Unlock()
+
+	/* Both locks got released in LIFO order, entry lock followed by db lock */
+	if ok == false {
+		store.hashmapDBLock.Unlock()
+	} else {
+		store.hashmapDBLock.RUnlock()
+	}
+
+	/* All fields expired and update failed */
+	if ok == true && empty == true {
+		store.hashDeleteIfEmpty(key, entry)
+	}
+
+	return err
+}
+
+
+/* Set field value pairs, returns the number of fields added */
+func (store *db) HSET(key string, pairs []string) (int, error) {
+	if store == nil {
+		fmt.Println("HSET : store is nil")
+		return 0, errors.New(fmt.Sprint("HSET : store is nil"))
+	}
+
+	added := 0
+
+	err := store.hashUpdate(key, func(entry *hashmapData) error {
+		/* A field set by HSET loses its TTL */
+		for i := 0; i + 1 < len(pairs); i = i + 2 {
+			if entry.set(pairs[i], pairs[i+1]) == true {
+				added++
+			}
+			entry.persist(pairs[i])
+		}
+		return nil
+	})
+
+	return added, err
+}
+
+
+/* Set field only if it does not exist, returns true if set */
+func (store *db) HSETNX(key string, field string, val string) (bool, error) {
+	if store == nil {
+		fmt.Println("HSETNX : store is nil")
+		return false, errors.New(fmt.Sprint("HSETNX : store is nil"))
+	}
+
+	added := false
+
+	err := store.hashUpdate(key, func(entry *hashmapData) error {
+		if _, ok := entry.get(field); ok == false {
+			added = entry.set(field, val)
+		}
+		return nil
+	})
+
+	return added, err
+}
+
+
+/* Values of fields, found is false for fields not in the hash */
+func (store *db) HMGET(key string, fields []string) ([]string, []bool, error) {
+	if store == nil {
+		fmt.Println("HMGET : store is nil")
+		return nil, nil, errors.New(fmt.Sprint("HMGET : store is nil"))
+	}
+
+	vals := make([]string, len(fields))
+	found := make([]bool, len(fields))
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.hashmapDBLock.RLock()
+	defer store.hashmapDBLock.RUnlock()
+
+	entry, ok := store.hashmapEntry[key]
+
+	if ok == false {
+		return vals, found, errors.New(fmt.Sprint("HMGET : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	for i, field := range fields {
+		vals[i], found[i] = entry.get(field)
+	}
+
+	return vals, found, nil
+}
+
+
+/* All field value pairs of the hash */
+func (store *db) HGETALL(key string) ([]string, error) {
+	if store == nil {
+		fmt.Println("HGETALL : store is nil")
+		return nil, errors.New(fmt.Sprint("HGETALL : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.hashmapDBLock.RLock()
+	defer store.hashmapDBLock.RUnlock()
+
+	entry, ok := store.hashmapEntry[key]
+
+	if ok == false {
+		return []string{}, errors.New(fmt.Sprint("HGETALL : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	return entry.pairs(), nil
+}
+
+
+func (store *db) HLEN(key string) (int, error) {
+	if store == nil {
+		fmt.Println("HLEN : store is nil")
+		return 0, errors.New(fmt.Sprint("HLEN : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.hashmapDBLock.RLock()
+	defer store.hashmapDBLock.RUnlock()
+
+	entry, ok := store.hashmapEntry[key]
+
+	if ok == false {
+		return 0, errors.New(fmt.Sprint("HLEN : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	return entry.length(), nil
+}
+
+
+/* Delete fields, returns the number of fields removed, the key is deleted with its last field */
+func (store *db) HDEL(key string, fields []string) (int, error) {
+	if store == nil {
+		fmt.Println("HDEL : store is nil")
+		return 0, errors.New(fmt.Sprint("HDEL : store is nil"))
+	}
+
+	removed := 0
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.hashmapDBLock.RLock()
+
+	entry, ok := store.hashmapEntry[key]
+
+	if ok == false {
+		store.hashmapDBLock.RUnlock()
+		return 0, errors.New(fmt.Sprint("HDEL : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before delete, this is lock per key entry */
+	entry.lock.Lock()
+
+	/* Lazy expiration, an expired field does not count as removed */
+	entry.removeExpired(time.Now().UnixNano())
+
+	for _, field := range fields {
+		if entry.del(field) == true {
+			removed++
+		}
+	}
+
+	store.searchIndexHash(key, entry)
+
+	empty := entry.length() == 0
+
+	entry.lock.Unlock()
+	store.hashmapDBLock.RUnlock()
+
+	if empty == true {
+		store.hashDeleteIfEmpty(key, entry)
+	}
+
+	return removed, nil
+}
+
+
+/* Increment the integer value of field by incr, a missing field counts as 0 */
+func (store *db) HINCRBY(key string, field string, incr int64) (int64, error) {
+	if store == nil {
+		fmt.Println("HINCRBY : store is nil")
+		return 0, errors.New(fmt.Sprint("HINCRBY : store is nil"))
+	}
+
+	var value int64
+
+	err := store.hashUpdate(key, func(entry *hashmapData) error {
+		var cur int64
+
+		if val, ok := entry.get(field); ok == true {
+			var err error
+			cur, err = strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return errors.New("hash value is not an integer")
+			}
+		}
+
+		if (incr > 0 && cur > math.MaxInt64 - incr) || (incr < 0 && cur < math.MinInt64 - incr) {
+			return errors.New("increment or decrement would overflow")
+		}
+
+		value = cur + incr
+		entry.set(field, strconv.FormatInt(value, 10))
+		return nil
+	})
+
+	return value, err
+}
+
+
+/* Increment the float value of field by incr, a missing field counts as 0 */
+func (store *db) HINCRBYFLOAT(key string, field string, incr float64) (float64, error) {
+	if store == nil {
+		fmt.Println("HINCRBYFLOAT : store is nil")
+		return 0, errors.New(fmt.Sprint("HINCRBYFLOAT : store is nil"))
+	}
+
+	var value float64
+
+	err := store.hashUpdate(key, func(entry *hashmapData) error {
+		var cur float64
+
+		if val, ok := entry.get(field); ok == true {
+			var err error
+			cur, err = strconv.ParseFloat(val, 64)
+			if err != nil || math.IsNaN(cur) || math.IsInf(cur, 0) {
+				return errors.New("hash value is not a float")
+			}
+		}
+
+		value = cur + incr
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return errors.New("increment would produce NaN or Infinity")
+		}
+
+		entry.set(field, strconv.FormatFloat(value, 'f', -1, 64))
+		return nil
+	})
+
+	return value, err
+}
+
+
+/*
+  Scan the hash from cursor, returns the next cursor and up to count field value pairs
+  The cursor is the index of the next field in the encoding, 0 starts and ends the iteration.
+  Fields deleted during the iteration move other fields and may cause fields to be
+  skipped or returned twice. Expired fields count against count and are not returned.
+*/
+func (store *db) HSCAN(key string, cursor int, pattern string, count int) (int, []string, error) {
+	if store == nil {
+		fmt.Println("HSCAN : store is nil")
+		return 0, nil, errors.New(fmt.Sprint("HSCAN : store is nil"))
+	}
+
+	pairs := []string{}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.hashmapDBLock.RLock()
+	defer store.hashmapDBLock.RUnlock()
+
+	entry, ok := store.hashmapEntry[key]
+
+	if ok == false {
+		return 0, pairs, errors.New(fmt.Sprint("HSCAN : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	now := time.Now().UnixNano()
+	page := entry.rawPairs(cursor, count)
+
+	for i := 0; i < len(page); i = i + 2 {
+		if entry.expired(page[i], now) == false && (pattern == "" || stringMatch(pattern, page[i])) {
+			pairs = append(pairs, page[i], page[i+1])
+		}
+	}
+
+	cursor = cursor + len(page) / 2
+	if cursor >= entry.size() {
+		cursor = 0
+	}
+
+	return cursor, pairs, nil
+}
+
+
+/*
+  Random fields of hash as field value pairs
+  count > 0 returns up to count distinct fields, count < 0 returns -count fields which may repeat
+*/
+func (store *db) HRANDFIELD(key string, count int) ([]string, error) {
+	if store == nil {
+		fmt.Println("HRANDFIELD : store is nil")
+		return nil, errors.New(fmt.Sprint("HRANDFIELD : store is nil"))
+	}
+
+	vals := []string{}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.hashmapDBLock.RLock()
+	defer store.hashmapDBLock.RUnlock()
+
+	entry, ok := store.hashmapEntry[key]
+
+	if ok == false {
+		return vals, errors.New(fmt.Sprint("HRANDFIELD : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	pairs := entry.pairs()
+	size := len(pairs) / 2
+
+	/* Every field expired, the caretaker has not removed the key yet */
+	if size == 0 {
+		return vals, nil
+	}
+
+	/* Repetition allowed, every field is a random pick */
+	if count < 0 {
+		for i := 0; i < -count; i++ {
+			k := rand.Intn(size)
+			vals = append(vals, pairs[2*k], pairs[2*k + 1])
+		}
+		return vals, nil
+	}
+
+	/* Distinct fields, count covers the whole hash */
+	if count >= size {
+		return pairs, nil
+	}
+
+	/* Distinct fields, first count of a random permutation of the pair indexes */
+	perm := rand.Perm(size)
+	for _, k := range perm[:count] {
+		vals = append(vals, pairs[2*k], pairs[2*k + 1])
+	}
+
+	return vals, nil
+}
+
+
+/*
+  Set the expiration time of fields in unix nano, cond is one of NX XX GT LT or empty
+  Result per field is -2 no such field, 0 condition not met, 1 TTL set, 2 field deleted as the time is over
+*/
+func (store *db) HEXPIRE(key string, fields []string, e int64, cond string) ([]int, error) {
+	if store == nil {
+		fmt.Println("HEXPIRE : store is nil")
+		return nil, errors.New(fmt.Sprint("HEXPIRE : store is nil"))
+	}
+
+	results := make([]int, len(fields))
+	for i := range results {
+		results[i] = -2
+	}
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.hashmapDBLock.RLock()
+
+	entry, ok := store.hashmapEntry[key]
+
+	if ok == false {
+		store.hashmapDBLock.RUnlock()
+		return results, errors.New(fmt.Sprint("HEXPIRE : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before update, this is lock per key entry */
+	entry.lock.Lock()
+
+	now := time.Now().UnixNano()
+
+	/* Lazy expiration, an expired field is no such field */
+	entry.removeExpired(now)
+
+	for i, field := range fields {
+		if _, found := entry.get(field); found == false {
+			continue
+		}
+
+		/* A field without TTL has infinite TTL for GT and LT */
+		cur, hasTTL := entry.expires[field]
+
+		if (cond == "NX" && hasTTL == true) || (cond == "XX" && hasTTL == false) ||
+			(cond == "GT" && (hasTTL == false || e <= cur)) || (cond == "LT" && hasTTL == true && e >= cur) {
+			results[i] = 0
+			continue
+		}
+
+		if e <= now {
+			entry.del(field)
+			results[i] = 2
+			continue
+		}
+
+		entry.expire(field, e)
+		results[i] = 1
+	}
+
+	store.searchIndexHash(key, entry)
+
+	empty := entry.length() == 0
+
+	entry.lock.Unlock()
+	store.hashmapDBLock.RUnlock()
+
+	if empty == true {
+		store.hashDeleteIfEmpty(key, entry)
+	}
+
+	return results, nil
+}
+
+
+/* Remaining time to live of fields, -2 no such field, -1 field has no TTL */
+func (store *db) HTTL(key string, fields []string) ([]time.Duration, error) {
+	if store == nil {
+		fmt.Println("HTTL : store is nil")
+		return nil, errors.New(fmt.Sprint("HTTL : store is nil"))
+	}
+
+	ttls := make([]time.Duration, len(fields))
+	for i := range ttls {
+		ttls[i] = -2
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.hashmapDBLock.RLock()
+	defer store.hashmapDBLock.RUnlock()
+
+	entry, ok := store.hashmapEntry[key]
+
+	if ok == false {
+		return ttls, errors.New(fmt.Sprint("HTTL : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	now := time.Now().UnixNano()
+
+	for i, field := range fields {
+		if _, found := entry.get(field); found == false {
+			continue
+		}
+
+		if e, hasTTL := entry.expires[field]; hasTTL == true {
+			ttls[i] = time.Duration(e - now)
+		} else {
+			ttls[i] = -1
+		}
+	}
+
+	return ttls, nil
+}
+
+
+/* Remove the TTL of fields, result per field is -2 no such field, -1 field has no TTL, 1 TTL removed */
+func (store *db) HPERSIST(key string, fields []string) ([]int, error) {
+	if store == nil {
+		fmt.Println("HPERSIST : store is nil")
+		return nil, errors.New(fmt.Sprint("HPERSIST : store is nil"))
+	}
+
+	results := make([]int, len(fields))
+	for i := range results {
+		results[i] = -2
+	}
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.hashmapDBLock.RLock()
+
+	entry, ok := store.hashmapEntry[key]
+
+	if ok == false {
+		store.hashmapDBLock.RUnlock()
+		return results, errors.New(fmt.Sprint("HPERSIST : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before update, this is lock per key entry */
+	entry.lock.Lock()
+
+	/* Lazy expiration, an expired field is no such field */
+	if entry.removeExpired(time.Now().UnixNano()) > 0 {
+		store.searchIndexHash(key, entry)
+	}
+
+	for i, field := range fields {
+		if _, found := entry.get(field); found == false {
+			continue
+		}
+
+		if entry.persist(field) == true {
+			results[i] = 1
+		} else {
+			results[i] = -1
+		}
+	}
+
+	empty := entry.length() == 0
+
+	entry.lock.Unlock()
+	store.hashmapDBLock.RUnlock()
+
+	if empty == true {
+		store.hashDeleteIfEmpty(key, entry)
+	}
+
+	return results, nil
+}
+
+
+/* Lazy expiration of hash key outside a write, indexes the hash again as its TTLs may have changed */
+func (store *db) hashRemoveExpired(key string) {
+	store.hashmapDBLock.RLock()
+
+	entry, ok := store.hashmapEntry[key]
+
+	if ok == false {
+		store.hashmapDBLock.RUnlock()
+		return
+	}
+
+	entry.lock.Lock()
+
+	entry.removeExpired(time.Now().UnixNano())
+	store.searchIndexHash(key, entry)
+
+	empty := entry.length() == 0
+
+	entry.lock.Unlock()
+	store.hashmapDBLock.RUnlock()
+
+	if empty == true {
+		store.hashDeleteIfEmpty(key, entry)
+	}
+}
+
+
+/* Active expiration of hash fields, run by the caretaker. Deletes the key with its last field */
+func (store *db) HashDeleteExpired() {
+	if store == nil {
+		fmt.Println("HashDeleteExpired : store is nil")
+		return
+	}
+
+	now := time.Now().UnixNano()
+
+	store.hashmapDBLock.Lock()
+	defer store.hashmapDBLock.Unlock()
+
+	for key, entry := range store.hashmapEntry {
+		if entry.expires == nil {
+			continue
+		}
+
+		entry.lock.Lock()
+		removed := entry.removeExpired(now)
+		if removed > 0 {
+			store.searchIndexHash(key, entry)
+		}
+		empty := entry.length() == 0
+		entry.lock.Unlock()
+
+		if removed > 0 {
+			fmt.Println("Evicted - key : ", key, "  hash fields : ", removed, "  Now: ", now)
+		}
+
+		if empty == true {
+			delete(store.hashmapEntry, key)
+		}
+	}
+}
+
+
+/* Parse FIELDS numfields field [field ...] of the hash field expiration commands */
+func parseHashFieldsArgs(args []string) ([]string, error) {
+	if len(args) < 3 || strings.ToUpper(args[0]) != "FIELDS" {
+		return nil, errors.New("syntax error")
+	}
+
+	numfields, err := strconv.Atoi(args[1])
+	if err != nil || numfields < 1 {
+		return nil, errors.New("numfields should be greater than 0")
+	}
+
+	if numfields != len(args) - 2 {
+		return nil, errors.New("numfields parameter must match the number of arguments")
+	}
+
+	return args[2:], nil
+}
+
+
+/* Delete the key if the hash is still empty, other routine may have set a field since the check */
+func (store *db) hashDeleteIfEmpty(key string, entry *hashmapData) {
+	store.hashmapDBLock.Lock()
+	defer store.hashmapDBLock.Unlock()
+
+	if store.hashmapEntry[key] == entry && entry.length() == 0 {
+		delete(store.hashmapEntry, key)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+
+/* Fail unless the field value pairs of key are want, in any order */
+func checkHash(t *testing.T, store *db, key string, want map[string]string) {
+	t.Helper()
+
+	pairs, err := store.HGETALL(key)
+	if err != nil && len(want) > 0 {
+		t.Fatalf("HGETALL %v: %v", key, err)
+	}
+
+	if len(pairs) != 2 * len(want) {
+		t.Fatalf("%v pairs, want %v", len(pairs) / 2, len(want))
+	}
+	for i := 0; i < len(pairs); i = i + 2 {
+		if val, ok := want[pairs[i]]; ok == false || val != pairs[i+1] {
+			t.Fatalf("field %q is %q, want %q", pairs[i], pairs[i+1], val)
+		}
+	}
+}
+
+
+func TestHashConvertByEntries(t *testing.T) {
+	store := newDB()
+	want := make(map[string]string)
+
+	for i := 0; i < hashMaxListpackEntries; i++ {
+		f, v := "f" + strconv.Itoa(i), "v" + strconv.Itoa(i)
+		store.HSET("h", []string{f, v})
+		want[f] = v
+	}
+
+	if store.hashmapEntry["h"].lp == nil {
+		t.Fatalf("%v fields should stay listpack", hashMaxListpackEntries)
+	}
+	checkHash(t, store, "h", want)
+
+	/* Overwrite in the listpack does not add a field */
+	if n, _ := store.HSET("h", []string{"f0", "new"}); n != 0 {
+		t.Fatalf("HSET existing field returned %v", n)
+	}
+	want["f0"] = "new"
+
+	store.HSET("h", []string{"last", "x"})
+	want["last"] = "x"
+
+	if store.hashmapEntry["h"].lp != nil {
+		t.Fatalf("%v fields should convert to map", hashMaxListpackEntries + 1)
+	}
+	checkHash(t, store, "h", want)
+}
+
+
+func TestHashConvertByValueLength(t *testing.T) {
+	store := newDB()
+
+	store.HSET("h", []string{"a", "1", "b", "2"})
+	if store.hashmapEntry["h"].lp == nil {
+		t.Fatalf("small hash should be listpack")
+	}
+
+	long := strings.Repeat("v", hashMaxListpackValue + 1)
+	store.HSET("h", []string{"c", long})
+
+	if store.hashmapEntry["h"].lp != nil {
+		t.Fatalf("long value should convert to map")
+	}
+	checkHash(t, store, "h", map[string]string{"a": "1", "b": "2", "c": long})
+}
+
+
+func TestHashListpackDelete(t *testing.T) {
+	store := newDB()
+
+	store.HSET("h", []string{"a", "1", "b", "2", "c", "3"})
+
+	if n, _ := store.HDEL("h", []string{"b", "missing"}); n != 1 {
+		t.Fatalf("HDEL removed %v", n)
+	}
+	checkHash(t, store, "h", map[string]string{"a": "1", "c": "3"})
+
+	store.HDEL("h", []string{"a", "c"})
+	if _, ok := store.hashmapEntry["h"]; ok == true {
+		t.Fatalf("hash without fields not deleted")
+	}
+}
+
+
+/* Both encodings are kept by Save and Load */
+func TestHashSaveLoad(t *testing.T) {
+	store := newDB()
+
+	store.HSET("hsmall", []string{"a", "1", "b", "2"})
+	store.HSET("hbig", []string{"long", strings.Repeat("v", 100), "x", "y"})
+
+	loaded := saveLoad(t, store)
+
+	checkHash(t, loaded, "hsmall", map[string]string{"a": "1", "b": "2"})
+	checkHash(t, loaded, "hbig", map[string]string{"long": strings.Repeat("v", 100), "x": "y"})
+	if loaded.hashmapEntry["hsmall"].lp == nil || loaded.hashmapEntry["hbig"].lp != nil {
+		t.Fatalf("hash encodings not kept")
+	}
+}
+
+
+func TestHashExpiredFieldHidden(t *testing.T) {
+	store := newDB()
+
+	store.HSET("h", []string{"a", "1", "b", "2"})
+	store.HEXPIRE("h", []string{"a"}, time.Now().Add(time.Millisecond).UnixNano(), "")
+	time.Sleep(5 * time.Millisecond)
+
+	checkHash(t, store, "h", map[string]string{"b": "2"})
+	if n, _ := store.HLEN("h"); n != 1 {
+		t.Fatalf("HLEN %v counts the expired field", n)
+	}
+
+	/* Every field expired, HRANDFIELD must not pick from nothing */
+	store.HEXPIRE("h", []string{"b"}, time.Now().Add(time.Millisecond).UnixNano(), "")
+	time.Sleep(5 * time.Millisecond)
+
+	if vals, err := store.HRANDFIELD("h", -2); err != nil || len(vals) != 0 {
+		t.Fatalf("HRANDFIELD of expired fields %v %v", vals, err)
+	}
+
+	/* Lazy expiration on write deletes the key with its last field */
+	store.HDEL("h", []string{"missing"})
+	if _, ok := store.hashmapEntry["h"]; ok == true {
+		t.Fatalf("hash of expired fields not deleted")
+	}
+}
+
+
+/* Field TTLs are kept by Save and Load */
+func TestHashFieldTTLSaveLoad(t *testing.T) {
+	store := newDB()
+	ttl := time.Now().Add(time.Hour).UnixNano()
+
+	store.HSET("h", []string{"a", "1", "b", "2"})
+	store.HEXPIRE("h", []string{"a"}, ttl, "")
+
+	loaded := saveLoad(t, store)
+
+	checkHash(t, loaded, "h", map[string]string{"a": "1", "b": "2"})
+	if loaded.hashmapEntry["h"].expires["a"] != ttl {
+		t.Fatalf("hash field TTL not kept")
+	}
+	if _, ok := loaded.hashmapEntry["h"].expires["b"]; ok == true {
+		t.Fatalf("field without TTL loaded with one")
+	}
+}
+
+
+/* Fail unless dict holds the index of every field in fields */
+func checkHashIndex(t *testing.T, h *hashmapData) {
+	t.Helper()
+
+	if len(h.dict) != len(h.fields) || len(h.fields) != len(h.vals) {
+		t.Fatalf("%v fields in dict, %v in fields, %v values", len(h.dict), len(h.fields), len(h.vals))
+	}
+	for i, field := range h.fields {
+		if h.dict[field] != i {
+			t.Fatalf("field %q at %v, dict has %v", field, i, h.dict[field])
+		}
+	}
+}
+
+
+func TestHashMapDelete(t *testing.T) {
+	store := newDB()
+	want := make(map[string]string)
+
+	for i := 0; i < 200; i++ {
+		f, v := "f" + strconv.Itoa(i), "v" + strconv.Itoa(i)
+		store.HSET("h", []string{f, v})
+		want[f] = v
+	}
+
+	/* First, last and middle fields */
+	store.HDEL("h", []string{"f0", "f199", "f100", "missing"})
+	delete(want, "f0")
+	delete(want, "f199")
+	delete(want, "f100")
+
+	checkHashIndex(t, store.hashmapEntry["h"])
+	checkHash(t, store, "h", want)
+
+	store.HSET("h", []string{"f1", "new"})
+	want["f1"] = "new"
+	checkHash(t, store, "h", want)
+}
+
+
+/* A full scan returns every field once, for both encodings */
+func TestHashScan(t *testing.T) {
+	for _, n := range []int{50, 300} {
+		store := newDB()
+		want := []string{}
+
+		for i := 0; i < n; i++ {
+			f := "f" + strconv.Itoa(i)
+			store.HSET("h", []string{f, "v" + strconv.Itoa(i)})
+			want = append(want, f)
+		}
+
+		got := []string{}
+		cursor := 0
+		for {
+			var pairs []string
+			cursor, pairs, _ = store.HSCAN("h", cursor, "", 7)
+			for i := 0; i < len(pairs); i = i + 2 {
+				if pairs[i+1] != "v" + pairs[i][1:] {
+					t.Fatalf("HSCAN field %q value %q", pairs[i], pairs[i+1])
+				}
+				got = append(got, pairs[i])
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("HSCAN returned %v fields, want %v", len(got), len(want))
+		}
+
+		if _, pairs, _ := store.HSCAN("h", 0, "f1?", 1000); len(pairs) != 20 {
+			t.Fatalf("HSCAN MATCH f1? returned %v", pairs)
+		}
+	}
+}
+
+
+func TestHashScanExpiredField(t *testing.T) {
+	store := newDB()
+
+	store.HSET("h", []string{"a", "1", "b", "2", "c", "3"})
+	store.HEXPIRE("h", []string{"b"}, time.Now().Add(time.Millisecond).UnixNano(), "")
+	time.Sleep(5 * time.Millisecond)
+
+	cursor, pairs, _ := store.HSCAN("h", 0, "", 10)
+	if cursor != 0 || strings.Join(pairs, ",") != "a,1,c,3" {
+		t.Fatalf("HSCAN %v %v", cursor, pairs)
+	}
+}
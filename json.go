@@ -0,0 +1,762 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+
+/*
+  JSON document type, same commands as the redis JSON module
+
+  A document is kept parsed, objects are map[string]interface{}, arrays are
+  *[]interface{} so an array can grow or shrink in place, numbers are
+  json.Number so integers stay integers. Object keys are returned in key order.
+
+  Paths are a subset of JSONPath
+    $            root
+    .key ['key'] child of an object
+    [n]          element of an array, negative n counts from the end
+    .* [*]       every child
+    ..key ..*    every descendant
+  A path starting with $ replies with every match, a legacy path without $
+  (ie, . or .a.b or a.b) replies with the first match only.
+*/
+
+const (
+	jsonSegKey = iota
+	jsonSegIndex
+	jsonSegWildcard
+)
+
+type jsonSeg struct {
+	kind int
+	key string
+	index int
+	recursive bool
+}
+
+type jsonPath struct {
+	segs []jsonSeg
+	legacy bool
+}
+
+/* A matched value and where it lives, parent is nil for the root */
+type jsonLoc struct {
+	parent interface{}
+	key string
+	index int
+	value interface{}
+}
+
+type jsonmapData struct {
+	doc interface{}
+	lock *sync.RWMutex
+}
+
+
+/* Parse one JSON value, arrays are turned into *[]interface{} */
+func jsonParse(s string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.New("expected JSON value")
+	}
+
+	var extra interface{}
+	if dec.Decode(&extra) != io.EOF {
+		return nil, errors.New("expected a single JSON value")
+	}
+
+	return jsonWrap(v), nil
+}
+
+
+func jsonWrap(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			val[k] = jsonWrap(child)
+		}
+	case []interface{}:
+		for i, child := range val {
+			val[i] = jsonWrap(child)
+		}
+		return &val
+	}
+
+	return v
+}
+
+
+/* Compact JSON text of v */
+func jsonMarshal(v interface{}) string {
+	var b bytes.Buffer
+
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+
+/* Type name of v, same as JSON.TYPE */
+func jsonTypeName(v interface{}) string {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case *[]interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if strings.ContainsAny(string(val), ".eE") {
+			return "number"
+		}
+		return "integer"
+	}
+
+	return "null"
+}
+
+
+/* Parse path, see the JSONPath subset above */
+func parseJSONPath(path string) (*jsonPath, error) {
+	p := &jsonPath{}
+	rest := path
+
+	if strings.HasPrefix(path, "$") {
+		rest = path[1:]
+	} else {
+		p.legacy = true
+		if path == "." {
+			rest = ""
+		} else if strings.HasPrefix(path, ".") == false && strings.HasPrefix(path, "[") == false {
+			rest = "." + path
+		}
+	}
+
+	invalid := errors.New(fmt.Sprint("invalid JSON path '", path, "'"))
+
+	for len(rest) > 0 {
+		seg := jsonSeg{}
+
+		if strings.HasPrefix(rest, "..") {
+			seg.recursive = true
+			rest = rest[1:]
+			if strings.HasPrefix(rest, ".[") {
+				rest = rest[1:]
+			}
+		}
+
+		if rest[0] == '.' {
+			rest = rest[1:]
+
+			/* Name runs up to the next . or [ */
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, invalid
+			}
+
+			if rest[:end] == "*" {
+				seg.kind = jsonSegWildcard
+			} else {
+				seg.kind = jsonSegKey
+				seg.key = rest[:end]
+			}
+			rest = rest[end:]
+		} else if rest[0] == '[' {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, invalid
+			}
+
+			/* A quoted key may contain ] */
+			if len(rest) > 1 && (rest[1] == '\'' || rest[1] == '"') {
+				close := strings.IndexByte(rest[2:], rest[1])
+				if close < 0 || len(rest) < close + 4 || rest[close+3] != ']' {
+					return nil, invalid
+				}
+				seg.kind = jsonSegKey
+				seg.key = rest[2 : close+2]
+				end = close + 3
+			} else if rest[1:end] == "*" {
+				seg.kind = jsonSegWildcard
+			} else {
+				index, err := strconv.Atoi(strings.TrimSpace(rest[1:end]))
+				if err != nil {
+					return nil, invalid
+				}
+				seg.kind = jsonSegIndex
+				seg.index = index
+			}
+			rest = rest[end+1:]
+		} else {
+			return nil, invalid
+		}
+
+		p.segs = append(p.segs, seg)
+	}
+
+	return p, nil
+}
+
+
+/* Children of loc selected by seg */
+func (seg *jsonSeg) children(loc jsonLoc, out []jsonLoc) []jsonLoc {
+	switch val := loc.value.(type) {
+	case map[string]interface{}:
+		if seg.kind == jsonSegKey {
+			if child, ok := val[seg.key]; ok == true {
+				out = append(out, jsonLoc{parent: val, key: seg.key, value: child})
+			}
+		} else if seg.kind == jsonSegWildcard {
+			for _, k := range jsonSortedKeys(val) {
+				out = append(out, jsonLoc{parent: val, key: k, value: val[k]})
+			}
+		}
+
+	case *[]interface{}:
+		if seg.kind == jsonSegIndex {
+			index := seg.index
+			if index < 0 {
+				index = len(*val) + index
+			}
+			if index >= 0 && index < len(*val) {
+				out = append(out, jsonLoc{parent: val, index: index, value: (*val)[index]})
+			}
+		} else if seg.kind == jsonSegWildcard {
+			for i, child := range *val {
+				out = append(out, jsonLoc{parent: val, index: i, value: child})
+			}
+		}
+	}
+
+	return out
+}
+
+
+/* loc and all locations below it, parents before children */
+func jsonDescendants(loc jsonLoc, out []jsonLoc) []jsonLoc {
+	out = append(out, loc)
+
+	all := jsonSeg{kind: jsonSegWildcard}
+	for _, child := range all.children(loc, nil) {
+		out = jsonDescendants(child, out)
+	}
+
+	return out
+}
+
+
+func jsonSortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+
+/* Locations matching segs, starting at the root doc */
+func jsonEval(doc interface{}, segs []jsonSeg) []jsonLoc {
+	locs := []jsonLoc{{value: doc}}
+
+	for i := range segs {
+		seg := &segs[i]
+		next := []jsonLoc{}
+
+		for _, loc := range locs {
+			if seg.recursive == true {
+				for _, d := range jsonDescendants(loc, nil) {
+					next = seg.children(d, next)
+				}
+			} else {
+				next = seg.children(loc, next)
+			}
+		}
+
+		locs = next
+	}
+
+	return locs
+}
+
+
+/* Replace the value at loc, the root is replaced in entry */
+func (entry *jsonmapData) replace(loc jsonLoc, v interface{}) {
+	switch parent := loc.parent.(type) {
+	case nil:
+		entry.doc = v
+	case map[string]interface{}:
+		parent[loc.key] = v
+	case *[]interface{}:
+		(*parent)[loc.index] = v
+	}
+}
+
+
+/* Add two JSON numbers, integers stay integers unless the sum overflows */
+func jsonAddNumbers(a json.Number, b json.Number) (json.Number, error) {
+	x, errX := a.Int64()
+	y, errY := b.Int64()
+
+	if errX == nil && errY == nil {
+		sum := x + y
+		if (sum > x) == (y > 0) {
+			return json.Number(strconv.FormatInt(sum, 10)), nil
+		}
+	}
+
+	fx, errX := a.Float64()
+	fy, errY := b.Float64()
+	if errX != nil || errY != nil {
+		return "", errors.New("value is not a number")
+	}
+
+	sum := fx + fy
+	if math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return "", errors.New("result is an overflow")
+	}
+
+	s := strconv.FormatFloat(sum, 'f', -1, 64)
+	if strings.Contains(s, ".") == false {
+		s = s + ".0"
+	}
+	return json.Number(s), nil
+}
+
+
+/* Run fn with the key write lock held, the key is created if absent and create is set. fn tells if the entry is to be kept */
+func (store *db) jsonUpdate(key string, create bool, fn func(entry *jsonmapData, exists bool) (bool, error)) error {
+	var entry *jsonmapData
+	var ok bool
+
+	store.jsonmapDBLock.RLock()
+
+	/* Check if db has the key entry */
+	entry, ok = store.jsonmapEntry[key]
+	exists := ok
+
+	/* If entry not present */
+	if ok == false {
+		if create == false {
+			store.jsonmapDBLock.RUnlock()
+			return errors.New(fmt.Sprint("key ", key, " not found"))
+		}
+
+		/* Take DB lock before creating entry for this key, to ensure only one entry gets created */
+		store.jsonmapDBLock.RUnlock()
+		store.jsonmapDBLock.Lock()
+
+		/* Again Check if db has the key entry, between above if & db lock it is possible other routine has created this entry */
+		var okrecheck bool
+		entry, okrecheck = store.jsonmapEntry[key]
+
+		if okrecheck == false {
+			entry = &jsonmapData{
+				lock: &sync.RWMutex{},
+			}
+		}
+		exists = okrecheck
+	}
+
+	/* Take DB entry lock before update, this is lock per key entry */
+	entry.lock.Lock()
+
+	keep, err := fn(entry, exists)
+
+	if ok == false {
+		if keep == true {
+			store.jsonmapEntry[key] = entry
+		} else {
+			delete(store.jsonmapEntry, key)
+		}
+	}
+
+	entry.lock.Unlock()
+
+	/* Both locks got released in LIFO order, entry lock followed by db lock */
+	if ok == false {
+		store.jsonmapDBLock.Unlock()
+	} else {
+		store.jsonmapDBLock.RUnlock()
+
+		/* Root got deleted */
+		if keep == false {
+			store.jsonDeleteIfEmpty(key, entry)
+		}
+	}
+
+	return err
+}
+
+
+/* Delete key if its document got deleted, the entry may have been replaced in the meantime */
+func (store *db) jsonDeleteIfEmpty(key string, entry *jsonmapData) {
+	store.jsonmapDBLock.Lock()
+	defer store.jsonmapDBLock.Unlock()
+
+	if cur, ok := store.jsonmapEntry[key]; ok == true && cur == entry && entry.doc == nil {
+		delete(store.jsonmapEntry, key)
+	}
+}
+
+
+/* Run fn with the key read lock held */
+func (store *db) jsonRead(key string, fn func(entry *jsonmapData) error) error {
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.jsonmapDBLock.RLock()
+	defer store.jsonmapDBLock.RUnlock()
+
+	entry, ok := store.jsonmapEntry[key]
+
+	if ok == false {
+		return errors.New(fmt.Sprint("key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	return fn(entry)
+}
+
+
+/*
+  Set the value at path, a new key must be set at the root
+  A path ending with a key creates the key in the matched objects. nx sets only new values, xx only existing values
+  Returns false if nothing got set
+*/
+func (store *db) JSONSET(key string, path string, value string, nx bool, xx bool) (bool, error) {
+	if store == nil {
+		fmt.Println("JSONSET : store is nil")
+		return false, errors.New(fmt.Sprint("JSONSET : store is nil"))
+	}
+
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := jsonParse(value); err != nil {
+		return false, err
+	}
+
+	set := false
+
+	err = store.jsonUpdate(key, len(p.segs) == 0, func(entry *jsonmapData, exists bool) (bool, error) {
+		if len(p.segs) == 0 {
+			if (nx == true && exists == true) || (xx == true && exists == false) {
+				return exists, nil
+			}
+			entry.doc, _ = jsonParse(value)
+			set = true
+			return true, nil
+		}
+
+		last := p.segs[len(p.segs) - 1]
+		locs := jsonEval(entry.doc, p.segs)
+
+		/* Existing values */
+		if nx == false {
+			for _, loc := range locs {
+				v, _ := jsonParse(value)
+				entry.replace(loc, v)
+				set = true
+			}
+		}
+
+		/* New key in the parent objects */
+		if xx == false && last.kind == jsonSegKey && last.recursive == false {
+			for _, parent := range jsonEval(entry.doc, p.segs[:len(p.segs) - 1]) {
+				obj, ok := parent.value.(map[string]interface{})
+				if ok == false {
+					continue
+				}
+				if _, found := obj[last.key]; found == true {
+					continue
+				}
+				obj[last.key], _ = jsonParse(value)
+				set = true
+			}
+		}
+
+		return true, nil
+	})
+
+	if err != nil && len(p.segs) > 0 && err.Error() == fmt.Sprint("key ", key, " not found") {
+		return false, errors.New("new objects must be created at the root")
+	}
+
+	return set, err
+}
+
+
+/* JSON text of the values at paths, one path replies its matches, several paths an object of path and matches */
+func (store *db) JSONGET(key string, paths []string) (string, error) {
+	if store == nil {
+		fmt.Println("JSONGET : store is nil")
+		return "", errors.New(fmt.Sprint("JSONGET : store is nil"))
+	}
+
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	parsed := make([]*jsonPath, len(paths))
+	for i, path := range paths {
+		p, err := parseJSONPath(path)
+		if err != nil {
+			return "", err
+		}
+		parsed[i] = p
+	}
+
+	var reply string
+
+	err := store.jsonRead(key, func(entry *jsonmapData) error {
+		results := make(map[string]interface{})
+
+		for i, p := range parsed {
+			locs := jsonEval(entry.doc, p.segs)
+
+			if p.legacy == true {
+				if len(locs) == 0 {
+					return errors.New(fmt.Sprint("Path '", paths[i], "' does not exist"))
+				}
+				results[paths[i]] = locs[0].value
+				continue
+			}
+
+			matches := make([]interface{}, len(locs))
+			for k, loc := range locs {
+				matches[k] = loc.value
+			}
+			results[paths[i]] = matches
+		}
+
+		if len(paths) == 1 {
+			reply = jsonMarshal(results[paths[0]])
+		} else {
+			reply = jsonMarshal(results)
+		}
+		return nil
+	})
+
+	return reply, err
+}
+
+
+/* Delete the values at path, the root deletes the key. Returns the number of values deleted */
+func (store *db) JSONDEL(key string, path string) (int, error) {
+	if store == nil {
+		fmt.Println("JSONDEL : store is nil")
+		return 0, errors.New(fmt.Sprint("JSONDEL : store is nil"))
+	}
+
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+
+	err = store.jsonUpdate(key, false, func(entry *jsonmapData, exists bool) (bool, error) {
+		if len(p.segs) == 0 {
+			entry.doc = nil
+			deleted = 1
+			return false, nil
+		}
+
+		/* Array elements are removed from the highest index, so the lower indexes stay valid */
+		arrays := make(map[*[]interface{}][]int)
+
+		for _, loc := range jsonEval(entry.doc, p.segs) {
+			switch parent := loc.parent.(type) {
+			case map[string]interface{}:
+				delete(parent, loc.key)
+			case *[]interface{}:
+				arrays[parent] = append(arrays[parent], loc.index)
+			}
+			deleted++
+		}
+
+		for arr, indexes := range arrays {
+			sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+			for _, i := range indexes {
+				*arr = append((*arr)[:i], (*arr)[i+1:]...)
+			}
+		}
+
+		return true, nil
+	})
+
+	if err != nil && err.Error() == fmt.Sprint("key ", key, " not found") {
+		return 0, nil
+	}
+
+	return deleted, err
+}
+
+
+/* Increment the numbers at path by incr, returns the new values with nil for the values which are not numbers */
+func (store *db) JSONNUMINCRBY(key string, path string, incr string) ([]interface{}, bool, error) {
+	if store == nil {
+		fmt.Println("JSONNUMINCRBY : store is nil")
+		return nil, false, errors.New(fmt.Sprint("JSONNUMINCRBY : store is nil"))
+	}
+
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	by, err := jsonParse(incr)
+	if _, ok := by.(json.Number); err != nil || ok == false {
+		return nil, false, errors.New("increment is not a number")
+	}
+
+	results := []interface{}{}
+
+	err = store.jsonUpdate(key, false, func(entry *jsonmapData, exists bool) (bool, error) {
+		locs := jsonEval(entry.doc, p.segs)
+
+		/* Check all before updating any, a legacy path fails on the first match which is not a number */
+		sums := make([]interface{}, len(locs))
+		for i, loc := range locs {
+			num, ok := loc.value.(json.Number)
+			if ok == false {
+				if p.legacy == true {
+					return true, errors.New(fmt.Sprint("Path '", path, "' is not a number"))
+				}
+				continue
+			}
+
+			sum, err := jsonAddNumbers(num, by.(json.Number))
+			if err != nil {
+				return true, err
+			}
+			sums[i] = sum
+		}
+
+		if p.legacy == true && len(locs) == 0 {
+			return true, errors.New(fmt.Sprint("Path '", path, "' does not exist"))
+		}
+
+		for i, loc := range locs {
+			if sums[i] != nil {
+				entry.replace(loc, sums[i])
+			}
+		}
+
+		results = sums
+		return true, nil
+	})
+
+	return results, p.legacy, err
+}
+
+
+/* Append values to the arrays at path, returns the new lengths with -1 for the values which are not arrays */
+func (store *db) JSONARRAPPEND(key string, path string, values []string) ([]int, bool, error) {
+	if store == nil {
+		fmt.Println("JSONARRAPPEND : store is nil")
+		return nil, false, errors.New(fmt.Sprint("JSONARRAPPEND : store is nil"))
+	}
+
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, value := range values {
+		if _, err := jsonParse(value); err != nil {
+			return nil, false, err
+		}
+	}
+
+	lengths := []int{}
+
+	err = store.jsonUpdate(key, false, func(entry *jsonmapData, exists bool) (bool, error) {
+		locs := jsonEval(entry.doc, p.segs)
+
+		if p.legacy == true {
+			if len(locs) == 0 {
+				return true, errors.New(fmt.Sprint("Path '", path, "' does not exist"))
+			}
+			if _, ok := locs[0].value.(*[]interface{}); ok == false {
+				return true, errors.New(fmt.Sprint("Path '", path, "' is not an array"))
+			}
+			locs = locs[:1]
+		}
+
+		for _, loc := range locs {
+			arr, ok := loc.value.(*[]interface{})
+			if ok == false {
+				lengths = append(lengths, -1)
+				continue
+			}
+
+			for _, value := range values {
+				v, _ := jsonParse(value)
+				*arr = append(*arr, v)
+			}
+			lengths = append(lengths, len(*arr))
+		}
+
+		return true, nil
+	})
+
+	return lengths, p.legacy, err
+}
+
+
+/* Type names of the values at path */
+func (store *db) JSONTYPE(key string, path string) ([]string, bool, error) {
+	if store == nil {
+		fmt.Println("JSONTYPE : store is nil")
+		return nil, false, errors.New(fmt.Sprint("JSONTYPE : store is nil"))
+	}
+
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	types := []string{}
+
+	err = store.jsonRead(key, func(entry *jsonmapData) error {
+		for _, loc := range jsonEval(entry.doc, p.segs) {
+			types = append(types, jsonTypeName(loc.value))
+			if p.legacy == true {
+				break
+			}
+		}
+		return nil
+	})
+
+	return types, p.legacy, err
+}
@@ -0,0 +1,266 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+
+/* JSON.GET of key at paths, fails on error */
+func jsonget(t *testing.T, store *db, key string, paths ...string) string {
+	t.Helper()
+
+	reply, err := store.JSONGET(key, paths)
+	must(t, err)
+	return reply
+}
+
+
+const jsonTestDoc = `{"a":1,"b":{"a":2.5,"c":[1,"x",{"a":true}]},"d":null,"e":"s"}`
+
+
+func TestJSONPath(t *testing.T) {
+	store := newDB()
+	_, err := store.JSONSET("j", "$", jsonTestDoc, false, false)
+	must(t, err)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"$", "[" + jsonTestDoc + "]"},
+		{".", jsonTestDoc},
+		{"$.a", "[1]"},
+		{"a", "1"},
+		{".b.a", "2.5"},
+		{"$.b.c[1]", `["x"]`},
+		{"$.b.c[-1].a", "[true]"},
+		{"$.b.c[9]", "[]"},
+		{"$['b']['a']", "[2.5]"},
+		{`$["b"].c[0]`, "[1]"},
+		{"$.*", `[1,{"a":2.5,"c":[1,"x",{"a":true}]},null,"s"]`},
+		{"$.b.c[*]", `[1,"x",{"a":true}]`},
+		{"$..a", "[1,2.5,true]"},
+		{"..a", "1"},
+		{"$.missing", "[]"},
+		{"$.a.b", "[]"},
+	}
+
+	for _, test := range tests {
+		if got := jsonget(t, store, "j", test.path); got != test.want {
+			t.Fatalf("JSON.GET %s gave %s, want %s", test.path, got, test.want)
+		}
+	}
+
+	/* Several paths reply an object of path and matches */
+	if got := jsonget(t, store, "j", "$.a", ".e"); got != `{"$.a":[1],".e":"s"}` {
+		t.Fatalf("JSON.GET of two paths gave %s", got)
+	}
+
+	for _, path := range []string{"$.", "$[", "$[x]", "$['a]", "$..", "$a"} {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Fatalf("path %s parsed", path)
+		}
+	}
+	if _, err := store.JSONGET("j", []string{".missing"}); err == nil {
+		t.Fatalf("JSON.GET of missing legacy path")
+	}
+	if _, err := store.JSONGET("none", []string{"$"}); err == nil {
+		t.Fatalf("JSON.GET of missing key")
+	}
+}
+
+
+func TestJSONSet(t *testing.T) {
+	store := newDB()
+
+	if _, err := store.JSONSET("j", "$.a", "1", false, false); err == nil {
+		t.Fatalf("JSON.SET of new key below the root")
+	}
+	if set, _ := store.JSONSET("j", "$", "{}", false, true); set == true {
+		t.Fatalf("JSON.SET XX of new key")
+	}
+	if _, ok := store.jsonmapEntry["j"]; ok == true {
+		t.Fatalf("JSON.SET XX created the key")
+	}
+	if _, err := store.JSONSET("j", "$", "{", false, false); err == nil {
+		t.Fatalf("JSON.SET of invalid JSON")
+	}
+	if _, err := store.JSONSET("j", "$", "1 2", false, false); err == nil {
+		t.Fatalf("JSON.SET of two values")
+	}
+
+	_, err := store.JSONSET("j", "$", `{"a":{"x":1},"b":{"x":2},"c":[]}`, true, false)
+	must(t, err)
+	if set, _ := store.JSONSET("j", "$", "{}", true, false); set == true {
+		t.Fatalf("JSON.SET NX of existing key")
+	}
+
+	tests := []struct {
+		path string
+		value string
+		nx bool
+		xx bool
+		set bool
+		want string
+	}{
+		/* Every match is replaced */
+		{"$..x", "9", false, false, true, `{"a":{"x":9},"b":{"x":9},"c":[]}`},
+		/* A new key in every matched object */
+		{"$.*.y", `"n"`, false, false, true, `{"a":{"x":9,"y":"n"},"b":{"x":9,"y":"n"},"c":[]}`},
+		{"$.a.x", "1", true, false, false, `{"a":{"x":9,"y":"n"},"b":{"x":9,"y":"n"},"c":[]}`},
+		{"$.a.z", "1", false, true, false, `{"a":{"x":9,"y":"n"},"b":{"x":9,"y":"n"},"c":[]}`},
+		{"$.a.z", "[1]", true, false, true, `{"a":{"x":9,"y":"n","z":[1]},"b":{"x":9,"y":"n"},"c":[]}`},
+		{"$.a.x", "1", false, true, true, `{"a":{"x":1,"y":"n","z":[1]},"b":{"x":9,"y":"n"},"c":[]}`},
+		/* Array elements are replaced, never created */
+		{"$.a.z[0]", "2", false, false, true, `{"a":{"x":1,"y":"n","z":[2]},"b":{"x":9,"y":"n"},"c":[]}`},
+		{"$.c[0]", "2", false, false, false, `{"a":{"x":1,"y":"n","z":[2]},"b":{"x":9,"y":"n"},"c":[]}`},
+		{"$.c", `{"k":"a<b"}`, false, false, true, `{"a":{"x":1,"y":"n","z":[2]},"b":{"x":9,"y":"n"},"c":{"k":"a<b"}}`},
+		{"$.missing.y", "1", false, false, false, `{"a":{"x":1,"y":"n","z":[2]},"b":{"x":9,"y":"n"},"c":{"k":"a<b"}}`},
+	}
+
+	for _, test := range tests {
+		set, err := store.JSONSET("j", test.path, test.value, test.nx, test.xx)
+		must(t, err)
+		if set != test.set {
+			t.Fatalf("JSON.SET %s %s NX %v XX %v gave %v", test.path, test.value, test.nx, test.xx, set)
+		}
+		if got := jsonget(t, store, "j", "."); got != test.want {
+			t.Fatalf("JSON.SET %s %s left %s, want %s", test.path, test.value, got, test.want)
+		}
+	}
+
+	/* The values set are copies, changing one leaves the others */
+	store.JSONARRAPPEND("j", "$.a.z", []string{"3"})
+	store.JSONSET("j", "$..y", "[]", false, false)
+	store.JSONARRAPPEND("j", "$.a.y", []string{"1"})
+	if got := jsonget(t, store, "j", "$..y"); got != "[[1],[]]" {
+		t.Fatalf("JSON.SET of shared value gave %s", got)
+	}
+}
+
+
+func TestJSONDel(t *testing.T) {
+	store := newDB()
+	store.JSONSET("j", "$", `{"a":[0,1,2,3,4],"b":{"a":1},"c":2}`, false, false)
+
+	deleted, err := store.JSONDEL("j", "$.a[1]")
+	must(t, err)
+	if deleted != 1 || jsonget(t, store, "j", "$.a") != "[[0,2,3,4]]" {
+		t.Fatalf("JSON.DEL $.a[1] gave %d, left %s", deleted, jsonget(t, store, "j", "$.a"))
+	}
+
+	/* Several elements of one array */
+	if deleted, _ = store.JSONDEL("j", "$.a[*]"); deleted != 4 || jsonget(t, store, "j", "$.a") != "[[]]" {
+		t.Fatalf("JSON.DEL $.a[*] gave %d, left %s", deleted, jsonget(t, store, "j", "$.a"))
+	}
+
+	if deleted, _ = store.JSONDEL("j", "$..a"); deleted != 2 || jsonget(t, store, "j", ".") != `{"b":{},"c":2}` {
+		t.Fatalf("JSON.DEL $..a gave %d, left %s", deleted, jsonget(t, store, "j", "."))
+	}
+	if deleted, _ = store.JSONDEL("j", "$.missing"); deleted != 0 {
+		t.Fatalf("JSON.DEL of missing path gave %d", deleted)
+	}
+
+	/* The root deletes the key */
+	if deleted, _ = store.JSONDEL("j", "$"); deleted != 1 {
+		t.Fatalf("JSON.DEL $ gave %d", deleted)
+	}
+	if _, ok := store.jsonmapEntry["j"]; ok == true {
+		t.Fatalf("JSON.DEL $ kept the key")
+	}
+	if deleted, err = store.JSONDEL("j", "$"); err != nil || deleted != 0 {
+		t.Fatalf("JSON.DEL of missing key gave %d, %v", deleted, err)
+	}
+}
+
+
+func TestJSONNumIncrBy(t *testing.T) {
+	store := newDB()
+	store.JSONSET("j", "$", `{"a":1,"b":{"a":"x"},"c":{"a":1.5},"big":9223372036854775807}`, false, false)
+
+	results, legacy, err := store.JSONNUMINCRBY("j", "$..a", "2")
+	must(t, err)
+	if legacy == true || jsonMarshal(results) != `[3,null,3.5]` {
+		t.Fatalf("JSON.NUMINCRBY $..a gave %s", jsonMarshal(results))
+	}
+
+	/* A legacy path fails on a value which is not a number and changes nothing */
+	if _, _, err := store.JSONNUMINCRBY("j", "b.a", "1"); err == nil {
+		t.Fatalf("JSON.NUMINCRBY of a string")
+	}
+	if _, _, err := store.JSONNUMINCRBY("j", ".missing", "1"); err == nil {
+		t.Fatalf("JSON.NUMINCRBY of missing legacy path")
+	}
+	if _, _, err := store.JSONNUMINCRBY("j", "$.a", "x"); err == nil {
+		t.Fatalf("JSON.NUMINCRBY by a string")
+	}
+
+	/* An integer overflowing turns into a float */
+	results, _, err = store.JSONNUMINCRBY("j", "$.big", "1")
+	must(t, err)
+	if types, _, _ := store.JSONTYPE("j", "$.big"); types[0] != "number" || strings.Contains(jsonMarshal(results), "9223372036854775808") {
+		t.Fatalf("JSON.NUMINCRBY overflow gave %s of type %v", jsonMarshal(results), types)
+	}
+	if got := jsonget(t, store, "j", "$.a", "$.c.a"); got != `{"$.a":[3],"$.c.a":[3.5]}` {
+		t.Fatalf("JSON.NUMINCRBY left %s", got)
+	}
+}
+
+
+func TestJSONArrAppendType(t *testing.T) {
+	store := newDB()
+	store.JSONSET("j", "$", `{"a":[],"b":{"a":[1]},"c":{"a":"x"}}`, false, false)
+
+	lengths, _, err := store.JSONARRAPPEND("j", "$..a", []string{"1", `{"k":[]}`})
+	must(t, err)
+	if len(lengths) != 3 || lengths[0] != 2 || lengths[1] != 3 || lengths[2] != -1 {
+		t.Fatalf("JSON.ARRAPPEND $..a gave %v", lengths)
+	}
+	if got := jsonget(t, store, "j", "$.b.a"); got != `[[1,1,{"k":[]}]]` {
+		t.Fatalf("JSON.ARRAPPEND left %s", got)
+	}
+	if _, _, err := store.JSONARRAPPEND("j", "c.a", []string{"1"}); err == nil {
+		t.Fatalf("JSON.ARRAPPEND to a string on legacy path")
+	}
+	if _, _, err := store.JSONARRAPPEND("j", "$.a", []string{"["}); err == nil {
+		t.Fatalf("JSON.ARRAPPEND of invalid JSON")
+	}
+
+	store.JSONSET("t", "$", `{"o":{},"a":[],"s":"","b":false,"i":-3,"n":1e3,"f":0.5,"z":null}`, false, false)
+	types, legacy, err := store.JSONTYPE("t", "$.*")
+	must(t, err)
+	if legacy == true || strings.Join(types, " ") != "array boolean number integer number object string null" {
+		t.Fatalf("JSON.TYPE $.* gave %v", types)
+	}
+	if types, _, _ = store.JSONTYPE("t", "."); strings.Join(types, " ") != "object" {
+		t.Fatalf("JSON.TYPE . gave %v", types)
+	}
+}
+
+
+func TestJSONSaveLoad(t *testing.T) {
+	store := newDB()
+	store.JSONSET("j", "$", jsonTestDoc, false, false)
+	store.JSONSET("n", "$", "12345678901234567890", false, false)
+	store.JSONSET("s", "$", `"é\n"`, false, false)
+
+	loaded := saveLoad(t, store)
+
+	for _, key := range []string{"j", "n", "s"} {
+		if got, want := jsonget(t, loaded, key, "$"), jsonget(t, store, key, "$"); got != want {
+			t.Fatalf("loaded %s %s, want %s", key, got, want)
+		}
+	}
+
+	/* Loaded arrays still grow in place */
+	loaded.JSONARRAPPEND("j", "$.b.c", []string{"2"})
+	if got := jsonget(t, loaded, "j", "$.b.c[-1]"); got != "[2]" {
+		t.Fatalf("JSON.ARRAPPEND after load left %s", got)
+	}
+}
@@ -0,0 +1,1000 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+
+const (
+	// Max number of elements in a quicklist node
+	quicklistNodeEntries = 128
+
+	// Max bytes of a quicklist node, a single bigger element still gets its own node
+	quicklistNodeBytes = 8192
+)
+
+
+/*
+  List storage, same idea as the redis quicklist
+
+  quicklist is a doubly linked list of nodes, every node holds a listpack.
+  listpack packs up to quicklistNodeEntries elements in a single byte slice,
+  every element is stored as uvarint length followed by the element bytes.
+  This avoids a string header and an allocation per element, and keeps
+  insert\delete in the middle of a long list limited to one node.
+*/
+
+type listpack struct {
+	data []byte
+	count int
+}
+
+type quicklistNode struct {
+	lp listpack
+	prev *quicklistNode
+	next *quicklistNode
+}
+
+type quicklist struct {
+	head *quicklistNode
+	tail *quicklistNode
+	length int
+}
+
+
+/* Byte offset of entry i, i == count gives the end of data */
+func (lp *listpack) offset(i int) int {
+	off := 0
+	for ; i > 0; i-- {
+		l, n := binary.Uvarint(lp.data[off:])
+		off += n + int(l)
+	}
+	return off
+}
+
+
+/* Returns the entry at byte offset off and the offset of the next entry */
+func (lp *listpack) entry(off int) (string, int) {
+	l, n := binary.Uvarint(lp.data[off:])
+	start := off + n
+	return string(lp.data[start : start+int(l)]), start + int(l)
+}
+
+
+func (lp *listpack) get(i int) string {
+	val, _ := lp.entry(lp.offset(i))
+	return val
+}
+
+
+/* Insert val before entry i, i == count appends */
+func (lp *listpack) insert(i int, val string) {
+	var hdr [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hdr[:], uint64(len(val)))
+
+	off := lp.offset(i)
+	size := n + len(val)
+
+	lp.data = append(lp.data, make([]byte, size)...)
+	copy(lp.data[off+size:], lp.data[off:len(lp.data)-size])
+	copy(lp.data[off:], hdr[:n])
+	copy(lp.data[off+n:], val)
+	lp.count++
+}
+
+
+/* Delete num entries starting at entry i */
+func (lp *listpack) delete(i int, num int) {
+	start := lp.offset(i)
+
+	end := start
+	for k := 0; k < num; k++ {
+		l, n := binary.Uvarint(lp.data[end:])
+		end += n + int(l)
+	}
+
+	lp.data = append(lp.data[:start], lp.data[end:]...)
+	lp.count -= num
+}
+
+
+/* Replace entry i with val */
+func (lp *listpack) replace(i int, val string) {
+	lp.delete(i, 1)
+	lp.insert(i, val)
+}
+
+
+/* All entries in order */
+func (lp *listpack) values() []string {
+	vals := make([]string, 0, lp.count)
+
+	off := 0
+	for k := 0; k < lp.count; k++ {
+		var val string
+		val, off = lp.entry(off)
+		vals = append(vals, val)
+	}
+
+	return vals
+}
+
+
+/* Returns true if the node can take one more element of size */
+func (node *quicklistNode) allowInsert(size int) bool {
+	if node.lp.count == 0 {
+		return true
+	}
+	return node.lp.count < quicklistNodeEntries && len(node.lp.data) + size + binary.MaxVarintLen64 <= quicklistNodeBytes
+}
+
+
+func newQuicklist() *quicklist {
+	return &quicklist{}
+}
+
+
+/* Link a new empty node after prev, at head if prev is nil */
+func (ql *quicklist) insertNode(prev *quicklistNode) *quicklistNode {
+	node := &quicklistNode{prev: prev}
+
+	if prev == nil {
+		node.next = ql.head
+		ql.head = node
+	} else {
+		node.next = prev.next
+		prev.next = node
+	}
+
+	if node.next != nil {
+		node.next.prev = node
+	} else {
+		ql.tail = node
+	}
+
+	return node
+}
+
+
+func (ql *quicklist) unlinkNode(node *quicklistNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		ql.head = node.next
+	}
+
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		ql.tail = node.prev
+	}
+}
+
+
+/* Returns the node holding element at index and the index inside the node, walks from the closer end */
+func (ql *quicklist) lookup(index int) (*quicklistNode, int) {
+	if index < 0 || index >= ql.length {
+		return nil, 0
+	}
+
+	if index < ql.length / 2 {
+		node := ql.head
+		for index >= node.lp.count {
+			index -= node.lp.count
+			node = node.next
+		}
+		return node, index
+	}
+
+	node := ql.tail
+	index = ql.length - 1 - index
+	for index >= node.lp.count {
+		index -= node.lp.count
+		node = node.prev
+	}
+	return node, node.lp.count - 1 - index
+}
+
+
+func (ql *quicklist) pushHead(val string) {
+	if ql.head == nil || ql.head.allowInsert(len(val)) == false {
+		ql.insertNode(nil)
+	}
+
+	ql.head.lp.insert(0, val)
+	ql.length++
+}
+
+
+func (ql *quicklist) pushTail(val string) {
+	if ql.tail == nil || ql.tail.allowInsert(len(val)) == false {
+		ql.insertNode(ql.tail)
+	}
+
+	ql.tail.lp.insert(ql.tail.lp.count, val)
+	ql.length++
+}
+
+
+/* Insert val before element at index, index == length appends */
+func (ql *quicklist) insert(index int, val string) {
+	if index <= 0 {
+		ql.pushHead(val)
+		return
+	}
+
+	if index >= ql.length {
+		ql.pushTail(val)
+		return
+	}
+
+	node, offset := ql.lookup(index)
+
+	if node.allowInsert(len(val)) == false && node.lp.count < 2 {
+		/* A single oversize element can not be split, val gets a new node before it */
+		node = ql.insertNode(node.prev)
+		offset = 0
+	} else if node.allowInsert(len(val)) == false {
+		/* Split the full node, second half moves to a new node after it */
+		half := node.lp.count / 2
+		vals := node.lp.values()
+
+		newNode := ql.insertNode(node)
+		for _, v := range vals[half:] {
+			newNode.lp.insert(newNode.lp.count, v)
+		}
+		node.lp.delete(half, node.lp.count - half)
+
+		if offset >= half {
+			node = newNode
+			offset -= half
+		}
+	}
+
+	node.lp.insert(offset, val)
+	ql.length++
+}
+
+
+func (ql *quicklist) get(index int) (string, bool) {
+	node, offset := ql.lookup(index)
+	if node == nil {
+		return "", false
+	}
+	return node.lp.get(offset), true
+}
+
+
+func (ql *quicklist) set(index int, val string) bool {
+	node, offset := ql.lookup(index)
+	if node == nil {
+		return false
+	}
+	node.lp.replace(offset, val)
+	return true
+}
+
+
+/* Delete count elements starting at index, whole nodes in range are unlinked without touching their elements */
+func (ql *quicklist) deleteRange(index int, count int) {
+	if index < 0 {
+		index = 0
+	}
+	if index + count > ql.length {
+		count = ql.length - index
+	}
+
+	for count > 0 {
+		node, offset := ql.lookup(index)
+
+		num := node.lp.count - offset
+		if num > count {
+			num = count
+		}
+
+		if offset == 0 && num == node.lp.count {
+			ql.unlinkNode(node)
+		} else {
+			node.lp.delete(offset, num)
+		}
+
+		ql.length -= num
+		count -= num
+	}
+}
+
+
+func (ql *quicklist) popHead() (string, bool) {
+	if ql.length == 0 {
+		return "", false
+	}
+
+	val := ql.head.lp.get(0)
+	ql.deleteRange(0, 1)
+	return val, true
+}
+
+
+func (ql *quicklist) popTail() (string, bool) {
+	if ql.length == 0 {
+		return "", false
+	}
+
+	val := ql.tail.lp.get(ql.tail.lp.count - 1)
+	ql.deleteRange(ql.length - 1, 1)
+	return val, true
+}
+
+
+/* Elements from start to stop inclusive, indexes are already sanitized */
+func (ql *quicklist) rangeValues(start int, stop int) []string {
+	vals := []string{}
+
+	node, offset := ql.lookup(start)
+	for node != nil && start <= stop {
+		off := node.lp.offset(offset)
+		for ; offset < node.lp.count && start <= stop; offset++ {
+			var val string
+			val, off = node.lp.entry(off)
+			vals = append(vals, val)
+			start++
+		}
+
+		node = node.next
+		offset = 0
+	}
+
+	return vals
+}
+
+
+/* All elements in order */
+func (ql *quicklist) values() []string {
+	vals := make([]string, 0, ql.length)
+
+	for node := ql.head; node != nil; node = node.next {
+		vals = append(vals, node.lp.values()...)
+	}
+
+	return vals
+}
+
+
+/*
+  Storing data of form LPUSH key element1 [element2]
+  listmapEntry map[key] value is pointer to listmapData
+  listmapData ql is quicklist of elements
+*/
+
+type listmapData struct {
+	ql *quicklist
+	lock *sync.RWMutex
+}
+
+
+/* Sanitize start stop range of length llen as LRANGE\LTRIM, returns false if range is empty */
+func listRange(start int, stop int, llen int) (int, int, bool) {
+	if start < 0 {
+		start = llen + start
+	}
+	if stop < 0 {
+		stop = llen + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	if start > stop || start >= llen {
+		return 0, 0, false
+	}
+	if stop >= llen {
+		stop = llen - 1
+	}
+
+	return start, stop, true
+}
+
+
+/* Push elements at head or tail, creates the key unless onlyExisting is set, returns the list length */
+func (store *db) LPUSH(key string, vals []string, head bool, onlyExisting bool) (int, error) {
+	if store == nil {
+		fmt.Println("LPUSH : store is nil")
+		return 0, errors.New(fmt.Sprint("LPUSH : store is nil"))
+	}
+
+	var entry *listmapData
+	var ok bool
+
+	store.listmapDBLock.RLock()
+
+	/* Check if db has the key entry */
+	entry, ok = store.listmapEntry[key]
+
+	/* If entry not present */
+	if ok == false {
+		if onlyExisting == true {
+			store.listmapDBLock.RUnlock()
+			return 0, nil
+		}
+
+		/* Take DB lock before creating entry for this key, to ensure only one entry gets created */
+		store.listmapDBLock.RUnlock()
+		store.listmapDBLock.Lock()
+
+		/* Again Check if db has the key entry, between above if & db lock it is possible other routine has created this entry */
+		var okrecheck bool
+		entry, okrecheck = store.listmapEntry[key]
+
+		if okrecheck == false {
+			entry = &listmapData{
+				ql: newQuicklist(),
+				lock: &sync.RWMutex{},
+			}
+		}
+	}
+
+	/* Take DB entry lock before push, this is lock per key entry */
+	entry.lock.Lock()
+
+	for _, val := range vals {
+		if head == true {
+			entry.ql.pushHead(val)
+		} else {
+			entry.ql.pushTail(val)
+		}
+	}
+
+	length := entry.ql.length
+
+	if ok == false {
+		store.listmapEntry[key] = entry
+	}
+
+	entry.lock.Unlock()
+
+	/* Both locks got released in LIFO order, entry lock followed by db lock */
+	if ok == false {
+		store.listmapDBLock.Unlock()
+	} else {
+		store.listmapDBLock.RUnlock()
+	}
+
+	/* Serve clients blocked on the key, with data locks released */
+	store.signalKeyReady(blockList, key)
+
+	return length, nil
+}
+
+
+/* Pop up to count elements from head or tail */
+func (store *db) LPOP(key string, count int, head bool) ([]string, error) {
+	if store == nil {
+		fmt.Println("LPOP : store is nil")
+		return nil, errors.New(fmt.Sprint("LPOP : store is nil"))
+	}
+
+	vals := []string{}
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.listmapDBLock.RLock()
+
+	entry, ok := store.listmapEntry[key]
+
+	if ok == false {
+		store.listmapDBLock.RUnlock()
+		return nil, errors.New(fmt.Sprint("LPOP : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before pop, this is lock per key entry */
+	entry.lock.Lock()
+
+	/* Emptied by other routine which has not deleted the key yet */
+	if entry.ql.length == 0 {
+		entry.lock.Unlock()
+		store.listmapDBLock.RUnlock()
+		return nil, errors.New(fmt.Sprint("LPOP : key ", key, " not found"))
+	}
+
+	for ; count > 0; count-- {
+		var val string
+		var found bool
+
+		if head == true {
+			val, found = entry.ql.popHead()
+		} else {
+			val, found = entry.ql.popTail()
+		}
+
+		if found == false {
+			break
+		}
+		vals = append(vals, val)
+	}
+
+	empty := entry.ql.length == 0
+
+	entry.lock.Unlock()
+	store.listmapDBLock.RUnlock()
+
+	if empty == true {
+		store.listDeleteIfEmpty(key, entry)
+	}
+
+	return vals, nil
+}
+
+
+func (store *db) LLEN(key string) (int, error) {
+	if store == nil {
+		fmt.Println("LLEN : store is nil")
+		return 0, errors.New(fmt.Sprint("LLEN : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.listmapDBLock.RLock()
+	defer store.listmapDBLock.RUnlock()
+
+	entry, ok := store.listmapEntry[key]
+
+	if ok == false {
+		return 0, errors.New(fmt.Sprint("LLEN : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	return entry.ql.length, nil
+}
+
+
+/* Elements from start to stop inclusive, negative index is counted from the end */
+func (store *db) LRANGE(key string, start int, stop int) ([]string, error) {
+	if store == nil {
+		fmt.Println("LRANGE : store is nil")
+		return nil, errors.New(fmt.Sprint("LRANGE : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.listmapDBLock.RLock()
+	defer store.listmapDBLock.RUnlock()
+
+	entry, ok := store.listmapEntry[key]
+
+	if ok == false {
+		return []string{}, errors.New(fmt.Sprint("LRANGE : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	start, stop, ok = listRange(start, stop, entry.ql.length)
+	if ok == false {
+		return []string{}, nil
+	}
+
+	return entry.ql.rangeValues(start, stop), nil
+}
+
+
+func (store *db) LINDEX(key string, index int) (string, error) {
+	if store == nil {
+		fmt.Println("LINDEX : store is nil")
+		return "", errors.New(fmt.Sprint("LINDEX : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.listmapDBLock.RLock()
+	defer store.listmapDBLock.RUnlock()
+
+	entry, ok := store.listmapEntry[key]
+
+	if ok == false {
+		return "", errors.New(fmt.Sprint("LINDEX : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	if index < 0 {
+		index = entry.ql.length + index
+	}
+
+	val, found := entry.ql.get(index)
+	if found == false {
+		return "", errors.New(fmt.Sprint("LINDEX : key ", key, " index ", index, " out of range"))
+	}
+
+	return val, nil
+}
+
+
+func (store *db) LSET(key string, index int, val string) error {
+	if store == nil {
+		fmt.Println("LSET : store is nil")
+		return errors.New(fmt.Sprint("LSET : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.listmapDBLock.RLock()
+	defer store.listmapDBLock.RUnlock()
+
+	entry, ok := store.listmapEntry[key]
+
+	if ok == false {
+		return errors.New("no such key")
+	}
+
+	/* Take DB entry lock before set, this is lock per key entry */
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	if index < 0 {
+		index = entry.ql.length + index
+	}
+
+	if entry.ql.set(index, val) == false {
+		return errors.New("index out of range")
+	}
+
+	return nil
+}
+
+
+/* Insert val before or after the first pivot, returns list length, -1 if pivot not found, 0 if key not found */
+func (store *db) LINSERT(key string, before bool, pivot string, val string) (int, error) {
+	if store == nil {
+		fmt.Println("LINSERT : store is nil")
+		return 0, errors.New(fmt.Sprint("LINSERT : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.listmapDBLock.RLock()
+	defer store.listmapDBLock.RUnlock()
+
+	entry, ok := store.listmapEntry[key]
+
+	if ok == false {
+		return 0, errors.New(fmt.Sprint("LINSERT : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before insert, this is lock per key entry */
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	index := 0
+	for node := entry.ql.head; node != nil; node = node.next {
+		for _, v := range node.lp.values() {
+			if v == pivot {
+				if before == false {
+					index++
+				}
+				entry.ql.insert(index, val)
+				return entry.ql.length, nil
+			}
+			index++
+		}
+	}
+
+	return -1, nil
+}
+
+
+/* Remove count occurrences of val, from head for count > 0, from tail for count < 0, all for 0 */
+func (store *db) LREM(key string, count int, val string) (int, error) {
+	if store == nil {
+		fmt.Println("LREM : store is nil")
+		return 0, errors.New(fmt.Sprint("LREM : store is nil"))
+	}
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.listmapDBLock.RLock()
+
+	entry, ok := store.listmapEntry[key]
+
+	if ok == false {
+		store.listmapDBLock.RUnlock()
+		return 0, errors.New(fmt.Sprint("LREM : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before remove, this is lock per key entry */
+	entry.lock.Lock()
+
+	/* Collect matching indexes in scan order */
+	vals := entry.ql.values()
+	matches := []int{}
+
+	if count >= 0 {
+		for i := 0; i < len(vals) && (count == 0 || len(matches) < count); i++ {
+			if vals[i] == val {
+				matches = append(matches, i)
+			}
+		}
+	} else {
+		for i := len(vals) - 1; i >= 0 && len(matches) < -count; i-- {
+			if vals[i] == val {
+				matches = append(matches, i)
+			}
+		}
+		sort.Ints(matches)
+	}
+
+	/* Delete from the highest index so lower indexes stay valid */
+	for i := len(matches) - 1; i >= 0; i-- {
+		entry.ql.deleteRange(matches[i], 1)
+	}
+
+	empty := entry.ql.length == 0
+
+	entry.lock.Unlock()
+	store.listmapDBLock.RUnlock()
+
+	if empty == true {
+		store.listDeleteIfEmpty(key, entry)
+	}
+
+	return len(matches), nil
+}
+
+
+/* Trim the list to the range start stop inclusive */
+func (store *db) LTRIM(key string, start int, stop int) error {
+	if store == nil {
+		fmt.Println("LTRIM : store is nil")
+		return errors.New(fmt.Sprint("LTRIM : store is nil"))
+	}
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.listmapDBLock.RLock()
+
+	entry, ok := store.listmapEntry[key]
+
+	if ok == false {
+		store.listmapDBLock.RUnlock()
+		return errors.New(fmt.Sprint("LTRIM : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before trim, this is lock per key entry */
+	entry.lock.Lock()
+
+	llen := entry.ql.length
+	start, stop, ok = listRange(start, stop, llen)
+
+	if ok == false {
+		/* Out of range start or start > stop, list is emptied */
+		entry.ql.deleteRange(0, llen)
+	} else {
+		entry.ql.deleteRange(stop + 1, llen - stop - 1)
+		entry.ql.deleteRange(0, start)
+	}
+
+	empty := entry.ql.length == 0
+
+	entry.lock.Unlock()
+	store.listmapDBLock.RUnlock()
+
+	if empty == true {
+		store.listDeleteIfEmpty(key, entry)
+	}
+
+	return nil
+}
+
+
+/*
+  Indexes of val in the list
+  rank is the match to start from, negative rank scans from the tail
+  count is the number of matches to return, 0 for all
+  maxlen is the max number of elements to compare, 0 for all
+*/
+func (store *db) LPOS(key string, val string, rank int, count int, maxlen int) ([]int, error) {
+	if store == nil {
+		fmt.Println("LPOS : store is nil")
+		return nil, errors.New(fmt.Sprint("LPOS : store is nil"))
+	}
+
+	matches := []int{}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.listmapDBLock.RLock()
+	defer store.listmapDBLock.RUnlock()
+
+	entry, ok := store.listmapEntry[key]
+
+	if ok == false {
+		return matches, errors.New(fmt.Sprint("LPOS : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	vals := entry.ql.values()
+
+	skip := rank - 1
+	step, index := 1, 0
+	if rank < 0 {
+		skip = -rank - 1
+		step, index = -1, len(vals) - 1
+	}
+
+	for compared := 0; index >= 0 && index < len(vals); index += step {
+		if maxlen > 0 && compared >= maxlen {
+			break
+		}
+		compared++
+
+		if vals[index] != val {
+			continue
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		matches = append(matches, index)
+		if count > 0 && len(matches) >= count {
+			break
+		}
+	}
+
+	return matches, nil
+}
+
+
+/* Pop from head or tail of src and push at head or tail of dst, returns the element moved */
+func (store *db) LMOVE(src string, dst string, srcHead bool, dstHead bool) (string, error) {
+	if store == nil {
+		fmt.Println("LMOVE : store is nil")
+		return "", errors.New(fmt.Sprint("LMOVE : store is nil"))
+	}
+
+	val, err := store.lmove(src, dst, srcHead, dstHead)
+
+	/* Serve clients blocked on dst, with data locks released */
+	if err == nil {
+		store.signalKeyReady(blockList, dst)
+	}
+
+	return val, err
+}
+
+
+/* LMOVE without serving the clients blocked on dst, used by BLMOVE which serves with blockedLock held */
+func (store *db) lmove(src string, dst string, srcHead bool, dstHead bool) (string, error) {
+	/* Take Global write lock, pop and push on two keys is a single operation */
+	store.listmapDBLock.Lock()
+	defer store.listmapDBLock.Unlock()
+
+	srcEntry, ok := store.listmapEntry[src]
+
+	/* An empty entry is a list emptied by other routine which has not deleted the key yet */
+	if ok == false || srcEntry.ql.length == 0 {
+		return "", errors.New(fmt.Sprint("LMOVE : key ", src, " not found"))
+	}
+
+	var val string
+	if srcHead == true {
+		val, _ = srcEntry.ql.popHead()
+	} else {
+		val, _ = srcEntry.ql.popTail()
+	}
+
+	dstEntry, ok := store.listmapEntry[dst]
+	if ok == false {
+		dstEntry = &listmapData{
+			ql: newQuicklist(),
+			lock: &sync.RWMutex{},
+		}
+		store.listmapEntry[dst] = dstEntry
+	}
+
+	if dstHead == true {
+		dstEntry.ql.pushHead(val)
+	} else {
+		dstEntry.ql.pushTail(val)
+	}
+
+	if srcEntry.ql.length == 0 {
+		delete(store.listmapEntry, src)
+	}
+
+	return val, nil
+}
+
+
+/* Pop up to count elements from head or tail of the first non empty list of keys */
+func (store *db) LMPOP(keys []string, count int, head bool) (string, []string, error) {
+	if store == nil {
+		fmt.Println("LMPOP : store is nil")
+		return "", nil, errors.New(fmt.Sprint("LMPOP : store is nil"))
+	}
+
+	for _, key := range keys {
+		vals, errRet := store.LPOP(key, count, head)
+
+		if errRet == nil && len(vals) > 0 {
+			return key, vals, nil
+		}
+	}
+
+	return "", []string{}, errors.New(fmt.Sprint("LMPOP : keys ", keys, " empty"))
+}
+
+
+/* Delete the key if the list is still empty, other routine may have pushed since the check */
+func (store *db) listDeleteIfEmpty(key string, entry *listmapData) {
+	store.listmapDBLock.Lock()
+	defer store.listmapDBLock.Unlock()
+
+	if store.listmapEntry[key] == entry && entry.ql.length == 0 {
+		delete(store.listmapEntry, key)
+	}
+}
+
+
+/* Parse LEFT|RIGHT wherefrom and whereto of LMOVE, returns true for LEFT (head) */
+func parseListDirections(from string, to string) (bool, bool, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+
+	if (from != "LEFT" && from != "RIGHT") || (to != "LEFT" && to != "RIGHT") {
+		return false, false, errors.New("syntax error")
+	}
+
+	return from == "LEFT", to == "LEFT", nil
+}
+
+
+/* Parse numkeys key [key ...] LEFT|RIGHT [COUNT count] of LMPOP, returns true for LEFT (head) */
+func parseLmpopArgs(args []string) ([]string, bool, int, error) {
+	var head bool
+	var count int = 1
+
+	numkeys, err := strconv.Atoi(args[0])
+	if err != nil || numkeys < 1 {
+		return nil, false, 0, errors.New("numkeys should be greater than 0")
+	}
+
+	if numkeys + 2 > len(args) {
+		return nil, false, 0, errors.New("syntax error")
+	}
+
+	keys := args[1 : numkeys+1]
+
+	switch strings.ToUpper(args[numkeys+1]) {
+	case "LEFT":
+		head = true
+	case "RIGHT":
+		head = false
+	default:
+		return nil, false, 0, errors.New("syntax error")
+	}
+
+	rest := args[numkeys+2:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(rest[0]) != "COUNT" {
+			return nil, false, 0, errors.New("syntax error")
+		}
+
+		count, err = strconv.Atoi(rest[1])
+		if err != nil || count < 1 {
+			return nil, false, 0, errors.New("count should be greater than 0")
+		}
+	}
+
+	return keys, head, count, nil
+}
@@ -0,0 +1,244 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+
+/* Fail unless the nodes are linked both ways, none is empty and the counts add up to length */
+func checkQuicklist(t *testing.T, ql *quicklist) {
+	t.Helper()
+
+	count := 0
+	var prev *quicklistNode
+	for node := ql.head; node != nil; node = node.next {
+		if node.prev != prev {
+			t.Fatalf("node prev link broken")
+		}
+		if node.lp.count == 0 {
+			t.Fatalf("empty node left linked")
+		}
+		count += node.lp.count
+		prev = node
+	}
+
+	if ql.tail != prev {
+		t.Fatalf("tail is not the last node")
+	}
+	if count != ql.length {
+		t.Fatalf("nodes hold %v elements, length is %v", count, ql.length)
+	}
+}
+
+
+func checkValues(t *testing.T, ql *quicklist, want []string) {
+	t.Helper()
+	checkQuicklist(t, ql)
+
+	got := ql.values()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("values %v, want %v", got, want)
+	}
+}
+
+
+func TestQuicklistInsertBeforeOversizeElement(t *testing.T) {
+	big := strings.Repeat("b", 9000)
+
+	ql := newQuicklist()
+	ql.pushTail("a")
+	ql.pushTail(big)
+	ql.pushTail("c")
+	checkValues(t, ql, []string{"a", big, "c"})
+
+	/* LINSERT k BEFORE big x */
+	ql.insert(1, "x")
+	checkValues(t, ql, []string{"a", "x", big, "c"})
+
+	for _, want := range []string{"a", "x", big, "c"} {
+		val, ok := ql.popHead()
+		if ok == false || val != want {
+			t.Fatalf("popHead %q %v, want %q", val, ok, want)
+		}
+		checkQuicklist(t, ql)
+	}
+
+	if _, ok := ql.popHead(); ok == true {
+		t.Fatalf("popHead of empty list")
+	}
+}
+
+
+func TestQuicklistSplit(t *testing.T) {
+	ql := newQuicklist()
+	want := []string{}
+
+	for i := 0; i < quicklistNodeEntries; i++ {
+		ql.pushTail(string(rune('a' + i % 26)))
+		want = append(want, string(rune('a' + i % 26)))
+	}
+	if ql.head != ql.tail {
+		t.Fatalf("%v elements should fit one node", quicklistNodeEntries)
+	}
+
+	/* Full node splits in halves, the new element goes to the second half */
+	ql.insert(100, "x")
+	want = append(want[:100], append([]string{"x"}, want[100:]...)...)
+	checkValues(t, ql, want)
+
+	if ql.head == ql.tail || ql.head.lp.count != quicklistNodeEntries / 2 {
+		t.Fatalf("full node not split in halves")
+	}
+
+	for i := 0; i < len(want); i++ {
+		if val, _ := ql.get(i); val != want[i] {
+			t.Fatalf("get %v is %q, want %q", i, val, want[i])
+		}
+	}
+}
+
+
+func TestQuicklistNodeBytes(t *testing.T) {
+	ql := newQuicklist()
+	val := strings.Repeat("v", 3000)
+
+	for i := 0; i < 6; i++ {
+		ql.pushTail(val)
+	}
+	checkQuicklist(t, ql)
+
+	for node := ql.head; node != nil; node = node.next {
+		if len(node.lp.data) > quicklistNodeBytes {
+			t.Fatalf("node of %v bytes", len(node.lp.data))
+		}
+	}
+}
+
+
+func TestQuicklistDeleteRange(t *testing.T) {
+	ql := newQuicklist()
+	want := []string{}
+
+	for i := 0; i < 1000; i++ {
+		v := strings.Repeat("e", i % 50)
+		ql.pushTail(v)
+		want = append(want, v)
+	}
+
+	/* Across node bounds, whole nodes in the middle get unlinked */
+	ql.deleteRange(100, 500)
+	want = append(want[:100], want[600:]...)
+	checkValues(t, ql, want)
+
+	ql.deleteRange(0, 100)
+	want = want[100:]
+	checkValues(t, ql, want)
+
+	ql.deleteRange(0, ql.length)
+	checkValues(t, ql, []string{})
+
+	if ql.head != nil || ql.tail != nil {
+		t.Fatalf("empty list still has nodes")
+	}
+}
+
+
+func TestListPopOfEmptiedList(t *testing.T) {
+	store := newDB()
+
+	store.LPUSH("l", []string{"a"}, false, false)
+	store.LPUSH("src", []string{"b"}, false, false)
+
+	/* Emptied but still in the map, as between a pop and the delete of the key */
+	store.listmapEntry["l"].ql.popHead()
+	store.listmapEntry["src"].ql.popHead()
+
+	if vals, err := store.LPOP("l", 1, true); err == nil || len(vals) != 0 {
+		t.Fatalf("LPOP of empty list %v %v", vals, err)
+	}
+
+	if _, err := store.LMOVE("src", "dst", true, true); err == nil {
+		t.Fatalf("LMOVE from empty list")
+	}
+	if _, ok := store.listmapEntry["dst"]; ok == true {
+		t.Fatalf("LMOVE from empty list created dst")
+	}
+}
+
+
+func TestListInsertAndPop(t *testing.T) {
+	store := newDB()
+	big := strings.Repeat("b", 9000)
+
+	store.LPUSH("k", []string{"a", big, "c"}, false, false)
+
+	if n, _ := store.LINSERT("k", true, big, "x"); n != 4 {
+		t.Fatalf("LINSERT returned %v", n)
+	}
+	checkQuicklist(t, store.listmapEntry["k"].ql)
+
+	for _, want := range []string{"a", "x", big, "c"} {
+		vals, err := store.LPOP("k", 1, true)
+		if err != nil || len(vals) != 1 || vals[0] != want {
+			t.Fatalf("LPOP %v %v, want %q", vals, err, want)
+		}
+	}
+
+	if _, ok := store.listmapEntry["k"]; ok == true {
+		t.Fatalf("empty list not deleted")
+	}
+}
+
+
+/* Elements over several nodes read the same after Load, saved twice */
+func TestListSaveLoad(t *testing.T) {
+	store := newDB()
+
+	list := []string{}
+	for i := 0; i < 300; i++ {
+		list = append(list, "e" + strconv.Itoa(i))
+	}
+	list = append(list, strings.Repeat("b", 9000), "last")
+	store.LPUSH("l", list, false, false)
+
+	loaded := saveLoad(t, saveLoad(t, store))
+
+	vals, _ := loaded.LRANGE("l", 0, -1)
+	if strings.Join(vals, ",") != strings.Join(list, ",") {
+		t.Fatalf("LRANGE %v elements, want %v", len(vals), len(list))
+	}
+	checkQuicklist(t, loaded.listmapEntry["l"].ql)
+}
+
+
+/* Pushes to an existing key only hold the global read lock, run with -race */
+func TestListConcurrentPush(t *testing.T) {
+	store := newDB()
+	store.LPUSH("l", []string{"first"}, false, false)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				store.LPUSH("l", []string{"v"}, i % 2 == 0, false)
+				store.LLEN("l")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, _ := store.LLEN("l"); n != 1601 {
+		t.Fatalf("LLEN %v after concurrent pushes", n)
+	}
+	checkQuicklist(t, store.listmapEntry["l"].ql)
+}
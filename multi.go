@@ -0,0 +1,110 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"fmt"
+)
+
+
+/*
+  Transactions (MULTI, EXEC, DISCARD)
+
+  MULTI starts queueing the commands of the client, every command is answered
+  with QUEUED. EXEC runs the queued commands in order and sends their replies,
+  DISCARD drops them.
+
+  Synchronization
+  1. Every command holds execLock read lock while it runs, commands of
+     different clients run concurrently
+  2. EXEC holds execLock write lock while it runs the queued commands, no
+     command of other clients runs in the middle of a transaction
+  3. Blocking commands inside EXEC never block, and clients blocked on keys
+     pushed inside EXEC are served once the transaction is done, see block.go
+*/
+
+
+/* Handle MULTI\EXEC\DISCARD and queue commands inside MULTI, returns false if cmd is to be executed */
+func (client *client) transaction(cmd *command) bool {
+	switch cmd.Name {
+	case "MULTI":
+		if client.multi == true {
+			client.sendError(fmt.Errorf("MULTI calls can not be nested"))
+			return true
+		}
+
+		client.multi = true
+		client.queued = nil
+		client.send("+OK")
+
+	case "EXEC":
+		if client.multi == false {
+			client.sendError(fmt.Errorf("EXEC without MULTI"))
+			return true
+		}
+
+		queued := client.queued
+		client.multi = false
+		client.queued = nil
+
+		client.exec(queued)
+
+	case "DISCARD":
+		if client.multi == false {
+			client.sendError(fmt.Errorf("DISCARD without MULTI"))
+			return true
+		}
+
+		client.multi = false
+		client.queued = nil
+		client.send("+OK")
+
+	case "EXIT":
+		/* EXIT is never queued, the connection is closed right away */
+		return false
+
+	default:
+		if client.multi == false {
+			return false
+		}
+
+		client.queued = append(client.queued, cmd)
+		client.send("QUEUED")
+	}
+
+	return true
+}
+
+
+/* Run the queued commands of a transaction with no other command running in between */
+func (client *client) exec(queued []*command) {
+	if len(queued) == 0 {
+		client.send("(empty list or set)")
+		return
+	}
+
+	client.store.execLock.Lock()
+
+	client.store.blockedLock.Lock()
+	client.store.execRunning = true
+	client.store.blockedLock.Unlock()
+
+	client.inExec = true
+
+	for _, cmd := range queued {
+		client.execute(cmd)
+	}
+
+	client.inExec = false
+
+	/* Serve clients blocked on keys pushed by the transaction */
+	client.store.blockedLock.Lock()
+	client.store.execRunning = false
+	client.store.serveReadyKeys()
+	client.store.blockedLock.Unlock()
+
+	client.store.execLock.Unlock()
+}
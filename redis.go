@@ -46,13 +46,7 @@ func main() {
 	}
 	
 	// Create the db instance
-	store := &db{
-		mapEntry: make(map[string]*mapData),
-		mapDBLock: &sync.RWMutex{},
-		onEvicted: display,
-		setmapEntry: make(map[string]*setmapData),
-		setmapDBLock: &sync.RWMutex{},
-	}
+	store := newDB()
 
 	// Run the Caretaker to periodicly clean the expired map entry
 	runCaretaker(store, timeInterval)
@@ -121,8 +115,40 @@ func main() {
 }
 
 
-
-
-
-
-
+/* Empty db instance */
+func newDB() *db {
+	return &db{
+		mapEntry: make(map[string]*mapData),
+		mapDBLock: &sync.RWMutex{},
+		onEvicted: display,
+		setmapEntry: make(map[string]*setmapData),
+		setmapDBLock: &sync.RWMutex{},
+		listmapEntry: make(map[string]*listmapData),
+		listmapDBLock: &sync.RWMutex{},
+		hashmapEntry: make(map[string]*hashmapData),
+		hashmapDBLock: &sync.RWMutex{},
+		smapEntry: make(map[string]*smapData),
+		smapDBLock: &sync.RWMutex{},
+		streammapEntry: make(map[string]*streammapData),
+		streammapDBLock: &sync.RWMutex{},
+		jsonmapEntry: make(map[string]*jsonmapData),
+		jsonmapDBLock: &sync.RWMutex{},
+		bfmapEntry: make(map[string]*bfmapData),
+		bfmapDBLock: &sync.RWMutex{},
+		cfmapEntry: make(map[string]*cfmapData),
+		cfmapDBLock: &sync.RWMutex{},
+		cmsmapEntry: make(map[string]*cmsmapData),
+		cmsmapDBLock: &sync.RWMutex{},
+		topkmapEntry: make(map[string]*topkmapData),
+		topkmapDBLock: &sync.RWMutex{},
+		tdigestmapEntry: make(map[string]*tdigestmapData),
+		tdigestmapDBLock: &sync.RWMutex{},
+		tsmapEntry: make(map[string]*tsmapData),
+		tsmapDBLock: &sync.RWMutex{},
+		ftmapEntry: make(map[string]*ftIndex),
+		ftmapDBLock: &sync.RWMutex{},
+		blockedEntry: make(map[blockingKey][]*blockedClient),
+		blockedLock: &sync.Mutex{},
+		execLock: &sync.RWMutex{},
+	}
+}
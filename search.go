@@ -0,0 +1,753 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+
+/*
+  Secondary indexes over hashes, same commands as redis search
+
+  An index covers every hash whose key starts with one of its prefixes (all
+  hashes without PREFIX). Every hash write calls searchIndexHash with the key
+  write lock held, which indexes the hash again in every covering index, so
+  the indexes are always in sync with the hashes. An index keeps the earliest
+  TTL of the indexed fields of every hash, FT.SEARCH first removes the expired
+  fields of these hashes which indexes them again.
+
+  Field types
+     TEXT    - inverted index of term to keys and term frequency, terms are the
+               lower cased runs of letters and digits. Matches are scored by tf-idf
+     NUMERIC - keys sorted by value for range queries
+     TAG     - exact lower cased values split by the separator, default ","
+     VECTOR  - float32 vectors for KNN queries, see vector.go
+
+  Lock order is hash DB lock, hash key lock, ftmapDBLock, index lock. FT.SEARCH
+  releases the index lock before it reads the hashes of the results.
+
+  Index definitions are persisted, the indexes are built again on load.
+*/
+
+const (
+	// Defaults of FT.SEARCH LIMIT
+	ftDefaultOffset = 0
+	ftDefaultNum = 10
+
+	// Default separator of TAG fields
+	ftDefaultSeparator = ","
+)
+
+type ftField struct {
+	name string
+	kind string
+	weight float64
+	separator string
+	sortable bool
+
+	/* VECTOR fields */
+	algorithm string
+	vecType string
+	dim int
+	metric string
+	m int
+	efConstruction int
+	efRuntime int
+}
+
+type ftNumEntry struct {
+	val float64
+	key string
+}
+
+/* Indexed values of a hash, schema fields only */
+type ftDoc struct {
+	values map[string]string
+	terms map[string]map[string]int
+}
+
+type ftIndex struct {
+	name string
+	prefixes []string
+	fields []ftField
+
+	docs map[string]*ftDoc
+	text map[string]map[string]map[string]int
+	tags map[string]map[string]map[string]bool
+	nums map[string][]ftNumEntry
+	vectors map[string]*ftVectorIndex
+
+	/* Earliest expiration time of the indexed fields in unix nano, keys with a TTL only */
+	expiring map[string]int64
+
+	lock *sync.RWMutex
+}
+
+type ftSearchOptions struct {
+	offset int
+	num int
+	sortBy string
+	desc bool
+	noContent bool
+	withScores bool
+	returnFields []string
+	params map[string]string
+}
+
+type ftResult struct {
+	key string
+	score float64
+	fields []string
+}
+
+
+func newFTIndex(name string, prefixes []string, fields []ftField) *ftIndex {
+	idx := &ftIndex{
+		name: name,
+		prefixes: prefixes,
+		fields: fields,
+		docs: make(map[string]*ftDoc),
+		text: make(map[string]map[string]map[string]int),
+		tags: make(map[string]map[string]map[string]bool),
+		nums: make(map[string][]ftNumEntry),
+		vectors: make(map[string]*ftVectorIndex),
+		expiring: make(map[string]int64),
+		lock: &sync.RWMutex{},
+	}
+
+	for i, f := range fields {
+		switch f.kind {
+		case "TEXT":
+			idx.text[f.name] = make(map[string]map[string]int)
+		case "TAG":
+			idx.tags[f.name] = make(map[string]map[string]bool)
+		case "NUMERIC":
+			idx.nums[f.name] = []ftNumEntry{}
+		case "VECTOR":
+			idx.vectors[f.name] = newFTVectorIndex(&idx.fields[i])
+		}
+	}
+
+	return idx
+}
+
+
+/* Lower cased runs of letters and digits */
+func ftTokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsLetter(r) == false && unicode.IsDigit(r) == false
+	})
+}
+
+
+/* Lower cased tags of value split by sep, empty tags left out */
+func ftSplitTags(value string, sep string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(value, sep) {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+
+func (idx *ftIndex) field(name string) (*ftField, bool) {
+	for i := range idx.fields {
+		if idx.fields[i].name == name {
+			return &idx.fields[i], true
+		}
+	}
+	return nil, false
+}
+
+
+func (idx *ftIndex) covers(key string) bool {
+	if len(idx.prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range idx.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+
+/* Position of value key in the sorted numeric entries of field */
+func (idx *ftIndex) numSearch(field string, val float64, key string) int {
+	entries := idx.nums[field]
+	return sort.Search(len(entries), func(i int) bool {
+		return entries[i].val > val || (entries[i].val == val && entries[i].key >= key)
+	})
+}
+
+
+/* Remove key from the index */
+func (idx *ftIndex) remove(key string) {
+	doc, ok := idx.docs[key]
+	if ok == false {
+		return
+	}
+
+	for field, terms := range doc.terms {
+		for term := range terms {
+			delete(idx.text[field][term], key)
+			if len(idx.text[field][term]) == 0 {
+				delete(idx.text[field], term)
+			}
+		}
+	}
+
+	for _, f := range idx.fields {
+		value, ok := doc.values[f.name]
+		if ok == false {
+			continue
+		}
+
+		if f.kind == "TAG" {
+			for _, tag := range ftSplitTags(value, f.separator) {
+				delete(idx.tags[f.name][tag], key)
+				if len(idx.tags[f.name][tag]) == 0 {
+					delete(idx.tags[f.name], tag)
+				}
+			}
+		} else if f.kind == "NUMERIC" {
+			val, _ := strconv.ParseFloat(value, 64)
+			entries := idx.nums[f.name]
+			if i := idx.numSearch(f.name, val, key); i < len(entries) && entries[i].key == key {
+				idx.nums[f.name] = append(entries[:i], entries[i+1:]...)
+			}
+		} else if f.kind == "VECTOR" {
+			idx.vectors[f.name].remove(key)
+		}
+	}
+
+	delete(idx.docs, key)
+	delete(idx.expiring, key)
+}
+
+
+/* Index the field value pairs of hash key with the TTLs of its fields, a key without pairs is not indexed */
+func (idx *ftIndex) add(key string, pairs []string, expires map[string]int64) {
+	if len(pairs) == 0 {
+		return
+	}
+
+	doc := &ftDoc{
+		values: make(map[string]string),
+		terms: make(map[string]map[string]int),
+	}
+
+	for i := 0; i + 1 < len(pairs); i = i + 2 {
+		f, ok := idx.field(pairs[i])
+		if ok == false {
+			continue
+		}
+		value := pairs[i+1]
+
+		switch f.kind {
+		case "TEXT":
+			terms := make(map[string]int)
+			for _, term := range ftTokenize(value) {
+				terms[term]++
+			}
+
+			for term, tf := range terms {
+				if idx.text[f.name][term] == nil {
+					idx.text[f.name][term] = make(map[string]int)
+				}
+				idx.text[f.name][term][key] = tf
+			}
+			doc.terms[f.name] = terms
+
+		case "TAG":
+			for _, tag := range ftSplitTags(value, f.separator) {
+				if idx.tags[f.name][tag] == nil {
+					idx.tags[f.name][tag] = make(map[string]bool)
+				}
+				idx.tags[f.name][tag][key] = true
+			}
+
+		case "NUMERIC":
+			/* A value which is not a number is not indexed */
+			val, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(val) {
+				continue
+			}
+
+			entries := idx.nums[f.name]
+			i := idx.numSearch(f.name, val, key)
+			entries = append(entries, ftNumEntry{})
+			copy(entries[i+1:], entries[i:])
+			entries[i] = ftNumEntry{val: val, key: key}
+			idx.nums[f.name] = entries
+
+		case "VECTOR":
+			/* A value which is not a vector of the field dimension is not indexed */
+			vec, err := parseVector(value, f.dim)
+			if err != nil {
+				continue
+			}
+			idx.vectors[f.name].add(key, vec)
+		}
+
+		doc.values[f.name] = value
+
+		if e, ok := expires[f.name]; ok == true && (idx.expiring[key] == 0 || e < idx.expiring[key]) {
+			idx.expiring[key] = e
+		}
+	}
+
+	idx.docs[key] = doc
+}
+
+
+/* Index all the hashes covered, the caller holds hashmapDBLock */
+func (idx *ftIndex) build(store *db) {
+	for key, entry := range store.hashmapEntry {
+		if idx.covers(key) == true {
+			idx.add(key, entry.pairs(), entry.expires)
+		}
+	}
+}
+
+
+/* Index hash key again in every index covering it, called with the key write lock held after every write */
+func (store *db) searchIndexHash(key string, entry *hashmapData) {
+	store.ftmapDBLock.RLock()
+	defer store.ftmapDBLock.RUnlock()
+
+	var pairs []string
+	read := false
+
+	for _, idx := range store.ftmapEntry {
+		if idx.covers(key) == false {
+			continue
+		}
+
+		if read == false {
+			pairs = entry.pairs()
+			read = true
+		}
+
+		idx.lock.Lock()
+		idx.remove(key)
+		idx.add(key, pairs, entry.expires)
+		idx.lock.Unlock()
+	}
+}
+
+
+/* Remove the expired fields of the hashes in index name, which indexes them again. No lock may be held */
+func (store *db) ftExpireFields(name string) {
+	now := time.Now().UnixNano()
+	keys := []string{}
+
+	store.ftmapDBLock.RLock()
+	if idx, ok := store.ftmapEntry[name]; ok == true {
+		idx.lock.RLock()
+		for key, e := range idx.expiring {
+			if now > e {
+				keys = append(keys, key)
+			}
+		}
+		idx.lock.RUnlock()
+	}
+	store.ftmapDBLock.RUnlock()
+
+	for _, key := range keys {
+		store.hashRemoveExpired(key)
+	}
+}
+
+
+/* Sort results by field value, missing values last, else by score. Ties by key */
+func (idx *ftIndex) sortResults(results []ftResult, opts *ftSearchOptions) {
+	f, byField := idx.field(opts.sortBy)
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+
+		if byField == false {
+			if a.score != b.score {
+				return a.score > b.score
+			}
+			return a.key < b.key
+		}
+
+		va, oka := idx.docs[a.key].values[f.name]
+		vb, okb := idx.docs[b.key].values[f.name]
+
+		if oka != okb {
+			return oka
+		}
+
+		if oka == true && va != vb {
+			less := va < vb
+			if f.kind == "NUMERIC" {
+				na, _ := strconv.ParseFloat(va, 64)
+				nb, _ := strconv.ParseFloat(vb, 64)
+				if na != nb {
+					less = na < nb
+				}
+			}
+			return less != opts.desc
+		}
+		return a.key < b.key
+	})
+}
+
+
+/* Create index name over the hashes with prefixes, the existing hashes are indexed right away */
+func (store *db) FTCREATE(name string, prefixes []string, fields []ftField) error {
+	if store == nil {
+		fmt.Println("FTCREATE : store is nil")
+		return errors.New(fmt.Sprint("FTCREATE : store is nil"))
+	}
+
+	/* Take hash Global write lock, no hash gets written while the index is built */
+	store.hashmapDBLock.Lock()
+	defer store.hashmapDBLock.Unlock()
+
+	/* Take Global write lock, to ensure only one index gets created */
+	store.ftmapDBLock.Lock()
+	defer store.ftmapDBLock.Unlock()
+
+	if _, ok := store.ftmapEntry[name]; ok == true {
+		return errors.New("Index already exists")
+	}
+
+	idx := newFTIndex(name, prefixes, fields)
+	idx.build(store)
+
+	store.ftmapEntry[name] = idx
+
+	return nil
+}
+
+
+/* Search index name, returns the number of matches and the page of results asked by opts */
+func (store *db) FTSEARCH(name string, query string, opts *ftSearchOptions) (int, []ftResult, error) {
+	if store == nil {
+		fmt.Println("FTSEARCH : store is nil")
+		return 0, nil, errors.New(fmt.Sprint("FTSEARCH : store is nil"))
+	}
+
+	filter, knn, err := parseFTKNN(query)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	/* Hash reads hide expired fields without removing them, the index has to drop them before the search */
+	store.ftExpireFields(name)
+
+	/* Take Global Read lock to hold drop of the index until the matches are found */
+	store.ftmapDBLock.RLock()
+
+	idx, ok := store.ftmapEntry[name]
+
+	if ok == false {
+		store.ftmapDBLock.RUnlock()
+		return 0, nil, errors.New(fmt.Sprint(name, ": no such index"))
+	}
+
+	/* Take index Rlock before search */
+	idx.lock.RLock()
+
+	/* KNN results sort by the distance field too */
+	byDistance := knn != nil && (opts.sortBy == "" || opts.sortBy == knn.scoreField)
+
+	if _, ok := idx.field(opts.sortBy); opts.sortBy != "" && ok == false && byDistance == false {
+		idx.lock.RUnlock()
+		store.ftmapDBLock.RUnlock()
+		return 0, nil, errors.New(fmt.Sprint("Property `", opts.sortBy, "` not loaded nor in schema"))
+	}
+
+	var results []ftResult
+
+	if knn == nil {
+		var matches map[string]float64
+		matches, err = idx.query(query)
+
+		results = make([]ftResult, 0, len(matches))
+		for key, score := range matches {
+			results = append(results, ftResult{key: key, score: score})
+		}
+
+		idx.sortResults(results, opts)
+	} else {
+		results, err = idx.knnSearch(filter, knn, opts.params)
+
+		if byDistance == false {
+			idx.sortResults(results, opts)
+		} else if opts.desc == true {
+			for i, j := 0, len(results) - 1; i < j; i, j = i + 1, j - 1 {
+				results[i], results[j] = results[j], results[i]
+			}
+		}
+	}
+
+	idx.lock.RUnlock()
+	store.ftmapDBLock.RUnlock()
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	total := len(results)
+
+	if opts.offset >= len(results) {
+		results = results[:0]
+	} else {
+		results = results[opts.offset:]
+	}
+	if len(results) > opts.num {
+		results = results[:opts.num]
+	}
+
+	if opts.noContent == true {
+		return total, results, nil
+	}
+
+	/* Fields of the hashes, read after the index lock is released as hash writers lock the index */
+	for i := range results {
+		pairs, err := store.HGETALL(results[i].key)
+		if err != nil {
+			continue
+		}
+
+		/* Distance of a KNN result comes first */
+		if opts.returnFields == nil {
+			if knn != nil {
+				results[i].fields = append(results[i].fields, knn.scoreField, formatScore(results[i].score))
+			}
+			results[i].fields = append(results[i].fields, pairs...)
+			continue
+		}
+
+		for _, field := range opts.returnFields {
+			if knn != nil && field == knn.scoreField {
+				results[i].fields = append(results[i].fields, field, formatScore(results[i].score))
+				continue
+			}
+
+			for k := 0; k + 1 < len(pairs); k = k + 2 {
+				if pairs[k] == field {
+					results[i].fields = append(results[i].fields, field, pairs[k+1])
+					break
+				}
+			}
+		}
+	}
+
+	return total, results, nil
+}
+
+
+/*
+  Parse [ON HASH] [PREFIX count prefix ...] SCHEMA field type [options] ... of FT.CREATE
+  type is TEXT [WEIGHT weight], NUMERIC or TAG [SEPARATOR separator], each with optional SORTABLE,
+  or VECTOR FLAT|HNSW count option value ...
+*/
+func parseFTCreateArgs(args []string) ([]string, []ftField, error) {
+	prefixes := []string{}
+	fields := []ftField{}
+
+	i := 0
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+
+		if opt == "ON" && i + 1 < len(args) {
+			i++
+			if strings.ToUpper(args[i]) != "HASH" {
+				return nil, nil, errors.New("Only HASH indexes are supported")
+			}
+		} else if opt == "PREFIX" && i + 1 < len(args) {
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 || i + 2 + n > len(args) {
+				return nil, nil, errors.New("Bad arguments for PREFIX")
+			}
+			prefixes = append(prefixes, args[i+2 : i+2+n]...)
+			i = i + 1 + n
+		} else if opt == "SCHEMA" {
+			break
+		} else {
+			return nil, nil, errors.New(fmt.Sprint("Unknown argument `", args[i], "`"))
+		}
+	}
+
+	if i >= len(args) {
+		return nil, nil, errors.New("No schema found")
+	}
+
+	for i++; i < len(args); i++ {
+		if i + 1 >= len(args) {
+			return nil, nil, errors.New(fmt.Sprint("Field `", args[i], "` has no type"))
+		}
+
+		f := ftField{name: args[i], kind: strings.ToUpper(args[i+1]), weight: 1, separator: ftDefaultSeparator}
+		i++
+
+		if f.kind != "TEXT" && f.kind != "NUMERIC" && f.kind != "TAG" && f.kind != "VECTOR" {
+			return nil, nil, errors.New(fmt.Sprint("Invalid field type for field `", f.name, "`"))
+		}
+
+		for _, other := range fields {
+			if other.name == f.name {
+				return nil, nil, errors.New(fmt.Sprint("Duplicate field in schema - ", f.name))
+			}
+		}
+
+		if f.kind == "VECTOR" {
+			if i + 2 >= len(args) {
+				return nil, nil, errors.New(fmt.Sprint("Bad arguments for vector field `", f.name, "`"))
+			}
+
+			f.algorithm = strings.ToUpper(args[i+1])
+			if f.algorithm != "FLAT" && f.algorithm != "HNSW" {
+				return nil, nil, errors.New(fmt.Sprint("Bad vector algorithm for field `", f.name, "`"))
+			}
+
+			n, err := strconv.Atoi(args[i+2])
+			if err != nil || n < 0 || i + 3 + n > len(args) {
+				return nil, nil, errors.New(fmt.Sprint("Bad arguments for vector field `", f.name, "`"))
+			}
+
+			err = parseVectorFieldArgs(&f, args[i+3 : i+3+n])
+			if err != nil {
+				return nil, nil, err
+			}
+			i = i + 2 + n
+		}
+
+		/* Field options */
+		for i + 1 < len(args) && f.kind != "VECTOR" {
+			opt := strings.ToUpper(args[i+1])
+
+			if opt == "SORTABLE" {
+				f.sortable = true
+				i++
+			} else if opt == "WEIGHT" && f.kind == "TEXT" && i + 2 < len(args) {
+				weight, err := strconv.ParseFloat(args[i+2], 64)
+				if err != nil || weight <= 0 {
+					return nil, nil, errors.New(fmt.Sprint("Bad WEIGHT for field `", f.name, "`"))
+				}
+				f.weight = weight
+				i = i + 2
+			} else if opt == "SEPARATOR" && f.kind == "TAG" && i + 2 < len(args) {
+				if len(args[i+2]) != 1 {
+					return nil, nil, errors.New(fmt.Sprint("Bad SEPARATOR for field `", f.name, "`"))
+				}
+				f.separator = args[i+2]
+				i = i + 2
+			} else {
+				break
+			}
+		}
+
+		fields = append(fields, f)
+	}
+
+	if len(fields) == 0 {
+		return nil, nil, errors.New("No fields in schema")
+	}
+
+	return prefixes, fields, nil
+}
+
+
+/*
+  Parse query [NOCONTENT] [WITHSCORES] [RETURN count field ...] [SORTBY field [ASC|DESC]] [LIMIT offset num]
+  [PARAMS count name value ...] [DIALECT dialect] of FT.SEARCH. The query is split on spaces by the protocol, its words are joined
+  up to the first option
+*/
+func parseFTSearchArgs(args []string) (string, *ftSearchOptions, error) {
+	opts := &ftSearchOptions{offset: ftDefaultOffset, num: ftDefaultNum}
+
+	isOption := func(arg string) bool {
+		switch strings.ToUpper(arg) {
+		case "NOCONTENT", "WITHSCORES", "RETURN", "SORTBY", "LIMIT", "PARAMS", "DIALECT":
+			return true
+		}
+		return false
+	}
+
+	n := 0
+	for n < len(args) && isOption(args[n]) == false {
+		n++
+	}
+
+	if n == 0 {
+		return "", nil, errors.New("No query given")
+	}
+	query := strings.Join(args[:n], " ")
+
+	for i := n; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+
+		if opt == "NOCONTENT" {
+			opts.noContent = true
+		} else if opt == "WITHSCORES" {
+			opts.withScores = true
+		} else if opt == "RETURN" && i + 1 < len(args) {
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil || count < 0 || i + 2 + count > len(args) {
+				return "", nil, errors.New("Bad arguments for RETURN")
+			}
+			opts.returnFields = append([]string{}, args[i+2 : i+2+count]...)
+			i = i + 1 + count
+		} else if opt == "SORTBY" && i + 1 < len(args) {
+			opts.sortBy = args[i+1]
+			i++
+			if i + 1 < len(args) && (strings.ToUpper(args[i+1]) == "ASC" || strings.ToUpper(args[i+1]) == "DESC") {
+				opts.desc = strings.ToUpper(args[i+1]) == "DESC"
+				i++
+			}
+		} else if opt == "LIMIT" && i + 2 < len(args) {
+			offset, err1 := strconv.Atoi(args[i+1])
+			num, err2 := strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil || offset < 0 || num < 0 {
+				return "", nil, errors.New("Bad arguments for LIMIT")
+			}
+			opts.offset = offset
+			opts.num = num
+			i = i + 2
+		} else if opt == "PARAMS" && i + 1 < len(args) {
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil || count < 0 || count % 2 != 0 || i + 2 + count > len(args) {
+				return "", nil, errors.New("Bad arguments for PARAMS")
+			}
+			opts.params = make(map[string]string)
+			for k := i + 2; k < i + 2 + count; k = k + 2 {
+				opts.params[args[k]] = args[k+1]
+			}
+			i = i + 1 + count
+		} else if opt == "DIALECT" && i + 1 < len(args) {
+			/* Accepted for compatibility, there is one dialect */
+			if _, err := strconv.Atoi(args[i+1]); err != nil {
+				return "", nil, errors.New("Bad arguments for DIALECT")
+			}
+			i++
+		} else {
+			return "", nil, errors.New(fmt.Sprint("Unknown argument `", args[i], "`"))
+		}
+	}
+
+	return query, opts, nil
+}
@@ -0,0 +1,117 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+
+/* Index idx over doc: with a text field t, a tag field tag and a numeric field n */
+func newFTStore(t *testing.T) *db {
+	t.Helper()
+
+	store := newDB()
+	must(t, store.FTCREATE("idx", []string{"doc:"}, []ftField{
+		{name: "t", kind: "TEXT", weight: 1, separator: ftDefaultSeparator},
+		{name: "tag", kind: "TAG", weight: 1, separator: ftDefaultSeparator},
+		{name: "n", kind: "NUMERIC", weight: 1, separator: ftDefaultSeparator},
+	}))
+	return store
+}
+
+
+/* Fail unless query matches the keys want, in any order */
+func checkFTSearch(t *testing.T, store *db, query string, want []string) {
+	t.Helper()
+
+	total, results, err := store.FTSEARCH("idx", query, &ftSearchOptions{num: 100, noContent: true})
+	if err != nil {
+		t.Fatalf("FT.SEARCH %q: %v", query, err)
+	}
+
+	keys := []string{}
+	for _, res := range results {
+		keys = append(keys, res.key)
+	}
+	sort.Strings(keys)
+	sort.Strings(want)
+
+	if total != len(want) || strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Fatalf("FT.SEARCH %q %v %v, want %v", query, total, keys, want)
+	}
+}
+
+
+func TestFTSearchQuery(t *testing.T) {
+	store := newFTStore(t)
+
+	store.HSET("doc:1", []string{"t", "hello world", "tag", "red,blue", "n", "10"})
+	store.HSET("doc:2", []string{"t", "hello there", "tag", "blue", "n", "20"})
+	store.HSET("doc:3", []string{"t", "world peace", "tag", "green", "n", "30"})
+	store.HSET("other:1", []string{"t", "hello"})
+
+	checkFTSearch(t, store, "hello", []string{"doc:1", "doc:2"})
+	checkFTSearch(t, store, "hello world", []string{"doc:1"})
+	checkFTSearch(t, store, "hello | peace", []string{"doc:1", "doc:2", "doc:3"})
+	checkFTSearch(t, store, "hello -world", []string{"doc:2"})
+	checkFTSearch(t, store, "@tag:{blue}", []string{"doc:1", "doc:2"})
+	checkFTSearch(t, store, "@n:[15 +inf]", []string{"doc:2", "doc:3"})
+	checkFTSearch(t, store, "@n:[(10 30]", []string{"doc:2", "doc:3"})
+	checkFTSearch(t, store, "*", []string{"doc:1", "doc:2", "doc:3"})
+
+	/* Rewrite and delete of a hash update the index */
+	store.HSET("doc:2", []string{"t", "goodbye"})
+	checkFTSearch(t, store, "hello", []string{"doc:1"})
+	store.HDEL("doc:1", []string{"t", "tag", "n"})
+	checkFTSearch(t, store, "hello", []string{})
+}
+
+
+func TestFTSearchQuotedPhrase(t *testing.T) {
+	store := newFTStore(t)
+
+	store.HSET("doc:1", []string{"t", "hello big world"})
+	store.HSET("doc:2", []string{"t", "hello there"})
+
+	checkFTSearch(t, store, `"hello world"`, []string{"doc:1"})
+	checkFTSearch(t, store, `@t:"hello there"`, []string{"doc:2"})
+
+	for _, bad := range []string{`"hello world`, `@t:"hello`, `"`} {
+		if _, _, err := store.FTSEARCH("idx", bad, &ftSearchOptions{num: 10}); err == nil {
+			t.Fatalf("FT.SEARCH accepted %q", bad)
+		}
+	}
+}
+
+
+/* A hash is not found by a field expired since the last write */
+func TestFTSearchExpiredField(t *testing.T) {
+	store := newFTStore(t)
+
+	store.HSET("doc:1", []string{"t", "hello", "tag", "red"})
+	store.HEXPIRE("doc:1", []string{"t"}, time.Now().Add(time.Millisecond).UnixNano(), "")
+	time.Sleep(5 * time.Millisecond)
+
+	checkFTSearch(t, store, "hello", []string{})
+	checkFTSearch(t, store, "@tag:{red}", []string{"doc:1"})
+}
+
+
+func TestFTSaveLoad(t *testing.T) {
+	store := newFTStore(t)
+
+	store.HSET("doc:1", []string{"t", "hello", "n", "1"})
+	store.HSET("doc:2", []string{"t", "world", "n", "2"})
+
+	loaded := saveLoad(t, store)
+
+	checkFTSearch(t, loaded, "hello", []string{"doc:1"})
+	checkFTSearch(t, loaded, "@n:[2 2]", []string{"doc:2"})
+}
@@ -0,0 +1,392 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+
+/*
+  FT.SEARCH query language, a subset of redis search
+
+     hello world          - both terms, in any TEXT field
+     hello|hallo          - either term, | binds tighter than the space so
+                            hello|hallo world is (hello|hallo) and world
+     -hello               - not the term
+     hel*                 - terms starting with hel
+     "hello world"        - all the words of the phrase, word positions are not indexed so the order is not checked
+     (...)                - grouping
+     @title:hello         - term in field title, @title:(hello|world) for a group
+     @price:[10 20]       - numeric range, ( before a bound excludes it, -inf and +inf are open bounds
+     @tags:{red|blue}     - any of the tags
+     *                    - every document
+
+  The query is evaluated while it is parsed, every expression is the map of
+  the matching keys to their score. Text terms score tf-idf times the field
+  weight, intersections and unions add up the scores.
+*/
+
+type ftQueryParser struct {
+	s []rune
+	pos int
+	idx *ftIndex
+}
+
+
+/* Keys matching the query with their scores */
+func (idx *ftIndex) query(query string) (map[string]float64, error) {
+	p := &ftQueryParser{s: []rune(query), idx: idx}
+
+	p.skipSpaces()
+	if p.pos < len(p.s) && p.s[p.pos] == '*' && strings.TrimSpace(string(p.s[p.pos+1:])) == "" {
+		return p.all(), nil
+	}
+
+	result, err := p.parseIntersect("")
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.s) {
+		return nil, p.syntaxError()
+	}
+
+	return result, nil
+}
+
+
+func (p *ftQueryParser) syntaxError() error {
+	if p.pos >= len(p.s) {
+		return errors.New(fmt.Sprint("Syntax error at offset ", p.pos, ", unexpected end of query"))
+	}
+	return errors.New(fmt.Sprint("Syntax error at offset ", p.pos, " near ", string(p.s[p.pos:])))
+}
+
+
+func (p *ftQueryParser) skipSpaces() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+
+func (p *ftQueryParser) peek() rune {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+
+/* Text up to the closing rune, the closing rune is consumed */
+func (p *ftQueryParser) until(closing rune) (string, error) {
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] != closing {
+		p.pos++
+	}
+
+	if p.pos >= len(p.s) {
+		return "", p.syntaxError()
+	}
+
+	p.pos++
+	return string(p.s[start : p.pos-1]), nil
+}
+
+
+/* Space separated expressions, all must match. field is the TEXT field of @field:( ), empty for all */
+func (p *ftQueryParser) parseIntersect(field string) (map[string]float64, error) {
+	var result map[string]float64
+
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.s) || p.peek() == ')' {
+			break
+		}
+
+		expr, err := p.parseUnion(field)
+		if err != nil {
+			return nil, err
+		}
+
+		if result == nil {
+			result = expr
+		} else {
+			result = ftIntersect(result, expr)
+		}
+	}
+
+	if result == nil {
+		return nil, p.syntaxError()
+	}
+
+	return result, nil
+}
+
+
+/* | separated expressions, any must match */
+func (p *ftQueryParser) parseUnion(field string) (map[string]float64, error) {
+	result, err := p.parseUnary(field)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		p.skipSpaces()
+		if p.peek() != '|' {
+			return result, nil
+		}
+		p.pos++
+		p.skipSpaces()
+
+		expr, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, score := range expr {
+			result[key] += score
+		}
+	}
+}
+
+
+func (p *ftQueryParser) parseUnary(field string) (map[string]float64, error) {
+	if p.peek() != '-' {
+		return p.parsePrimary(field)
+	}
+
+	p.pos++
+	expr, err := p.parseUnary(field)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]float64)
+	for key := range p.idx.docs {
+		if _, ok := expr[key]; ok == false {
+			result[key] = 0
+		}
+	}
+	return result, nil
+}
+
+
+func (p *ftQueryParser) parsePrimary(field string) (map[string]float64, error) {
+	switch p.peek() {
+	case '(':
+		p.pos++
+		result, err := p.parseIntersect(field)
+		if err != nil {
+			return nil, err
+		}
+
+		p.skipSpaces()
+		if p.peek() != ')' {
+			return nil, p.syntaxError()
+		}
+		p.pos++
+		return result, nil
+
+	case '@':
+		p.pos++
+		name, err := p.until(':')
+		if err != nil {
+			return nil, err
+		}
+
+		f, ok := p.idx.field(name)
+		if ok == false {
+			return nil, errors.New(fmt.Sprint("Unknown field `", name, "`"))
+		}
+
+		switch f.kind {
+		case "NUMERIC":
+			if p.peek() != '[' {
+				return nil, p.syntaxError()
+			}
+			p.pos++
+			r, err := p.until(']')
+			if err != nil {
+				return nil, err
+			}
+			return p.numericRange(f.name, r)
+
+		case "TAG":
+			if p.peek() != '{' {
+				return nil, p.syntaxError()
+			}
+			p.pos++
+			r, err := p.until('}')
+			if err != nil {
+				return nil, err
+			}
+			return p.tags(f.name, r), nil
+
+		case "VECTOR":
+			return nil, errors.New(fmt.Sprint("Vector field `", f.name, "` is only searched with =>[KNN ...]"))
+		}
+
+		return p.parseUnary(f.name)
+
+	case '"':
+		p.pos++
+		phrase, err := p.until('"')
+		if err != nil {
+			return nil, err
+		}
+		return p.words(field, phrase), nil
+	}
+
+	/* Word up to a space or an operator */
+	start := p.pos
+	for p.pos < len(p.s) && strings.ContainsRune(" ()|@{}[]\"", p.s[p.pos]) == false {
+		p.pos++
+	}
+	word := string(p.s[start:p.pos])
+
+	if word == "" {
+		return nil, p.syntaxError()
+	}
+
+	if strings.HasSuffix(word, "*") && len(ftTokenize(word)) == 1 {
+		return p.term(field, ftTokenize(word)[0], true), nil
+	}
+
+	return p.words(field, word), nil
+}
+
+
+/* Keys with all the terms of text, a word of several terms like foo-bar needs all of them */
+func (p *ftQueryParser) words(field string, text string) map[string]float64 {
+	var result map[string]float64
+	for _, term := range ftTokenize(text) {
+		if result == nil {
+			result = p.term(field, term, false)
+		} else {
+			result = ftIntersect(result, p.term(field, term, false))
+		}
+	}
+
+	if result == nil {
+		result = make(map[string]float64)
+	}
+	return result
+}
+
+
+/* Every document */
+func (p *ftQueryParser) all() map[string]float64 {
+	result := make(map[string]float64, len(p.idx.docs))
+	for key := range p.idx.docs {
+		result[key] = 0
+	}
+	return result
+}
+
+
+/* Keys with term in field, or in any TEXT field if field is empty, scored by tf-idf */
+func (p *ftQueryParser) term(field string, term string, prefix bool) map[string]float64 {
+	result := make(map[string]float64)
+	n := float64(len(p.idx.docs))
+
+	for _, f := range p.idx.fields {
+		if f.kind != "TEXT" || (field != "" && f.name != field) {
+			continue
+		}
+
+		for t, postings := range p.idx.text[f.name] {
+			if t != term && (prefix == false || strings.HasPrefix(t, term) == false) {
+				continue
+			}
+
+			idf := math.Log(1 + n / float64(len(postings)))
+			for key, tf := range postings {
+				result[key] += f.weight * float64(tf) * idf
+			}
+		}
+	}
+
+	return result
+}
+
+
+/* Keys with the numeric field in the range "min max" */
+func (p *ftQueryParser) numericRange(field string, r string) (map[string]float64, error) {
+	bounds := strings.Fields(r)
+	if len(bounds) != 2 {
+		return nil, errors.New(fmt.Sprint("Bad numeric range [", r, "]"))
+	}
+
+	parse := func(bound string) (float64, bool, error) {
+		exclusive := strings.HasPrefix(bound, "(")
+		bound = strings.TrimPrefix(bound, "(")
+
+		v, err := strconv.ParseFloat(bound, 64)
+		if err != nil || math.IsNaN(v) {
+			return 0, false, errors.New(fmt.Sprint("Bad numeric bound ", bound))
+		}
+		return v, exclusive, nil
+	}
+
+	min, minEx, err := parse(bounds[0])
+	if err != nil {
+		return nil, err
+	}
+
+	max, maxEx, err := parse(bounds[1])
+	if err != nil {
+		return nil, err
+	}
+
+	entries := p.idx.nums[field]
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].val > min || (entries[i].val == min && minEx == false)
+	})
+
+	result := make(map[string]float64)
+	for ; i < len(entries); i++ {
+		if entries[i].val > max || (entries[i].val == max && maxEx == true) {
+			break
+		}
+		result[entries[i].key] = 0
+	}
+
+	return result, nil
+}
+
+
+/* Keys with any of the | separated tags in the tag field */
+func (p *ftQueryParser) tags(field string, r string) map[string]float64 {
+	result := make(map[string]float64)
+
+	for _, tag := range ftSplitTags(r, "|") {
+		for key := range p.idx.tags[field][tag] {
+			result[key] = 0
+		}
+	}
+
+	return result
+}
+
+
+/* Keys in both, scores added up */
+func ftIntersect(a map[string]float64, b map[string]float64) map[string]float64 {
+	result := make(map[string]float64)
+	for key, score := range a {
+		if other, ok := b[key]; ok == true {
+			result[key] = score + other
+		}
+	}
+	return result
+}
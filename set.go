@@ -0,0 +1,738 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+
+const (
+	// Max number of members of a set in intset encoding
+	setMaxIntsetEntries = 512
+
+	// Max -count of SRANDMEMBER, members picked with repetition
+	setMaxRandCount = 1 << 20
+)
+
+const (
+	setOpUnion = iota
+	setOpInter
+	setOpDiff
+)
+
+
+/*
+  Set storage, same idea as the redis set encodings
+
+  A set of integers only is stored as a sorted slice of int64 (intset), a
+  member lookup is a binary search and no string is kept per member. Once a
+  member which is not an integer in canonical form is added or the set grows
+  past setMaxIntsetEntries members, it is converted to a map and stays a map.
+
+  In map encoding the members are kept in a slice as well, dict maps a member
+  to its index in keys. A removed member takes the place of the last one, so a
+  random member or the member at a SSCAN cursor is a slice lookup.
+*/
+
+type smapData struct {
+	is []int64
+	dict map[string]int
+	keys []string
+	lock *sync.RWMutex
+}
+
+
+func newSmapData() *smapData {
+	return &smapData{
+		is: []int64{},
+		lock: &sync.RWMutex{},
+	}
+}
+
+
+/* Returns the integer value of member if member is an integer in canonical form, "01" or "+1" are not */
+func intsetValue(member string) (int64, bool) {
+	v, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != member {
+		return 0, false
+	}
+	return v, true
+}
+
+
+/* Returns the index of v in the intset, or the index to insert v at */
+func (s *smapData) search(v int64) (int, bool) {
+	i := sort.Search(len(s.is), func(k int) bool { return s.is[k] >= v })
+	return i, i < len(s.is) && s.is[i] == v
+}
+
+
+func (s *smapData) length() int {
+	if s.is != nil {
+		return len(s.is)
+	}
+	return len(s.keys)
+}
+
+
+func (s *smapData) contains(member string) bool {
+	if s.is != nil {
+		v, ok := intsetValue(member)
+		if ok == false {
+			return false
+		}
+		_, found := s.search(v)
+		return found
+	}
+
+	_, ok := s.dict[member]
+	return ok
+}
+
+
+/* Add member, returns true if member is new */
+func (s *smapData) add(member string) bool {
+	if s.is != nil {
+		v, ok := intsetValue(member)
+
+		if ok == true {
+			i, found := s.search(v)
+			if found == true {
+				return false
+			}
+
+			if len(s.is) < setMaxIntsetEntries {
+				s.is = append(s.is, 0)
+				copy(s.is[i+1:], s.is[i:])
+				s.is[i] = v
+				return true
+			}
+		}
+
+		s.convert()
+	}
+
+	if _, ok := s.dict[member]; ok == true {
+		return false
+	}
+
+	s.dict[member] = len(s.keys)
+	s.keys = append(s.keys, member)
+	return true
+}
+
+
+/* Remove member, returns true if it was present */
+func (s *smapData) remove(member string) bool {
+	if s.is != nil {
+		v, ok := intsetValue(member)
+		if ok == false {
+			return false
+		}
+
+		i, found := s.search(v)
+		if found == true {
+			s.is = append(s.is[:i], s.is[i+1:]...)
+		}
+		return found
+	}
+
+	i, ok := s.dict[member]
+	if ok == false {
+		return false
+	}
+
+	/* Last member takes the place of the removed one */
+	last := len(s.keys) - 1
+	s.keys[i] = s.keys[last]
+	s.dict[s.keys[i]] = i
+	s.keys[last] = ""
+	s.keys = s.keys[:last]
+
+	delete(s.dict, member)
+	return true
+}
+
+
+/* Convert intset encoding to map encoding */
+func (s *smapData) convert() {
+	s.dict = make(map[string]int, len(s.is))
+	s.keys = make([]string, 0, len(s.is))
+	for _, v := range s.is {
+		s.dict[strconv.FormatInt(v, 10)] = len(s.keys)
+		s.keys = append(s.keys, strconv.FormatInt(v, 10))
+	}
+	s.is = nil
+}
+
+
+/* Member at index i of the encoding, 0 <= i < length */
+func (s *smapData) member(i int) string {
+	if s.is != nil {
+		return strconv.FormatInt(s.is[i], 10)
+	}
+	return s.keys[i]
+}
+
+
+/* All members, intset is in integer order, map order is the order of keys */
+func (s *smapData) members() []string {
+	vals := make([]string, 0, s.length())
+
+	if s.is != nil {
+		for _, v := range s.is {
+			vals = append(vals, strconv.FormatInt(v, 10))
+		}
+		return vals
+	}
+
+	return append(vals, s.keys...)
+}
+
+
+/* Union, intersection or difference of sets, a nil set is an empty set. Intersection stops at limit members if limit > 0 */
+func setOperation(op int, sets []*smapData, limit int) []string {
+	result := []string{}
+
+	if len(sets) == 0 {
+		return result
+	}
+
+	switch op {
+	case setOpUnion:
+		seen := make(map[string]bool)
+		for _, set := range sets {
+			if set == nil {
+				continue
+			}
+			for _, member := range set.members() {
+				if seen[member] == false {
+					seen[member] = true
+					result = append(result, member)
+				}
+			}
+		}
+
+	case setOpInter:
+		/* Walk the smallest set, check membership in the others */
+		smallest := 0
+		for i, set := range sets {
+			if set == nil {
+				return result
+			}
+			if set.length() < sets[smallest].length() {
+				smallest = i
+			}
+		}
+
+		for _, member := range sets[smallest].members() {
+			in := true
+			for i, set := range sets {
+				if i != smallest && set.contains(member) == false {
+					in = false
+					break
+				}
+			}
+
+			if in == true {
+				result = append(result, member)
+				if limit > 0 && len(result) >= limit {
+					break
+				}
+			}
+		}
+
+	case setOpDiff:
+		if sets[0] == nil {
+			return result
+		}
+
+		for _, member := range sets[0].members() {
+			in := false
+			for _, set := range sets[1:] {
+				if set != nil && set.contains(member) == true {
+					in = true
+					break
+				}
+			}
+
+			if in == false {
+				result = append(result, member)
+			}
+		}
+	}
+
+	return result
+}
+
+
+/* Set operation of SINTER\SUNION\SDIFF and their STORE variants */
+func setOpByName(cmdName string) int {
+	switch {
+	case strings.HasPrefix(cmdName, "SINTER"):
+		return setOpInter
+	case strings.HasPrefix(cmdName, "SDIFF"):
+		return setOpDiff
+	}
+	return setOpUnion
+}
+
+
+/* Add members, returns the number of members added */
+func (store *db) SADD(key string, members []string) (int, error) {
+	if store == nil {
+		fmt.Println("SADD : store is nil")
+		return 0, errors.New(fmt.Sprint("SADD : store is nil"))
+	}
+
+	var entry *smapData
+	var ok bool
+
+	store.smapDBLock.RLock()
+
+	/* Check if db has the key entry */
+	entry, ok = store.smapEntry[key]
+
+	/* If entry not present */
+	if ok == false {
+		/* Take DB lock before creating entry for this key, to ensure only one entry gets created */
+		store.smapDBLock.RUnlock()
+		store.smapDBLock.Lock()
+
+		/* Again Check if db has the key entry, between above if & db lock it is possible other routine has created this entry */
+		var okrecheck bool
+		entry, okrecheck = store.smapEntry[key]
+
+		if okrecheck == false {
+			entry = newSmapData()
+		}
+	}
+
+	/* Take DB entry lock before add, this is lock per key entry */
+	entry.lock.Lock()
+
+	added := 0
+	for _, member := range members {
+		if entry.add(member) == true {
+			added++
+		}
+	}
+
+	if ok == false {
+		store.smapEntry[key] = entry
+	}
+
+	entry.lock.Unlock()
+
+	/* Both locks got released in LIFO order, entry lock followed by db lock */
+	if ok == false {
+		store.smapDBLock.Unlock()
+	} else {
+		store.smapDBLock.RUnlock()
+	}
+
+	return added, nil
+}
+
+
+/* Remove members, returns the number of members removed, the key is deleted with its last member */
+func (store *db) SREM(key string, members []string) (int, error) {
+	if store == nil {
+		fmt.Println("SREM : store is nil")
+		return 0, errors.New(fmt.Sprint("SREM : store is nil"))
+	}
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.smapDBLock.RLock()
+
+	entry, ok := store.smapEntry[key]
+
+	if ok == false {
+		store.smapDBLock.RUnlock()
+		return 0, errors.New(fmt.Sprint("SREM : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before remove, this is lock per key entry */
+	entry.lock.Lock()
+
+	removed := 0
+	for _, member := range members {
+		if entry.remove(member) == true {
+			removed++
+		}
+	}
+
+	empty := entry.length() == 0
+
+	entry.lock.Unlock()
+	store.smapDBLock.RUnlock()
+
+	if empty == true {
+		store.setDeleteIfEmpty(key, entry)
+	}
+
+	return removed, nil
+}
+
+
+func (store *db) SMEMBERS(key string) ([]string, error) {
+	if store == nil {
+		fmt.Println("SMEMBERS : store is nil")
+		return nil, errors.New(fmt.Sprint("SMEMBERS : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.smapDBLock.RLock()
+	defer store.smapDBLock.RUnlock()
+
+	entry, ok := store.smapEntry[key]
+
+	if ok == false {
+		return []string{}, errors.New(fmt.Sprint("SMEMBERS : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	return entry.members(), nil
+}
+
+
+/* Membership of every member in the set */
+func (store *db) SMISMEMBER(key string, members []string) ([]bool, error) {
+	if store == nil {
+		fmt.Println("SMISMEMBER : store is nil")
+		return nil, errors.New(fmt.Sprint("SMISMEMBER : store is nil"))
+	}
+
+	found := make([]bool, len(members))
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.smapDBLock.RLock()
+	defer store.smapDBLock.RUnlock()
+
+	entry, ok := store.smapEntry[key]
+
+	if ok == false {
+		return found, errors.New(fmt.Sprint("SMISMEMBER : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	for i, member := range members {
+		found[i] = entry.contains(member)
+	}
+
+	return found, nil
+}
+
+
+func (store *db) SCARD(key string) (int, error) {
+	if store == nil {
+		fmt.Println("SCARD : store is nil")
+		return 0, errors.New(fmt.Sprint("SCARD : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.smapDBLock.RLock()
+	defer store.smapDBLock.RUnlock()
+
+	entry, ok := store.smapEntry[key]
+
+	if ok == false {
+		return 0, errors.New(fmt.Sprint("SCARD : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	return entry.length(), nil
+}
+
+
+/* Remove and return up to count random members */
+func (store *db) SPOP(key string, count int) ([]string, error) {
+	if store == nil {
+		fmt.Println("SPOP : store is nil")
+		return nil, errors.New(fmt.Sprint("SPOP : store is nil"))
+	}
+
+	vals := []string{}
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.smapDBLock.RLock()
+
+	entry, ok := store.smapEntry[key]
+
+	if ok == false {
+		store.smapDBLock.RUnlock()
+		return vals, errors.New(fmt.Sprint("SPOP : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before remove, this is lock per key entry */
+	entry.lock.Lock()
+
+	/* Every pop is a random pick from the members left */
+	for ; count > 0 && entry.length() > 0; count-- {
+		member := entry.member(rand.Intn(entry.length()))
+		entry.remove(member)
+		vals = append(vals, member)
+	}
+
+	empty := entry.length() == 0
+
+	entry.lock.Unlock()
+	store.smapDBLock.RUnlock()
+
+	if empty == true {
+		store.setDeleteIfEmpty(key, entry)
+	}
+
+	return vals, nil
+}
+
+
+/*
+  Random members of set
+  count > 0 returns up to count distinct members, count < 0 returns -count members which may repeat
+*/
+func (store *db) SRANDMEMBER(key string, count int) ([]string, error) {
+	if store == nil {
+		fmt.Println("SRANDMEMBER : store is nil")
+		return nil, errors.New(fmt.Sprint("SRANDMEMBER : store is nil"))
+	}
+
+	vals := []string{}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.smapDBLock.RLock()
+	defer store.smapDBLock.RUnlock()
+
+	entry, ok := store.smapEntry[key]
+
+	if ok == false {
+		return vals, errors.New(fmt.Sprint("SRANDMEMBER : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	size := entry.length()
+
+	/* Emptied by other routine which has not deleted the key yet */
+	if size == 0 {
+		return vals, nil
+	}
+
+	/* Repetition allowed, every member is a random pick */
+	if count < 0 {
+		for i := 0; i < -count; i++ {
+			vals = append(vals, entry.member(rand.Intn(size)))
+		}
+		return vals, nil
+	}
+
+	if count >= size {
+		return entry.members(), nil
+	}
+
+	/* Distinct members, a count close to the size is the first count of the shuffled members */
+	if count * 2 > size {
+		members := entry.members()
+		for i := 0; i < count; i++ {
+			k := i + rand.Intn(size - i)
+			members[i], members[k] = members[k], members[i]
+		}
+		return members[:count], nil
+	}
+
+	/* Distinct members, random indexes until count of them are picked */
+	picked := make(map[int]bool, count)
+	for len(vals) < count {
+		i := rand.Intn(size)
+		if picked[i] == false {
+			picked[i] = true
+			vals = append(vals, entry.member(i))
+		}
+	}
+
+	return vals, nil
+}
+
+
+/*
+  Scan the set from cursor, returns the next cursor and up to count members
+  The cursor is the index of the next member in the encoding, 0 starts and ends the iteration.
+  Members removed during the iteration move other members and may cause members to be
+  skipped or returned twice.
+*/
+func (store *db) SSCAN(key string, cursor int, pattern string, count int) (int, []string, error) {
+	if store == nil {
+		fmt.Println("SSCAN : store is nil")
+		return 0, nil, errors.New(fmt.Sprint("SSCAN : store is nil"))
+	}
+
+	vals := []string{}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.smapDBLock.RLock()
+	defer store.smapDBLock.RUnlock()
+
+	entry, ok := store.smapEntry[key]
+
+	if ok == false {
+		return 0, vals, errors.New(fmt.Sprint("SSCAN : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	size := entry.length()
+
+	for ; cursor < size && count > 0; count-- {
+		member := entry.member(cursor)
+		if pattern == "" || stringMatch(pattern, member) {
+			vals = append(vals, member)
+		}
+		cursor++
+	}
+
+	if cursor >= size {
+		cursor = 0
+	}
+
+	return cursor, vals, nil
+}
+
+
+/* Move member from src to dst, returns true if member was moved */
+func (store *db) SMOVE(src string, dst string, member string) (bool, error) {
+	if store == nil {
+		fmt.Println("SMOVE : store is nil")
+		return false, errors.New(fmt.Sprint("SMOVE : store is nil"))
+	}
+
+	/* Take Global write lock, remove and add on two keys is a single operation */
+	store.smapDBLock.Lock()
+	defer store.smapDBLock.Unlock()
+
+	srcEntry, ok := store.smapEntry[src]
+
+	if ok == false {
+		return false, errors.New(fmt.Sprint("SMOVE : key ", src, " not found"))
+	}
+
+	if srcEntry.remove(member) == false {
+		return false, nil
+	}
+
+	dstEntry, ok := store.smapEntry[dst]
+	if ok == false {
+		dstEntry = newSmapData()
+		store.smapEntry[dst] = dstEntry
+	}
+
+	dstEntry.add(member)
+
+	if srcEntry.length() == 0 {
+		delete(store.smapEntry, src)
+	}
+
+	return true, nil
+}
+
+
+/* Union, intersection or difference of the sets at keys, intersection stops at limit members if limit > 0 */
+func (store *db) SETOP(op int, keys []string, limit int) ([]string, error) {
+	if store == nil {
+		fmt.Println("SETOP : store is nil")
+		return nil, errors.New(fmt.Sprint("SETOP : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete or replace of the source keys until operation is finished */
+	store.smapDBLock.RLock()
+	defer store.smapDBLock.RUnlock()
+
+	sets := make([]*smapData, len(keys))
+	for i, key := range keys {
+		sets[i] = store.smapEntry[key]
+	}
+
+	/* Take DB entry Rlock of every source key, in key order and once per key to be consistent with other multi key readers */
+	sorted := make([]string, 0, len(keys))
+	for key := range uniqueKeys(keys) {
+		if _, ok := store.smapEntry[key]; ok == true {
+			sorted = append(sorted, key)
+		}
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		entry := store.smapEntry[key]
+		entry.lock.RLock()
+		defer entry.lock.RUnlock()
+	}
+
+	return setOperation(op, sets, limit), nil
+}
+
+
+/* Stores the union, intersection or difference of the sets at dst, returns the number of members in dst */
+func (store *db) SETOPSTORE(op int, dst string, keys []string) (int, error) {
+	if store == nil {
+		fmt.Println("SETOPSTORE : store is nil")
+		return 0, errors.New(fmt.Sprint("SETOPSTORE : store is nil"))
+	}
+
+	/* Take Global write lock, no other routine is accessing any source key and dst can be a source key */
+	store.smapDBLock.Lock()
+	defer store.smapDBLock.Unlock()
+
+	sets := make([]*smapData, len(keys))
+	for i, key := range keys {
+		sets[i] = store.smapEntry[key]
+	}
+
+	members := setOperation(op, sets, 0)
+
+	/* dst is replaced, an empty result deletes dst */
+	if len(members) == 0 {
+		delete(store.smapEntry, dst)
+		return 0, nil
+	}
+
+	entry := newSmapData()
+	for _, member := range members {
+		entry.add(member)
+	}
+	store.smapEntry[dst] = entry
+
+	return len(members), nil
+}
+
+
+/* Delete the key if the set is still empty, other routine may have added since the check */
+func (store *db) setDeleteIfEmpty(key string, entry *smapData) {
+	store.smapDBLock.Lock()
+	defer store.smapDBLock.Unlock()
+
+	if store.smapEntry[key] == entry && entry.length() == 0 {
+		delete(store.smapEntry, key)
+	}
+}
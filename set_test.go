@@ -0,0 +1,257 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+
+/* Fail unless the members of key are want, in any order */
+func checkSet(t *testing.T, store *db, key string, want []string) {
+	t.Helper()
+
+	members, _ := store.SMEMBERS(key)
+	sort.Strings(members)
+
+	sorted := append([]string{}, want...)
+	sort.Strings(sorted)
+
+	if strings.Join(members, ",") != strings.Join(sorted, ",") {
+		t.Fatalf("members %v, want %v", members, sorted)
+	}
+}
+
+
+func TestSetIntset(t *testing.T) {
+	store := newDB()
+
+	store.SADD("s", []string{"5", "-3", "10", "5", "0"})
+	entry := store.smapEntry["s"]
+
+	if entry.is == nil {
+		t.Fatalf("integers should stay intset")
+	}
+	if sort.SliceIsSorted(entry.is, func(i, j int) bool { return entry.is[i] < entry.is[j] }) == false {
+		t.Fatalf("intset not sorted %v", entry.is)
+	}
+	checkSet(t, store, "s", []string{"-3", "0", "5", "10"})
+
+	/* Not canonical integers are not members of the intset */
+	if ok, _ := store.SMISMEMBER("s", []string{"05", "+5", "5"}); ok[0] == true || ok[1] == true || ok[2] == false {
+		t.Fatalf("SMISMEMBER %v", ok)
+	}
+
+	store.SREM("s", []string{"0", "7"})
+	checkSet(t, store, "s", []string{"-3", "5", "10"})
+}
+
+
+func TestSetConvertByMember(t *testing.T) {
+	store := newDB()
+
+	store.SADD("s", []string{"1", "2"})
+	store.SADD("s", []string{"01"})
+
+	if store.smapEntry["s"].is != nil {
+		t.Fatalf("not canonical integer should convert to map")
+	}
+	checkSet(t, store, "s", []string{"1", "2", "01"})
+
+	if n, _ := store.SADD("s", []string{"1"}); n != 0 {
+		t.Fatalf("member of converted set added again")
+	}
+}
+
+
+func TestSetConvertByEntries(t *testing.T) {
+	store := newDB()
+	want := []string{}
+
+	for i := 0; i < setMaxIntsetEntries; i++ {
+		want = append(want, strconv.Itoa(i * 7))
+	}
+	store.SADD("s", want)
+
+	if store.smapEntry["s"].is == nil {
+		t.Fatalf("%v integers should stay intset", setMaxIntsetEntries)
+	}
+
+	store.SADD("s", []string{"-1"})
+	want = append(want, "-1")
+
+	if store.smapEntry["s"].is != nil {
+		t.Fatalf("%v integers should convert to map", setMaxIntsetEntries + 1)
+	}
+	checkSet(t, store, "s", want)
+}
+
+
+func TestSetRandMemberOfEmptiedSet(t *testing.T) {
+	store := newDB()
+
+	store.SADD("s", []string{"a"})
+	entry := store.smapEntry["s"]
+
+	/* Emptied but still in the map, as between a pop and the delete of the key */
+	entry.remove("a")
+
+	if vals, err := store.SRANDMEMBER("s", -3); err != nil || len(vals) != 0 {
+		t.Fatalf("SRANDMEMBER of empty set %v %v", vals, err)
+	}
+}
+
+
+/* Both encodings are kept by Save and Load */
+func TestSetSaveLoad(t *testing.T) {
+	store := newDB()
+
+	store.SADD("sint", []string{"3", "1", "2"})
+	store.SADD("sstr", []string{"a", "b", "1"})
+
+	loaded := saveLoad(t, store)
+
+	checkSet(t, loaded, "sint", []string{"1", "2", "3"})
+	checkSet(t, loaded, "sstr", []string{"a", "b", "1"})
+	if loaded.smapEntry["sint"].is == nil || loaded.smapEntry["sstr"].is != nil {
+		t.Fatalf("set encodings not kept")
+	}
+}
+
+
+/* Fail unless dict holds the index of every member in keys */
+func checkSetIndex(t *testing.T, s *smapData) {
+	t.Helper()
+
+	if len(s.dict) != len(s.keys) {
+		t.Fatalf("%v members in dict, %v in keys", len(s.dict), len(s.keys))
+	}
+	for i, member := range s.keys {
+		if s.dict[member] != i {
+			t.Fatalf("member %q at %v, dict has %v", member, i, s.dict[member])
+		}
+	}
+}
+
+
+func TestSetMapRemove(t *testing.T) {
+	store := newDB()
+	want := []string{}
+
+	for i := 0; i < 100; i++ {
+		want = append(want, "m" + strconv.Itoa(i))
+	}
+	store.SADD("s", want)
+
+	/* First, last and middle members */
+	store.SREM("s", []string{"m0", "m99", "m50", "missing"})
+	checkSetIndex(t, store.smapEntry["s"])
+	checkSet(t, store, "s", append(append([]string{}, want[1:50]...), want[51:99]...))
+}
+
+
+/* Every member is popped once, the key is deleted with its last member */
+func TestSetPop(t *testing.T) {
+	for _, prefix := range []string{"", "m"} {
+		store := newDB()
+		want := []string{}
+
+		for i := 0; i < 1000; i++ {
+			want = append(want, prefix + strconv.Itoa(i))
+		}
+		store.SADD("s", want)
+
+		popped := []string{}
+		for _, count := range []int{1, 10, 500, 1000} {
+			vals, err := store.SPOP("s", count)
+			if err != nil {
+				t.Fatalf("SPOP %v", err)
+			}
+			popped = append(popped, vals...)
+			if entry, ok := store.smapEntry["s"]; ok == true && entry.is == nil {
+				checkSetIndex(t, entry)
+			}
+		}
+
+		sort.Strings(popped)
+		sort.Strings(want)
+		if strings.Join(popped, ",") != strings.Join(want, ",") {
+			t.Fatalf("SPOP returned %v members, want %v", len(popped), len(want))
+		}
+		if _, ok := store.smapEntry["s"]; ok == true {
+			t.Fatalf("set without members not deleted")
+		}
+	}
+}
+
+
+func TestSetRandMember(t *testing.T) {
+	store := newDB()
+	want := []string{}
+
+	for i := 0; i < 100; i++ {
+		want = append(want, "m" + strconv.Itoa(i))
+	}
+	store.SADD("s", want)
+
+	/* Few members by random indexes, many by shuffle, all of them */
+	for _, count := range []int{3, 70, 100, 150} {
+		vals, _ := store.SRANDMEMBER("s", count)
+
+		seen := make(map[string]bool)
+		for _, member := range vals {
+			if seen[member] == true || store.smapEntry["s"].contains(member) == false {
+				t.Fatalf("SRANDMEMBER %v returned %q twice or not a member", count, member)
+			}
+			seen[member] = true
+		}
+		if (count <= 100 && len(vals) != count) || (count > 100 && len(vals) != 100) {
+			t.Fatalf("SRANDMEMBER %v returned %v members", count, len(vals))
+		}
+	}
+
+	if vals, _ := store.SRANDMEMBER("s", -300); len(vals) != 300 {
+		t.Fatalf("SRANDMEMBER -300 returned %v members", len(vals))
+	}
+}
+
+
+/* A full scan returns every member once, for both encodings */
+func TestSetScan(t *testing.T) {
+	for _, prefix := range []string{"", "m"} {
+		store := newDB()
+		want := []string{}
+
+		for i := 0; i < 300; i++ {
+			want = append(want, prefix + strconv.Itoa(i))
+		}
+		store.SADD("s", want)
+
+		got := []string{}
+		cursor := 0
+		for {
+			var vals []string
+			cursor, vals, _ = store.SSCAN("s", cursor, "", 7)
+			got = append(got, vals...)
+			if cursor == 0 {
+				break
+			}
+		}
+
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("SSCAN returned %v members, want %v", len(got), len(want))
+		}
+
+		if _, vals, _ := store.SSCAN("s", 0, prefix + "1?", 1000); len(vals) != 10 {
+			t.Fatalf("SSCAN MATCH %v1? returned %v", prefix, vals)
+		}
+	}
+}
@@ -0,0 +1,780 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+
+const (
+	// Max number of entries of a stream node
+	streamNodeMaxEntries = 100
+
+	// Max bytes of a stream node, a single bigger entry still gets its own node
+	streamNodeMaxBytes = 4096
+)
+
+const (
+	streamTrimNone = iota
+	streamTrimMaxLen
+	streamTrimMinID
+)
+
+
+/*
+  Stream storage, same idea as the redis stream
+
+  The entries are appended in ID order to a list of nodes, every node packs up
+  to streamNodeMaxEntries entries in a listpack (see list.go). An entry is
+  stored as flag, ms delta to the node master ID, seq, number of fields and the
+  field value pairs. The nodes are kept in a slice sorted by ID, lookup of an
+  ID is a binary search on the last ID of the nodes followed by a walk of a
+  single node. entries is the number of entries in a node, count the number
+  of entries not deleted.
+
+  XDEL only flags the entry as deleted, a node is freed once all its entries
+  are deleted or trimmed. Trimming with ~ only frees whole nodes.
+*/
+
+type streamID struct {
+	ms uint64
+	seq uint64
+}
+
+type streamEntry struct {
+	id streamID
+	fields []string
+}
+
+type streamNode struct {
+	master streamID
+	last streamID
+	lp listpack
+	entries int
+	count int
+}
+
+type stream struct {
+	nodes []*streamNode
+	length int
+	lastID streamID
+	groups map[string]*streamGroup
+}
+
+type streammapData struct {
+	st *stream
+	lock *sync.RWMutex
+}
+
+type streamTrim struct {
+	strategy int
+	approx bool
+	maxlen int
+	minid streamID
+	limit int
+}
+
+
+func (a streamID) less(b streamID) bool {
+	return a.ms < b.ms || (a.ms == b.ms && a.seq < b.seq)
+}
+
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+
+/* Next ID after id, false on overflow */
+func (id streamID) incr() (streamID, bool) {
+	if id.seq < math.MaxUint64 {
+		return streamID{id.ms, id.seq + 1}, true
+	}
+	if id.ms < math.MaxUint64 {
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+
+/* Previous ID before id, false on underflow */
+func (id streamID) decr() (streamID, bool) {
+	if id.seq > 0 {
+		return streamID{id.ms, id.seq - 1}, true
+	}
+	if id.ms > 0 {
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+
+/* Parse ms-seq, seq defaults to missingSeq when only ms is given */
+func parseStreamID(s string, missingSeq uint64) (streamID, error) {
+	var id streamID
+	var err error
+
+	parts := strings.SplitN(s, "-", 2)
+
+	id.ms, err = strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return id, errors.New("Invalid stream ID specified as stream command argument")
+	}
+
+	id.seq = missingSeq
+	if len(parts) == 2 {
+		id.seq, err = strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return id, errors.New("Invalid stream ID specified as stream command argument")
+		}
+	}
+
+	return id, nil
+}
+
+
+/* Parse start or end of XRANGE, - and + are the lowest and highest IDs, ( prefix is exclusive */
+func parseStreamRangeID(s string, end bool) (streamID, error) {
+	if s == "-" {
+		return streamID{}, nil
+	}
+	if s == "+" {
+		return streamID{math.MaxUint64, math.MaxUint64}, nil
+	}
+
+	var missingSeq uint64
+	if end == true {
+		missingSeq = math.MaxUint64
+	}
+
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive == true {
+		s = s[1:]
+	}
+
+	id, err := parseStreamID(s, missingSeq)
+	if err != nil || exclusive == false {
+		return id, err
+	}
+
+	var ok bool
+	if end == true {
+		id, ok = id.decr()
+	} else {
+		id, ok = id.incr()
+	}
+
+	if ok == false {
+		return id, errors.New("invalid end ID for the interval")
+	}
+
+	return id, nil
+}
+
+
+/* Parse MAXLEN|MINID [=|~] threshold [LIMIT count] at args[i], returns the index after the option */
+func parseStreamTrimArgs(args []string, i int, trim *streamTrim) (int, error) {
+	switch strings.ToUpper(args[i]) {
+	case "MAXLEN":
+		trim.strategy = streamTrimMaxLen
+	case "MINID":
+		trim.strategy = streamTrimMinID
+	default:
+		return i, errors.New("syntax error")
+	}
+	i++
+
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		trim.approx = args[i] == "~"
+		i++
+	}
+
+	if i >= len(args) {
+		return i, errors.New("syntax error")
+	}
+
+	var err error
+	if trim.strategy == streamTrimMaxLen {
+		trim.maxlen, err = strconv.Atoi(args[i])
+		if err != nil || trim.maxlen < 0 {
+			return i, errors.New("The MAXLEN argument must be >= 0")
+		}
+	} else {
+		trim.minid, err = parseStreamID(args[i], 0)
+		if err != nil {
+			return i, err
+		}
+	}
+	i++
+
+	if i + 1 < len(args) && strings.ToUpper(args[i]) == "LIMIT" {
+		if trim.approx == false {
+			return i, errors.New("syntax error, LIMIT cannot be used without the special ~ option")
+		}
+
+		trim.limit, err = strconv.Atoi(args[i+1])
+		if err != nil || trim.limit < 0 {
+			return i, errors.New("The LIMIT argument must be >= 0")
+		}
+		i = i + 2
+	}
+
+	return i, nil
+}
+
+
+func newStream() *stream {
+	return &stream{}
+}
+
+
+/* Reads the entry at byte offset off of the node, returns the entry, the deleted flag and the offset of the next entry */
+func (node *streamNode) read(off int) (streamEntry, bool, int) {
+	var entry streamEntry
+	var flag, msDelta, seq, num string
+
+	flag, off = node.lp.entry(off)
+	msDelta, off = node.lp.entry(off)
+	seq, off = node.lp.entry(off)
+	num, off = node.lp.entry(off)
+
+	delta, _ := strconv.ParseUint(msDelta, 10, 64)
+	entry.id.ms = node.master.ms + delta
+	entry.id.seq, _ = strconv.ParseUint(seq, 10, 64)
+
+	n, _ := strconv.Atoi(num)
+	entry.fields = make([]string, n)
+	for k := 0; k < n; k++ {
+		entry.fields[k], off = node.lp.entry(off)
+	}
+
+	return entry, flag == "1", off
+}
+
+
+/* Live entries of the node within start and end */
+func (node *streamNode) rangeEntries(start streamID, end streamID) []streamEntry {
+	entries := []streamEntry{}
+
+	off := 0
+	for off < len(node.lp.data) {
+		entry, deleted, next := node.read(off)
+		off = next
+
+		if deleted == true || entry.id.less(start) {
+			continue
+		}
+		if end.less(entry.id) {
+			break
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+
+/* Flag the live entries of the node passing match as deleted, returns the number of entries deleted */
+func (node *streamNode) markDeleted(match func(id streamID) bool, limit int) int {
+	deleted := 0
+
+	off := 0
+	for off < len(node.lp.data) && (limit < 0 || deleted < limit) {
+		entry, isDeleted, next := node.read(off)
+
+		/* The flag is a single byte entry, length 1 followed by the flag byte */
+		if isDeleted == false && match(entry.id) == true {
+			node.lp.data[off+1] = '1'
+			node.count--
+			deleted++
+		}
+		off = next
+	}
+
+	return deleted
+}
+
+
+/* Returns the next ID to add, ms-seq from now unless last ID is in the future */
+func (st *stream) nextID() (streamID, bool) {
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+
+	if ms > st.lastID.ms {
+		return streamID{ms, 0}, true
+	}
+	return st.lastID.incr()
+}
+
+
+/* Append entry with id, id is greater than the last ID */
+func (st *stream) append(id streamID, fields []string) {
+	size := 0
+	for _, f := range fields {
+		size += len(f)
+	}
+
+	var node *streamNode
+	if len(st.nodes) > 0 {
+		node = st.nodes[len(st.nodes)-1]
+		if node.entries >= streamNodeMaxEntries || len(node.lp.data) + size > streamNodeMaxBytes {
+			node = nil
+		}
+	}
+
+	if node == nil {
+		node = &streamNode{master: id}
+		st.nodes = append(st.nodes, node)
+	}
+
+	node.lp.insert(node.lp.count, "0")
+	node.lp.insert(node.lp.count, strconv.FormatUint(id.ms - node.master.ms, 10))
+	node.lp.insert(node.lp.count, strconv.FormatUint(id.seq, 10))
+	node.lp.insert(node.lp.count, strconv.Itoa(len(fields)))
+	for _, f := range fields {
+		node.lp.insert(node.lp.count, f)
+	}
+
+	node.last = id
+	node.entries++
+	node.count++
+	st.length++
+	st.lastID = id
+}
+
+
+/* Index of the first node holding IDs >= id */
+func (st *stream) findNode(id streamID) int {
+	return sort.Search(len(st.nodes), func(i int) bool { return st.nodes[i].last.less(id) == false })
+}
+
+
+/* Entries within start and end, in reverse order with rev, up to count entries if count > 0 */
+func (st *stream) rangeEntries(start streamID, end streamID, count int, rev bool) []streamEntry {
+	entries := []streamEntry{}
+
+	if end.less(start) {
+		return entries
+	}
+
+	if rev == false {
+		for i := st.findNode(start); i < len(st.nodes) && end.less(st.nodes[i].master) == false; i++ {
+			for _, entry := range st.nodes[i].rangeEntries(start, end) {
+				entries = append(entries, entry)
+				if count > 0 && len(entries) >= count {
+					return entries
+				}
+			}
+		}
+		return entries
+	}
+
+	last := st.findNode(end)
+	if last == len(st.nodes) {
+		last--
+	}
+
+	for i := last; i >= 0 && st.nodes[i].last.less(start) == false; i-- {
+		nodeEntries := st.nodes[i].rangeEntries(start, end)
+		for k := len(nodeEntries) - 1; k >= 0; k-- {
+			entries = append(entries, nodeEntries[k])
+			if count > 0 && len(entries) >= count {
+				return entries
+			}
+		}
+	}
+
+	return entries
+}
+
+
+/* Delete entry id, returns true if it was present */
+func (st *stream) delete(id streamID) bool {
+	i := st.findNode(id)
+	if i == len(st.nodes) {
+		return false
+	}
+
+	node := st.nodes[i]
+	if node.markDeleted(func(e streamID) bool { return e == id }, 1) == 0 {
+		return false
+	}
+
+	st.length--
+	if node.count == 0 {
+		st.nodes = append(st.nodes[:i], st.nodes[i+1:]...)
+	}
+
+	return true
+}
+
+
+/* Trim by MAXLEN or MINID from the head, returns the number of entries removed */
+func (st *stream) trim(trim *streamTrim) int {
+	removed := 0
+
+	/* Default limit of approximate trimming, exact trimming has no limit */
+	limit := -1
+	if trim.approx == true {
+		limit = 100 * streamNodeMaxEntries
+		if trim.limit > 0 {
+			limit = trim.limit
+		}
+	}
+
+	for len(st.nodes) > 0 {
+		node := st.nodes[0]
+
+		if trim.strategy == streamTrimMaxLen && st.length <= trim.maxlen {
+			break
+		}
+		if trim.strategy == streamTrimMinID && node.master.less(trim.minid) == false {
+			break
+		}
+
+		/* Whole node goes away */
+		wholeNode := (trim.strategy == streamTrimMaxLen && st.length - node.count >= trim.maxlen) ||
+			(trim.strategy == streamTrimMinID && node.last.less(trim.minid))
+
+		if wholeNode == true {
+			if limit >= 0 && removed + node.count > limit {
+				break
+			}
+
+			st.nodes = st.nodes[1:]
+			st.length -= node.count
+			removed += node.count
+			continue
+		}
+
+		/* Approximate trimming never splits a node */
+		if trim.approx == true {
+			break
+		}
+
+		var deleted int
+		if trim.strategy == streamTrimMaxLen {
+			deleted = node.markDeleted(func(e streamID) bool { return true }, st.length - trim.maxlen)
+		} else {
+			deleted = node.markDeleted(func(e streamID) bool { return e.less(trim.minid) }, -1)
+		}
+
+		st.length -= deleted
+		removed += deleted
+
+		if node.count == 0 {
+			st.nodes = st.nodes[1:]
+		}
+		break
+	}
+
+	return removed
+}
+
+
+/* Add entry with id, * generates the ID, ms-* generates the seq. Returns the ID added */
+func (store *db) XADD(key string, idArg string, fields []string, trim *streamTrim, noMkStream bool) (string, error) {
+	if store == nil {
+		fmt.Println("XADD : store is nil")
+		return "", errors.New(fmt.Sprint("XADD : store is nil"))
+	}
+
+	var entry *streammapData
+	var ok bool
+
+	store.streammapDBLock.RLock()
+
+	/* Check if db has the key entry */
+	entry, ok = store.streammapEntry[key]
+
+	/* If entry not present */
+	if ok == false {
+		if noMkStream == true {
+			store.streammapDBLock.RUnlock()
+			return "", errors.New(fmt.Sprint("XADD : key ", key, " not found"))
+		}
+
+		/* Take DB lock before creating entry for this key, to ensure only one entry gets created */
+		store.streammapDBLock.RUnlock()
+		store.streammapDBLock.Lock()
+
+		/* Again Check if db has the key entry, between above if & db lock it is possible other routine has created this entry */
+		var okrecheck bool
+		entry, okrecheck = store.streammapEntry[key]
+
+		if okrecheck == false {
+			entry = &streammapData{
+				st: newStream(),
+				lock: &sync.RWMutex{},
+			}
+		}
+	}
+
+	/* Take DB entry lock before add, this is lock per key entry */
+	entry.lock.Lock()
+
+	id, err := entry.st.parseAddID(idArg)
+
+	if err == nil {
+		entry.st.append(id, fields)
+
+		if trim.strategy != streamTrimNone {
+			entry.st.trim(trim)
+		}
+
+		if ok == false {
+			store.streammapEntry[key] = entry
+		}
+	}
+
+	entry.lock.Unlock()
+
+	/* Both locks got released in LIFO order, entry lock followed by db lock */
+	if ok == false {
+		store.streammapDBLock.Unlock()
+	} else {
+		store.streammapDBLock.RUnlock()
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	/* Serve clients blocked on the key, with data locks released */
+	store.signalKeyReady(blockStream, key)
+
+	return id.String(), nil
+}
+
+
+/* ID of XADD, greater than the last ID of the stream */
+func (st *stream) parseAddID(idArg string) (streamID, error) {
+	if idArg == "*" {
+		id, ok := st.nextID()
+		if ok == false {
+			return id, errors.New("The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	}
+
+	var id streamID
+	var err error
+
+	if strings.HasSuffix(idArg, "-*") {
+		id.ms, err = strconv.ParseUint(strings.TrimSuffix(idArg, "-*"), 10, 64)
+		if err != nil {
+			return id, errors.New("Invalid stream ID specified as stream command argument")
+		}
+
+		/* Same ms as the last ID takes the next seq, 0-* gives 0-1 */
+		if id.ms == st.lastID.ms {
+			if st.lastID.seq == math.MaxUint64 {
+				return id, errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+			}
+			id.seq = st.lastID.seq + 1
+		}
+	} else {
+		id, err = parseStreamID(idArg, 0)
+		if err != nil {
+			return id, err
+		}
+	}
+
+	if (id.less(st.lastID) || id == st.lastID) && st.lastID != (streamID{}) {
+		return id, errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+
+	if id == (streamID{}) {
+		return id, errors.New("The ID specified in XADD must be greater than 0-0")
+	}
+
+	return id, nil
+}
+
+
+/* Entries within start and end, end to start with rev, up to count entries if count > 0 */
+func (store *db) XRANGE(key string, start streamID, end streamID, count int, rev bool) ([]streamEntry, error) {
+	if store == nil {
+		fmt.Println("XRANGE : store is nil")
+		return nil, errors.New(fmt.Sprint("XRANGE : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.streammapDBLock.RLock()
+	defer store.streammapDBLock.RUnlock()
+
+	entry, ok := store.streammapEntry[key]
+
+	if ok == false {
+		return []streamEntry{}, errors.New(fmt.Sprint("XRANGE : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	return entry.st.rangeEntries(start, end, count, rev), nil
+}
+
+
+func (store *db) XLEN(key string) (int, error) {
+	if store == nil {
+		fmt.Println("XLEN : store is nil")
+		return 0, errors.New(fmt.Sprint("XLEN : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.streammapDBLock.RLock()
+	defer store.streammapDBLock.RUnlock()
+
+	entry, ok := store.streammapEntry[key]
+
+	if ok == false {
+		return 0, errors.New(fmt.Sprint("XLEN : key ", key, " not found"))
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	return entry.st.length, nil
+}
+
+
+/* Trim the stream, returns the number of entries removed. An empty stream is kept */
+func (store *db) XTRIM(key string, trim *streamTrim) (int, error) {
+	if store == nil {
+		fmt.Println("XTRIM : store is nil")
+		return 0, errors.New(fmt.Sprint("XTRIM : store is nil"))
+	}
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.streammapDBLock.RLock()
+	defer store.streammapDBLock.RUnlock()
+
+	entry, ok := store.streammapEntry[key]
+
+	if ok == false {
+		return 0, errors.New(fmt.Sprint("XTRIM : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before trim, this is lock per key entry */
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	return entry.st.trim(trim), nil
+}
+
+
+/* Delete entries, returns the number of entries deleted. An empty stream is kept */
+func (store *db) XDEL(key string, ids []streamID) (int, error) {
+	if store == nil {
+		fmt.Println("XDEL : store is nil")
+		return 0, errors.New(fmt.Sprint("XDEL : store is nil"))
+	}
+
+	/* Take Global Read lock to hold Save DB until operation is finished */
+	store.streammapDBLock.RLock()
+	defer store.streammapDBLock.RUnlock()
+
+	entry, ok := store.streammapEntry[key]
+
+	if ok == false {
+		return 0, errors.New(fmt.Sprint("XDEL : key ", key, " not found"))
+	}
+
+	/* Take DB entry lock before delete, this is lock per key entry */
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	deleted := 0
+	for _, id := range ids {
+		if entry.st.delete(id) == true {
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+
+/* Last ID of the stream at key, 0-0 if no such key */
+func (store *db) streamLastID(key string) streamID {
+	store.streammapDBLock.RLock()
+	defer store.streammapDBLock.RUnlock()
+
+	entry, ok := store.streammapEntry[key]
+
+	if ok == false {
+		return streamID{}
+	}
+
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	return entry.st.lastID
+}
+
+
+/* Entries with ID greater than ids[i] of every key, up to count entries per key if count > 0 */
+func (store *db) XREAD(keys []string, ids []streamID, count int) ([][]streamEntry, error) {
+	if store == nil {
+		fmt.Println("XREAD : store is nil")
+		return nil, errors.New(fmt.Sprint("XREAD : store is nil"))
+	}
+
+	result := make([][]streamEntry, len(keys))
+
+	/* Take Global Read lock to hold delete of the keys until operation is finished */
+	store.streammapDBLock.RLock()
+	defer store.streammapDBLock.RUnlock()
+
+	for i, key := range keys {
+		entry, ok := store.streammapEntry[key]
+
+		if ok == false {
+			continue
+		}
+
+		start, ok := ids[i].incr()
+		if ok == false {
+			continue
+		}
+
+		/* Take DB entry Rlock of one key at a time, this is lock per key entry */
+		entry.lock.RLock()
+		result[i] = entry.st.rangeEntries(start, streamID{math.MaxUint64, math.MaxUint64}, count, false)
+		entry.lock.RUnlock()
+	}
+
+	return result, nil
+}
+
+
+/* Reply lines of stream entries, ID followed by the field value pairs */
+func streamReply(entries []streamEntry) []string {
+	vals := []string{}
+
+	for _, entry := range entries {
+		vals = append(vals, entry.id.String())
+
+		/* Pending entry deleted from the stream */
+		if entry.fields == nil {
+			vals = append(vals, "(nil)")
+			continue
+		}
+		vals = append(vals, entry.fields...)
+	}
+
+	return vals
+}
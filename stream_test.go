@@ -0,0 +1,398 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+
+/* IDs of entries joined with , */
+func streamIDs(entries []streamEntry) string {
+	ids := []string{}
+	for _, entry := range entries {
+		ids = append(ids, entry.id.String())
+	}
+	return strings.Join(ids, ",")
+}
+
+
+/* XADD of n entries to key with IDs 1-1 to n-1 */
+func xaddN(t *testing.T, store *db, key string, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		_, err := store.XADD(key, strconv.Itoa(i) + "-1", []string{"f", strconv.Itoa(i)}, &streamTrim{}, false)
+		must(t, err)
+	}
+}
+
+
+/* Entries of key from - to + */
+func xrangeAll(t *testing.T, store *db, key string) []streamEntry {
+	t.Helper()
+	entries, err := store.XRANGE(key, streamID{}, streamID{math.MaxUint64, math.MaxUint64}, 0, false)
+	must(t, err)
+	return entries
+}
+
+
+/* Block on key as XREAD BLOCK does, reading entries after id */
+func blockXREAD(t *testing.T, store *db, key string, id streamID) *blockedClient {
+	t.Helper()
+
+	serve := func() ([]string, bool) {
+		result, err := store.XREAD([]string{key}, []streamID{id}, 0)
+		if err != nil || len(result[0]) == 0 {
+			return nil, false
+		}
+		return streamReply(result[0]), true
+	}
+
+	w, _, ok := store.blockOn(blockStream, []string{key}, serve)
+	if ok == true {
+		t.Fatalf("XREAD from %v served without new entries", id)
+	}
+	return w
+}
+
+
+/* A reader waiting past the new entry does not hold up the readers after it */
+func TestXREADBlockedReaders(t *testing.T) {
+	store := newDB()
+	store.XADD("s", "1-1", []string{"f", "v"}, &streamTrim{}, false)
+
+	past := blockXREAD(t, store, "s", streamID{5, 0})
+	first := blockXREAD(t, store, "s", streamID{1, 1})
+	second := blockXREAD(t, store, "s", streamID{1, 1})
+
+	store.XADD("s", "2-1", []string{"f", "v"}, &streamTrim{}, false)
+
+	for _, w := range []*blockedClient{first, second} {
+		select {
+		case reply := <-w.reply:
+			if len(reply) == 0 || reply[0] != "2-1" {
+				t.Fatalf("XREAD reply %v", reply)
+			}
+		default:
+			t.Fatalf("XREAD waiter not served by XADD")
+		}
+	}
+
+	if reply, ok := store.unblock(past); ok == true {
+		t.Fatalf("XREAD past the last entry served %v", reply)
+	}
+	if len(store.blockedEntry) != 0 {
+		t.Fatalf("served waiters still queued")
+	}
+}
+
+
+func TestXaddIDs(t *testing.T) {
+	store := newDB()
+
+	tests := []struct {
+		id string
+		want string
+		err bool
+	}{
+		{"0-0", "", true},
+		{"0-*", "0-1", false},
+		{"0-*", "0-2", false},
+		{"5", "5-0", false},
+		{"5-*", "5-1", false},
+		{"5-1", "", true},
+		{"4-9", "", true},
+		{"x-1", "", true},
+		{"5-x", "", true},
+		{"7-3", "7-3", false},
+		{"7-*", "7-4", false},
+		{"8-*", "8-0", false},
+	}
+
+	for _, test := range tests {
+		id, err := store.XADD("s", test.id, []string{"f", "v"}, &streamTrim{}, false)
+		if test.err == true {
+			if err == nil {
+				t.Fatalf("XADD %s gave %s, want error", test.id, id)
+			}
+			continue
+		}
+		must(t, err)
+		if id != test.want {
+			t.Fatalf("XADD %s gave %s, want %s", test.id, id, test.want)
+		}
+	}
+
+	/* * is after the last ID */
+	id, err := store.XADD("s", "*", []string{"f", "v"}, &streamTrim{}, false)
+	must(t, err)
+	added, _ := parseStreamID(id, 0)
+	if added.less(streamID{8, 1}) == true {
+		t.Fatalf("XADD * gave %s after 8-0", id)
+	}
+
+	/* A full stream takes no more IDs */
+	store.XADD("max", strconv.FormatUint(math.MaxUint64, 10) + "-" + strconv.FormatUint(math.MaxUint64, 10), []string{"f", "v"}, &streamTrim{}, false)
+	if _, err := store.XADD("max", "*", []string{"f", "v"}, &streamTrim{}, false); err == nil {
+		t.Fatalf("XADD * after the last possible ID")
+	}
+
+	/* NOMKSTREAM does not create the key */
+	if _, err := store.XADD("none", "*", []string{"f", "v"}, &streamTrim{}, true); err == nil {
+		t.Fatalf("XADD NOMKSTREAM created the key")
+	}
+	if _, ok := store.streammapEntry["none"]; ok == true {
+		t.Fatalf("XADD NOMKSTREAM created the key")
+	}
+
+	/* A failed XADD on a new key does not create it */
+	store.XADD("bad", "0-0", []string{"f", "v"}, &streamTrim{}, false)
+	if _, ok := store.streammapEntry["bad"]; ok == true {
+		t.Fatalf("failed XADD created the key")
+	}
+}
+
+
+func TestXrange(t *testing.T) {
+	store := newDB()
+
+	/* Enough entries for more than one node */
+	xaddN(t, store, "s", 3 * streamNodeMaxEntries)
+
+	length, err := store.XLEN("s")
+	must(t, err)
+	if length != 3 * streamNodeMaxEntries {
+		t.Fatalf("XLEN %d", length)
+	}
+
+	entries := xrangeAll(t, store, "s")
+	if len(entries) != length || entries[0].id != (streamID{1, 1}) || entries[length-1].id != (streamID{uint64(length), 1}) {
+		t.Fatalf("XRANGE - + gave %d entries", len(entries))
+	}
+	if strings.Join(entries[41].fields, " ") != "f 42" {
+		t.Fatalf("XRANGE fields %v", entries[41].fields)
+	}
+
+	tests := []struct {
+		start string
+		end string
+		count int
+		rev bool
+		want string
+	}{
+		{"99", "102", 0, false, "99-1,100-1,101-1,102-1"},
+		{"(99-1", "(102-1", 0, false, "100-1,101-1"},
+		{"99-2", "102-0", 0, false, "100-1,101-1"},
+		{"-", "+", 3, false, "1-1,2-1,3-1"},
+		{"-", "+", 3, true, "300-1,299-1,298-1"},
+		{"99", "102", 2, true, "102-1,101-1"},
+		{"(200-1", "+", 1, false, "201-1"},
+		{"(200", "+", 1, false, "200-1"},
+		{"102", "99", 0, false, ""},
+		{"400", "+", 0, false, ""},
+	}
+
+	for _, test := range tests {
+		start, err := parseStreamRangeID(test.start, false)
+		must(t, err)
+		end, err := parseStreamRangeID(test.end, true)
+		must(t, err)
+
+		got, err := store.XRANGE("s", start, end, test.count, test.rev)
+		must(t, err)
+		if streamIDs(got) != test.want {
+			t.Fatalf("XRANGE %s %s COUNT %d rev %v gave %s, want %s", test.start, test.end, test.count, test.rev, streamIDs(got), test.want)
+		}
+	}
+
+	/* Exclusive bound past the last possible ID */
+	if _, err := parseStreamRangeID("(" + strconv.FormatUint(math.MaxUint64, 10) + "-" + strconv.FormatUint(math.MaxUint64, 10), false); err == nil {
+		t.Fatalf("exclusive start past the last ID")
+	}
+	if _, err := parseStreamRangeID("(0-0", true); err == nil {
+		t.Fatalf("exclusive end before 0-0")
+	}
+
+	if _, err := store.XRANGE("none", streamID{}, streamID{1, 1}, 0, false); err == nil {
+		t.Fatalf("XRANGE of missing key")
+	}
+}
+
+
+func TestXtrim(t *testing.T) {
+	tests := []struct {
+		args string
+		removed int
+		first string
+		err bool
+	}{
+		{"MAXLEN 250", 50, "51-1", false},
+		{"MAXLEN = 0", 300, "", false},
+		{"MAXLEN 300", 0, "1-1", false},
+		{"MINID 42", 41, "42-1", false},
+		{"MINID 42-2", 42, "43-1", false},
+		{"MINID = 1000", 300, "", false},
+		/* ~ frees whole nodes only */
+		{"MAXLEN ~ 250", 0, "1-1", false},
+		{"MAXLEN ~ 150", 100, "101-1", false},
+		{"MINID ~ 150", 100, "101-1", false},
+		{"MAXLEN ~ 0 LIMIT 100", 100, "101-1", false},
+		{"MAXLEN ~ 0 LIMIT 50", 0, "1-1", false},
+		{"MAXLEN -1", 0, "", true},
+		{"MAXLEN 1 LIMIT 10", 0, "", true},
+		{"MAXLEN ~ 1 LIMIT -1", 0, "", true},
+		{"MINID x", 0, "", true},
+		{"MAXLEN", 0, "", true},
+		{"LEN 1", 0, "", true},
+	}
+
+	for _, test := range tests {
+		store := newDB()
+		xaddN(t, store, "s", 3 * streamNodeMaxEntries)
+
+		args := strings.Fields(test.args)
+		trim := &streamTrim{}
+		i, err := parseStreamTrimArgs(args, 0, trim)
+		if test.err == true {
+			if err == nil && i == len(args) {
+				t.Fatalf("XTRIM %s parsed", test.args)
+			}
+			continue
+		}
+		must(t, err)
+		if i != len(args) {
+			t.Fatalf("XTRIM %s parsed up to %d", test.args, i)
+		}
+
+		removed, err := store.XTRIM("s", trim)
+		must(t, err)
+		if removed != test.removed {
+			t.Fatalf("XTRIM %s removed %d, want %d", test.args, removed, test.removed)
+		}
+
+		entries := xrangeAll(t, store, "s")
+		length, _ := store.XLEN("s")
+		if len(entries) != length || length != 3 * streamNodeMaxEntries - removed {
+			t.Fatalf("XTRIM %s left XLEN %d and %d entries", test.args, length, len(entries))
+		}
+		if len(entries) > 0 && entries[0].id.String() != test.first || len(entries) == 0 && test.first != "" {
+			t.Fatalf("XTRIM %s left first entry %s, want %s", test.args, streamIDs(entries[:1]), test.first)
+		}
+	}
+
+	/* XADD trims after adding, an emptied stream is kept */
+	store := newDB()
+	xaddN(t, store, "s", 10)
+	_, err := store.XADD("s", "11-1", []string{"f", "v"}, &streamTrim{strategy: streamTrimMaxLen, maxlen: 3}, false)
+	must(t, err)
+	if ids := streamIDs(xrangeAll(t, store, "s")); ids != "9-1,10-1,11-1" {
+		t.Fatalf("XADD MAXLEN 3 left %s", ids)
+	}
+	store.XTRIM("s", &streamTrim{strategy: streamTrimMaxLen})
+	if length, err := store.XLEN("s"); err != nil || length != 0 {
+		t.Fatalf("XTRIM MAXLEN 0 left XLEN %d, %v", length, err)
+	}
+}
+
+
+func TestXdel(t *testing.T) {
+	store := newDB()
+	xaddN(t, store, "s", 2 * streamNodeMaxEntries)
+
+	deleted, err := store.XDEL("s", []streamID{{1, 1}, {1, 1}, {3, 1}, {3, 2}, {150, 1}})
+	must(t, err)
+	if deleted != 3 {
+		t.Fatalf("XDEL deleted %d, want 3", deleted)
+	}
+
+	entries := xrangeAll(t, store, "s")
+	if len(entries) != 2 * streamNodeMaxEntries - 3 || entries[0].id != (streamID{2, 1}) || entries[1].id != (streamID{4, 1}) {
+		t.Fatalf("XDEL left %d entries starting %s", len(entries), streamIDs(entries[:2]))
+	}
+
+	/* Deleting every entry of a node frees it */
+	ids := []streamID{}
+	for i := 1; i <= streamNodeMaxEntries; i++ {
+		ids = append(ids, streamID{uint64(i), 1})
+	}
+	store.XDEL("s", ids)
+	if len(store.streammapEntry["s"].st.nodes) != 1 {
+		t.Fatalf("XDEL of a whole node left %d nodes", len(store.streammapEntry["s"].st.nodes))
+	}
+
+	/* The last ID stays after deleting the last entry */
+	store.XDEL("s", []streamID{{2 * streamNodeMaxEntries, 1}})
+	if _, err := store.XADD("s", strconv.Itoa(2 * streamNodeMaxEntries) + "-1", []string{"f", "v"}, &streamTrim{}, false); err == nil {
+		t.Fatalf("XADD reused a deleted last ID")
+	}
+	if store.streamLastID("s") != (streamID{2 * streamNodeMaxEntries, 1}) {
+		t.Fatalf("last ID %v", store.streamLastID("s"))
+	}
+}
+
+
+func TestXread(t *testing.T) {
+	store := newDB()
+	xaddN(t, store, "a", 5)
+	xaddN(t, store, "b", 2)
+
+	result, err := store.XREAD([]string{"a", "none", "b"}, []streamID{{2, 1}, {}, {2, 1}}, 0)
+	must(t, err)
+	if streamIDs(result[0]) != "3-1,4-1,5-1" || len(result[1]) != 0 || len(result[2]) != 0 {
+		t.Fatalf("XREAD gave %v", result)
+	}
+
+	result, err = store.XREAD([]string{"a"}, []streamID{{0, 0}}, 2)
+	must(t, err)
+	if streamIDs(result[0]) != "1-1,2-1" {
+		t.Fatalf("XREAD COUNT 2 gave %s", streamIDs(result[0]))
+	}
+
+	/* Nothing after the last possible ID */
+	result, err = store.XREAD([]string{"a"}, []streamID{{math.MaxUint64, math.MaxUint64}}, 0)
+	must(t, err)
+	if len(result[0]) != 0 {
+		t.Fatalf("XREAD after the last ID gave %s", streamIDs(result[0]))
+	}
+
+	reply := streamReply(result[0])
+	if len(reply) != 0 {
+		t.Fatalf("streamReply of nothing %v", reply)
+	}
+}
+
+
+func TestStreamSaveLoad(t *testing.T) {
+	store := newDB()
+	xaddN(t, store, "s", 2 * streamNodeMaxEntries + 5)
+	store.XDEL("s", []streamID{{3, 1}, {2 * streamNodeMaxEntries + 5, 1}})
+	store.XADD("empty", "7-7", []string{"f", "v"}, &streamTrim{}, false)
+	store.XTRIM("empty", &streamTrim{strategy: streamTrimMaxLen})
+
+	loaded := saveLoad(t, store)
+
+	want := xrangeAll(t, store, "s")
+	got := xrangeAll(t, loaded, "s")
+	if streamIDs(got) != streamIDs(want) {
+		t.Fatalf("loaded stream %s, want %s", streamIDs(got), streamIDs(want))
+	}
+	for i := range want {
+		if strings.Join(got[i].fields, " ") != strings.Join(want[i].fields, " ") {
+			t.Fatalf("loaded entry %v fields %v, want %v", got[i].id, got[i].fields, want[i].fields)
+		}
+	}
+
+	/* The last ID survives even when its entry is gone */
+	if loaded.streamLastID("s") != (streamID{2 * streamNodeMaxEntries + 5, 1}) || loaded.streamLastID("empty") != (streamID{7, 7}) {
+		t.Fatalf("loaded last IDs %v %v", loaded.streamLastID("s"), loaded.streamLastID("empty"))
+	}
+	if length, err := loaded.XLEN("empty"); err != nil || length != 0 {
+		t.Fatalf("loaded empty stream XLEN %d, %v", length, err)
+	}
+}
@@ -0,0 +1,452 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+
+/*
+  t-digest, same commands as the redis bloom module
+
+  Values are summarized as centroids of mean and weight sorted by mean. The
+  centroids are merged as long as they stay within one unit of the scale
+  function k(q) = compression / 2pi * asin(2q - 1), so the centroids near the
+  tails stay small and the quantiles there stay accurate. At most about
+  compression centroids are kept whatever the number of values.
+
+  Quantiles and CDF interpolate between the centers of the centroids, the
+  exact min and max are kept for the tails.
+*/
+
+const (
+	// Default compression of TDIGEST.CREATE
+	tdigestDefaultCompression = 100
+)
+
+type tdigestCentroid struct {
+	mean float64
+	weight float64
+}
+
+type tDigest struct {
+	compression float64
+	centroids []tdigestCentroid
+	count float64
+	min float64
+	max float64
+}
+
+type tdigestmapData struct {
+	td *tDigest
+	lock *sync.RWMutex
+}
+
+
+func newTDigest(compression float64) *tDigest {
+	return &tDigest{
+		compression: compression,
+	}
+}
+
+
+/* Scale function and its inverse */
+func (td *tDigest) k(q float64) float64 {
+	return td.compression / (2 * math.Pi) * math.Asin(2 * q - 1)
+}
+
+func (td *tDigest) q(k float64) float64 {
+	return (math.Sin(k * 2 * math.Pi / td.compression) + 1) / 2
+}
+
+
+/* Merge the centroids which fit in one unit of the scale function */
+func (td *tDigest) compress() {
+	if len(td.centroids) < 2 {
+		return
+	}
+
+	sort.SliceStable(td.centroids, func(i, j int) bool { return td.centroids[i].mean < td.centroids[j].mean })
+
+	merged := []tdigestCentroid{}
+	cur := td.centroids[0]
+	var weightSoFar float64
+	limit := td.count * td.q(td.k(0) + 1)
+
+	for _, c := range td.centroids[1:] {
+		if weightSoFar + cur.weight + c.weight <= limit {
+			cur.weight += c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / cur.weight
+			continue
+		}
+
+		weightSoFar += cur.weight
+		merged = append(merged, cur)
+		limit = td.count * td.q(td.k(weightSoFar / td.count) + 1)
+		cur = c
+	}
+
+	td.centroids = append(merged, cur)
+}
+
+
+/* Add values with weight 1 each */
+func (td *tDigest) add(values []float64) {
+	for _, v := range values {
+		if td.count == 0 || v < td.min {
+			td.min = v
+		}
+		if td.count == 0 || v > td.max {
+			td.max = v
+		}
+		td.centroids = append(td.centroids, tdigestCentroid{mean: v, weight: 1})
+		td.count++
+	}
+
+	td.compress()
+}
+
+
+/* Add all the centroids of other */
+func (td *tDigest) merge(other *tDigest) {
+	if other.count == 0 {
+		return
+	}
+
+	if td.count == 0 || other.min < td.min {
+		td.min = other.min
+	}
+	if td.count == 0 || other.max > td.max {
+		td.max = other.max
+	}
+
+	td.centroids = append(td.centroids, other.centroids...)
+	td.count += other.count
+
+	td.compress()
+}
+
+
+func (td *tDigest) reset() {
+	td.centroids = nil
+	td.count = 0
+	td.min = 0
+	td.max = 0
+}
+
+
+/* Value at quantile q between 0 and 1, NaN if empty */
+func (td *tDigest) quantile(q float64) float64 {
+	n := len(td.centroids)
+	if n == 0 {
+		return math.NaN()
+	}
+
+	if q == 0 || n == 1 && td.centroids[0].weight == 1 {
+		return td.min
+	}
+	if q == 1 {
+		return td.max
+	}
+
+	index := q * td.count
+	first := td.centroids[0]
+	last := td.centroids[n - 1]
+
+	/* Tails, between the exact min or max and the center of the outer centroid */
+	if index < 1 {
+		return td.min
+	}
+	if first.weight > 2 && index < first.weight / 2 {
+		return td.min + (index - 1) / (first.weight / 2 - 1) * (first.mean - td.min)
+	}
+	if index > td.count - 1 {
+		return td.max
+	}
+	if last.weight > 2 && td.count - index <= last.weight / 2 {
+		return td.max - (td.count - index - 1) / (last.weight / 2 - 1) * (td.max - last.mean)
+	}
+
+	/* Between the centers of two neighbour centroids */
+	weightSoFar := first.weight / 2
+	for i := 0; i < n - 1; i++ {
+		dw := (td.centroids[i].weight + td.centroids[i+1].weight) / 2
+		if weightSoFar + dw > index {
+			z1 := index - weightSoFar
+			z2 := weightSoFar + dw - index
+			return (td.centroids[i].mean * z2 + td.centroids[i+1].mean * z1) / (z1 + z2)
+		}
+		weightSoFar += dw
+	}
+
+	return td.max
+}
+
+
+/* Fraction of the values below or at value, the value itself counting half. NaN if empty */
+func (td *tDigest) cdf(value float64) float64 {
+	n := len(td.centroids)
+	if n == 0 {
+		return math.NaN()
+	}
+
+	if value < td.min {
+		return 0
+	}
+	if value > td.max {
+		return 1
+	}
+	if td.max == td.min {
+		return 0.5
+	}
+
+	first := td.centroids[0]
+	last := td.centroids[n - 1]
+
+	/* Tails, between the exact min or max and the center of the outer centroid */
+	if value < first.mean {
+		return first.weight / 2 * (value - td.min) / (first.mean - td.min) / td.count
+	}
+	if value >= last.mean {
+		if td.max == last.mean {
+			return 1 - last.weight / 2 / td.count
+		}
+		return (td.count - last.weight / 2 + last.weight / 2 * (value - last.mean) / (td.max - last.mean)) / td.count
+	}
+
+	/* Between the centers of two neighbour centroids */
+	var weightSoFar float64
+	for i := 0; i < n - 1; i++ {
+		left := td.centroids[i]
+		right := td.centroids[i+1]
+
+		if value >= left.mean && value < right.mean {
+			dw := (left.weight + right.weight) / 2
+			return (weightSoFar + left.weight / 2 + dw * (value - left.mean) / (right.mean - left.mean)) / td.count
+		}
+		weightSoFar += left.weight
+	}
+
+	return 1
+}
+
+
+/* Format a t-digest value for replies, nan for an empty digest */
+func formatDigestValue(value float64) string {
+	if math.IsNaN(value) {
+		return "nan"
+	}
+	return formatScore(value)
+}
+
+
+/* Create t-digest key, fails if the key exists */
+func (store *db) TDIGESTCREATE(key string, compression float64) error {
+	if store == nil {
+		fmt.Println("TDIGESTCREATE : store is nil")
+		return errors.New(fmt.Sprint("TDIGESTCREATE : store is nil"))
+	}
+
+	/* Take Global write lock, to ensure only one entry gets created */
+	store.tdigestmapDBLock.Lock()
+	defer store.tdigestmapDBLock.Unlock()
+
+	if _, ok := store.tdigestmapEntry[key]; ok == true {
+		return errors.New("T-Digest: key already exists")
+	}
+
+	store.tdigestmapEntry[key] = &tdigestmapData{
+		td: newTDigest(compression),
+		lock: &sync.RWMutex{},
+	}
+
+	return nil
+}
+
+
+/* Update t-digest key with fn under the key write lock */
+func (store *db) tdigestUpdate(key string, fn func(td *tDigest)) error {
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.tdigestmapDBLock.RLock()
+	defer store.tdigestmapDBLock.RUnlock()
+
+	entry, ok := store.tdigestmapEntry[key]
+
+	if ok == false {
+		return errors.New("T-Digest: key does not exist")
+	}
+
+	/* Take DB entry lock before update, this is lock per key entry */
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	fn(entry.td)
+
+	return nil
+}
+
+
+/* Read t-digest key with fn under the key read lock */
+func (store *db) tdigestRead(key string, fn func(td *tDigest)) error {
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.tdigestmapDBLock.RLock()
+	defer store.tdigestmapDBLock.RUnlock()
+
+	entry, ok := store.tdigestmapEntry[key]
+
+	if ok == false {
+		return errors.New("T-Digest: key does not exist")
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	fn(entry.td)
+
+	return nil
+}
+
+
+func (store *db) TDIGESTADD(key string, values []float64) error {
+	if store == nil {
+		fmt.Println("TDIGESTADD : store is nil")
+		return errors.New(fmt.Sprint("TDIGESTADD : store is nil"))
+	}
+
+	return store.tdigestUpdate(key, func(td *tDigest) {
+		td.add(values)
+	})
+}
+
+
+func (store *db) TDIGESTRESET(key string) error {
+	if store == nil {
+		fmt.Println("TDIGESTRESET : store is nil")
+		return errors.New(fmt.Sprint("TDIGESTRESET : store is nil"))
+	}
+
+	return store.tdigestUpdate(key, func(td *tDigest) {
+		td.reset()
+	})
+}
+
+
+/* Values at quantiles */
+func (store *db) TDIGESTQUANTILE(key string, quantiles []float64) ([]float64, error) {
+	if store == nil {
+		fmt.Println("TDIGESTQUANTILE : store is nil")
+		return nil, errors.New(fmt.Sprint("TDIGESTQUANTILE : store is nil"))
+	}
+
+	values := make([]float64, len(quantiles))
+
+	err := store.tdigestRead(key, func(td *tDigest) {
+		for i, q := range quantiles {
+			values[i] = td.quantile(q)
+		}
+	})
+
+	return values, err
+}
+
+
+/* Fractions of the values below or at values */
+func (store *db) TDIGESTCDF(key string, values []float64) ([]float64, error) {
+	if store == nil {
+		fmt.Println("TDIGESTCDF : store is nil")
+		return nil, errors.New(fmt.Sprint("TDIGESTCDF : store is nil"))
+	}
+
+	fractions := make([]float64, len(values))
+
+	err := store.tdigestRead(key, func(td *tDigest) {
+		for i, v := range values {
+			fractions[i] = td.cdf(v)
+		}
+	})
+
+	return fractions, err
+}
+
+
+/* Min and max of the values, NaN if empty */
+func (store *db) TDIGESTMINMAX(key string) (float64, float64, error) {
+	if store == nil {
+		fmt.Println("TDIGESTMINMAX : store is nil")
+		return 0, 0, errors.New(fmt.Sprint("TDIGESTMINMAX : store is nil"))
+	}
+
+	min, max := math.NaN(), math.NaN()
+
+	err := store.tdigestRead(key, func(td *tDigest) {
+		if td.count > 0 {
+			min, max = td.min, td.max
+		}
+	})
+
+	return min, max, err
+}
+
+
+/*
+  Merge the digests of keys into dst, dst is created if absent. An existing dst
+  is merged too unless override. compression 0 takes the largest compression
+  of the merged digests.
+*/
+func (store *db) TDIGESTMERGE(dst string, keys []string, compression float64, override bool) error {
+	if store == nil {
+		fmt.Println("TDIGESTMERGE : store is nil")
+		return errors.New(fmt.Sprint("TDIGESTMERGE : store is nil"))
+	}
+
+	/* Take Global write lock, dst may be one of the sources and gets replaced */
+	store.tdigestmapDBLock.Lock()
+	defer store.tdigestmapDBLock.Unlock()
+
+	srcs := []*tDigest{}
+	for _, key := range keys {
+		entry, ok := store.tdigestmapEntry[key]
+		if ok == false {
+			return errors.New("T-Digest: key does not exist")
+		}
+		srcs = append(srcs, entry.td)
+	}
+
+	dstEntry, ok := store.tdigestmapEntry[dst]
+	if ok == true && override == false {
+		srcs = append(srcs, dstEntry.td)
+	}
+
+	if compression == 0 {
+		for _, src := range srcs {
+			if src.compression > compression {
+				compression = src.compression
+			}
+		}
+	}
+
+	td := newTDigest(compression)
+	for _, src := range srcs {
+		td.merge(src)
+	}
+
+	if ok == true {
+		dstEntry.td = td
+	} else {
+		store.tdigestmapEntry[dst] = &tdigestmapData{
+			td: td,
+			lock: &sync.RWMutex{},
+		}
+	}
+
+	return nil
+}
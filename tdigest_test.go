@@ -0,0 +1,223 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+
+/* Values 1 to n in random order */
+func tdigestValues(n int) []float64 {
+	values := make([]float64, n)
+	for i, k := range rand.Perm(n) {
+		values[i] = float64(k + 1)
+	}
+	return values
+}
+
+
+/* Few values are kept exact, quantiles and CDF interpolate between them */
+func TestTDigestSmall(t *testing.T) {
+	store := newDB()
+	must(t, store.TDIGESTCREATE("td", 100))
+
+	quantiles, err := store.TDIGESTQUANTILE("td", []float64{0, 0.5, 1})
+	must(t, err)
+	for _, q := range quantiles {
+		if math.IsNaN(q) == false {
+			t.Fatalf("TDIGEST.QUANTILE of empty digest gave %v", quantiles)
+		}
+	}
+	if min, max, _ := store.TDIGESTMINMAX("td"); math.IsNaN(min) == false || math.IsNaN(max) == false {
+		t.Fatalf("TDIGEST.MIN MAX of empty digest gave %v %v", min, max)
+	}
+	if formatDigestValue(math.NaN()) != "nan" {
+		t.Fatalf("NaN formatted as %s", formatDigestValue(math.NaN()))
+	}
+
+	must(t, store.TDIGESTADD("td", []float64{7}))
+	if quantiles, _ = store.TDIGESTQUANTILE("td", []float64{0, 0.3, 1}); quantiles[0] != 7 || quantiles[1] != 7 || quantiles[2] != 7 {
+		t.Fatalf("TDIGEST.QUANTILE of one value gave %v", quantiles)
+	}
+
+	must(t, store.TDIGESTADD("td", []float64{5, 3, 1, 9}))
+
+	quantiles, _ = store.TDIGESTQUANTILE("td", []float64{0, 0.1, 0.5, 0.7, 0.9, 1})
+	for i, want := range []float64{1, 1, 5, 7, 9, 9} {
+		if quantiles[i] != want {
+			t.Fatalf("TDIGEST.QUANTILE of 1 3 5 7 9 gave %v", quantiles)
+		}
+	}
+
+	cdf, err := store.TDIGESTCDF("td", []float64{0, 1, 5, 6, 9, 10})
+	must(t, err)
+	for i, want := range []float64{0, 0.1, 0.5, 0.6, 0.9, 1} {
+		if math.Abs(cdf[i] - want) > 1e-9 {
+			t.Fatalf("TDIGEST.CDF of 1 3 5 7 9 gave %v", cdf)
+		}
+	}
+
+	if min, max, _ := store.TDIGESTMINMAX("td"); min != 1 || max != 9 {
+		t.Fatalf("TDIGEST.MIN MAX gave %v %v", min, max)
+	}
+
+	must(t, store.TDIGESTRESET("td"))
+	if cdf, _ = store.TDIGESTCDF("td", []float64{1}); math.IsNaN(cdf[0]) == false {
+		t.Fatalf("TDIGEST.CDF after reset gave %v", cdf)
+	}
+
+	if err := store.TDIGESTCREATE("td", 100); err == nil {
+		t.Fatalf("TDIGEST.CREATE of existing key")
+	}
+	if err := store.TDIGESTADD("none", []float64{1}); err == nil {
+		t.Fatalf("TDIGEST.ADD of missing key")
+	}
+	if _, err := store.TDIGESTQUANTILE("none", []float64{0.5}); err == nil {
+		t.Fatalf("TDIGEST.QUANTILE of missing key")
+	}
+}
+
+
+/* Quantiles of many values stay close, closer at the tails, with a bounded number of centroids */
+func TestTDigestQuantiles(t *testing.T) {
+	store := newDB()
+	must(t, store.TDIGESTCREATE("td", 100))
+
+	const n = 100000
+	values := tdigestValues(n)
+	for i := 0; i < n; i += 1000 {
+		must(t, store.TDIGESTADD("td", values[i:i+1000]))
+	}
+
+	td := store.tdigestmapEntry["td"].td
+	if td.count != n || len(td.centroids) > 2 * 100 {
+		t.Fatalf("%v values in %d centroids", td.count, len(td.centroids))
+	}
+
+	tests := []struct {
+		q float64
+		maxErr float64
+	}{
+		{0.001, 0.0005},
+		{0.01, 0.001},
+		{0.25, 0.01},
+		{0.5, 0.01},
+		{0.75, 0.01},
+		{0.99, 0.001},
+		{0.999, 0.0005},
+	}
+
+	for _, test := range tests {
+		quantiles, err := store.TDIGESTQUANTILE("td", []float64{test.q})
+		must(t, err)
+		if math.Abs(quantiles[0] / n - test.q) > test.maxErr {
+			t.Fatalf("TDIGEST.QUANTILE %v gave %v", test.q, quantiles[0])
+		}
+
+		cdf, _ := store.TDIGESTCDF("td", []float64{test.q * n})
+		if math.Abs(cdf[0] - test.q) > test.maxErr {
+			t.Fatalf("TDIGEST.CDF %v gave %v, want %v", test.q * n, cdf[0], test.q)
+		}
+	}
+
+	/* Quantiles never go down */
+	prev := math.Inf(-1)
+	for q := 0.0; q <= 1; q += 0.0005 {
+		quantiles, _ := store.TDIGESTQUANTILE("td", []float64{q})
+		if quantiles[0] < prev {
+			t.Fatalf("TDIGEST.QUANTILE %v gave %v below %v", q, quantiles[0], prev)
+		}
+		prev = quantiles[0]
+	}
+
+	if min, max, _ := store.TDIGESTMINMAX("td"); min != 1 || max != n {
+		t.Fatalf("TDIGEST.MIN MAX gave %v %v", min, max)
+	}
+}
+
+
+func TestTDigestMerge(t *testing.T) {
+	store := newDB()
+	must(t, store.TDIGESTCREATE("low", 50))
+	must(t, store.TDIGESTCREATE("high", 200))
+
+	values := tdigestValues(10000)
+	for _, v := range values {
+		if v <= 5000 {
+			store.TDIGESTADD("low", []float64{v})
+		} else {
+			store.TDIGESTADD("high", []float64{v})
+		}
+	}
+
+	/* compression 0 takes the largest */
+	must(t, store.TDIGESTMERGE("dst", []string{"low", "high"}, 0, false))
+	td := store.tdigestmapEntry["dst"].td
+	if td.compression != 200 || td.count != 10000 || td.min != 1 || td.max != 10000 {
+		t.Fatalf("merged digest compression %v count %v min %v max %v", td.compression, td.count, td.min, td.max)
+	}
+	if quantiles, _ := store.TDIGESTQUANTILE("dst", []float64{0.5}); math.Abs(quantiles[0] - 5000) > 100 {
+		t.Fatalf("merged median %v", quantiles[0])
+	}
+
+	/* An existing dst is merged too unless override */
+	must(t, store.TDIGESTMERGE("dst", []string{"low"}, 100, false))
+	if td = store.tdigestmapEntry["dst"].td; td.count != 15000 || td.compression != 100 {
+		t.Fatalf("merge into dst gave count %v compression %v", td.count, td.compression)
+	}
+	must(t, store.TDIGESTMERGE("dst", []string{"high"}, 0, true))
+	if min, max, _ := store.TDIGESTMINMAX("dst"); min != 5001 || max != 10000 || store.tdigestmapEntry["dst"].td.count != 5000 {
+		t.Fatalf("merge OVERRIDE gave min %v max %v", min, max)
+	}
+
+	/* A source as destination */
+	must(t, store.TDIGESTMERGE("low", []string{"low"}, 0, false))
+	if store.tdigestmapEntry["low"].td.count != 10000 {
+		t.Fatalf("merge of low into low gave count %v", store.tdigestmapEntry["low"].td.count)
+	}
+
+	if err := store.TDIGESTMERGE("dst", []string{"none"}, 0, false); err == nil {
+		t.Fatalf("TDIGEST.MERGE of missing key")
+	}
+
+	/* Merging an empty digest changes nothing */
+	must(t, store.TDIGESTCREATE("empty", 100))
+	must(t, store.TDIGESTMERGE("empty2", []string{"empty"}, 0, false))
+	if min, _, _ := store.TDIGESTMINMAX("empty2"); math.IsNaN(min) == false {
+		t.Fatalf("merge of empty digest gave min %v", min)
+	}
+}
+
+
+func TestTDigestSaveLoad(t *testing.T) {
+	store := newDB()
+	must(t, store.TDIGESTCREATE("td", 80))
+	store.TDIGESTADD("td", tdigestValues(5000))
+	must(t, store.TDIGESTCREATE("empty", 30))
+
+	loaded := saveLoad(t, store)
+
+	for _, key := range []string{"td", "empty"} {
+		got, want := loaded.tdigestmapEntry[key].td, store.tdigestmapEntry[key].td
+		if got.compression != want.compression || got.count != want.count || got.min != want.min || got.max != want.max || len(got.centroids) != len(want.centroids) {
+			t.Fatalf("loaded %s compression %v count %v", key, got.compression, got.count)
+		}
+		for i := range want.centroids {
+			if got.centroids[i] != want.centroids[i] {
+				t.Fatalf("loaded %s centroid %d is %v, want %v", key, i, got.centroids[i], want.centroids[i])
+			}
+		}
+	}
+
+	want, _ := store.TDIGESTQUANTILE("td", []float64{0.1, 0.9})
+	got, _ := loaded.TDIGESTQUANTILE("td", []float64{0.1, 0.9})
+	if got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("loaded quantiles %v, want %v", got, want)
+	}
+}
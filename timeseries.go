@@ -0,0 +1,814 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+
+/*
+  Time series, same commands as the redis time series module
+
+  Samples of millisecond timestamp and value are kept in gorilla compressed
+  chunks of about chunkSize bytes sorted by timestamp, see tschunk.go.
+
+  Retention is relative to the latest sample of the series, samples older than
+  latest - retention are rejected on add, skipped by reads and removed by the
+  caretaker. Retention 0 keeps samples forever.
+
+  A compaction rule aggregates the samples of the source series in buckets of
+  bucketDuration and adds every finished bucket to the destination series.
+  Only samples after the start of the open bucket are aggregated, updates of
+  older samples are not compacted again. A destination has one source and no
+  rules of its own, so a source locks its destinations and never the reverse.
+*/
+
+const (
+	// Defaults of TS.CREATE
+	tsDefaultChunkSize = 4096
+	tsDefaultDuplicatePolicy = "BLOCK"
+)
+
+var tsDuplicatePolicies = []string{"BLOCK", "FIRST", "LAST", "MIN", "MAX", "SUM"}
+
+var tsAggregations = []string{"avg", "sum", "min", "max", "range", "count", "first", "last", "std.p", "std.s", "var.p", "var.s"}
+
+type tsOptions struct {
+	retention int64
+	chunkSize int
+	duplicatePolicy string
+	labels map[string]string
+
+	/* Policy of TS.ADD ON_DUPLICATE, overrides duplicatePolicy of the series */
+	onDuplicate string
+}
+
+/* Running aggregates of the samples of a bucket, mean and m2 by welford for the variance */
+type tsAggregator struct {
+	count float64
+	sum float64
+	min float64
+	max float64
+	first float64
+	last float64
+	mean float64
+	m2 float64
+}
+
+type tsRule struct {
+	dst string
+	agg string
+	bucket int64
+	align int64
+
+	/* Open bucket of the samples not yet added to dst */
+	open bool
+	bucketStart int64
+	acc tsAggregator
+}
+
+type timeSeries struct {
+	chunks []*tsChunk
+	retention int64
+	chunkSize int
+	duplicatePolicy string
+	labels map[string]string
+	rules []*tsRule
+
+	/* Source of the rule adding to this series, empty if none */
+	srcKey string
+}
+
+type tsmapData struct {
+	ts *timeSeries
+	lock *sync.RWMutex
+}
+
+/* Label filter of TS.MRANGE, "" in values stands for a missing label */
+type tsFilter struct {
+	label string
+	not bool
+	values []string
+}
+
+type tsRangeResult struct {
+	key string
+	labels []string
+	samples []tsSample
+}
+
+
+func isTSDuplicatePolicy(policy string) bool {
+	for _, p := range tsDuplicatePolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+
+func isTSAggregation(agg string) bool {
+	for _, a := range tsAggregations {
+		if a == agg {
+			return true
+		}
+	}
+	return false
+}
+
+
+func (acc *tsAggregator) add(v float64) {
+	if acc.count == 0 {
+		acc.min = v
+		acc.max = v
+		acc.first = v
+	}
+
+	if v < acc.min {
+		acc.min = v
+	}
+	if v > acc.max {
+		acc.max = v
+	}
+
+	acc.last = v
+	acc.sum += v
+	acc.count++
+
+	d := v - acc.mean
+	acc.mean += d / acc.count
+	acc.m2 += d * (v - acc.mean)
+}
+
+
+func (acc *tsAggregator) value(agg string) float64 {
+	switch agg {
+	case "avg":
+		return acc.sum / acc.count
+	case "sum":
+		return acc.sum
+	case "min":
+		return acc.min
+	case "max":
+		return acc.max
+	case "range":
+		return acc.max - acc.min
+	case "count":
+		return acc.count
+	case "first":
+		return acc.first
+	case "last":
+		return acc.last
+	case "var.p":
+		return acc.m2 / acc.count
+	case "std.p":
+		return math.Sqrt(acc.m2 / acc.count)
+	}
+
+	/* Sample variance of a single sample is 0 */
+	if acc.count < 2 {
+		return 0
+	}
+
+	if agg == "var.s" {
+		return acc.m2 / (acc.count - 1)
+	}
+	return math.Sqrt(acc.m2 / (acc.count - 1))
+}
+
+
+/* Start of the bucket of ts, buckets start at align + n * bucket */
+func tsBucketStart(ts int64, bucket int64, align int64) int64 {
+	offset := (ts - align) % bucket
+	if offset < 0 {
+		offset += bucket
+	}
+	return ts - offset
+}
+
+
+/* Aggregate samples in buckets, a sample per non empty bucket */
+func tsAggregate(samples []tsSample, agg string, bucket int64) []tsSample {
+	result := []tsSample{}
+
+	var acc tsAggregator
+	var start int64
+
+	for _, s := range samples {
+		b := tsBucketStart(s.ts, bucket, 0)
+
+		if acc.count > 0 && b != start {
+			result = append(result, tsSample{ts: start, val: acc.value(agg)})
+			acc = tsAggregator{}
+		}
+
+		start = b
+		acc.add(s.val)
+	}
+
+	if acc.count > 0 {
+		result = append(result, tsSample{ts: start, val: acc.value(agg)})
+	}
+
+	return result
+}
+
+
+func newTimeSeries(opts *tsOptions) *timeSeries {
+	labels := make(map[string]string)
+	for l, v := range opts.labels {
+		labels[l] = v
+	}
+
+	return &timeSeries{
+		retention: opts.retention,
+		chunkSize: opts.chunkSize,
+		duplicatePolicy: opts.duplicatePolicy,
+		labels: labels,
+	}
+}
+
+
+/* Timestamp of the latest sample, false if empty */
+func (series *timeSeries) lastTimestamp() (int64, bool) {
+	if len(series.chunks) == 0 {
+		return 0, false
+	}
+	return series.chunks[len(series.chunks) - 1].end, true
+}
+
+
+/* Oldest timestamp kept by the retention, math.MinInt64 if all */
+func (series *timeSeries) retentionStart() int64 {
+	last, ok := series.lastTimestamp()
+	if ok == false || series.retention == 0 || last < math.MinInt64 + series.retention {
+		return math.MinInt64
+	}
+	return last - series.retention
+}
+
+
+/* Resolve a sample at an existing timestamp with the duplicate policy */
+func tsUpsertValue(policy string, old float64, val float64) (float64, error) {
+	switch policy {
+	case "FIRST":
+		return old, nil
+	case "LAST":
+		return val, nil
+	case "MIN":
+		return math.Min(old, val), nil
+	case "MAX":
+		return math.Max(old, val), nil
+	case "SUM":
+		return old + val, nil
+	}
+
+	return 0, errors.New("TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode")
+}
+
+
+/* Add sample, returns true if it is the latest sample of the series */
+func (series *timeSeries) add(s tsSample, policy string) (bool, error) {
+	if s.ts < series.retentionStart() {
+		return false, errors.New("TSDB: Timestamp is older than retention")
+	}
+
+	n := len(series.chunks)
+
+	/* Append after the latest sample */
+	if n == 0 || s.ts > series.chunks[n-1].end {
+		if n == 0 || len(series.chunks[n-1].data) >= series.chunkSize {
+			series.chunks = append(series.chunks, &tsChunk{})
+			n++
+		}
+		series.chunks[n-1].append(s)
+		return true, nil
+	}
+
+	/* Upsert, rebuild the first chunk ending at or after the timestamp */
+	i := sort.Search(n, func(i int) bool { return series.chunks[i].end >= s.ts })
+	samples := series.chunks[i].samples()
+
+	k := sort.Search(len(samples), func(k int) bool { return samples[k].ts >= s.ts })
+
+	if k < len(samples) && samples[k].ts == s.ts {
+		val, err := tsUpsertValue(policy, samples[k].val, s.val)
+		if err != nil {
+			return false, err
+		}
+		samples[k].val = val
+	} else {
+		samples = append(samples, tsSample{})
+		copy(samples[k+1:], samples[k:])
+		samples[k] = s
+	}
+
+	series.chunks[i] = newTSChunk(samples)
+
+	return false, nil
+}
+
+
+/* Samples from to to, both included, within the retention */
+func (series *timeSeries) rangeSamples(from int64, to int64) []tsSample {
+	if start := series.retentionStart(); from < start {
+		from = start
+	}
+
+	result := []tsSample{}
+
+	for _, c := range series.chunks {
+		if c.end < from || c.start > to {
+			continue
+		}
+
+		r := c.reader()
+		for s, ok := r.next(); ok == true && s.ts <= to; s, ok = r.next() {
+			if s.ts >= from {
+				result = append(result, s)
+			}
+		}
+	}
+
+	return result
+}
+
+
+/* Remove the samples older than the retention, returns the number removed */
+func (series *timeSeries) removeExpired() int {
+	start := series.retentionStart()
+	removed := 0
+
+	for len(series.chunks) > 0 && series.chunks[0].end < start {
+		removed += series.chunks[0].count
+		series.chunks = series.chunks[1:]
+	}
+
+	if len(series.chunks) > 0 && series.chunks[0].start < start {
+		samples := series.chunks[0].samples()
+		k := sort.Search(len(samples), func(k int) bool { return samples[k].ts >= start })
+
+		removed += k
+		series.chunks[0] = newTSChunk(samples[k:])
+	}
+
+	return removed
+}
+
+
+/* Label value pairs sorted by label */
+func (series *timeSeries) sortedLabels() []string {
+	names := make([]string, 0, len(series.labels))
+	for l := range series.labels {
+		names = append(names, l)
+	}
+	sort.Strings(names)
+
+	vals := []string{}
+	for _, l := range names {
+		vals = append(vals, l, series.labels[l])
+	}
+	return vals
+}
+
+
+func (series *timeSeries) matches(filters []tsFilter) bool {
+	for _, f := range filters {
+		value := series.labels[f.label]
+
+		found := false
+		for _, v := range f.values {
+			if v == value {
+				found = true
+				break
+			}
+		}
+
+		if found == f.not {
+			return false
+		}
+	}
+	return true
+}
+
+
+/*
+  Parse a TS.MRANGE filter: label=value, label!=value, label= (label missing),
+  label!= (label present), label=(value1,value2) and label!=(value1,value2)
+*/
+func parseTSFilter(arg string) (tsFilter, error) {
+	eq := strings.Index(arg, "=")
+	if eq < 1 {
+		return tsFilter{}, errors.New("TSDB: failed parsing labels")
+	}
+
+	f := tsFilter{label: arg[:eq]}
+	value := arg[eq+1:]
+
+	if strings.HasSuffix(f.label, "!") {
+		f.not = true
+		f.label = strings.TrimSuffix(f.label, "!")
+		if len(f.label) == 0 {
+			return tsFilter{}, errors.New("TSDB: failed parsing labels")
+		}
+	}
+
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		f.values = strings.Split(value[1:len(value)-1], ",")
+	} else {
+		f.values = []string{value}
+	}
+
+	return f, nil
+}
+
+
+/*
+  Parse [RETENTION retention] [CHUNK_SIZE size] [DUPLICATE_POLICY policy] [LABELS label value ...]
+  of TS.CREATE and TS.ADD, TS.ADD takes [ON_DUPLICATE policy] as well
+*/
+func parseTSOptions(args []string, add bool) (*tsOptions, error) {
+	opts := &tsOptions{
+		chunkSize: tsDefaultChunkSize,
+		duplicatePolicy: tsDefaultDuplicatePolicy,
+	}
+
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+
+		if opt == "LABELS" {
+			/* Labels come last */
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest) % 2 != 0 {
+				return nil, errors.New("TSDB: invalid labels")
+			}
+
+			opts.labels = make(map[string]string)
+			for k := 0; k < len(rest); k = k + 2 {
+				opts.labels[rest[k]] = rest[k+1]
+			}
+			break
+		}
+
+		if i + 1 >= len(args) {
+			return nil, errors.New("TSDB: syntax error")
+		}
+		i++
+
+		switch {
+		case opt == "RETENTION":
+			retention, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || retention < 0 {
+				return nil, errors.New("TSDB: invalid RETENTION value")
+			}
+			opts.retention = retention
+
+		case opt == "CHUNK_SIZE":
+			size, err := strconv.Atoi(args[i])
+			if err != nil || size < 48 || size > 1048576 {
+				return nil, errors.New("TSDB: invalid CHUNK_SIZE value, must be between 48 and 1048576")
+			}
+			opts.chunkSize = size
+
+		case opt == "DUPLICATE_POLICY":
+			opts.duplicatePolicy = strings.ToUpper(args[i])
+			if isTSDuplicatePolicy(opts.duplicatePolicy) == false {
+				return nil, errors.New("TSDB: unknown DUPLICATE_POLICY")
+			}
+
+		case opt == "ON_DUPLICATE" && add == true:
+			opts.onDuplicate = strings.ToUpper(args[i])
+			if isTSDuplicatePolicy(opts.onDuplicate) == false {
+				return nil, errors.New("TSDB: unknown ON_DUPLICATE policy")
+			}
+
+		default:
+			return nil, errors.New("TSDB: syntax error")
+		}
+	}
+
+	return opts, nil
+}
+
+
+/* Parse a timestamp of TS.RANGE, - and + are the smallest and the largest */
+func parseTSTimestamp(arg string) (int64, error) {
+	if arg == "-" {
+		return math.MinInt64, nil
+	}
+	if arg == "+" {
+		return math.MaxInt64, nil
+	}
+
+	ts, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, errors.New("TSDB: invalid timestamp")
+	}
+	return ts, nil
+}
+
+
+/* Parse [AGGREGATION aggregator bucketDuration] of TS.RANGE, TS.MRANGE and TS.CREATERULE */
+func parseTSAggregation(args []string) (string, int64, error) {
+	if len(args) < 2 {
+		return "", 0, errors.New("TSDB: wrong AGGREGATION arguments")
+	}
+
+	agg := strings.ToLower(args[0])
+	if isTSAggregation(agg) == false {
+		return "", 0, errors.New("TSDB: unknown aggregation type")
+	}
+
+	bucket, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || bucket <= 0 {
+		return "", 0, errors.New("TSDB: bucketDuration must be greater than zero")
+	}
+
+	return agg, bucket, nil
+}
+
+
+/* Feed an appended sample to the rules of series, finished buckets are added to the destinations */
+func (store *db) tsCompact(series *timeSeries, s tsSample) {
+	for _, rule := range series.rules {
+		start := tsBucketStart(s.ts, rule.bucket, rule.align)
+
+		if rule.open == true && start < rule.bucketStart {
+			continue
+		}
+
+		if rule.open == true && start > rule.bucketStart {
+			if dstEntry, ok := store.tsmapEntry[rule.dst]; ok == true {
+				dstEntry.lock.Lock()
+				dstEntry.ts.add(tsSample{ts: rule.bucketStart, val: rule.acc.value(rule.agg)}, "LAST")
+				dstEntry.lock.Unlock()
+			}
+			rule.open = false
+		}
+
+		if rule.open == false {
+			rule.open = true
+			rule.bucketStart = start
+			rule.acc = tsAggregator{}
+		}
+
+		rule.acc.add(s.val)
+	}
+}
+
+
+/* Create time series key, fails if the key exists */
+func (store *db) TSCREATE(key string, opts *tsOptions) error {
+	if store == nil {
+		fmt.Println("TSCREATE : store is nil")
+		return errors.New(fmt.Sprint("TSCREATE : store is nil"))
+	}
+
+	/* Take Global write lock, to ensure only one entry gets created */
+	store.tsmapDBLock.Lock()
+	defer store.tsmapDBLock.Unlock()
+
+	if _, ok := store.tsmapEntry[key]; ok == true {
+		return errors.New("TSDB: key already exists")
+	}
+
+	store.tsmapEntry[key] = &tsmapData{
+		ts: newTimeSeries(opts),
+		lock: &sync.RWMutex{},
+	}
+
+	return nil
+}
+
+
+/* Add sample, the key is created with opts if absent and create is true */
+func (store *db) TSADD(key string, s tsSample, opts *tsOptions, create bool) error {
+	if store == nil {
+		fmt.Println("TSADD : store is nil")
+		return errors.New(fmt.Sprint("TSADD : store is nil"))
+	}
+
+	var entry *tsmapData
+	var ok bool
+
+	store.tsmapDBLock.RLock()
+
+	/* Check if db has the key entry */
+	entry, ok = store.tsmapEntry[key]
+
+	if ok == false && create == false {
+		store.tsmapDBLock.RUnlock()
+		return errors.New("TSDB: the key does not exist")
+	}
+
+	/* If entry not present */
+	if ok == false {
+		/* Take DB lock before creating entry for this key, to ensure only one entry gets created */
+		store.tsmapDBLock.RUnlock()
+		store.tsmapDBLock.Lock()
+
+		/* Again Check if db has the key entry, between above if & db lock it is possible other routine has created this entry */
+		var okrecheck bool
+		entry, okrecheck = store.tsmapEntry[key]
+
+		if okrecheck == false {
+			entry = &tsmapData{
+				ts: newTimeSeries(opts),
+				lock: &sync.RWMutex{},
+			}
+			store.tsmapEntry[key] = entry
+		}
+	}
+
+	/* Take DB entry lock before update, this is lock per key entry */
+	entry.lock.Lock()
+
+	policy := entry.ts.duplicatePolicy
+	if opts != nil && opts.onDuplicate != "" {
+		policy = opts.onDuplicate
+	}
+
+	latest, err := entry.ts.add(s, policy)
+
+	/* Destination entries are locked under the source entry lock, never the reverse */
+	if err == nil && latest == true {
+		store.tsCompact(entry.ts, s)
+	}
+
+	entry.lock.Unlock()
+
+	/* Both locks got released in LIFO order, entry lock followed by db lock */
+	if ok == false {
+		store.tsmapDBLock.Unlock()
+	} else {
+		store.tsmapDBLock.RUnlock()
+	}
+
+	return err
+}
+
+
+/* Samples from to to, aggregated in buckets of bucket if agg is not empty, at most count if count > 0 */
+func (store *db) TSRANGE(key string, from int64, to int64, count int, agg string, bucket int64) ([]tsSample, error) {
+	if store == nil {
+		fmt.Println("TSRANGE : store is nil")
+		return nil, errors.New(fmt.Sprint("TSRANGE : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.tsmapDBLock.RLock()
+	defer store.tsmapDBLock.RUnlock()
+
+	entry, ok := store.tsmapEntry[key]
+
+	if ok == false {
+		return nil, errors.New("TSDB: the key does not exist")
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	samples := entry.ts.rangeSamples(from, to)
+
+	if agg != "" {
+		samples = tsAggregate(samples, agg, bucket)
+	}
+
+	if count > 0 && len(samples) > count {
+		samples = samples[:count]
+	}
+
+	return samples, nil
+}
+
+
+/* TS.RANGE of every series matching all the filters, in key order */
+func (store *db) TSMRANGE(from int64, to int64, count int, agg string, bucket int64, filters []tsFilter) ([]tsRangeResult, error) {
+	if store == nil {
+		fmt.Println("TSMRANGE : store is nil")
+		return nil, errors.New(fmt.Sprint("TSMRANGE : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the keys until operation is finished */
+	store.tsmapDBLock.RLock()
+	defer store.tsmapDBLock.RUnlock()
+
+	keys := make([]string, 0, len(store.tsmapEntry))
+	for key := range store.tsmapEntry {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results := []tsRangeResult{}
+
+	for _, key := range keys {
+		entry := store.tsmapEntry[key]
+
+		/* Take DB entry Rlock before get, this is lock per key entry */
+		entry.lock.RLock()
+
+		if entry.ts.matches(filters) == true {
+			samples := entry.ts.rangeSamples(from, to)
+
+			if agg != "" {
+				samples = tsAggregate(samples, agg, bucket)
+			}
+
+			if count > 0 && len(samples) > count {
+				samples = samples[:count]
+			}
+
+			results = append(results, tsRangeResult{key: key, labels: entry.ts.sortedLabels(), samples: samples})
+		}
+
+		entry.lock.RUnlock()
+	}
+
+	return results, nil
+}
+
+
+/* Add rule compacting src into dst with agg in buckets of bucket starting at align */
+func (store *db) TSCREATERULE(src string, dst string, agg string, bucket int64, align int64) error {
+	if store == nil {
+		fmt.Println("TSCREATERULE : store is nil")
+		return errors.New(fmt.Sprint("TSCREATERULE : store is nil"))
+	}
+
+	/* Take Global write lock, the rule links two keys */
+	store.tsmapDBLock.Lock()
+	defer store.tsmapDBLock.Unlock()
+
+	if src == dst {
+		return errors.New("TSDB: the source key and destination key should be different")
+	}
+
+	srcEntry, ok := store.tsmapEntry[src]
+	if ok == false {
+		return errors.New("TSDB: the key does not exist")
+	}
+
+	dstEntry, ok := store.tsmapEntry[dst]
+	if ok == false {
+		return errors.New("TSDB: the key does not exist")
+	}
+
+	if dstEntry.ts.srcKey != "" {
+		return errors.New("TSDB: the destination key already has a src rule")
+	}
+
+	if len(dstEntry.ts.rules) > 0 {
+		return errors.New("TSDB: the destination key already has a dst rule")
+	}
+
+	if srcEntry.ts.srcKey != "" {
+		return errors.New("TSDB: the source key is a destination of a rule")
+	}
+
+	srcEntry.ts.rules = append(srcEntry.ts.rules, &tsRule{
+		dst: dst,
+		agg: agg,
+		bucket: bucket,
+		align: align,
+	})
+	dstEntry.ts.srcKey = src
+
+	return nil
+}
+
+
+/* Active retention of time series, run by the caretaker */
+func (store *db) TSDeleteExpired() {
+	if store == nil {
+		fmt.Println("TSDeleteExpired : store is nil")
+		return
+	}
+
+	store.tsmapDBLock.RLock()
+	defer store.tsmapDBLock.RUnlock()
+
+	for key, entry := range store.tsmapEntry {
+		entry.lock.Lock()
+		removed := 0
+		if entry.ts.retention > 0 {
+			removed = entry.ts.removeExpired()
+		}
+		entry.lock.Unlock()
+
+		if removed > 0 {
+			fmt.Println("Evicted - key : ", key, "  time series samples : ", removed)
+		}
+	}
+}
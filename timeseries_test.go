@@ -0,0 +1,41 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+
+/* Samples and the state of an open compaction bucket are kept by Save and Load */
+func TestTSSaveLoad(t *testing.T) {
+	store := newDB()
+
+	opts, err := parseTSOptions([]string{"LABELS", "sensor", "1"}, false)
+	must(t, err)
+	must(t, store.TSCREATE("ts", opts))
+	dstOpts, _ := parseTSOptions([]string{}, false)
+	must(t, store.TSCREATE("tsavg", dstOpts))
+	must(t, store.TSCREATERULE("ts", "tsavg", "avg", 1000, 0))
+	for i := int64(0); i < 3500; i = i + 250 {
+		must(t, store.TSADD("ts", tsSample{ts: i, val: float64(i) / 10}, opts, false))
+	}
+
+	loaded := saveLoad(t, store)
+
+	samples, _ := loaded.TSRANGE("ts", 0, math.MaxInt64, 0, "", 0)
+	if len(samples) != 14 || samples[13].ts != 3250 || samples[13].val != 325 {
+		t.Fatalf("TS.RANGE %v", samples)
+	}
+
+	/* Rule state is kept, the next bucket closes 3000-3999 */
+	must(t, loaded.TSADD("ts", tsSample{ts: 4000, val: 0}, opts, false))
+	avg, _ := loaded.TSRANGE("tsavg", 0, math.MaxInt64, 0, "", 0)
+	if len(avg) != 4 || avg[3].ts != 3000 || avg[3].val != 312.5 {
+		t.Fatalf("compacted %v", avg)
+	}
+}
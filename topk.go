@@ -0,0 +1,268 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+
+/*
+  Top-K heavy hitters, same commands as the redis bloom module (HeavyKeeper)
+
+  depth rows of width buckets of fingerprint and count. An item counts up its
+  bucket in every row if the bucket is empty or holds its fingerprint, else it
+  decays the count of the bucket with probability decay^count and takes the
+  bucket over once the count drops to 0. So large counts belong to heavy items.
+  The estimate of an item is its largest count, the k items with the largest
+  estimates are kept in a list sorted by count.
+*/
+
+const (
+	// Defaults of TOPK.RESERVE
+	topkDefaultWidth = 8
+	topkDefaultDepth = 7
+	topkDefaultDecay = 0.9
+
+	// Limits of TOPK.RESERVE, the buckets of a key take at most 512MB
+	topkMaxWidth = 1 << 26
+	topkMaxDepth = 64
+	topkMaxBuckets = 1 << 26
+)
+
+type topkBucket struct {
+	fp uint32
+	count uint32
+}
+
+type topkItem struct {
+	item string
+	count uint32
+}
+
+type topK struct {
+	k int
+	width uint64
+	depth uint64
+	decay float64
+	buckets []topkBucket
+	list []topkItem
+}
+
+type topkmapData struct {
+	tk *topK
+	lock *sync.RWMutex
+}
+
+
+func newTopK(k int, width uint64, depth uint64, decay float64) *topK {
+	return &topK{
+		k: k,
+		width: width,
+		depth: depth,
+		decay: decay,
+		buckets: make([]topkBucket, width * depth),
+	}
+}
+
+
+/* Position of item in the top k list, -1 if not in */
+func (tk *topK) find(item string) int {
+	for i := range tk.list {
+		if tk.list[i].item == item {
+			return i
+		}
+	}
+	return -1
+}
+
+
+/* Keep the top k list sorted by count, highest first */
+func (tk *topK) sortList() {
+	sort.SliceStable(tk.list, func(i, j int) bool { return tk.list[i].count > tk.list[j].count })
+}
+
+
+/* Count item, returns the item expelled from the top k list and true if one got expelled */
+func (tk *topK) add(item string) (string, bool) {
+	h1, h2 := bloomHash(item)
+	fp := uint32(h1 >> 32)
+
+	var maxCount uint32
+
+	for row := uint64(0); row < tk.depth; row++ {
+		b := &tk.buckets[row * tk.width + (h1 + row * h2) % tk.width]
+
+		if b.count == 0 {
+			b.fp = fp
+			b.count = 1
+		} else if b.fp == fp {
+			if b.count < math.MaxUint32 {
+				b.count++
+			}
+		} else if rand.Float64() < math.Pow(tk.decay, float64(b.count)) {
+			b.count--
+			if b.count == 0 {
+				b.fp = fp
+				b.count = 1
+			}
+		}
+
+		if b.fp == fp && b.count > maxCount {
+			maxCount = b.count
+		}
+	}
+
+	if i := tk.find(item); i >= 0 {
+		if maxCount > tk.list[i].count {
+			tk.list[i].count = maxCount
+		}
+		tk.sortList()
+		return "", false
+	}
+
+	if len(tk.list) < tk.k {
+		tk.list = append(tk.list, topkItem{item: item, count: maxCount})
+		tk.sortList()
+		return "", false
+	}
+
+	last := len(tk.list) - 1
+	if maxCount <= tk.list[last].count {
+		return "", false
+	}
+
+	expelled := tk.list[last].item
+	tk.list[last] = topkItem{item: item, count: maxCount}
+	tk.sortList()
+
+	return expelled, true
+}
+
+
+/* Create top k key, fails if the key exists */
+func (store *db) TOPKRESERVE(key string, k int, width uint64, depth uint64, decay float64) error {
+	if store == nil {
+		fmt.Println("TOPKRESERVE : store is nil")
+		return errors.New(fmt.Sprint("TOPKRESERVE : store is nil"))
+	}
+
+	/* Take Global write lock, to ensure only one entry gets created */
+	store.topkmapDBLock.Lock()
+	defer store.topkmapDBLock.Unlock()
+
+	if _, ok := store.topkmapEntry[key]; ok == true {
+		return errors.New("TopK: key already exists")
+	}
+
+	/* width * depth must not wrap, the buckets are allocated at once */
+	if width == 0 || depth == 0 || width > topkMaxWidth || depth > topkMaxDepth ||
+		width > math.MaxUint64 / depth || width * depth > topkMaxBuckets {
+		return errors.New("TopK: invalid width or depth")
+	}
+
+	store.topkmapEntry[key] = &topkmapData{
+		tk: newTopK(k, width, depth, decay),
+		lock: &sync.RWMutex{},
+	}
+
+	return nil
+}
+
+
+/* Add items, returns the items expelled from the top k list, found is false where nothing got expelled */
+func (store *db) TOPKADD(key string, items []string) ([]string, []bool, error) {
+	if store == nil {
+		fmt.Println("TOPKADD : store is nil")
+		return nil, nil, errors.New(fmt.Sprint("TOPKADD : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.topkmapDBLock.RLock()
+	defer store.topkmapDBLock.RUnlock()
+
+	entry, ok := store.topkmapEntry[key]
+
+	if ok == false {
+		return nil, nil, errors.New("TopK: key does not exist")
+	}
+
+	/* Take DB entry lock before update, this is lock per key entry */
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	expelled := make([]string, len(items))
+	found := make([]bool, len(items))
+
+	for i, item := range items {
+		expelled[i], found[i] = entry.tk.add(item)
+	}
+
+	return expelled, found, nil
+}
+
+
+/* Check if items are in the top k list */
+func (store *db) TOPKQUERY(key string, items []string) ([]bool, error) {
+	if store == nil {
+		fmt.Println("TOPKQUERY : store is nil")
+		return nil, errors.New(fmt.Sprint("TOPKQUERY : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.topkmapDBLock.RLock()
+	defer store.topkmapDBLock.RUnlock()
+
+	entry, ok := store.topkmapEntry[key]
+
+	if ok == false {
+		return nil, errors.New("TopK: key does not exist")
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	found := make([]bool, len(items))
+	for i, item := range items {
+		found[i] = entry.tk.find(item) >= 0
+	}
+
+	return found, nil
+}
+
+
+/* Items of the top k list with their counts, highest count first */
+func (store *db) TOPKLIST(key string) ([]topkItem, error) {
+	if store == nil {
+		fmt.Println("TOPKLIST : store is nil")
+		return nil, errors.New(fmt.Sprint("TOPKLIST : store is nil"))
+	}
+
+	/* Take Global Read lock to hold delete of the key until operation is finished */
+	store.topkmapDBLock.RLock()
+	defer store.topkmapDBLock.RUnlock()
+
+	entry, ok := store.topkmapEntry[key]
+
+	if ok == false {
+		return nil, errors.New("TopK: key does not exist")
+	}
+
+	/* Take DB entry Rlock before get, this is lock per key entry */
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	items := make([]topkItem, len(entry.tk.list))
+	copy(items, entry.tk.list)
+
+	return items, nil
+}
@@ -0,0 +1,112 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+
+/* A width * depth that wraps or is too large to allocate is rejected */
+func TestTopKReserveBounds(t *testing.T) {
+	store := newDB()
+
+	for _, dim := range [][2]uint64{{1 << 62, 4}, {1 << 32, 1 << 32}, {topkMaxWidth + 1, 1}, {1, topkMaxDepth + 1}, {topkMaxWidth, 2}, {0, 1}} {
+		if err := store.TOPKRESERVE("topk", 3, dim[0], dim[1], 0.9); err == nil {
+			t.Fatalf("TOPK.RESERVE accepted %v x %v", dim[0], dim[1])
+		}
+	}
+
+	must(t, store.TOPKRESERVE("topk", 3, 100, 5, 0.9))
+}
+
+
+/* Heavy items end up in the list, sorted by count */
+func TestTopKAddList(t *testing.T) {
+	store := newDB()
+	must(t, store.TOPKRESERVE("topk", 3, 50, 4, 0.9))
+
+	/* Item hN is added N times, among light items added once */
+	for round := 0; round < 100; round++ {
+		items := []string{"light" + strconv.Itoa(round)}
+		for n := 1; n <= 5; n++ {
+			if round < n * 20 {
+				items = append(items, "h" + strconv.Itoa(n))
+			}
+		}
+		_, _, err := store.TOPKADD("topk", items)
+		must(t, err)
+	}
+
+	list, err := store.TOPKLIST("topk")
+	must(t, err)
+	if len(list) != 3 || list[0].item != "h5" || list[1].item != "h4" || list[2].item != "h3" {
+		t.Fatalf("TOPK.LIST %v", list)
+	}
+	if list[0].count < list[1].count || list[1].count < list[2].count || list[0].count > 100 {
+		t.Fatalf("TOPK.LIST counts %v", list)
+	}
+
+	found, err := store.TOPKQUERY("topk", []string{"h5", "h1", "light1"})
+	must(t, err)
+	if found[0] == false || found[1] == true || found[2] == true {
+		t.Fatalf("TOPK.QUERY gave %v", found)
+	}
+}
+
+
+/* An item taking a place in a full list expels the last one */
+func TestTopKExpelled(t *testing.T) {
+	store := newDB()
+	must(t, store.TOPKRESERVE("topk", 2, 100, 5, 0.9))
+
+	store.TOPKADD("topk", []string{"a", "a", "b"})
+
+	expelled, found, err := store.TOPKADD("topk", []string{"c", "c"})
+	must(t, err)
+	if found[0] == true || found[1] == false || expelled[1] != "b" {
+		t.Fatalf("TOPK.ADD c c expelled %v %v", expelled, found)
+	}
+
+	if found, _ := store.TOPKQUERY("topk", []string{"a", "b", "c"}); found[0] == false || found[1] == true || found[2] == false {
+		t.Fatalf("TOPK.QUERY after expel gave %v", found)
+	}
+
+	if _, _, err := store.TOPKADD("none", []string{"a"}); err == nil {
+		t.Fatalf("TOPK.ADD of missing key")
+	}
+	if _, err := store.TOPKLIST("none"); err == nil {
+		t.Fatalf("TOPK.LIST of missing key")
+	}
+	if err := store.TOPKRESERVE("topk", 3, 10, 2, 0.9); err == nil {
+		t.Fatalf("TOPK.RESERVE of existing key")
+	}
+}
+
+
+func TestTopKSaveLoad(t *testing.T) {
+	store := newDB()
+	must(t, store.TOPKRESERVE("topk", 2, 20, 3, 0.8))
+	store.TOPKADD("topk", []string{"a", "a", "a", "b", "b", "c"})
+
+	loaded := saveLoad(t, store)
+
+	got, want := loaded.topkmapEntry["topk"].tk, store.topkmapEntry["topk"].tk
+	if got.k != want.k || got.width != want.width || got.depth != want.depth || got.decay != want.decay {
+		t.Fatalf("loaded top k %v %v %v %v", got.k, got.width, got.depth, got.decay)
+	}
+	for i := range want.buckets {
+		if got.buckets[i] != want.buckets[i] {
+			t.Fatalf("loaded bucket %d is %v, want %v", i, got.buckets[i], want.buckets[i])
+		}
+	}
+
+	list, _ := loaded.TOPKLIST("topk")
+	if len(list) != 2 || list[0] != (topkItem{"a", 3}) || list[1] != (topkItem{"b", 2}) {
+		t.Fatalf("loaded list %v", list)
+	}
+}
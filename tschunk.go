@@ -0,0 +1,248 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"math"
+	"math/bits"
+)
+
+
+/*
+  Compressed chunk of time series samples, gorilla encoding
+
+  The first sample is stored as 64 bit timestamp and 64 bit value. Every next
+  timestamp is stored as the delta of its delta to the previous timestamp:
+     '0'                      - same delta
+     '10'   + 7 bits          - delta of delta in [-64, 63]
+     '110'  + 9 bits          - delta of delta in [-256, 255]
+     '1110' + 12 bits         - delta of delta in [-2048, 2047]
+     '1111' + 64 bits         - any other delta of delta
+  Every next value is stored as the xor with the previous value:
+     '0'                      - same value
+     '10'   + meaningful bits - xor fits in the leading/trailing zeros of the previous xor
+     '11'   + 6 bits leading zeros + 6 bits meaningful length - 1 + meaningful bits
+
+  Samples are only appended, a sample before the end of the chunk rebuilds the chunk.
+*/
+
+type tsSample struct {
+	ts int64
+	val float64
+}
+
+type tsChunk struct {
+	data []byte
+	nbits uint64
+	count int
+	start int64
+	end int64
+
+	/* Encoder state of the last sample */
+	prevDelta int64
+	prevVal uint64
+	leading uint8
+	trailing uint8
+}
+
+type tsChunkReader struct {
+	chunk *tsChunk
+	pos uint64
+	n int
+	ts int64
+	delta int64
+	val uint64
+	leading uint8
+	trailing uint8
+}
+
+
+func (c *tsChunk) writeBits(v uint64, n uint) {
+	for n > 0 {
+		if c.nbits % 8 == 0 {
+			c.data = append(c.data, 0)
+		}
+
+		free := 8 - uint(c.nbits % 8)
+		take := free
+		if n < take {
+			take = n
+		}
+
+		/* Top take bits of the n remaining bits of v */
+		part := byte((v >> (n - take)) & (1 << take - 1))
+		c.data[len(c.data) - 1] |= part << (free - take)
+
+		c.nbits += uint64(take)
+		n -= take
+	}
+}
+
+
+func (r *tsChunkReader) readBits(n uint) uint64 {
+	var v uint64
+	data := r.chunk.data
+
+	for n > 0 {
+		left := 8 - uint(r.pos % 8)
+		take := left
+		if n < take {
+			take = n
+		}
+
+		part := (data[r.pos / 8] >> (left - take)) & (1 << take - 1)
+		v = v << take | uint64(part)
+
+		r.pos += uint64(take)
+		n -= take
+	}
+
+	return v
+}
+
+
+/* Sign extend the n bit two's complement v */
+func tsSignExtend(v uint64, n uint) int64 {
+	return int64(v << (64 - n)) >> (64 - n)
+}
+
+
+/* Append a sample after the end of the chunk */
+func (c *tsChunk) append(s tsSample) {
+	val := math.Float64bits(s.val)
+
+	if c.count == 0 {
+		c.writeBits(uint64(s.ts), 64)
+		c.writeBits(val, 64)
+		c.start = s.ts
+		c.end = s.ts
+		c.prevVal = val
+		c.count++
+		return
+	}
+
+	/* Timestamp */
+	delta := s.ts - c.end
+	dod := delta - c.prevDelta
+
+	switch {
+	case dod == 0:
+		c.writeBits(0, 1)
+	case dod >= -64 && dod <= 63:
+		c.writeBits(0x2, 2)
+		c.writeBits(uint64(dod), 7)
+	case dod >= -256 && dod <= 255:
+		c.writeBits(0x6, 3)
+		c.writeBits(uint64(dod), 9)
+	case dod >= -2048 && dod <= 2047:
+		c.writeBits(0xe, 4)
+		c.writeBits(uint64(dod), 12)
+	default:
+		c.writeBits(0xf, 4)
+		c.writeBits(uint64(dod), 64)
+	}
+
+	/* Value */
+	xor := val ^ c.prevVal
+
+	if xor == 0 {
+		c.writeBits(0, 1)
+	} else {
+		leading := uint8(bits.LeadingZeros64(xor))
+		trailing := uint8(bits.TrailingZeros64(xor))
+
+		if c.leading + c.trailing > 0 && leading >= c.leading && trailing >= c.trailing {
+			c.writeBits(0x2, 2)
+			c.writeBits(xor >> c.trailing, uint(64 - c.leading - c.trailing))
+		} else {
+			c.writeBits(0x3, 2)
+			c.writeBits(uint64(leading), 6)
+			c.writeBits(uint64(64 - leading - trailing - 1), 6)
+			c.writeBits(xor >> trailing, uint(64 - leading - trailing))
+			c.leading = leading
+			c.trailing = trailing
+		}
+	}
+
+	c.end = s.ts
+	c.prevDelta = delta
+	c.prevVal = val
+	c.count++
+}
+
+
+func (c *tsChunk) reader() *tsChunkReader {
+	return &tsChunkReader{chunk: c}
+}
+
+
+/* Next sample of the chunk, false at the end */
+func (r *tsChunkReader) next() (tsSample, bool) {
+	if r.n >= r.chunk.count {
+		return tsSample{}, false
+	}
+
+	if r.n == 0 {
+		r.ts = int64(r.readBits(64))
+		r.val = r.readBits(64)
+		r.n++
+		return tsSample{ts: r.ts, val: math.Float64frombits(r.val)}, true
+	}
+
+	/* Timestamp */
+	var dod int64
+
+	if r.readBits(1) == 1 {
+		if r.readBits(1) == 0 {
+			dod = tsSignExtend(r.readBits(7), 7)
+		} else if r.readBits(1) == 0 {
+			dod = tsSignExtend(r.readBits(9), 9)
+		} else if r.readBits(1) == 0 {
+			dod = tsSignExtend(r.readBits(12), 12)
+		} else {
+			dod = int64(r.readBits(64))
+		}
+	}
+
+	r.delta += dod
+	r.ts += r.delta
+
+	/* Value */
+	if r.readBits(1) == 1 {
+		if r.readBits(1) == 1 {
+			r.leading = uint8(r.readBits(6))
+			length := uint8(r.readBits(6)) + 1
+			r.trailing = 64 - r.leading - length
+		}
+		r.val ^= r.readBits(uint(64 - r.leading - r.trailing)) << r.trailing
+	}
+
+	r.n++
+	return tsSample{ts: r.ts, val: math.Float64frombits(r.val)}, true
+}
+
+
+/* All the samples of the chunk */
+func (c *tsChunk) samples() []tsSample {
+	samples := make([]tsSample, 0, c.count)
+
+	r := c.reader()
+	for s, ok := r.next(); ok == true; s, ok = r.next() {
+		samples = append(samples, s)
+	}
+
+	return samples
+}
+
+
+/* Chunk of samples sorted by timestamp */
+func newTSChunk(samples []tsSample) *tsChunk {
+	c := &tsChunk{}
+	for _, s := range samples {
+		c.append(s)
+	}
+	return c
+}
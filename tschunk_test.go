@@ -0,0 +1,62 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+
+/* Every delta of delta size and value xor case decodes to the samples appended */
+func TestTSChunkRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	samples := []tsSample{}
+	ts := int64(1700000000000)
+	val := 10.0
+
+	deltas := []int64{1000, 1000, 1001, 1063, 1300, 3000, 1, 100000000, 0}
+	values := []float64{10, 10, 10.5, -3, 0, math.Inf(1), math.Inf(-1), math.MaxFloat64, math.SmallestNonzeroFloat64}
+
+	for i := 0; i < 2000; i++ {
+		if i < len(deltas) {
+			ts += deltas[i] + 1
+			val = values[i]
+		} else {
+			ts += 1 + r.Int63n(5000)
+			if r.Intn(3) > 0 {
+				val = val + r.NormFloat64()
+			}
+		}
+		samples = append(samples, tsSample{ts: ts, val: val})
+	}
+
+	/* Negative timestamps sign extend */
+	samples = append([]tsSample{{ts: -5000, val: 1}, {ts: -1, val: 2}}, samples...)
+
+	got := newTSChunk(samples).samples()
+
+	if len(got) != len(samples) {
+		t.Fatalf("%v samples decoded, want %v", len(got), len(samples))
+	}
+	for i := range samples {
+		if got[i].ts != samples[i].ts || math.Float64bits(got[i].val) != math.Float64bits(samples[i].val) {
+			t.Fatalf("sample %v is %v, want %v", i, got[i], samples[i])
+		}
+	}
+}
+
+
+func TestTSChunkNaN(t *testing.T) {
+	samples := []tsSample{{ts: 1, val: math.NaN()}, {ts: 2, val: 1}, {ts: 3, val: math.NaN()}}
+
+	got := newTSChunk(samples).samples()
+	if len(got) != 3 || math.IsNaN(got[0].val) == false || got[1].val != 1 || math.IsNaN(got[2].val) == false {
+		t.Fatalf("samples %v", got)
+	}
+}
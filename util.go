@@ -9,6 +9,7 @@ import (
 	"bufio"
 	"fmt"
 	"log"
+	"math"
 	"io"
 	"strconv"
 	"strings"
@@ -21,6 +22,9 @@ type client struct {
 	conn   net.Conn
 	reader *bufio.Reader
 	store  *db
+	multi  bool
+	queued []*command
+	inExec bool
 }
 
 
@@ -43,6 +47,32 @@ func (client *client) send(val string) {
 	fmt.Fprintf(client.conn, "\r%s\r\n", val)
 }
 
+/* Send multi element reply one element per line */
+func (client *client) sendArray(vals []string) {
+	if len(vals) == 0 {
+		client.send("(empty list or set)")
+		return
+	}
+
+	for _, val := range vals {
+		client.send(val)
+	}
+}
+
+/* Send sorted set items as member [score] lines */
+func (client *client) sendItems(items []zsetItem, withScores bool) {
+	vals := make([]string, 0, len(items))
+
+	for _, item := range items {
+		vals = append(vals, item.member)
+		if withScores == true {
+			vals = append(vals, formatScore(item.score))
+		}
+	}
+
+	client.sendArray(vals)
+}
+
 func (client *client) sendError(err error) {
 	client.logError(err.Error())
 	client.sendLine("-Error " + err.Error() + "\r\n")
@@ -123,339 +153,3780 @@ func (client *client) serve() {
 			return
 		} 
 
-		switch cmd.Name {
-		case "GET":
-			if len(cmd.Args) < 1 {
-				client.sendError(fmt.Errorf("GET expects 1 argument"))
-				continue
-			}
-			val, errRet := client.store.Get(cmd.Args[0])
+		/* Transaction commands, and commands queued inside MULTI, see multi.go */
+		if client.transaction(cmd) == true {
+			continue
+		}
 
-			if errRet == nil {
-				client.send(val)
-			} else {
-				client.send("(nil)")
-				fmt.Println(errRet)
-			}
+		/* Commands run concurrently, EXEC of a transaction runs exclusive */
+		client.store.execLock.RLock()
+		open := client.execute(cmd)
+		client.store.execLock.RUnlock()
 
+		if open == false {
+			return
+		}
+	}
+}
 
-	        case "GETBIT":
-			if len(cmd.Args) < 2 {
-				client.sendError(fmt.Errorf("GETBIT expects 2 argument"))
-				continue
-			}
-			
-			// string to int
-			offset, err := strconv.Atoi(cmd.Args[1])
 
-			if err != nil {
-				client.sendError(fmt.Errorf("GETBIT expects unsigned offset"))
-				continue
-			}
+/* Execute a command, returns false if the connection is closed by the command */
+func (client *client) execute(cmd *command) bool {
+	var err error
+
+	switch cmd.Name {
+	case "GET":
+		if len(cmd.Args) < 1 {
+			client.sendError(fmt.Errorf("GET expects 1 argument"))
+			return true
+		}
+		val, errRet := client.store.Get(cmd.Args[0])
+
+		if errRet == nil {
+			client.send(val)
+		} else {
+			client.send("(nil)")
+			fmt.Println(errRet)
+		}
+
+
+        case "GETBIT":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("GETBIT expects 2 argument"))
+			return true
+		}
+		
+		// string to int
+		offset, err := strconv.Atoi(cmd.Args[1])
+
+		if err != nil {
+			client.sendError(fmt.Errorf("GETBIT expects unsigned offset"))
+			return true
+		}
+
+		val, errRet := client.store.GetBit(cmd.Args[0], offset)
+		
+		if errRet == nil {
+			client.send(val)
+		} else {
+			client.send("0")
+			fmt.Println(errRet)
+		}
+
+
+	case "SET":
+		
+		if len(cmd.Args) < 2 {
+			client.send("-Error SET expects atleast 2 arguments")
+			return true
+		}
+
+		if len(cmd.Args) == 2 {
+			ok,errRet := client.store.Set(cmd.Args[0], cmd.Args[1], NoExpiration)
 
-			val, errRet := client.store.GetBit(cmd.Args[0], offset)
 			
-			if errRet == nil {
-				client.send(val)
+
+			if ok == true {
+				client.send("+OK")
 			} else {
-				client.send("0")
+				client.send("$-1")
 				fmt.Println(errRet)
 			}
-	
 
-		case "SET":
-			
-			if len(cmd.Args) < 2 {
-				client.send("-Error SET expects atleast 2 arguments")
-				continue
-			}
+			return true
 
-			if len(cmd.Args) == 2 {
-				ok,errRet := client.store.Set(cmd.Args[0], cmd.Args[1], NoExpiration)
+		} else {
+			if cmd.Args[2] == "NX" {
+				ok, err := client.store.SetNX(cmd.Args[0], cmd.Args[1], NoExpiration)
 
-				
+				if ok == true {
+					client.send("(integer) 1")
+				} else {
+					client.send("(integer) 0")
+					fmt.Println(err)
+				}
+
+				return true
+
+			} else if cmd.Args[2] == "XX" {
+				ok, err := client.store.SetXX(cmd.Args[0], cmd.Args[1], NoExpiration)
 
 				if ok == true {
-					client.send("+OK")
+					client.send("(integer) 1")
 				} else {
-					client.send("$-1")
-					fmt.Println(errRet)
+					client.send("(integer) 0")
+					fmt.Println(err)
 				}
 
-				continue
+				return true
 
-			} else {
-				if cmd.Args[2] == "NX" {
-					ok, err := client.store.SetNX(cmd.Args[0], cmd.Args[1], NoExpiration)
+			} else if cmd.Args[2] == "EX" {
+				if len(cmd.Args) == 4 {
+
+					val, valErr := strconv.Atoi(cmd.Args[3])
+					if valErr != nil {
+						client.sendError(fmt.Errorf("SET EX expects time (seconds) in integer"))
+						return true
+					}
+					
+					ok,errRet := client.store.Set(cmd.Args[0], cmd.Args[1], (time.Duration(val) * time.Second))
 
 					if ok == true {
-						client.send("(integer) 1")
+						client.send("+OK")
 					} else {
-						client.send("(integer) 0")
-						fmt.Println(err)
+						client.send("$-1")
+						fmt.Println(errRet)
 					}
 
-					continue
+					return true
 
-				} else if cmd.Args[2] == "XX" {
-					ok, err := client.store.SetXX(cmd.Args[0], cmd.Args[1], NoExpiration)
+				} else {
+					client.send("-Error SET EX expects 4 arguments\r\n")
+					return true
+				}
+			} else if cmd.Args[2] == "PX" {
+				if len(cmd.Args) == 4 {
+					val, valErr := strconv.Atoi(cmd.Args[3])
+					if valErr != nil {
+						client.sendError(fmt.Errorf("SET EX expects time (milliseconds) in integer"))
+						return true
+					}
+					ok,errRet := client.store.Set(cmd.Args[0], cmd.Args[1], (time.Duration(val) * time.Millisecond))
 
 					if ok == true {
-						client.send("(integer) 1")
+						client.send("+OK")
 					} else {
-						client.send("(integer) 0")
-						fmt.Println(err)
+						client.send("$-1")
+						fmt.Println(errRet)
 					}
 
-					continue
+					return true
 
-				} else if cmd.Args[2] == "EX" {
-					if len(cmd.Args) == 4 {
+				} else {
+					client.send("-Error SET EX expects 4 arguments\r\n")
+					return true
+				}
+			}
 
-						val, valErr := strconv.Atoi(cmd.Args[3])
-						if valErr != nil {
-							client.sendError(fmt.Errorf("SET EX expects time (seconds) in integer"))
-							continue
-						}
-						
-						ok,errRet := client.store.Set(cmd.Args[0], cmd.Args[1], (time.Duration(val) * time.Second))
+		}
 
-						if ok == true {
-							client.send("+OK")
-						} else {
-							client.send("$-1")
-							fmt.Println(errRet)
-						}
+		client.send("-Error Invalid Set option")
 
-						continue
 
-					} else {
-						client.send("-Error SET EX expects 4 arguments\r\n")
-						continue
-					}
-				} else if cmd.Args[2] == "PX" {
-					if len(cmd.Args) == 4 {
-						val, valErr := strconv.Atoi(cmd.Args[3])
-						if valErr != nil {
-							client.sendError(fmt.Errorf("SET EX expects time (milliseconds) in integer"))
-							continue
-						}
-						ok,errRet := client.store.Set(cmd.Args[0], cmd.Args[1], (time.Duration(val) * time.Millisecond))
-
-						if ok == true {
-							client.send("+OK")
-						} else {
-							client.send("$-1")
-							fmt.Println(errRet)
-						}
-
-						continue
+	case "SETBIT":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("SETBIT expects 3 arguments"))
+			//return
+			return true
+		}
 
-					} else {
-						client.send("-Error SET EX expects 4 arguments\r\n")
-						continue
-					}
-				}
+		// string to int
+		offset, err := strconv.Atoi(cmd.Args[1])
+		
 
-			}
+		if err != nil {
+			client.sendError(fmt.Errorf("GETBIT expects unsigned offset"))
+			return true
+		}
+
+		val, bitErr := strconv.Atoi(cmd.Args[2])
+		if bitErr != nil {
+			client.sendError(fmt.Errorf("GETBIT expects binary bit 0 or 1"))
+			return true
+		}
 
-			client.send("-Error Invalid Set option")
+		var bitFlag byte = 0
+		
+		if val > 0 {
+			 bitFlag = 1
+		}
 
 
-		case "SETBIT":
-			if len(cmd.Args) < 3 {
-				client.sendError(fmt.Errorf("SETBIT expects 3 arguments"))
-				//return
-				continue
+		bitret, errRet := client.store.SetBit(cmd.Args[0], offset, bitFlag, NoExpiration)
+		
+		if errRet == nil {
+			client.send(bitret)
+		} else {
+			client.send("0")
+			fmt.Println(err)
+		}
+	
+	
+	case "ZADD":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("ZADD expects 3 arguments"))
+			return true
+		}
+
+		/* Options NX|XX GT|LT CH INCR come before the score member pairs */
+		var flags int = 0
+		var i int = 1
+		for ; i < len(cmd.Args); i++ {
+			opt := strings.ToUpper(cmd.Args[i])
+			if opt == "NX" {
+				flags |= zaddNX
+			} else if opt == "XX" {
+				flags |= zaddXX
+			} else if opt == "GT" {
+				flags |= zaddGT
+			} else if opt == "LT" {
+				flags |= zaddLT
+			} else if opt == "CH" {
+				flags |= zaddCH
+			} else if opt == "INCR" {
+				flags |= zaddINCR
+			} else {
+				break
 			}
+		}
 
-			// string to int
-			offset, err := strconv.Atoi(cmd.Args[1])
-			
+		if (len(cmd.Args) - i) == 0 || (len(cmd.Args) - i) % 2 != 0 {
+			client.sendError(fmt.Errorf("ZADD expects pair of score-member in arguments"))
+			return true
+		}
+
+		if err := checkZaddFlags(flags, (len(cmd.Args) - i) / 2); err != nil {
+			client.sendError(fmt.Errorf("ZADD %s", err))
+			return true
+		}
+		
+		/* 
+		    Taking score member pairs in order
+		    The member has to be unique over a key 
+		    If user enters ZADD mykey 2 q 2 w 1 q
+		    only 2 w and 1 q will be added
+		    q a unique mwmber need to have latest score which is 1 in this case
+		*/
+		items := make([]zsetItem, 0, (len(cmd.Args) - i) / 2)
+
+		for ; (i+1)<len(cmd.Args); i=i+2 {
+			// string to float, accepts -inf and +inf
+			score, err := parseScore(cmd.Args[i])
 
 			if err != nil {
-				client.sendError(fmt.Errorf("GETBIT expects unsigned offset"))
-				continue
+				client.sendError(fmt.Errorf("ZADD expects float score, %s", err))
+				break
 			}
 
-			val, bitErr := strconv.Atoi(cmd.Args[2])
-			if bitErr != nil {
-				client.sendError(fmt.Errorf("GETBIT expects binary bit 0 or 1"))
-				continue
-			}
+			items = append(items, zsetItem{member: cmd.Args[i+1], score: score})
+		}
 
-			var bitFlag byte = 0
-			
-			if val > 0 {
-				 bitFlag = 1
-			}
+		if i < len(cmd.Args) {
+			return true
+		}
 
+		memberAdded, score, errRet := client.store.ZADD(cmd.Args[0], items, flags)
 
-			bitret, errRet := client.store.SetBit(cmd.Args[0], offset, bitFlag, NoExpiration)
-			
-			if errRet == nil {
-				client.send(bitret)
+		if errRet != nil {
+			client.sendError(errRet)
+		} else if flags & zaddINCR != 0 {
+			/* INCR replies with the new score, nil when aborted by NX\XX\GT\LT */
+			if memberAdded == 0 {
+				client.send("(nil)")
 			} else {
-				client.send("0")
-				fmt.Println(err)
-			}
-		
-		
-		case "ZADD":
-			if len(cmd.Args) < 3 {
-				client.sendError(fmt.Errorf("ZADD expects 3 arguments"))
-				continue
+				client.send(formatScore(score))
 			}
+		} else {
+			client.send(strconv.Itoa(memberAdded))
+		}
 
-			if len(cmd.Args) % 2 == 0 {
-				client.sendError(fmt.Errorf("ZADD expects pair of score-member in arguments"))
-				continue
-			}
-			
-			/* 
-			    Taking map of member to score 
-			    The member has to be unique over a key 
-			    If user enters ZADD mykey 2 q 2 w 1 q
-			    only 2 w and 1 q will be added
-			    q a unique mwmber need to have latest score which is 1 in this case
-			*/
-			zaddMap := make(map[string]int)
-
-			var i int = 1
-			for i=1; (i+1)<len(cmd.Args); i=i+2 {
-				// string to int
-				score, err := strconv.Atoi(cmd.Args[i])
+	case "ZINCRBY":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("ZINCRBY expects 3 arguments"))
+			return true
+		}
 
-				if err != nil {
-					client.sendError(fmt.Errorf("ZADD expects int score"))
-					break
-				}
+		incr, err := parseScore(cmd.Args[1])
 
-				zaddMap[cmd.Args[i+1]] = score
-			}
+		if err != nil {
+			client.sendError(fmt.Errorf("ZINCRBY expects float increment, %s", err))
+			return true
+		}
 
-			if i < len(cmd.Args) {
-				continue
-			}
+		items := []zsetItem{zsetItem{member: cmd.Args[2], score: incr}}
 
-			memberAdded, errRet := client.store.ZADD(cmd.Args[0], &zaddMap)
+		_, score, errRet := client.store.ZADD(cmd.Args[0], items, zaddINCR)
 
-			if errRet == nil {
-				client.send(strconv.Itoa(memberAdded))
-			} else {
-				client.send("0")
-				fmt.Println(errRet)
-			}
+		if errRet == nil {
+			client.send(formatScore(score))
+		} else {
+			client.sendError(errRet)
+		}
 
-		case "ZCARD":
-			if len(cmd.Args) < 1 {
-				client.sendError(fmt.Errorf("ZCARD expects 1 argument"))
-				continue
-			}
+	case "ZCARD":
+		if len(cmd.Args) < 1 {
+			client.sendError(fmt.Errorf("ZCARD expects 1 argument"))
+			return true
+		}
 
-			count, errRet := client.store.ZCARD(cmd.Args[0])
+		count, errRet := client.store.ZCARD(cmd.Args[0])
 
-			if errRet == nil {
-				client.send(strconv.Itoa(count))
-			} else {
-				client.send(strconv.Itoa(0))
-				fmt.Println(errRet)
-			}
+		if errRet == nil {
+			client.send(strconv.Itoa(count))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
 
-		case "ZCOUNT":
-			if len(cmd.Args) < 3 {
-				client.sendError(fmt.Errorf("ZCOUNT expects 3 arguments"))
-				continue
-			}
+	case "ZCOUNT":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("ZCOUNT expects 3 arguments"))
+			return true
+		}
 
-			// string to int
-			min, err := strconv.Atoi(cmd.Args[1])
-			
+		// string to float range, accepts '(' exclusive bounds and -inf +inf
+		spec, err := parseRangeSpec(cmd.Args[1], cmd.Args[2])
 
-			if err != nil {
-				client.sendError(fmt.Errorf("ZCOUNT expects int min"))
-				continue
-			}
+		if err != nil {
+			client.sendError(fmt.Errorf("ZCOUNT %s", err))
+			return true
+		}
 
-			// string to int
-			max, err := strconv.Atoi(cmd.Args[2])
-			
+		count, errRet := client.store.ZCOUNT(cmd.Args[0], spec)
 
-			if err != nil {
-				client.sendError(fmt.Errorf("ZCOUNT expects int max"))
-				continue
-			}
+		if errRet == nil {
+			client.send(strconv.Itoa(count))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
+
+	case "ZRANGE", "ZREVRANGE":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("%s expects minimum 3 arguments", cmd.Name))
+			return true
+		}
+
+		args, withScores, err := parseZrangeArgs(cmd.Name, cmd.Args[1:], zrangeRank, cmd.Name == "ZREVRANGE")
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		items, errRet := client.store.ZRANGE(cmd.Args[0], args)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.sendItems(items, withScores)
+
+	case "ZRANGEBYSCORE", "ZREVRANGEBYSCORE":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("%s expects minimum 3 arguments", cmd.Name))
+			return true
+		}
+
+		args, withScores, err := parseZrangeArgs(cmd.Name, cmd.Args[1:], zrangeScore, cmd.Name == "ZREVRANGEBYSCORE")
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		items, errRet := client.store.ZRANGE(cmd.Args[0], args)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.sendItems(items, withScores)
+
+	case "ZRANGEBYLEX", "ZREVRANGEBYLEX":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("%s expects minimum 3 arguments", cmd.Name))
+			return true
+		}
+
+		args, _, err := parseZrangeArgs(cmd.Name, cmd.Args[1:], zrangeLex, cmd.Name == "ZREVRANGEBYLEX")
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		items, errRet := client.store.ZRANGE(cmd.Args[0], args)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.sendItems(items, false)
+
+	case "ZLEXCOUNT":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("ZLEXCOUNT expects 3 arguments"))
+			return true
+		}
+
+		spec, err := parseLexRangeSpec(cmd.Args[1], cmd.Args[2])
+
+		if err != nil {
+			client.sendError(fmt.Errorf("ZLEXCOUNT %s", err))
+			return true
+		}
+
+		count, errRet := client.store.ZLEXCOUNT(cmd.Args[0], spec)
+
+		if errRet == nil {
+			client.send(strconv.Itoa(count))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
 
-			count, errRet := client.store.ZCOUNT(cmd.Args[0], min, max)
+	case "ZRANGESTORE":
+		if len(cmd.Args) < 4 {
+			client.sendError(fmt.Errorf("ZRANGESTORE expects minimum 4 arguments"))
+			return true
+		}
+
+		args, withScores, err := parseZrangeArgs(cmd.Name, cmd.Args[2:], zrangeRank, false)
+
+		if err == nil && withScores == true {
+			err = fmt.Errorf("syntax error")
+		}
+
+		if err != nil {
+			client.sendError(fmt.Errorf("ZRANGESTORE %s", err))
+			return true
+		}
+
+		count, errRet := client.store.ZRANGESTORE(cmd.Args[0], cmd.Args[1], args)
+
+		if errRet == nil {
+			client.send(strconv.Itoa(count))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
+
+	case "ZSCORE":
+		if len(cmd.Args) != 2 {
+			client.sendError(fmt.Errorf("ZSCORE expects 2 arguments"))
+			return true
+		}
+
+		scores, found, errRet := client.store.ZMSCORE(cmd.Args[0], cmd.Args[1:])
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		if found[0] == true {
+			client.send(formatScore(scores[0]))
+		} else {
+			client.send("(nil)")
+		}
+
+	case "ZMSCORE":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("ZMSCORE expects minimum 2 arguments"))
+			return true
+		}
+
+		scores, found, errRet := client.store.ZMSCORE(cmd.Args[0], cmd.Args[1:])
 
-			if errRet == nil {
-				client.send(strconv.Itoa(count))
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		vals := make([]string, 0, len(scores))
+		for i, score := range scores {
+			if found[i] == true {
+				vals = append(vals, formatScore(score))
 			} else {
-				client.send(strconv.Itoa(0))
-				fmt.Println(errRet)
+				vals = append(vals, "(nil)")
 			}
+		}
 
-		case "ZRANGE":
-			if len(cmd.Args) < 3 {
-				client.sendError(fmt.Errorf("ZRANGE expects minimum 3 arguments"))
-				continue
-			}
+		client.sendArray(vals)
 
-			// string to int
-			start, err := strconv.Atoi(cmd.Args[1])
-			
+	case "ZRANK", "ZREVRANK":
+		if len(cmd.Args) < 2 || len(cmd.Args) > 3 {
+			client.sendError(fmt.Errorf("%s expects 2 or 3 arguments", cmd.Name))
+			return true
+		}
 
-			if err != nil {
-				client.sendError(fmt.Errorf("ZRANGE expects int start"))
-				continue
+		withScore := false
+		if len(cmd.Args) == 3 {
+			if strings.ToUpper(cmd.Args[2]) != "WITHSCORE" {
+				client.sendError(fmt.Errorf("%s syntax error", cmd.Name))
+				return true
 			}
+			withScore = true
+		}
 
-			// string to int
-			stop, err := strconv.Atoi(cmd.Args[2])
-			
+		rank, score, errRet := client.store.ZRANK(cmd.Args[0], cmd.Args[1], cmd.Name == "ZREVRANK")
 
-			if err != nil {
-				client.sendError(fmt.Errorf("ZRANGE expects int stop"))
-				continue
+		if errRet == nil {
+			client.send(strconv.Itoa(rank))
+			if withScore == true {
+				client.send(formatScore(score))
 			}
+		} else {
+			client.send("(nil)")
+			fmt.Println(errRet)
+		}
 
+	case "ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("%s expects minimum 3 arguments", cmd.Name))
+			return true
+		}
 
-			retMap, errRet := client.store.ZRANGE(cmd.Args[0], start, stop)
+		op := zsetOpUnion
+		if cmd.Name == "ZINTERSTORE" {
+			op = zsetOpInter
+		} else if cmd.Name == "ZDIFFSTORE" {
+			op = zsetOpDiff
+		}
 
-			if errRet == nil && retMap != nil {
-				if len(cmd.Args) == 4 && cmd.Args[3] == "WITHSCORES" {
-					for key,value := range *retMap {
-						client.send(key)
-						client.send(strconv.Itoa(value))
-					}
-				} else {
-					for key,_ := range *retMap {
-						client.send(key)
-					}
-				}
-			} else {
-				client.send("+Error")
-				fmt.Println(errRet)
-			}
+		args, _, err := parseZsetOpArgs(cmd.Name, op, cmd.Args[1:])
 
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
 
-		case "SAVE":
-			ok := client.store.Save(dbFile)
-			if ok == true {
-				client.send("+OK") 
-			} else {
-				client.send("-Error DB save failed")
-			}
+		count, errRet := client.store.ZSETOPSTORE(cmd.Args[0], args)
 
-		case "EXIT":
-			client.conn.Close()
-			return
-		
-		default:
-			client.sendError(fmt.Errorf("unkonwn command: %s", cmd.Name))
+		if errRet == nil {
+			client.send(strconv.Itoa(count))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
 		}
-	}
-}
 
+	case "ZUNION", "ZINTER", "ZDIFF", "ZINTERCARD":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("%s expects minimum 2 arguments", cmd.Name))
+			return true
+		}
+
+		op := zsetOpUnion
+		if cmd.Name == "ZINTER" || cmd.Name == "ZINTERCARD" {
+			op = zsetOpInter
+		} else if cmd.Name == "ZDIFF" {
+			op = zsetOpDiff
+		}
+
+		args, withScores, err := parseZsetOpArgs(cmd.Name, op, cmd.Args)
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
 
+		items, errRet := client.store.ZSETOP(args)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
 
+		if cmd.Name == "ZINTERCARD" {
+			client.send(strconv.Itoa(len(items)))
+		} else {
+			client.sendItems(items, withScores)
+		}
+
+	case "ZREM":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("ZREM expects minimum 2 arguments"))
+			return true
+		}
+
+		removed, errRet := client.store.ZREM(cmd.Args[0], cmd.Args[1:])
+
+		if errRet == nil {
+			client.send(strconv.Itoa(removed))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
+
+	case "ZREMRANGEBYRANK", "ZREMRANGEBYSCORE", "ZREMRANGEBYLEX":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("%s expects 3 arguments", cmd.Name))
+			return true
+		}
+
+		rangeType := zrangeRank
+		if cmd.Name == "ZREMRANGEBYSCORE" {
+			rangeType = zrangeScore
+		} else if cmd.Name == "ZREMRANGEBYLEX" {
+			rangeType = zrangeLex
+		}
+
+		args, _, err := parseZrangeArgs(cmd.Name, cmd.Args[1:], rangeType, false)
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		removed, errRet := client.store.ZREMRANGE(cmd.Args[0], args)
+
+		if errRet == nil {
+			client.send(strconv.Itoa(removed))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
+
+	case "ZPOPMIN", "ZPOPMAX":
+		if len(cmd.Args) < 1 || len(cmd.Args) > 2 {
+			client.sendError(fmt.Errorf("%s expects 1 or 2 arguments", cmd.Name))
+			return true
+		}
+
+		var count int = 1
+		if len(cmd.Args) == 2 {
+			count, err = strconv.Atoi(cmd.Args[1])
+			if err != nil || count < 0 {
+				client.sendError(fmt.Errorf("%s value is out of range, must be positive", cmd.Name))
+				return true
+			}
+		}
+
+		items, errRet := client.store.ZPOP(cmd.Args[0], count, cmd.Name == "ZPOPMAX")
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.sendItems(items, true)
+
+	case "ZMPOP":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("ZMPOP expects minimum 3 arguments"))
+			return true
+		}
+
+		keys, max, count, err := parseZmpopArgs(cmd.Args)
+
+		if err != nil {
+			client.sendError(fmt.Errorf("ZMPOP %s", err))
+			return true
+		}
+
+		key, items, errRet := client.store.ZMPOP(keys, count, max)
+
+		if errRet == nil {
+			client.sendArray(zpopReply(key, items))
+		} else {
+			client.send("(nil)")
+			fmt.Println(errRet)
+		}
+
+	case "BZPOPMIN", "BZPOPMAX", "BZMPOP":
+		if len(cmd.Args) < 2 || (cmd.Name == "BZMPOP" && len(cmd.Args) < 4) {
+			client.sendError(fmt.Errorf("%s wrong number of arguments", cmd.Name))
+			return true
+		}
+
+		var keys []string
+		var max bool = cmd.Name == "BZPOPMAX"
+		var count int = 1
+		var timeoutArg string
+
+		if cmd.Name == "BZMPOP" {
+			timeoutArg = cmd.Args[0]
+			keys, max, count, err = parseZmpopArgs(cmd.Args[1:])
+		} else {
+			timeoutArg = cmd.Args[len(cmd.Args)-1]
+			keys = cmd.Args[:len(cmd.Args)-1]
+		}
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		timeout, err := parseBlockTimeout(timeoutArg)
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		/* Pop on behalf of the client, run now and by ZADD on keys while client is blocked */
+		serve := func() ([]string, bool) {
+			key, items, errRet := client.store.ZMPOP(keys, count, max)
+			if errRet != nil {
+				return nil, false
+			}
+			return zpopReply(key, items), true
+		}
+
+		reply, ok := client.block(blockZset, keys, timeout, serve)
+
+		if ok == true {
+			client.sendArray(reply)
+		} else {
+			client.send("(nil)")
+		}
+
+	case "ZSCAN":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("ZSCAN expects minimum 2 arguments"))
+			return true
+		}
+
+		cursor, pattern, count, err := parseScanArgs(cmd.Args[1:])
+
+		if err != nil {
+			client.sendError(fmt.Errorf("ZSCAN %s", err))
+			return true
+		}
+
+		cursor, items, errRet := client.store.ZSCAN(cmd.Args[0], cursor, pattern, count)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		/* Next cursor followed by member score pairs */
+		client.send(strconv.Itoa(cursor))
+		client.sendItems(items, true)
+
+	case "ZRANDMEMBER":
+		if len(cmd.Args) < 1 || len(cmd.Args) > 3 {
+			client.sendError(fmt.Errorf("ZRANDMEMBER expects 1 to 3 arguments"))
+			return true
+		}
+
+		var count int = 1
+		if len(cmd.Args) > 1 {
+			count, err = strconv.Atoi(cmd.Args[1])
+			if err != nil || count < -zsetMaxRandCount {
+				client.sendError(fmt.Errorf("ZRANDMEMBER value is not an integer or out of range"))
+				return true
+			}
+		}
+
+		withScores := false
+		if len(cmd.Args) == 3 {
+			if strings.ToUpper(cmd.Args[2]) != "WITHSCORES" {
+				client.sendError(fmt.Errorf("ZRANDMEMBER syntax error"))
+				return true
+			}
+			withScores = true
+		}
+
+		items, errRet := client.store.ZRANDMEMBER(cmd.Args[0], count)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		/* Without count the reply is a single member or nil */
+		if len(cmd.Args) == 1 {
+			if len(items) > 0 {
+				client.send(items[0].member)
+			} else {
+				client.send("(nil)")
+			}
+			return true
+		}
+
+		client.sendItems(items, withScores)
+
+	case "LPUSH", "RPUSH", "LPUSHX", "RPUSHX":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("%s expects minimum 2 arguments", cmd.Name))
+			return true
+		}
+
+		head := cmd.Name == "LPUSH" || cmd.Name == "LPUSHX"
+		onlyExisting := cmd.Name == "LPUSHX" || cmd.Name == "RPUSHX"
+
+		length, errRet := client.store.LPUSH(cmd.Args[0], cmd.Args[1:], head, onlyExisting)
+
+		if errRet == nil {
+			client.send(strconv.Itoa(length))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
+
+	case "LPOP", "RPOP":
+		if len(cmd.Args) < 1 || len(cmd.Args) > 2 {
+			client.sendError(fmt.Errorf("%s expects 1 or 2 arguments", cmd.Name))
+			return true
+		}
+
+		var count int = 1
+		if len(cmd.Args) == 2 {
+			count, err = strconv.Atoi(cmd.Args[1])
+			if err != nil || count < 0 {
+				client.sendError(fmt.Errorf("%s value is out of range, must be positive", cmd.Name))
+				return true
+			}
+		}
+
+		vals, errRet := client.store.LPOP(cmd.Args[0], count, cmd.Name == "LPOP")
+
+		/* Without count the reply is a single element, nil if the key does not exist */
+		if errRet != nil {
+			client.send("(nil)")
+			fmt.Println(errRet)
+		} else if len(cmd.Args) == 1 {
+			client.send(vals[0])
+		} else {
+			client.sendArray(vals)
+		}
+
+	case "LLEN":
+		if len(cmd.Args) != 1 {
+			client.sendError(fmt.Errorf("LLEN expects 1 argument"))
+			return true
+		}
+
+		length, errRet := client.store.LLEN(cmd.Args[0])
+
+		if errRet == nil {
+			client.send(strconv.Itoa(length))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
+
+	case "LRANGE":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("LRANGE expects 3 arguments"))
+			return true
+		}
+
+		start, errStart := strconv.Atoi(cmd.Args[1])
+		stop, errStop := strconv.Atoi(cmd.Args[2])
+
+		if errStart != nil || errStop != nil {
+			client.sendError(fmt.Errorf("LRANGE expects int start and stop"))
+			return true
+		}
+
+		vals, errRet := client.store.LRANGE(cmd.Args[0], start, stop)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.sendArray(vals)
+
+	case "LINDEX":
+		if len(cmd.Args) != 2 {
+			client.sendError(fmt.Errorf("LINDEX expects 2 arguments"))
+			return true
+		}
+
+		index, err := strconv.Atoi(cmd.Args[1])
+
+		if err != nil {
+			client.sendError(fmt.Errorf("LINDEX expects int index"))
+			return true
+		}
+
+		val, errRet := client.store.LINDEX(cmd.Args[0], index)
+
+		if errRet == nil {
+			client.send(val)
+		} else {
+			client.send("(nil)")
+			fmt.Println(errRet)
+		}
+
+	case "LSET":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("LSET expects 3 arguments"))
+			return true
+		}
+
+		index, err := strconv.Atoi(cmd.Args[1])
+
+		if err != nil {
+			client.sendError(fmt.Errorf("LSET expects int index"))
+			return true
+		}
+
+		errRet := client.store.LSET(cmd.Args[0], index, cmd.Args[2])
+
+		if errRet == nil {
+			client.send("+OK")
+		} else {
+			client.sendError(errRet)
+		}
+
+	case "LINSERT":
+		if len(cmd.Args) != 4 {
+			client.sendError(fmt.Errorf("LINSERT expects 4 arguments"))
+			return true
+		}
+
+		where := strings.ToUpper(cmd.Args[1])
+
+		if where != "BEFORE" && where != "AFTER" {
+			client.sendError(fmt.Errorf("LINSERT syntax error"))
+			return true
+		}
+
+		length, errRet := client.store.LINSERT(cmd.Args[0], where == "BEFORE", cmd.Args[2], cmd.Args[3])
+
+		if errRet == nil {
+			client.send(strconv.Itoa(length))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
+
+	case "LREM":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("LREM expects 3 arguments"))
+			return true
+		}
+
+		count, err := strconv.Atoi(cmd.Args[1])
+
+		if err != nil {
+			client.sendError(fmt.Errorf("LREM expects int count"))
+			return true
+		}
+
+		removed, errRet := client.store.LREM(cmd.Args[0], count, cmd.Args[2])
+
+		if errRet == nil {
+			client.send(strconv.Itoa(removed))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
+
+	case "LTRIM":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("LTRIM expects 3 arguments"))
+			return true
+		}
+
+		start, errStart := strconv.Atoi(cmd.Args[1])
+		stop, errStop := strconv.Atoi(cmd.Args[2])
+
+		if errStart != nil || errStop != nil {
+			client.sendError(fmt.Errorf("LTRIM expects int start and stop"))
+			return true
+		}
+
+		errRet := client.store.LTRIM(cmd.Args[0], start, stop)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.send("+OK")
+
+	case "LPOS":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("LPOS expects minimum 2 arguments"))
+			return true
+		}
+
+		/* Options RANK rank, COUNT num, MAXLEN len */
+		var rank int = 1
+		var count int = 1
+		var maxlen int = 0
+		var withCount bool = false
+		var optErr error
+
+		for i := 2; i < len(cmd.Args) && optErr == nil; i = i + 2 {
+			if (i + 1) >= len(cmd.Args) {
+				optErr = fmt.Errorf("syntax error")
+				break
+			}
+
+			val, err := strconv.Atoi(cmd.Args[i+1])
+			if err != nil {
+				optErr = fmt.Errorf("value is not an integer or out of range")
+				break
+			}
+
+			switch strings.ToUpper(cmd.Args[i]) {
+			case "RANK":
+				if val == 0 {
+					optErr = fmt.Errorf("RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+				}
+				rank = val
+			case "COUNT":
+				if val < 0 {
+					optErr = fmt.Errorf("COUNT can't be negative")
+				}
+				count = val
+				withCount = true
+			case "MAXLEN":
+				if val < 0 {
+					optErr = fmt.Errorf("MAXLEN can't be negative")
+				}
+				maxlen = val
+			default:
+				optErr = fmt.Errorf("syntax error")
+			}
+		}
+
+		if optErr != nil {
+			client.sendError(fmt.Errorf("LPOS %s", optErr))
+			return true
+		}
+
+		matches, errRet := client.store.LPOS(cmd.Args[0], cmd.Args[1], rank, count, maxlen)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		/* With COUNT the reply is the array of indexes, otherwise the first index or nil */
+		if withCount == true {
+			vals := make([]string, 0, len(matches))
+			for _, index := range matches {
+				vals = append(vals, strconv.Itoa(index))
+			}
+			client.sendArray(vals)
+		} else if len(matches) > 0 {
+			client.send(strconv.Itoa(matches[0]))
+		} else {
+			client.send("(nil)")
+		}
+
+	case "LMOVE", "RPOPLPUSH":
+		if (cmd.Name == "LMOVE" && len(cmd.Args) != 4) || (cmd.Name == "RPOPLPUSH" && len(cmd.Args) != 2) {
+			client.sendError(fmt.Errorf("%s wrong number of arguments", cmd.Name))
+			return true
+		}
+
+		srcHead, dstHead, err := false, true, error(nil)
+		if cmd.Name == "LMOVE" {
+			srcHead, dstHead, err = parseListDirections(cmd.Args[2], cmd.Args[3])
+		}
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		val, errRet := client.store.LMOVE(cmd.Args[0], cmd.Args[1], srcHead, dstHead)
+
+		if errRet == nil {
+			client.send(val)
+		} else {
+			client.send("(nil)")
+			fmt.Println(errRet)
+		}
+
+	case "LMPOP":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("LMPOP wrong number of arguments"))
+			return true
+		}
+
+		keys, head, count, err := parseLmpopArgs(cmd.Args)
+
+		if err != nil {
+			client.sendError(fmt.Errorf("LMPOP %s", err))
+			return true
+		}
+
+		key, vals, errRet := client.store.LMPOP(keys, count, head)
+
+		if errRet == nil {
+			client.sendArray(append([]string{key}, vals...))
+		} else {
+			client.send("(nil)")
+			fmt.Println(errRet)
+		}
+
+	case "BLPOP", "BRPOP", "BLMPOP":
+		if len(cmd.Args) < 2 || (cmd.Name == "BLMPOP" && len(cmd.Args) < 4) {
+			client.sendError(fmt.Errorf("%s wrong number of arguments", cmd.Name))
+			return true
+		}
+
+		var keys []string
+		var head bool = cmd.Name == "BLPOP"
+		var count int = 1
+		var timeoutArg string
+
+		if cmd.Name == "BLMPOP" {
+			timeoutArg = cmd.Args[0]
+			keys, head, count, err = parseLmpopArgs(cmd.Args[1:])
+		} else {
+			timeoutArg = cmd.Args[len(cmd.Args)-1]
+			keys = cmd.Args[:len(cmd.Args)-1]
+		}
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		timeout, err := parseBlockTimeout(timeoutArg)
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		/* Pop on behalf of the client, run now and by LPUSH on keys while client is blocked */
+		serve := func() ([]string, bool) {
+			key, vals, errRet := client.store.LMPOP(keys, count, head)
+			if errRet != nil {
+				return nil, false
+			}
+			return append([]string{key}, vals...), true
+		}
+
+		reply, ok := client.block(blockList, keys, timeout, serve)
+
+		if ok == true {
+			client.sendArray(reply)
+		} else {
+			client.send("(nil)")
+		}
+
+	case "BLMOVE", "BRPOPLPUSH":
+		if (cmd.Name == "BLMOVE" && len(cmd.Args) != 5) || (cmd.Name == "BRPOPLPUSH" && len(cmd.Args) != 3) {
+			client.sendError(fmt.Errorf("%s wrong number of arguments", cmd.Name))
+			return true
+		}
+
+		srcHead, dstHead, err := false, true, error(nil)
+		if cmd.Name == "BLMOVE" {
+			srcHead, dstHead, err = parseListDirections(cmd.Args[2], cmd.Args[3])
+		}
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		timeout, err := parseBlockTimeout(cmd.Args[len(cmd.Args)-1])
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		src, dst := cmd.Args[0], cmd.Args[1]
+
+		/* Move on behalf of the client, dst is marked ready for the clients blocked on it */
+		serve := func() ([]string, bool) {
+			val, errRet := client.store.lmove(src, dst, srcHead, dstHead)
+			if errRet != nil {
+				return nil, false
+			}
+			client.store.markKeyReady(blockList, dst)
+			return []string{val}, true
+		}
+
+		reply, ok := client.block(blockList, []string{src}, timeout, serve)
+
+		if ok == true {
+			client.send(reply[0])
+		} else {
+			client.send("(nil)")
+		}
+
+	case "HSET", "HMSET":
+		if len(cmd.Args) < 3 || len(cmd.Args) % 2 == 0 {
+			client.sendError(fmt.Errorf("%s wrong number of arguments", cmd.Name))
+			return true
+		}
+
+		added, errRet := client.store.HSET(cmd.Args[0], cmd.Args[1:])
+
+		if errRet != nil {
+			client.sendError(errRet)
+		} else if cmd.Name == "HMSET" {
+			client.send("+OK")
+		} else {
+			client.send(strconv.Itoa(added))
+		}
+
+	case "HSETNX":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("HSETNX expects 3 arguments"))
+			return true
+		}
+
+		added, errRet := client.store.HSETNX(cmd.Args[0], cmd.Args[1], cmd.Args[2])
+
+		if errRet != nil {
+			client.sendError(errRet)
+		} else if added == true {
+			client.send(strconv.Itoa(1))
+		} else {
+			client.send(strconv.Itoa(0))
+		}
+
+	case "HGET", "HEXISTS":
+		if len(cmd.Args) != 2 {
+			client.sendError(fmt.Errorf("%s expects 2 arguments", cmd.Name))
+			return true
+		}
+
+		vals, found, errRet := client.store.HMGET(cmd.Args[0], cmd.Args[1:])
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		if cmd.Name == "HEXISTS" {
+			if found[0] == true {
+				client.send(strconv.Itoa(1))
+			} else {
+				client.send(strconv.Itoa(0))
+			}
+		} else if found[0] == true {
+			client.send(vals[0])
+		} else {
+			client.send("(nil)")
+		}
+
+	case "HMGET":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("HMGET expects minimum 2 arguments"))
+			return true
+		}
+
+		vals, found, errRet := client.store.HMGET(cmd.Args[0], cmd.Args[1:])
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		for i := range vals {
+			if found[i] == false {
+				vals[i] = "(nil)"
+			}
+		}
+
+		client.sendArray(vals)
+
+	case "HGETALL", "HKEYS", "HVALS":
+		if len(cmd.Args) != 1 {
+			client.sendError(fmt.Errorf("%s expects 1 argument", cmd.Name))
+			return true
+		}
+
+		pairs, errRet := client.store.HGETALL(cmd.Args[0])
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		/* Pairs are field value, HKEYS takes the fields and HVALS the values */
+		vals := pairs
+		if cmd.Name != "HGETALL" {
+			vals = make([]string, 0, len(pairs) / 2)
+			for i := 0; i < len(pairs); i = i + 2 {
+				if cmd.Name == "HKEYS" {
+					vals = append(vals, pairs[i])
+				} else {
+					vals = append(vals, pairs[i+1])
+				}
+			}
+		}
+
+		client.sendArray(vals)
+
+	case "HLEN":
+		if len(cmd.Args) != 1 {
+			client.sendError(fmt.Errorf("HLEN expects 1 argument"))
+			return true
+		}
+
+		length, errRet := client.store.HLEN(cmd.Args[0])
+
+		if errRet == nil {
+			client.send(strconv.Itoa(length))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
+
+	case "HDEL":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("HDEL expects minimum 2 arguments"))
+			return true
+		}
+
+		removed, errRet := client.store.HDEL(cmd.Args[0], cmd.Args[1:])
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.send(strconv.Itoa(removed))
+
+	case "HINCRBY":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("HINCRBY expects 3 arguments"))
+			return true
+		}
+
+		incr, err := strconv.ParseInt(cmd.Args[2], 10, 64)
+
+		if err != nil {
+			client.sendError(fmt.Errorf("HINCRBY value is not an integer or out of range"))
+			return true
+		}
+
+		value, errRet := client.store.HINCRBY(cmd.Args[0], cmd.Args[1], incr)
+
+		if errRet == nil {
+			client.send(strconv.FormatInt(value, 10))
+		} else {
+			client.sendError(fmt.Errorf("HINCRBY %s", errRet))
+		}
+
+	case "HINCRBYFLOAT":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("HINCRBYFLOAT expects 3 arguments"))
+			return true
+		}
+
+		incr, err := strconv.ParseFloat(cmd.Args[2], 64)
+
+		if err != nil || math.IsNaN(incr) || math.IsInf(incr, 0) {
+			client.sendError(fmt.Errorf("HINCRBYFLOAT value is not a valid float"))
+			return true
+		}
+
+		value, errRet := client.store.HINCRBYFLOAT(cmd.Args[0], cmd.Args[1], incr)
+
+		if errRet == nil {
+			client.send(strconv.FormatFloat(value, 'f', -1, 64))
+		} else {
+			client.sendError(fmt.Errorf("HINCRBYFLOAT %s", errRet))
+		}
+
+	case "HSCAN":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("HSCAN expects minimum 2 arguments"))
+			return true
+		}
+
+		cursor, pattern, count, err := parseScanArgs(cmd.Args[1:])
+
+		if err != nil {
+			client.sendError(fmt.Errorf("HSCAN %s", err))
+			return true
+		}
+
+		cursor, pairs, errRet := client.store.HSCAN(cmd.Args[0], cursor, pattern, count)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		/* Next cursor followed by field value pairs */
+		client.send(strconv.Itoa(cursor))
+		client.sendArray(pairs)
+
+	case "HRANDFIELD":
+		if len(cmd.Args) < 1 || len(cmd.Args) > 3 {
+			client.sendError(fmt.Errorf("HRANDFIELD expects 1 to 3 arguments"))
+			return true
+		}
+
+		var count int = 1
+		if len(cmd.Args) > 1 {
+			count, err = strconv.Atoi(cmd.Args[1])
+			if err != nil || count < -hashMaxRandCount {
+				client.sendError(fmt.Errorf("HRANDFIELD value is not an integer or out of range"))
+				return true
+			}
+		}
+
+		withValues := false
+		if len(cmd.Args) == 3 {
+			if strings.ToUpper(cmd.Args[2]) != "WITHVALUES" {
+				client.sendError(fmt.Errorf("HRANDFIELD syntax error"))
+				return true
+			}
+			withValues = true
+		}
+
+		pairs, errRet := client.store.HRANDFIELD(cmd.Args[0], count)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		/* Without count the reply is a single field or nil */
+		if len(cmd.Args) == 1 {
+			if len(pairs) > 0 {
+				client.send(pairs[0])
+			} else {
+				client.send("(nil)")
+			}
+			return true
+		}
+
+		vals := pairs
+		if withValues == false {
+			vals = make([]string, 0, len(pairs) / 2)
+			for i := 0; i < len(pairs); i = i + 2 {
+				vals = append(vals, pairs[i])
+			}
+		}
+
+		client.sendArray(vals)
+
+	case "HEXPIRE", "HPEXPIRE":
+		if len(cmd.Args) < 5 {
+			client.sendError(fmt.Errorf("%s wrong number of arguments", cmd.Name))
+			return true
+		}
+
+		ttl, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+
+		if err != nil || ttl < 0 {
+			client.sendError(fmt.Errorf("%s invalid expire time", cmd.Name))
+			return true
+		}
+
+		unit := time.Second
+		if cmd.Name == "HPEXPIRE" {
+			unit = time.Millisecond
+		}
+
+		if ttl > int64(math.MaxInt64 / unit) - time.Now().UnixNano() / int64(unit) {
+			client.sendError(fmt.Errorf("%s invalid expire time", cmd.Name))
+			return true
+		}
+
+		var cond string
+		rest := cmd.Args[2:]
+
+		switch strings.ToUpper(rest[0]) {
+		case "NX", "XX", "GT", "LT":
+			cond = strings.ToUpper(rest[0])
+			rest = rest[1:]
+		}
+
+		fields, err := parseHashFieldsArgs(rest)
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		e := time.Now().UnixNano() + ttl * int64(unit)
+
+		results, errRet := client.store.HEXPIRE(cmd.Args[0], fields, e, cond)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		vals := make([]string, 0, len(results))
+		for _, result := range results {
+			vals = append(vals, strconv.Itoa(result))
+		}
+
+		client.sendArray(vals)
+
+	case "HTTL", "HPTTL":
+		if len(cmd.Args) < 4 {
+			client.sendError(fmt.Errorf("%s wrong number of arguments", cmd.Name))
+			return true
+		}
+
+		fields, err := parseHashFieldsArgs(cmd.Args[1:])
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		ttls, errRet := client.store.HTTL(cmd.Args[0], fields)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		/* Remaining time rounded to seconds or milliseconds, negative values are the -2\-1 codes */
+		unit := time.Second
+		if cmd.Name == "HPTTL" {
+			unit = time.Millisecond
+		}
+
+		vals := make([]string, 0, len(ttls))
+		for _, ttl := range ttls {
+			if ttl < 0 {
+				vals = append(vals, strconv.FormatInt(int64(ttl), 10))
+			} else {
+				vals = append(vals, strconv.FormatInt(int64((ttl + unit / 2) / unit), 10))
+			}
+		}
+
+		client.sendArray(vals)
+
+	case "HPERSIST":
+		if len(cmd.Args) < 4 {
+			client.sendError(fmt.Errorf("HPERSIST wrong number of arguments"))
+			return true
+		}
+
+		fields, err := parseHashFieldsArgs(cmd.Args[1:])
+
+		if err != nil {
+			client.sendError(fmt.Errorf("HPERSIST %s", err))
+			return true
+		}
+
+		results, errRet := client.store.HPERSIST(cmd.Args[0], fields)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		vals := make([]string, 0, len(results))
+		for _, result := range results {
+			vals = append(vals, strconv.Itoa(result))
+		}
+
+		client.sendArray(vals)
+
+	case "SADD", "SREM":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("%s expects minimum 2 arguments", cmd.Name))
+			return true
+		}
+
+		var count int
+		var errRet error
+
+		if cmd.Name == "SADD" {
+			count, errRet = client.store.SADD(cmd.Args[0], cmd.Args[1:])
+		} else {
+			count, errRet = client.store.SREM(cmd.Args[0], cmd.Args[1:])
+		}
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.send(strconv.Itoa(count))
+
+	case "SMEMBERS":
+		if len(cmd.Args) != 1 {
+			client.sendError(fmt.Errorf("SMEMBERS expects 1 argument"))
+			return true
+		}
+
+		members, errRet := client.store.SMEMBERS(cmd.Args[0])
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.sendArray(members)
+
+	case "SISMEMBER", "SMISMEMBER":
+		if len(cmd.Args) < 2 || (cmd.Name == "SISMEMBER" && len(cmd.Args) != 2) {
+			client.sendError(fmt.Errorf("%s wrong number of arguments", cmd.Name))
+			return true
+		}
+
+		found, errRet := client.store.SMISMEMBER(cmd.Args[0], cmd.Args[1:])
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		vals := make([]string, 0, len(found))
+		for _, in := range found {
+			if in == true {
+				vals = append(vals, strconv.Itoa(1))
+			} else {
+				vals = append(vals, strconv.Itoa(0))
+			}
+		}
+
+		client.sendArray(vals)
+
+	case "SCARD":
+		if len(cmd.Args) != 1 {
+			client.sendError(fmt.Errorf("SCARD expects 1 argument"))
+			return true
+		}
+
+		count, errRet := client.store.SCARD(cmd.Args[0])
+
+		if errRet == nil {
+			client.send(strconv.Itoa(count))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
+
+	case "SPOP", "SRANDMEMBER":
+		if len(cmd.Args) < 1 || len(cmd.Args) > 2 {
+			client.sendError(fmt.Errorf("%s expects 1 or 2 arguments", cmd.Name))
+			return true
+		}
+
+		var count int = 1
+		if len(cmd.Args) == 2 {
+			count, err = strconv.Atoi(cmd.Args[1])
+			if err != nil || (cmd.Name == "SPOP" && count < 0) {
+				client.sendError(fmt.Errorf("%s value is out of range, must be positive", cmd.Name))
+				return true
+			}
+			if count < -setMaxRandCount {
+				client.sendError(fmt.Errorf("%s value is out of range", cmd.Name))
+				return true
+			}
+		}
+
+		var members []string
+		var errRet error
+
+		if cmd.Name == "SPOP" {
+			members, errRet = client.store.SPOP(cmd.Args[0], count)
+		} else {
+			members, errRet = client.store.SRANDMEMBER(cmd.Args[0], count)
+		}
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		/* Without count the reply is a single member or nil */
+		if len(cmd.Args) == 1 {
+			if len(members) > 0 {
+				client.send(members[0])
+			} else {
+				client.send("(nil)")
+			}
+			return true
+		}
+
+		client.sendArray(members)
+
+	case "SINTER", "SUNION", "SDIFF":
+		if len(cmd.Args) < 1 {
+			client.sendError(fmt.Errorf("%s expects minimum 1 argument", cmd.Name))
+			return true
+		}
+
+		members, errRet := client.store.SETOP(setOpByName(cmd.Name), cmd.Args, 0)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.sendArray(members)
+
+	case "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("%s expects minimum 2 arguments", cmd.Name))
+			return true
+		}
+
+		count, errRet := client.store.SETOPSTORE(setOpByName(cmd.Name), cmd.Args[0], cmd.Args[1:])
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.send(strconv.Itoa(count))
+
+	case "SINTERCARD":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("SINTERCARD expects minimum 2 arguments"))
+			return true
+		}
+
+		numkeys, err := strconv.Atoi(cmd.Args[0])
+
+		if err != nil || numkeys < 1 {
+			client.sendError(fmt.Errorf("SINTERCARD numkeys should be greater than 0"))
+			return true
+		}
+
+		if numkeys > len(cmd.Args) - 1 {
+			client.sendError(fmt.Errorf("SINTERCARD syntax error"))
+			return true
+		}
+
+		var limit int
+		rest := cmd.Args[numkeys+1:]
+
+		if len(rest) > 0 {
+			if len(rest) != 2 || strings.ToUpper(rest[0]) != "LIMIT" {
+				client.sendError(fmt.Errorf("SINTERCARD syntax error"))
+				return true
+			}
+
+			limit, err = strconv.Atoi(rest[1])
+			if err != nil || limit < 0 {
+				client.sendError(fmt.Errorf("SINTERCARD LIMIT can't be negative"))
+				return true
+			}
+		}
+
+		members, errRet := client.store.SETOP(setOpInter, cmd.Args[1:numkeys+1], limit)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.send(strconv.Itoa(len(members)))
+
+	case "SMOVE":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("SMOVE expects 3 arguments"))
+			return true
+		}
+
+		moved, errRet := client.store.SMOVE(cmd.Args[0], cmd.Args[1], cmd.Args[2])
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		if moved == true {
+			client.send(strconv.Itoa(1))
+		} else {
+			client.send(strconv.Itoa(0))
+		}
+
+	case "SSCAN":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("SSCAN expects minimum 2 arguments"))
+			return true
+		}
+
+		cursor, pattern, count, err := parseScanArgs(cmd.Args[1:])
+
+		if err != nil {
+			client.sendError(fmt.Errorf("SSCAN %s", err))
+			return true
+		}
+
+		cursor, members, errRet := client.store.SSCAN(cmd.Args[0], cursor, pattern, count)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		/* Next cursor followed by members */
+		client.send(strconv.Itoa(cursor))
+		client.sendArray(members)
+
+	case "XADD":
+		if len(cmd.Args) < 4 {
+			client.sendError(fmt.Errorf("XADD wrong number of arguments"))
+			return true
+		}
+
+		var trim streamTrim
+		var noMkStream bool
+
+		i := 1
+		for ; i < len(cmd.Args); i++ {
+			opt := strings.ToUpper(cmd.Args[i])
+
+			if opt == "NOMKSTREAM" {
+				noMkStream = true
+			} else if opt == "MAXLEN" || opt == "MINID" {
+				i, err = parseStreamTrimArgs(cmd.Args, i, &trim)
+				if err != nil {
+					client.sendError(fmt.Errorf("XADD %s", err))
+					return true
+				}
+				i--
+			} else {
+				break
+			}
+		}
+
+		/* ID followed by field value pairs */
+		if i >= len(cmd.Args) || (len(cmd.Args) - i - 1) == 0 || (len(cmd.Args) - i - 1) % 2 != 0 {
+			client.sendError(fmt.Errorf("XADD wrong number of arguments"))
+			return true
+		}
+
+		id, errRet := client.store.XADD(cmd.Args[0], cmd.Args[i], cmd.Args[i+1:], &trim, noMkStream)
+
+		if errRet == nil {
+			client.send(id)
+		} else if noMkStream == true && strings.HasSuffix(errRet.Error(), "not found") {
+			client.send("(nil)")
+			fmt.Println(errRet)
+		} else {
+			client.sendError(fmt.Errorf("XADD %s", errRet))
+		}
+
+	case "XRANGE", "XREVRANGE":
+		if len(cmd.Args) != 3 && len(cmd.Args) != 5 {
+			client.sendError(fmt.Errorf("%s wrong number of arguments", cmd.Name))
+			return true
+		}
+
+		rev := cmd.Name == "XREVRANGE"
+
+		/* XREVRANGE takes end before start */
+		startArg, endArg := cmd.Args[1], cmd.Args[2]
+		if rev == true {
+			startArg, endArg = endArg, startArg
+		}
+
+		start, err := parseStreamRangeID(startArg, false)
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		end, err := parseStreamRangeID(endArg, true)
+
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		var count int
+		if len(cmd.Args) == 5 {
+			count, err = strconv.Atoi(cmd.Args[4])
+			if strings.ToUpper(cmd.Args[3]) != "COUNT" || err != nil || count < 0 {
+				client.sendError(fmt.Errorf("%s syntax error", cmd.Name))
+				return true
+			}
+
+			/* COUNT 0 returns nothing */
+			if count == 0 {
+				client.sendArray([]string{})
+				return true
+			}
+		}
+
+		entries, errRet := client.store.XRANGE(cmd.Args[0], start, end, count, rev)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.sendArray(streamReply(entries))
+
+	case "XLEN":
+		if len(cmd.Args) != 1 {
+			client.sendError(fmt.Errorf("XLEN expects 1 argument"))
+			return true
+		}
+
+		length, errRet := client.store.XLEN(cmd.Args[0])
+
+		if errRet == nil {
+			client.send(strconv.Itoa(length))
+		} else {
+			client.send(strconv.Itoa(0))
+			fmt.Println(errRet)
+		}
+
+	case "XTRIM":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("XTRIM wrong number of arguments"))
+			return true
+		}
+
+		var trim streamTrim
+
+		i, err := parseStreamTrimArgs(cmd.Args, 1, &trim)
+
+		if err == nil && i != len(cmd.Args) {
+			err = fmt.Errorf("syntax error")
+		}
+
+		if err != nil {
+			client.sendError(fmt.Errorf("XTRIM %s", err))
+			return true
+		}
+
+		removed, errRet := client.store.XTRIM(cmd.Args[0], &trim)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.send(strconv.Itoa(removed))
+
+	case "XDEL":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("XDEL expects minimum 2 arguments"))
+			return true
+		}
+
+		ids := make([]streamID, 0, len(cmd.Args) - 1)
+		for _, arg := range cmd.Args[1:] {
+			id, err := parseStreamID(arg, 0)
+			if err != nil {
+				client.sendError(fmt.Errorf("XDEL %s", err))
+				return true
+			}
+			ids = append(ids, id)
+		}
+
+		deleted, errRet := client.store.XDEL(cmd.Args[0], ids)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.send(strconv.Itoa(deleted))
+
+	case "XREAD":
+		var count int
+		var timeout time.Duration
+		block := false
+
+		i := 0
+		for ; i + 1 < len(cmd.Args); i = i + 2 {
+			opt := strings.ToUpper(cmd.Args[i])
+
+			if opt == "COUNT" {
+				count, err = strconv.Atoi(cmd.Args[i+1])
+				if err != nil || count < 0 {
+					client.sendError(fmt.Errorf("XREAD value is not an integer or out of range"))
+					return true
+				}
+			} else if opt == "BLOCK" {
+				ms, err := strconv.ParseInt(cmd.Args[i+1], 10, 64)
+				if err != nil || ms < 0 {
+					client.sendError(fmt.Errorf("XREAD timeout is not an integer or out of range"))
+					return true
+				}
+				timeout = time.Duration(ms) * time.Millisecond
+				block = true
+			} else {
+				break
+			}
+		}
+
+		/* STREAMS key [key ...] id [id ...] */
+		if i >= len(cmd.Args) || strings.ToUpper(cmd.Args[i]) != "STREAMS" || (len(cmd.Args) - i - 1) == 0 || (len(cmd.Args) - i - 1) % 2 != 0 {
+			client.sendError(fmt.Errorf("XREAD syntax error"))
+			return true
+		}
+
+		numkeys := (len(cmd.Args) - i - 1) / 2
+		keys := cmd.Args[i+1 : i+1+numkeys]
+		ids := make([]streamID, numkeys)
+
+		/* $ is the last ID at the time of the call, only entries added after the call are read */
+		for k, arg := range cmd.Args[i+1+numkeys:] {
+			if arg == "$" {
+				ids[k] = client.store.streamLastID(keys[k])
+				continue
+			}
+
+			ids[k], err = parseStreamID(arg, 0)
+			if err != nil {
+				client.sendError(fmt.Errorf("XREAD %s", err))
+				return true
+			}
+		}
+
+		/* Read on behalf of the client, run now and by XADD on keys while client is blocked */
+		serve := func() ([]string, bool) {
+			result, errRet := client.store.XREAD(keys, ids, count)
+			if errRet != nil {
+				return nil, false
+			}
+
+			vals := []string{}
+			for k, entries := range result {
+				if len(entries) > 0 {
+					vals = append(vals, keys[k])
+					vals = append(vals, streamReply(entries)...)
+				}
+			}
+			return vals, len(vals) > 0
+		}
+
+		var reply []string
+		var ok bool
+
+		if block == true {
+			reply, ok = client.block(blockStream, keys, timeout, serve)
+		} else {
+			reply, ok = serve()
+		}
+
+		if ok == true {
+			client.sendArray(reply)
+		} else {
+			client.send("(nil)")
+		}
+
+	case "XGROUP":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("XGROUP expects minimum 3 arguments"))
+			return true
+		}
+
+		key, name := cmd.Args[1], cmd.Args[2]
+
+		switch strings.ToUpper(cmd.Args[0]) {
+		case "CREATE", "SETID":
+			/* CREATE key group id|$ [MKSTREAM], SETID key group id|$ */
+			if len(cmd.Args) < 4 {
+				client.sendError(fmt.Errorf("XGROUP %s expects minimum 4 arguments", strings.ToUpper(cmd.Args[0])))
+				return true
+			}
+
+			mkStream := false
+			for _, opt := range cmd.Args[4:] {
+				if strings.ToUpper(opt) == "MKSTREAM" && strings.ToUpper(cmd.Args[0]) == "CREATE" {
+					mkStream = true
+				} else {
+					client.sendError(fmt.Errorf("XGROUP syntax error"))
+					return true
+				}
+			}
+
+			if strings.ToUpper(cmd.Args[0]) == "CREATE" {
+				err = client.store.XGROUPCREATE(key, name, cmd.Args[3], mkStream)
+			} else {
+				err = client.store.XGROUPSETID(key, name, cmd.Args[3])
+			}
+
+			if err != nil {
+				client.sendError(err)
+			} else {
+				client.send("+OK")
+			}
+
+		case "DESTROY":
+			if len(cmd.Args) != 3 {
+				client.sendError(fmt.Errorf("XGROUP DESTROY expects 3 arguments"))
+				return true
+			}
+
+			destroyed, errRet := client.store.XGROUPDESTROY(key, name)
+			if errRet != nil {
+				client.sendError(errRet)
+			} else if destroyed == true {
+				client.send("1")
+			} else {
+				client.send("0")
+			}
+
+		case "CREATECONSUMER":
+			if len(cmd.Args) != 4 {
+				client.sendError(fmt.Errorf("XGROUP CREATECONSUMER expects 4 arguments"))
+				return true
+			}
+
+			created, errRet := client.store.XGROUPCREATECONSUMER(key, name, cmd.Args[3])
+			if errRet != nil {
+				client.sendError(errRet)
+			} else if created == true {
+				client.send("1")
+			} else {
+				client.send("0")
+			}
+
+		case "DELCONSUMER":
+			if len(cmd.Args) != 4 {
+				client.sendError(fmt.Errorf("XGROUP DELCONSUMER expects 4 arguments"))
+				return true
+			}
+
+			pending, errRet := client.store.XGROUPDELCONSUMER(key, name, cmd.Args[3])
+			if errRet != nil {
+				client.sendError(errRet)
+			} else {
+				client.send(strconv.Itoa(pending))
+			}
+
+		default:
+			client.sendError(fmt.Errorf("XGROUP unknown subcommand %s", cmd.Args[0]))
+		}
+
+	case "XREADGROUP":
+		/* GROUP group consumer [COUNT count] [BLOCK ms] [NOACK] STREAMS key [key ...] id [id ...] */
+		if len(cmd.Args) < 3 || strings.ToUpper(cmd.Args[0]) != "GROUP" {
+			client.sendError(fmt.Errorf("XREADGROUP syntax error"))
+			return true
+		}
+
+		name, consumer := cmd.Args[1], cmd.Args[2]
+
+		var count int
+		var timeout time.Duration
+		block := false
+		noack := false
+
+		i := 3
+		for ; i < len(cmd.Args); i++ {
+			opt := strings.ToUpper(cmd.Args[i])
+
+			if opt == "NOACK" {
+				noack = true
+			} else if opt == "COUNT" && i + 1 < len(cmd.Args) {
+				i++
+				count, err = strconv.Atoi(cmd.Args[i])
+				if err != nil || count < 0 {
+					client.sendError(fmt.Errorf("XREADGROUP value is not an integer or out of range"))
+					return true
+				}
+			} else if opt == "BLOCK" && i + 1 < len(cmd.Args) {
+				i++
+				ms, err := strconv.ParseInt(cmd.Args[i], 10, 64)
+				if err != nil || ms < 0 {
+					client.sendError(fmt.Errorf("XREADGROUP timeout is not an integer or out of range"))
+					return true
+				}
+				timeout = time.Duration(ms) * time.Millisecond
+				block = true
+			} else {
+				break
+			}
+		}
+
+		if i >= len(cmd.Args) || strings.ToUpper(cmd.Args[i]) != "STREAMS" || (len(cmd.Args) - i - 1) == 0 || (len(cmd.Args) - i - 1) % 2 != 0 {
+			client.sendError(fmt.Errorf("XREADGROUP syntax error"))
+			return true
+		}
+
+		numkeys := (len(cmd.Args) - i - 1) / 2
+		keys := cmd.Args[i+1 : i+1+numkeys]
+		ids := make([]streamID, numkeys)
+		newOnly := make([]bool, numkeys)
+
+		/* > reads entries never delivered to the group, an ID reads the pending entries of the consumer after the ID */
+		for k, arg := range cmd.Args[i+1+numkeys:] {
+			if arg == ">" {
+				newOnly[k] = true
+				continue
+			}
+
+			ids[k], err = parseStreamID(arg, 0)
+			if err != nil {
+				client.sendError(fmt.Errorf("XREADGROUP %s", err))
+				return true
+			}
+
+			/* Reading the pending entries never blocks */
+			block = false
+		}
+
+		/* Read on behalf of the client, run now and by XADD on keys while client is blocked. An error is a reply as well */
+		var serveErr error
+		serve := func() ([]string, bool) {
+			result, errRet := client.store.XREADGROUP(name, consumer, keys, ids, newOnly, count, noack)
+			if errRet != nil {
+				serveErr = errRet
+				return nil, true
+			}
+
+			vals := []string{}
+			for k, entries := range result {
+				if len(entries) > 0 {
+					vals = append(vals, keys[k])
+					vals = append(vals, streamReply(entries)...)
+				}
+			}
+			return vals, len(vals) > 0
+		}
+
+		var reply []string
+		var ok bool
+
+		if block == true {
+			reply, ok = client.block(blockStream, keys, timeout, serve)
+		} else {
+			reply, ok = serve()
+		}
+
+		if serveErr != nil {
+			client.sendError(serveErr)
+		} else if ok == true {
+			client.sendArray(reply)
+		} else {
+			client.send("(nil)")
+		}
+
+	case "XACK":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("XACK expects minimum 3 arguments"))
+			return true
+		}
+
+		ids := make([]streamID, 0, len(cmd.Args) - 2)
+		for _, arg := range cmd.Args[2:] {
+			id, err := parseStreamID(arg, 0)
+			if err != nil {
+				client.sendError(fmt.Errorf("XACK %s", err))
+				return true
+			}
+			ids = append(ids, id)
+		}
+
+		acked, errRet := client.store.XACK(cmd.Args[0], cmd.Args[1], ids)
+
+		if errRet != nil {
+			fmt.Println(errRet)
+		}
+
+		client.send(strconv.Itoa(acked))
+
+	case "XPENDING":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("XPENDING expects minimum 2 arguments"))
+			return true
+		}
+
+		key, name := cmd.Args[0], cmd.Args[1]
+
+		/* Summary form, number of pending entries, lowest and highest ID and pending entries per consumer */
+		if len(cmd.Args) == 2 {
+			count, bounds, consumers, counts, errRet := client.store.XPENDINGSUMMARY(key, name)
+			if errRet != nil {
+				client.sendError(errRet)
+				return true
+			}
+
+			vals := []string{strconv.Itoa(count)}
+			if count == 0 {
+				vals = append(vals, "(nil)", "(nil)", "(nil)")
+			} else {
+				vals = append(vals, bounds[0].String(), bounds[1].String())
+				for k, c := range consumers {
+					vals = append(vals, c, strconv.Itoa(counts[k]))
+				}
+			}
+			client.sendArray(vals)
+			return true
+		}
+
+		/* Extended form, [IDLE min-idle-time] start end count [consumer] */
+		var minIdle int64
+		args := cmd.Args[2:]
+
+		if strings.ToUpper(args[0]) == "IDLE" {
+			if len(args) < 2 {
+				client.sendError(fmt.Errorf("XPENDING syntax error"))
+				return true
+			}
+
+			minIdle, err = strconv.ParseInt(args[1], 10, 64)
+			if err != nil || minIdle < 0 {
+				client.sendError(fmt.Errorf("XPENDING min-idle-time is not an integer or out of range"))
+				return true
+			}
+			args = args[2:]
+		}
+
+		if len(args) != 3 && len(args) != 4 {
+			client.sendError(fmt.Errorf("XPENDING syntax error"))
+			return true
+		}
+
+		start, errStart := parseStreamRangeID(args[0], false)
+		end, errEnd := parseStreamRangeID(args[1], true)
+		if errStart != nil || errEnd != nil {
+			client.sendError(fmt.Errorf("XPENDING Invalid stream ID specified as stream command argument"))
+			return true
+		}
+
+		count, err := strconv.Atoi(args[2])
+		if err != nil || count < 0 {
+			client.sendError(fmt.Errorf("XPENDING value is not an integer or out of range"))
+			return true
+		}
+
+		consumer := ""
+		if len(args) == 4 {
+			consumer = args[3]
+		}
+
+		ids, nacks, errRet := client.store.XPENDING(key, name, start, end, count, consumer, minIdle)
+		if errRet != nil {
+			client.sendError(errRet)
+			return true
+		}
+
+		/* Per entry, ID, consumer, idle time in ms and number of deliveries */
+		now := mstime()
+		vals := []string{}
+		for k, id := range ids {
+			vals = append(vals, id.String(), nacks[k].consumer.name, fmt.Sprint(now - nacks[k].deliveryTime), fmt.Sprint(nacks[k].deliveryCount))
+		}
+
+		client.sendArray(vals)
+
+	case "XCLAIM":
+		/* key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-ms] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID id] */
+		if len(cmd.Args) < 5 {
+			client.sendError(fmt.Errorf("XCLAIM expects minimum 5 arguments"))
+			return true
+		}
+
+		args := &xclaimArgs{idle: -1, time: -1, retryCount: -1}
+
+		args.minIdle, err = strconv.ParseInt(cmd.Args[3], 10, 64)
+		if err != nil {
+			client.sendError(fmt.Errorf("XCLAIM Invalid min-idle-time argument for XCLAIM"))
+			return true
+		}
+
+		i := 4
+		for ; i < len(cmd.Args); i++ {
+			id, err := parseStreamID(cmd.Args[i], 0)
+			if err != nil {
+				break
+			}
+			args.ids = append(args.ids, id)
+		}
+
+		if len(args.ids) == 0 {
+			client.sendError(fmt.Errorf("XCLAIM Invalid stream ID specified as stream command argument"))
+			return true
+		}
+
+		for ; i < len(cmd.Args); i++ {
+			opt := strings.ToUpper(cmd.Args[i])
+
+			if opt == "FORCE" {
+				args.force = true
+				continue
+			}
+			if opt == "JUSTID" {
+				args.justID = true
+				continue
+			}
+
+			if i + 1 >= len(cmd.Args) {
+				client.sendError(fmt.Errorf("XCLAIM syntax error"))
+				return true
+			}
+			i++
+
+			var val int64
+			if opt == "LASTID" {
+				id, err := parseStreamID(cmd.Args[i], 0)
+				if err != nil {
+					client.sendError(fmt.Errorf("XCLAIM %s", err))
+					return true
+				}
+				args.lastID = &id
+				continue
+			}
+
+			val, err = strconv.ParseInt(cmd.Args[i], 10, 64)
+			if err != nil || val < 0 {
+				client.sendError(fmt.Errorf("XCLAIM Invalid %s argument for XCLAIM", opt))
+				return true
+			}
+
+			if opt == "IDLE" {
+				args.idle = val
+			} else if opt == "TIME" {
+				args.time = val
+			} else if opt == "RETRYCOUNT" {
+				args.retryCount = val
+			} else {
+				client.sendError(fmt.Errorf("XCLAIM syntax error"))
+				return true
+			}
+		}
+
+		claimed, errRet := client.store.XCLAIM(cmd.Args[0], cmd.Args[1], cmd.Args[2], args)
+		if errRet != nil {
+			client.sendError(errRet)
+			return true
+		}
+
+		if args.justID == true {
+			vals := []string{}
+			for _, entry := range claimed {
+				vals = append(vals, entry.id.String())
+			}
+			client.sendArray(vals)
+		} else {
+			client.sendArray(streamReply(claimed))
+		}
+
+	case "XAUTOCLAIM":
+		/* key group consumer min-idle-time start [COUNT count] [JUSTID] */
+		if len(cmd.Args) < 5 {
+			client.sendError(fmt.Errorf("XAUTOCLAIM expects minimum 5 arguments"))
+			return true
+		}
+
+		minIdle, err := strconv.ParseInt(cmd.Args[3], 10, 64)
+		if err != nil || minIdle < 0 {
+			client.sendError(fmt.Errorf("XAUTOCLAIM Invalid min-idle-time argument for XAUTOCLAIM"))
+			return true
+		}
+
+		start, err := parseStreamRangeID(cmd.Args[4], false)
+		if err != nil {
+			client.sendError(fmt.Errorf("XAUTOCLAIM %s", err))
+			return true
+		}
+
+		count := 100
+		justID := false
+
+		for i := 5; i < len(cmd.Args); i++ {
+			opt := strings.ToUpper(cmd.Args[i])
+
+			if opt == "JUSTID" {
+				justID = true
+			} else if opt == "COUNT" && i + 1 < len(cmd.Args) {
+				i++
+				count, err = strconv.Atoi(cmd.Args[i])
+				if err != nil || count < 1 {
+					client.sendError(fmt.Errorf("XAUTOCLAIM COUNT must be > 0"))
+					return true
+				}
+			} else {
+				client.sendError(fmt.Errorf("XAUTOCLAIM syntax error"))
+				return true
+			}
+		}
+
+		next, claimed, deleted, errRet := client.store.XAUTOCLAIM(cmd.Args[0], cmd.Args[1], cmd.Args[2], minIdle, start, count, justID)
+		if errRet != nil {
+			client.sendError(errRet)
+			return true
+		}
+
+		/* Next start ID, the claimed entries and the IDs no longer in the stream */
+		client.send(next.String())
+
+		if justID == true {
+			vals := []string{}
+			for _, entry := range claimed {
+				vals = append(vals, entry.id.String())
+			}
+			client.sendArray(vals)
+		} else {
+			client.sendArray(streamReply(claimed))
+		}
+
+		vals := []string{}
+		for _, id := range deleted {
+			vals = append(vals, id.String())
+		}
+		client.sendArray(vals)
+
+	case "XINFO":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("XINFO expects minimum 2 arguments"))
+			return true
+		}
+
+		var vals []string
+		var errRet error
+
+		switch strings.ToUpper(cmd.Args[0]) {
+		case "STREAM":
+			vals, errRet = client.store.XINFOSTREAM(cmd.Args[1])
+		case "GROUPS":
+			vals, errRet = client.store.XINFOGROUPS(cmd.Args[1])
+		case "CONSUMERS":
+			if len(cmd.Args) != 3 {
+				client.sendError(fmt.Errorf("XINFO CONSUMERS expects 3 arguments"))
+				return true
+			}
+			vals, errRet = client.store.XINFOCONSUMERS(cmd.Args[1], cmd.Args[2])
+		default:
+			client.sendError(fmt.Errorf("XINFO unknown subcommand %s", cmd.Args[0]))
+			return true
+		}
+
+		if errRet != nil {
+			client.sendError(errRet)
+		} else {
+			client.sendArray(vals)
+		}
+
+	case "GEOADD":
+		if len(cmd.Args) < 4 {
+			client.sendError(fmt.Errorf("GEOADD expects minimum 4 arguments"))
+			return true
+		}
+
+		/* Options NX|XX CH come before the longitude latitude member triples */
+		var flags int = 0
+		var i int = 1
+		for ; i < len(cmd.Args); i++ {
+			opt := strings.ToUpper(cmd.Args[i])
+			if opt == "NX" {
+				flags |= zaddNX
+			} else if opt == "XX" {
+				flags |= zaddXX
+			} else if opt == "CH" {
+				flags |= zaddCH
+			} else {
+				break
+			}
+		}
+
+		if (len(cmd.Args) - i) == 0 || (len(cmd.Args) - i) % 3 != 0 {
+			client.sendError(fmt.Errorf("GEOADD expects triples of longitude-latitude-member in arguments"))
+			return true
+		}
+
+		if err := checkZaddFlags(flags, (len(cmd.Args) - i) / 3); err != nil {
+			client.sendError(fmt.Errorf("GEOADD %s", err))
+			return true
+		}
+
+		/* The score of a member is the geohash of its position */
+		items := make([]zsetItem, 0, (len(cmd.Args) - i) / 3)
+
+		for ; i + 2 < len(cmd.Args); i = i + 3 {
+			lon, lat, err := geoParseLonLat(cmd.Args[i], cmd.Args[i+1])
+			if err != nil {
+				client.sendError(fmt.Errorf("GEOADD %s", err))
+				return true
+			}
+
+			items = append(items, zsetItem{member: cmd.Args[i+2], score: float64(geohashEncode(lon, lat, geoStepMax))})
+		}
+
+		memberAdded, _, errRet := client.store.ZADD(cmd.Args[0], items, flags)
+
+		if errRet != nil {
+			client.sendError(errRet)
+		} else {
+			client.send(strconv.Itoa(memberAdded))
+		}
+
+	case "GEODIST":
+		if len(cmd.Args) != 3 && len(cmd.Args) != 4 {
+			client.sendError(fmt.Errorf("GEODIST expects 3 or 4 arguments"))
+			return true
+		}
+
+		unit := 1.0
+		if len(cmd.Args) == 4 {
+			unit, err = geoParseUnit(cmd.Args[3])
+			if err != nil {
+				client.sendError(fmt.Errorf("GEODIST %s", err))
+				return true
+			}
+		}
+
+		scores, found, _ := client.store.ZMSCORE(cmd.Args[0], cmd.Args[1:3])
+
+		if found[0] == false || found[1] == false {
+			client.send("(nil)")
+			return true
+		}
+
+		lon1, lat1 := geohashDecode(scores[0])
+		lon2, lat2 := geohashDecode(scores[1])
+
+		client.send(geoFormatDistance(geoDistance(lon1, lat1, lon2, lat2), unit))
+
+	case "GEOPOS", "GEOHASH":
+		if len(cmd.Args) < 1 {
+			client.sendError(fmt.Errorf("%s expects minimum 1 argument", cmd.Name))
+			return true
+		}
+
+		scores, found, _ := client.store.ZMSCORE(cmd.Args[0], cmd.Args[1:])
+
+		/* GEOPOS replies longitude and latitude of every member, GEOHASH the geohash string */
+		vals := []string{}
+		for i, score := range scores {
+			if found[i] == false {
+				vals = append(vals, "(nil)")
+			} else if cmd.Name == "GEOHASH" {
+				vals = append(vals, geohashString(score))
+			} else {
+				lon, lat := geohashDecode(score)
+				vals = append(vals, geoFormatCoord(lon), geoFormatCoord(lat))
+			}
+		}
+
+		client.sendArray(vals)
+
+	case "GEOSEARCH":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("GEOSEARCH expects minimum 2 arguments"))
+			return true
+		}
+
+		search, err := parseGeoSearchArgs(cmd.Name, cmd.Args[1:], false)
+		if err != nil {
+			client.sendError(fmt.Errorf("GEOSEARCH %s", err))
+			return true
+		}
+
+		points, errRet := client.store.GEOSEARCH(cmd.Args[0], search)
+
+		if errRet != nil {
+			client.sendError(errRet)
+		} else {
+			client.sendArray(geoSearchReply(search, points))
+		}
+
+	case "GEOSEARCHSTORE":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("GEOSEARCHSTORE expects minimum 3 arguments"))
+			return true
+		}
+
+		search, err := parseGeoSearchArgs(cmd.Name, cmd.Args[2:], true)
+		if err != nil {
+			client.sendError(fmt.Errorf("GEOSEARCHSTORE %s", err))
+			return true
+		}
+
+		stored, errRet := client.store.GEOSEARCHSTORE(cmd.Args[0], cmd.Args[1], search)
+
+		if errRet != nil {
+			client.sendError(errRet)
+		} else {
+			client.send(strconv.Itoa(stored))
+		}
+
+	case "JSON.SET":
+		if len(cmd.Args) != 3 && len(cmd.Args) != 4 {
+			client.sendError(fmt.Errorf("JSON.SET expects 3 or 4 arguments"))
+			return true
+		}
+
+		nx, xx := false, false
+		if len(cmd.Args) == 4 {
+			opt := strings.ToUpper(cmd.Args[3])
+			if opt == "NX" {
+				nx = true
+			} else if opt == "XX" {
+				xx = true
+			} else {
+				client.sendError(fmt.Errorf("JSON.SET syntax error"))
+				return true
+			}
+		}
+
+		set, errRet := client.store.JSONSET(cmd.Args[0], cmd.Args[1], cmd.Args[2], nx, xx)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("JSON.SET %s", errRet))
+		} else if set == true {
+			client.send("+OK")
+		} else {
+			client.send("(nil)")
+		}
+
+	case "JSON.GET":
+		if len(cmd.Args) < 1 {
+			client.sendError(fmt.Errorf("JSON.GET expects minimum 1 argument"))
+			return true
+		}
+
+		text, errRet := client.store.JSONGET(cmd.Args[0], cmd.Args[1:])
+
+		if errRet != nil && errRet.Error() == fmt.Sprint("key ", cmd.Args[0], " not found") {
+			client.send("(nil)")
+		} else if errRet != nil {
+			client.sendError(fmt.Errorf("JSON.GET %s", errRet))
+		} else {
+			client.send(text)
+		}
+
+	case "JSON.DEL":
+		if len(cmd.Args) != 1 && len(cmd.Args) != 2 {
+			client.sendError(fmt.Errorf("JSON.DEL expects 1 or 2 arguments"))
+			return true
+		}
+
+		path := "$"
+		if len(cmd.Args) == 2 {
+			path = cmd.Args[1]
+		}
+
+		deleted, errRet := client.store.JSONDEL(cmd.Args[0], path)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("JSON.DEL %s", errRet))
+		} else {
+			client.send(strconv.Itoa(deleted))
+		}
+
+	case "JSON.NUMINCRBY":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("JSON.NUMINCRBY expects 3 arguments"))
+			return true
+		}
+
+		results, legacy, errRet := client.store.JSONNUMINCRBY(cmd.Args[0], cmd.Args[1], cmd.Args[2])
+
+		/* A $ path replies a JSON array of the new values, null for the values which are not numbers */
+		if errRet != nil {
+			client.sendError(fmt.Errorf("JSON.NUMINCRBY %s", errRet))
+		} else if legacy == true {
+			client.send(jsonMarshal(results[0]))
+		} else {
+			client.send(jsonMarshal(results))
+		}
+
+	case "JSON.ARRAPPEND":
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("JSON.ARRAPPEND expects minimum 3 arguments"))
+			return true
+		}
+
+		lengths, legacy, errRet := client.store.JSONARRAPPEND(cmd.Args[0], cmd.Args[1], cmd.Args[2:])
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("JSON.ARRAPPEND %s", errRet))
+			return true
+		}
+
+		if legacy == true {
+			client.send(strconv.Itoa(lengths[0]))
+			return true
+		}
+
+		vals := []string{}
+		for _, length := range lengths {
+			if length < 0 {
+				vals = append(vals, "(nil)")
+			} else {
+				vals = append(vals, strconv.Itoa(length))
+			}
+		}
+
+		client.sendArray(vals)
+
+	case "JSON.TYPE":
+		if len(cmd.Args) != 1 && len(cmd.Args) != 2 {
+			client.sendError(fmt.Errorf("JSON.TYPE expects 1 or 2 arguments"))
+			return true
+		}
+
+		path := "."
+		if len(cmd.Args) == 2 {
+			path = cmd.Args[1]
+		}
+
+		types, legacy, errRet := client.store.JSONTYPE(cmd.Args[0], path)
+
+		if errRet != nil && errRet.Error() == fmt.Sprint("key ", cmd.Args[0], " not found") {
+			client.send("(nil)")
+		} else if errRet != nil {
+			client.sendError(fmt.Errorf("JSON.TYPE %s", errRet))
+		} else if legacy == true && len(types) == 0 {
+			client.send("(nil)")
+		} else if legacy == true {
+			client.send(types[0])
+		} else {
+			client.sendArray(types)
+		}
+
+	case "BF.RESERVE":
+		/* key error_rate capacity [EXPANSION expansion] [NONSCALING] */
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("BF.RESERVE expects minimum 3 arguments"))
+			return true
+		}
+
+		errorRate, err := strconv.ParseFloat(cmd.Args[1], 64)
+		if err != nil || errorRate <= 0 || errorRate >= 1 {
+			client.sendError(fmt.Errorf("BF.RESERVE error rate should be between 0 and 1"))
+			return true
+		}
+
+		capacity, err := strconv.ParseInt(cmd.Args[2], 10, 64)
+		if err != nil || capacity <= 0 || capacity > bloomMaxCapacity {
+			client.sendError(fmt.Errorf("BF.RESERVE capacity should be larger than 0 and at most %d", bloomMaxCapacity))
+			return true
+		}
+
+		var expansion int64 = bloomDefaultExpansion
+		nonScaling := false
+
+		for i := 3; i < len(cmd.Args); i++ {
+			opt := strings.ToUpper(cmd.Args[i])
+
+			if opt == "NONSCALING" {
+				nonScaling = true
+			} else if opt == "EXPANSION" && i + 1 < len(cmd.Args) {
+				i++
+				expansion, err = strconv.ParseInt(cmd.Args[i], 10, 64)
+				if err != nil || expansion < 1 {
+					client.sendError(fmt.Errorf("BF.RESERVE expansion should be greater or equal to 1"))
+					return true
+				}
+			} else {
+				client.sendError(fmt.Errorf("BF.RESERVE syntax error"))
+				return true
+			}
+		}
+
+		errRet := client.store.BFRESERVE(cmd.Args[0], errorRate, capacity, expansion, nonScaling)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("BF.RESERVE %s", errRet))
+		} else {
+			client.send("+OK")
+		}
+
+	case "BF.ADD", "BF.MADD":
+		if len(cmd.Args) < 2 || (cmd.Name == "BF.ADD" && len(cmd.Args) != 2) {
+			client.sendError(fmt.Errorf("%s expects key and item arguments", cmd.Name))
+			return true
+		}
+
+		added, errRet := client.store.BFADD(cmd.Args[0], cmd.Args[1:])
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, errRet))
+			return true
+		}
+
+		/* 1 for a new item, 0 for an item which may already be in */
+		vals := make([]string, len(added))
+		for i, ok := range added {
+			if ok == true {
+				vals[i] = "1"
+			} else {
+				vals[i] = "0"
+			}
+		}
+
+		if cmd.Name == "BF.ADD" {
+			client.send(vals[0])
+		} else {
+			client.sendArray(vals)
+		}
+
+	case "BF.EXISTS", "BF.MEXISTS", "CF.EXISTS", "CF.MEXISTS":
+		if len(cmd.Args) < 2 || ((cmd.Name == "BF.EXISTS" || cmd.Name == "CF.EXISTS") && len(cmd.Args) != 2) {
+			client.sendError(fmt.Errorf("%s expects key and item arguments", cmd.Name))
+			return true
+		}
+
+		var found []bool
+		var errRet error
+
+		if strings.HasPrefix(cmd.Name, "BF.") {
+			found, errRet = client.store.BFEXISTS(cmd.Args[0], cmd.Args[1:])
+		} else {
+			found, errRet = client.store.CFEXISTS(cmd.Args[0], cmd.Args[1:])
+		}
+
+		if errRet != nil {
+			client.sendError(errRet)
+			return true
+		}
+
+		/* 1 for an item which may be in, 0 for an item surely not in */
+		vals := make([]string, len(found))
+		for i, ok := range found {
+			if ok == true {
+				vals[i] = "1"
+			} else {
+				vals[i] = "0"
+			}
+		}
+
+		if cmd.Name == "BF.EXISTS" || cmd.Name == "CF.EXISTS" {
+			client.send(vals[0])
+		} else {
+			client.sendArray(vals)
+		}
+
+	case "CF.RESERVE":
+		/* key capacity [BUCKETSIZE bucketsize] [MAXITERATIONS maxiterations] [EXPANSION expansion] */
+		if len(cmd.Args) < 2 || len(cmd.Args) % 2 != 0 {
+			client.sendError(fmt.Errorf("CF.RESERVE expects key capacity and option value pairs"))
+			return true
+		}
+
+		capacity, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+		if err != nil || capacity <= 0 || capacity > cuckooMaxCapacity {
+			client.sendError(fmt.Errorf("CF.RESERVE capacity should be larger than 0 and at most %d", cuckooMaxCapacity))
+			return true
+		}
+
+		bucketSize := cuckooDefaultBucketSize
+		maxIterations := cuckooDefaultMaxIterations
+		var expansion int64 = cuckooDefaultExpansion
+
+		for i := 2; i + 1 < len(cmd.Args); i = i + 2 {
+			opt := strings.ToUpper(cmd.Args[i])
+			val, err := strconv.ParseInt(cmd.Args[i+1], 10, 64)
+
+			if opt == "BUCKETSIZE" && err == nil && val >= 1 && val <= 255 {
+				bucketSize = int(val)
+			} else if opt == "MAXITERATIONS" && err == nil && val >= 1 && val <= 65535 {
+				maxIterations = int(val)
+			} else if opt == "EXPANSION" && err == nil && val >= 0 && val <= 32768 {
+				expansion = val
+			} else {
+				client.sendError(fmt.Errorf("CF.RESERVE invalid option %s %s", cmd.Args[i], cmd.Args[i+1]))
+				return true
+			}
+		}
+
+		errRet := client.store.CFRESERVE(cmd.Args[0], capacity, bucketSize, maxIterations, expansion)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("CF.RESERVE %s", errRet))
+		} else {
+			client.send("+OK")
+		}
+
+	case "CF.ADD":
+		if len(cmd.Args) != 2 {
+			client.sendError(fmt.Errorf("CF.ADD expects 2 arguments"))
+			return true
+		}
+
+		errRet := client.store.CFADD(cmd.Args[0], cmd.Args[1])
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("CF.ADD %s", errRet))
+		} else {
+			client.send("1")
+		}
+
+	case "CF.DEL":
+		if len(cmd.Args) != 2 {
+			client.sendError(fmt.Errorf("CF.DEL expects 2 arguments"))
+			return true
+		}
+
+		deleted, errRet := client.store.CFDEL(cmd.Args[0], cmd.Args[1])
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("CF.DEL %s", errRet))
+		} else if deleted == true {
+			client.send("1")
+		} else {
+			client.send("0")
+		}
+
+	case "CMS.INITBYDIM":
+		if len(cmd.Args) != 3 {
+			client.sendError(fmt.Errorf("CMS.INITBYDIM expects 3 arguments"))
+			return true
+		}
+
+		width, err := strconv.ParseUint(cmd.Args[1], 10, 64)
+		if err != nil || width == 0 {
+			client.sendError(fmt.Errorf("CMS.INITBYDIM invalid width"))
+			return true
+		}
+
+		depth, err := strconv.ParseUint(cmd.Args[2], 10, 64)
+		if err != nil || depth == 0 {
+			client.sendError(fmt.Errorf("CMS.INITBYDIM invalid depth"))
+			return true
+		}
+
+		errRet := client.store.CMSINITBYDIM(cmd.Args[0], width, depth)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("CMS.INITBYDIM %s", errRet))
+		} else {
+			client.send("+OK")
+		}
+
+	case "CMS.INCRBY":
+		/* key item increment [item increment ...] */
+		if len(cmd.Args) < 3 || len(cmd.Args) % 2 != 1 {
+			client.sendError(fmt.Errorf("CMS.INCRBY expects key and item increment pairs"))
+			return true
+		}
+
+		items := []string{}
+		incrs := []uint64{}
+
+		for i := 1; i + 1 < len(cmd.Args); i = i + 2 {
+			incr, err := strconv.ParseUint(cmd.Args[i+1], 10, 64)
+			if err != nil {
+				client.sendError(fmt.Errorf("CMS.INCRBY cannot parse number"))
+				return true
+			}
+			items = append(items, cmd.Args[i])
+			incrs = append(incrs, incr)
+		}
+
+		counts, errRet := client.store.CMSINCRBY(cmd.Args[0], items, incrs)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("CMS.INCRBY %s", errRet))
+			return true
+		}
+
+		vals := make([]string, len(counts))
+		for i, c := range counts {
+			vals[i] = strconv.FormatUint(c, 10)
+		}
+		client.sendArray(vals)
+
+	case "CMS.QUERY":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("CMS.QUERY expects key and item arguments"))
+			return true
+		}
+
+		counts, errRet := client.store.CMSQUERY(cmd.Args[0], cmd.Args[1:])
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("CMS.QUERY %s", errRet))
+			return true
+		}
+
+		vals := make([]string, len(counts))
+		for i, c := range counts {
+			vals[i] = strconv.FormatUint(c, 10)
+		}
+		client.sendArray(vals)
+
+	case "CMS.MERGE":
+		/* destination numkeys source [source ...] [WEIGHTS weight [weight ...]] */
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("CMS.MERGE expects minimum 3 arguments"))
+			return true
+		}
+
+		numKeys, err := strconv.Atoi(cmd.Args[1])
+		if err != nil || numKeys < 1 || 2 + numKeys > len(cmd.Args) {
+			client.sendError(fmt.Errorf("CMS.MERGE invalid numkeys"))
+			return true
+		}
+
+		keys := cmd.Args[2 : 2 + numKeys]
+		rest := cmd.Args[2 + numKeys:]
+
+		weights := make([]uint64, numKeys)
+		for i := range weights {
+			weights[i] = 1
+		}
+
+		if len(rest) > 0 {
+			if strings.ToUpper(rest[0]) != "WEIGHTS" || len(rest) != numKeys + 1 {
+				client.sendError(fmt.Errorf("CMS.MERGE syntax error"))
+				return true
+			}
+
+			for i := range weights {
+				weights[i], err = strconv.ParseUint(rest[i+1], 10, 64)
+				if err != nil {
+					client.sendError(fmt.Errorf("CMS.MERGE cannot parse weight"))
+					return true
+				}
+			}
+		}
+
+		errRet := client.store.CMSMERGE(cmd.Args[0], keys, weights)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("CMS.MERGE %s", errRet))
+		} else {
+			client.send("+OK")
+		}
+
+	case "TOPK.RESERVE":
+		/* key topk [width depth decay] */
+		if len(cmd.Args) != 2 && len(cmd.Args) != 5 {
+			client.sendError(fmt.Errorf("TOPK.RESERVE expects 2 or 5 arguments"))
+			return true
+		}
+
+		k, err := strconv.Atoi(cmd.Args[1])
+		if err != nil || k < 1 {
+			client.sendError(fmt.Errorf("TOPK.RESERVE invalid k"))
+			return true
+		}
+
+		var width uint64 = topkDefaultWidth
+		var depth uint64 = topkDefaultDepth
+		decay := topkDefaultDecay
+
+		if len(cmd.Args) == 5 {
+			width, err = strconv.ParseUint(cmd.Args[2], 10, 64)
+			if err != nil || width == 0 {
+				client.sendError(fmt.Errorf("TOPK.RESERVE invalid width"))
+				return true
+			}
+
+			depth, err = strconv.ParseUint(cmd.Args[3], 10, 64)
+			if err != nil || depth == 0 {
+				client.sendError(fmt.Errorf("TOPK.RESERVE invalid depth"))
+				return true
+			}
+
+			decay, err = strconv.ParseFloat(cmd.Args[4], 64)
+			if err != nil || decay <= 0 || decay > 1 {
+				client.sendError(fmt.Errorf("TOPK.RESERVE decay should be larger than 0 and at most 1"))
+				return true
+			}
+		}
+
+		errRet := client.store.TOPKRESERVE(cmd.Args[0], k, width, depth, decay)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("TOPK.RESERVE %s", errRet))
+		} else {
+			client.send("+OK")
+		}
+
+	case "TOPK.ADD":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("TOPK.ADD expects key and item arguments"))
+			return true
+		}
+
+		expelled, found, errRet := client.store.TOPKADD(cmd.Args[0], cmd.Args[1:])
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("TOPK.ADD %s", errRet))
+			return true
+		}
+
+		/* Expelled item, or nil if nothing got expelled */
+		vals := make([]string, len(expelled))
+		for i := range expelled {
+			if found[i] == true {
+				vals[i] = expelled[i]
+			} else {
+				vals[i] = "(nil)"
+			}
+		}
+		client.sendArray(vals)
+
+	case "TOPK.QUERY":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("TOPK.QUERY expects key and item arguments"))
+			return true
+		}
+
+		found, errRet := client.store.TOPKQUERY(cmd.Args[0], cmd.Args[1:])
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("TOPK.QUERY %s", errRet))
+			return true
+		}
+
+		vals := make([]string, len(found))
+		for i, ok := range found {
+			if ok == true {
+				vals[i] = "1"
+			} else {
+				vals[i] = "0"
+			}
+		}
+		client.sendArray(vals)
+
+	case "TOPK.LIST":
+		/* key [WITHCOUNT] */
+		if len(cmd.Args) < 1 || len(cmd.Args) > 2 || (len(cmd.Args) == 2 && strings.ToUpper(cmd.Args[1]) != "WITHCOUNT") {
+			client.sendError(fmt.Errorf("TOPK.LIST expects key and optional WITHCOUNT"))
+			return true
+		}
+
+		items, errRet := client.store.TOPKLIST(cmd.Args[0])
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("TOPK.LIST %s", errRet))
+			return true
+		}
+
+		vals := []string{}
+		for _, item := range items {
+			vals = append(vals, item.item)
+			if len(cmd.Args) == 2 {
+				vals = append(vals, strconv.FormatUint(uint64(item.count), 10))
+			}
+		}
+		client.sendArray(vals)
+
+	case "TDIGEST.CREATE":
+		/* key [COMPRESSION compression] */
+		if len(cmd.Args) != 1 && (len(cmd.Args) != 3 || strings.ToUpper(cmd.Args[1]) != "COMPRESSION") {
+			client.sendError(fmt.Errorf("TDIGEST.CREATE expects key and optional COMPRESSION compression"))
+			return true
+		}
+
+		var compression float64 = tdigestDefaultCompression
+
+		if len(cmd.Args) == 3 {
+			val, err := strconv.ParseInt(cmd.Args[2], 10, 64)
+			if err != nil || val < 1 {
+				client.sendError(fmt.Errorf("TDIGEST.CREATE compression should be larger than 0"))
+				return true
+			}
+			compression = float64(val)
+		}
+
+		errRet := client.store.TDIGESTCREATE(cmd.Args[0], compression)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("TDIGEST.CREATE %s", errRet))
+		} else {
+			client.send("+OK")
+		}
+
+	case "TDIGEST.ADD", "TDIGEST.QUANTILE", "TDIGEST.CDF":
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("%s expects key and value arguments", cmd.Name))
+			return true
+		}
+
+		values := make([]float64, len(cmd.Args) - 1)
+		for i := range values {
+			v, err := strconv.ParseFloat(cmd.Args[i+1], 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				client.sendError(fmt.Errorf("%s value is not a valid float", cmd.Name))
+				return true
+			}
+			if cmd.Name == "TDIGEST.QUANTILE" && (v < 0 || v > 1) {
+				client.sendError(fmt.Errorf("TDIGEST.QUANTILE quantile should be in [0,1]"))
+				return true
+			}
+			values[i] = v
+		}
+
+		if cmd.Name == "TDIGEST.ADD" {
+			errRet := client.store.TDIGESTADD(cmd.Args[0], values)
+
+			if errRet != nil {
+				client.sendError(fmt.Errorf("TDIGEST.ADD %s", errRet))
+			} else {
+				client.send("+OK")
+			}
+			return true
+		}
+
+		var results []float64
+		var errRet error
+
+		if cmd.Name == "TDIGEST.QUANTILE" {
+			results, errRet = client.store.TDIGESTQUANTILE(cmd.Args[0], values)
+		} else {
+			results, errRet = client.store.TDIGESTCDF(cmd.Args[0], values)
+		}
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, errRet))
+			return true
+		}
+
+		vals := make([]string, len(results))
+		for i, v := range results {
+			vals[i] = formatDigestValue(v)
+		}
+		client.sendArray(vals)
+
+	case "TDIGEST.MERGE":
+		/* destination numkeys source [source ...] [COMPRESSION compression] [OVERRIDE] */
+		if len(cmd.Args) < 3 {
+			client.sendError(fmt.Errorf("TDIGEST.MERGE expects minimum 3 arguments"))
+			return true
+		}
+
+		numKeys, err := strconv.Atoi(cmd.Args[1])
+		if err != nil || numKeys < 1 || 2 + numKeys > len(cmd.Args) {
+			client.sendError(fmt.Errorf("TDIGEST.MERGE invalid numkeys"))
+			return true
+		}
+
+		keys := cmd.Args[2 : 2 + numKeys]
+		var compression float64
+		override := false
+
+		for i := 2 + numKeys; i < len(cmd.Args); i++ {
+			opt := strings.ToUpper(cmd.Args[i])
+
+			if opt == "OVERRIDE" {
+				override = true
+			} else if opt == "COMPRESSION" && i + 1 < len(cmd.Args) {
+				i++
+				val, err := strconv.ParseInt(cmd.Args[i], 10, 64)
+				if err != nil || val < 1 {
+					client.sendError(fmt.Errorf("TDIGEST.MERGE compression should be larger than 0"))
+					return true
+				}
+				compression = float64(val)
+			} else {
+				client.sendError(fmt.Errorf("TDIGEST.MERGE syntax error"))
+				return true
+			}
+		}
+
+		errRet := client.store.TDIGESTMERGE(cmd.Args[0], keys, compression, override)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("TDIGEST.MERGE %s", errRet))
+		} else {
+			client.send("+OK")
+		}
+
+	case "TDIGEST.MIN", "TDIGEST.MAX":
+		if len(cmd.Args) != 1 {
+			client.sendError(fmt.Errorf("%s expects 1 argument", cmd.Name))
+			return true
+		}
+
+		min, max, errRet := client.store.TDIGESTMINMAX(cmd.Args[0])
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, errRet))
+		} else if cmd.Name == "TDIGEST.MIN" {
+			client.send(formatDigestValue(min))
+		} else {
+			client.send(formatDigestValue(max))
+		}
+
+	case "TDIGEST.RESET":
+		if len(cmd.Args) != 1 {
+			client.sendError(fmt.Errorf("TDIGEST.RESET expects 1 argument"))
+			return true
+		}
+
+		errRet := client.store.TDIGESTRESET(cmd.Args[0])
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("TDIGEST.RESET %s", errRet))
+		} else {
+			client.send("+OK")
+		}
+
+	case "TS.CREATE":
+		/* key [RETENTION retention] [CHUNK_SIZE size] [DUPLICATE_POLICY policy] [LABELS label value ...] */
+		if len(cmd.Args) < 1 {
+			client.sendError(fmt.Errorf("TS.CREATE expects minimum 1 argument"))
+			return true
+		}
+
+		opts, err := parseTSOptions(cmd.Args[1:], false)
+		if err != nil {
+			client.sendError(fmt.Errorf("TS.CREATE %s", err))
+			return true
+		}
+
+		errRet := client.store.TSCREATE(cmd.Args[0], opts)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("TS.CREATE %s", errRet))
+		} else {
+			client.send("+OK")
+		}
+
+	case "TS.ADD", "TS.MADD":
+		/* TS.ADD key timestamp value [options], TS.MADD key timestamp value [key timestamp value ...] */
+		if len(cmd.Args) < 3 || (cmd.Name == "TS.MADD" && len(cmd.Args) % 3 != 0) {
+			client.sendError(fmt.Errorf("%s expects key timestamp value arguments", cmd.Name))
+			return true
+		}
+
+		var opts *tsOptions
+		var err error
+		n := len(cmd.Args)
+
+		if cmd.Name == "TS.ADD" {
+			opts, err = parseTSOptions(cmd.Args[3:], true)
+			if err != nil {
+				client.sendError(fmt.Errorf("TS.ADD %s", err))
+				return true
+			}
+			n = 3
+		}
+
+		/* Timestamp or the error of every sample */
+		vals := []string{}
+
+		for i := 0; i + 2 < n; i = i + 3 {
+			var s tsSample
+
+			if cmd.Args[i+1] == "*" {
+				s.ts = mstime()
+			} else if s.ts, err = strconv.ParseInt(cmd.Args[i+1], 10, 64); err != nil || s.ts < 0 {
+				vals = append(vals, "-Error TSDB: invalid timestamp, must be a nonnegative integer")
+				continue
+			}
+
+			s.val, err = strconv.ParseFloat(cmd.Args[i+2], 64)
+			if err != nil || math.IsNaN(s.val) || math.IsInf(s.val, 0) {
+				vals = append(vals, "-Error TSDB: invalid value")
+				continue
+			}
+
+			errRet := client.store.TSADD(cmd.Args[i], s, opts, cmd.Name == "TS.ADD")
+
+			if errRet != nil {
+				vals = append(vals, "-Error " + errRet.Error())
+			} else {
+				vals = append(vals, strconv.FormatInt(s.ts, 10))
+			}
+		}
+
+		if cmd.Name == "TS.MADD" {
+			client.sendArray(vals)
+		} else if strings.HasPrefix(vals[0], "-Error ") {
+			client.sendError(fmt.Errorf("TS.ADD %s", strings.TrimPrefix(vals[0], "-Error ")))
+		} else {
+			client.send(vals[0])
+		}
+
+	case "TS.RANGE", "TS.MRANGE":
+		/* TS.RANGE key from to [COUNT count] [AGGREGATION aggregator bucketDuration] */
+		/* TS.MRANGE from to [COUNT count] [AGGREGATION aggregator bucketDuration] [WITHLABELS] FILTER filter ... */
+		args := cmd.Args
+		if cmd.Name == "TS.RANGE" {
+			if len(args) < 3 {
+				client.sendError(fmt.Errorf("TS.RANGE expects minimum 3 arguments"))
+				return true
+			}
+			args = args[1:]
+		}
+
+		if len(args) < 2 {
+			client.sendError(fmt.Errorf("%s expects minimum 3 arguments", cmd.Name))
+			return true
+		}
+
+		from, err := parseTSTimestamp(args[0])
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		to, err := parseTSTimestamp(args[1])
+		if err != nil {
+			client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+			return true
+		}
+
+		count := 0
+		agg := ""
+		var bucket int64
+		withLabels := false
+		filters := []tsFilter{}
+
+		for i := 2; i < len(args); i++ {
+			opt := strings.ToUpper(args[i])
+
+			if opt == "COUNT" && i + 1 < len(args) {
+				i++
+				count, err = strconv.Atoi(args[i])
+				if err != nil || count < 1 {
+					client.sendError(fmt.Errorf("%s invalid COUNT", cmd.Name))
+					return true
+				}
+			} else if opt == "AGGREGATION" {
+				agg, bucket, err = parseTSAggregation(args[i+1:])
+				if err != nil {
+					client.sendError(fmt.Errorf("%s %s", cmd.Name, err))
+					return true
+				}
+				i = i + 2
+			} else if opt == "WITHLABELS" && cmd.Name == "TS.MRANGE" {
+				withLabels = true
+			} else if opt == "FILTER" && cmd.Name == "TS.MRANGE" {
+				/* Filters come last */
+				for _, arg := range args[i+1:] {
+					f, err := parseTSFilter(arg)
+					if err != nil {
+						client.sendError(fmt.Errorf("TS.MRANGE %s", err))
+						return true
+					}
+					filters = append(filters, f)
+				}
+				break
+			} else {
+				client.sendError(fmt.Errorf("%s syntax error", cmd.Name))
+				return true
+			}
+		}
+
+		if cmd.Name == "TS.RANGE" {
+			samples, errRet := client.store.TSRANGE(cmd.Args[0], from, to, count, agg, bucket)
+
+			if errRet != nil {
+				client.sendError(fmt.Errorf("TS.RANGE %s", errRet))
+				return true
+			}
+
+			vals := []string{}
+			for _, s := range samples {
+				vals = append(vals, strconv.FormatInt(s.ts, 10), formatScore(s.val))
+			}
+			client.sendArray(vals)
+			return true
+		}
+
+		/* At least one filter selecting a label value, else every series would match */
+		matcher := false
+		for _, f := range filters {
+			if f.not == false && f.values[0] != "" {
+				matcher = true
+			}
+		}
+
+		if matcher == false {
+			client.sendError(fmt.Errorf("TS.MRANGE FILTER expects at least one label=value filter"))
+			return true
+		}
+
+		results, errRet := client.store.TSMRANGE(from, to, count, agg, bucket, filters)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("TS.MRANGE %s", errRet))
+			return true
+		}
+
+		/* key, label value pairs if WITHLABELS, then timestamp value pairs of every series */
+		vals := []string{}
+		for _, result := range results {
+			vals = append(vals, result.key)
+			if withLabels == true {
+				vals = append(vals, result.labels...)
+			}
+			for _, s := range result.samples {
+				vals = append(vals, strconv.FormatInt(s.ts, 10), formatScore(s.val))
+			}
+		}
+		client.sendArray(vals)
+
+	case "TS.CREATERULE":
+		/* sourceKey destKey AGGREGATION aggregator bucketDuration [alignTimestamp] */
+		if (len(cmd.Args) != 5 && len(cmd.Args) != 6) || strings.ToUpper(cmd.Args[2]) != "AGGREGATION" {
+			client.sendError(fmt.Errorf("TS.CREATERULE expects sourceKey destKey AGGREGATION aggregator bucketDuration [alignTimestamp]"))
+			return true
+		}
+
+		agg, bucket, err := parseTSAggregation(cmd.Args[3:5])
+		if err != nil {
+			client.sendError(fmt.Errorf("TS.CREATERULE %s", err))
+			return true
+		}
+
+		var align int64
+		if len(cmd.Args) == 6 {
+			align, err = strconv.ParseInt(cmd.Args[5], 10, 64)
+			if err != nil {
+				client.sendError(fmt.Errorf("TS.CREATERULE invalid alignTimestamp"))
+				return true
+			}
+		}
+
+		errRet := client.store.TSCREATERULE(cmd.Args[0], cmd.Args[1], agg, bucket, align)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("TS.CREATERULE %s", errRet))
+		} else {
+			client.send("+OK")
+		}
+
+	case "FT.CREATE":
+		/* index [ON HASH] [PREFIX count prefix ...] SCHEMA field type [options] ... */
+		if len(cmd.Args) < 4 {
+			client.sendError(fmt.Errorf("FT.CREATE expects minimum 4 arguments"))
+			return true
+		}
+
+		prefixes, fields, err := parseFTCreateArgs(cmd.Args[1:])
+		if err != nil {
+			client.sendError(fmt.Errorf("FT.CREATE %s", err))
+			return true
+		}
+
+		errRet := client.store.FTCREATE(cmd.Args[0], prefixes, fields)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("FT.CREATE %s", errRet))
+		} else {
+			client.send("+OK")
+		}
+
+	case "FT.SEARCH":
+		/* index query [NOCONTENT] [WITHSCORES] [RETURN count field ...] [SORTBY field [ASC|DESC]] [LIMIT offset num] [PARAMS count name value ...] [DIALECT dialect] */
+		if len(cmd.Args) < 2 {
+			client.sendError(fmt.Errorf("FT.SEARCH expects minimum 2 arguments"))
+			return true
+		}
+
+		query, opts, err := parseFTSearchArgs(cmd.Args[1:])
+		if err != nil {
+			client.sendError(fmt.Errorf("FT.SEARCH %s", err))
+			return true
+		}
+
+		total, results, errRet := client.store.FTSEARCH(cmd.Args[0], query, opts)
+
+		if errRet != nil {
+			client.sendError(fmt.Errorf("FT.SEARCH %s", errRet))
+			return true
+		}
+
+		/* Number of matches, then key, score if WITHSCORES and field value pairs of every result */
+		vals := []string{strconv.Itoa(total)}
+		for _, result := range results {
+			vals = append(vals, result.key)
+			if opts.withScores == true {
+				vals = append(vals, formatScore(result.score))
+			}
+			vals = append(vals, result.fields...)
+		}
+		client.sendArray(vals)
+
+	case "SAVE":
+		ok := client.store.Save(dbFile)
+		if ok == true {
+			client.send("+OK") 
+		} else {
+			client.send("-Error DB save failed")
+		}
+
+	case "EXIT":
+		client.conn.Close()
+		return false
+	
+	default:
+		client.sendError(fmt.Errorf("unkonwn command: %s", cmd.Name))
+	}
+
+	return true
+}
+
+
+
+
+
+/* Parse cursor [MATCH pattern] [COUNT count] of the SCAN family */
+func parseScanArgs(args []string) (int, string, int, error) {
+	var pattern string
+	var count int = 10
+
+	cursor, err := strconv.Atoi(args[0])
+	if err != nil || cursor < 0 {
+		return 0, "", 0, fmt.Errorf("invalid cursor")
+	}
+
+	for i := 1; i < len(args); i = i + 2 {
+		if (i + 1) >= len(args) {
+			return 0, "", 0, fmt.Errorf("syntax error")
+		}
+
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+			if pattern == "*" {
+				pattern = ""
+			}
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				return 0, "", 0, fmt.Errorf("syntax error")
+			}
+		default:
+			return 0, "", 0, fmt.Errorf("syntax error")
+		}
+	}
+
+	return cursor, pattern, count, nil
+}
+
+
+/* Glob style match of s against pattern, supports * ? [abc] [^a] [a-z] and \ escape */
+func stringMatch(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if stringMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						match = true
+					}
+				} else if len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']' {
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					if s[0] >= start && s[0] <= end {
+						match = true
+					}
+					pattern = pattern[2:]
+				} else if pattern[0] == s[0] {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+
+			/* Skip the closing ] */
+			if len(pattern) > 0 {
+				pattern = pattern[1:]
+			}
+
+			if not {
+				match = !match
+			}
+			if match == false {
+				return false
+			}
+			s = s[1:]
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+
+	return len(s) == 0
+}
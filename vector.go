@@ -0,0 +1,694 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+
+/*
+  Vector fields of search indexes, same options as redis search
+
+  A vector is a blob of dim little endian float32, written in the hash field as
+  hex since the protocol is line based. A field value which is not a valid
+  vector is not indexed.
+
+  Distance metrics, smaller is closer
+     L2     - squared euclidean distance
+     IP     - 1 - inner product
+     COSINE - 1 - cosine similarity, vectors are normalized when indexed
+
+  FLAT compares the query with every vector, exact. HNSW keeps a hierarchical
+  navigable small world graph, approximate and much faster for many vectors:
+  every vector is a node on layers 0 to a random level, linked to its closest
+  M nodes per layer (2*M on layer 0). A search walks greedily from the entry
+  point down the layers and explores efRuntime candidates on layer 0.
+
+  A removed vector is marked deleted and stays in the graph to keep it
+  connected, the graph is built again once deleted nodes outnumber the live
+  ones. Every hash write indexes the hash again, a deleted node comes back to
+  life if the same vector is added again for its key.
+
+  KNN queries go with FT.SEARCH, the filter query then =>[KNN k @field $param]
+  with the query vector in PARAMS:
+     FT.SEARCH idx *=>[KNN 10 @vec $blob] PARAMS 2 blob <hex>
+     FT.SEARCH idx @genre:{jazz}=>[KNN 10 @vec $blob EF_RUNTIME 50 AS dist] PARAMS 2 blob <hex>
+  The results are sorted by distance, which is returned as the field
+  __field_score or the name given with AS. A KNN query with a filter compares
+  the filtered vectors exactly.
+*/
+
+const (
+	// Defaults of the HNSW options
+	hnswDefaultM = 16
+	hnswDefaultEFConstruction = 200
+	hnswDefaultEFRuntime = 10
+
+	// Least number of deleted nodes before the graph is built again
+	hnswMinRebuild = 64
+)
+
+type hnswNode struct {
+	key string
+	vec []float32
+	links [][]*hnswNode
+	deleted bool
+}
+
+type hnswCand struct {
+	node *hnswNode
+	dist float64
+}
+
+/* Closest first */
+type hnswMinHeap []hnswCand
+
+/* Farthest first */
+type hnswMaxHeap []hnswCand
+
+type hnswGraph struct {
+	m int
+	m0 int
+	efConstruction int
+	ml float64
+
+	entry *hnswNode
+	maxLevel int
+	nodes map[string]*hnswNode
+	removed map[string]*hnswNode
+	deleted int
+
+	dist func(a []float32, b []float32) float64
+}
+
+type ftVectorIndex struct {
+	field *ftField
+	vectors map[string][]float32
+	graph *hnswGraph
+}
+
+type ftKNNResult struct {
+	key string
+	dist float64
+}
+
+type ftKNNQuery struct {
+	k int
+	field string
+	param string
+	efRuntime int
+	scoreField string
+}
+
+
+func (h hnswMinHeap) Len() int { return len(h) }
+func (h hnswMinHeap) Less(i, j int) bool { return h[i].dist < h[j].dist }
+func (h hnswMinHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *hnswMinHeap) Push(x interface{}) { *h = append(*h, x.(hnswCand)) }
+func (h *hnswMinHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old) - 1]
+	*h = old[:len(old) - 1]
+	return c
+}
+
+func (h hnswMaxHeap) Len() int { return len(h) }
+func (h hnswMaxHeap) Less(i, j int) bool { return h[i].dist > h[j].dist }
+func (h hnswMaxHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *hnswMaxHeap) Push(x interface{}) { *h = append(*h, x.(hnswCand)) }
+func (h *hnswMaxHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old) - 1]
+	*h = old[:len(old) - 1]
+	return c
+}
+
+
+func vectorL2(a []float32, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		d := float64(a[i]) - float64(b[i])
+		sum += d * d
+	}
+	return sum
+}
+
+
+/* 1 - inner product, also the cosine distance of normalized vectors */
+func vectorIP(a []float32, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return 1 - sum
+}
+
+
+/* Vector scaled to length 1, a zero vector stays zero */
+func vectorNormalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+
+	norm := math.Sqrt(sum)
+	if norm == 0 {
+		return v
+	}
+
+	n := make([]float32, len(v))
+	for i, x := range v {
+		n[i] = float32(float64(x) / norm)
+	}
+	return n
+}
+
+
+/* Parse hex of dim little endian float32 */
+func parseVector(value string, dim int) ([]float32, error) {
+	blob, err := hex.DecodeString(value)
+	if err != nil || len(blob) != 4 * dim {
+		return nil, errors.New(fmt.Sprint("Vector should be the hex of ", dim, " FLOAT32"))
+	}
+
+	vec := make([]float32, dim)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(blob[4*i:]))
+		if math.IsNaN(float64(vec[i])) || math.IsInf(float64(vec[i]), 0) {
+			return nil, errors.New("Vector values should be finite")
+		}
+	}
+	return vec, nil
+}
+
+
+func newHNSWGraph(m int, efConstruction int, dist func(a []float32, b []float32) float64) *hnswGraph {
+	return &hnswGraph{
+		m: m,
+		m0: 2 * m,
+		efConstruction: efConstruction,
+		ml: 1 / math.Log(float64(m)),
+		nodes: make(map[string]*hnswNode),
+		removed: make(map[string]*hnswNode),
+		dist: dist,
+	}
+}
+
+
+/* ef closest nodes to q on level, starting from eps, closest first */
+func (g *hnswGraph) searchLayer(q []float32, eps []hnswCand, ef int, level int) []hnswCand {
+	visited := make(map[*hnswNode]bool)
+	candidates := &hnswMinHeap{}
+	results := &hnswMaxHeap{}
+
+	for _, ep := range eps {
+		visited[ep.node] = true
+		heap.Push(candidates, ep)
+		heap.Push(results, ep)
+		if results.Len() > ef {
+			heap.Pop(results)
+		}
+	}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(hnswCand)
+
+		/* Closest candidate is farther than the farthest result */
+		if results.Len() >= ef && c.dist > (*results)[0].dist {
+			break
+		}
+
+		for _, n := range c.node.links[level] {
+			if visited[n] == true {
+				continue
+			}
+			visited[n] = true
+
+			d := g.dist(q, n.vec)
+			if results.Len() < ef || d < (*results)[0].dist {
+				heap.Push(candidates, hnswCand{node: n, dist: d})
+				heap.Push(results, hnswCand{node: n, dist: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sorted := make([]hnswCand, results.Len())
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(results).(hnswCand)
+	}
+	return sorted
+}
+
+
+/*
+  At most m of the candidates sorted closest first, a candidate closer to a
+  selected node than to the base is skipped so the links spread in all
+  directions. Skipped candidates fill up the free links
+*/
+func (g *hnswGraph) selectNeighbors(cands []hnswCand, m int) []*hnswNode {
+	selected := []*hnswNode{}
+	skipped := []*hnswNode{}
+
+	for _, c := range cands {
+		if len(selected) >= m {
+			break
+		}
+
+		good := true
+		for _, s := range selected {
+			if g.dist(c.node.vec, s.vec) < c.dist {
+				good = false
+				break
+			}
+		}
+
+		if good == true {
+			selected = append(selected, c.node)
+		} else {
+			skipped = append(skipped, c.node)
+		}
+	}
+
+	for _, n := range skipped {
+		if len(selected) >= m {
+			break
+		}
+		selected = append(selected, n)
+	}
+
+	return selected
+}
+
+
+func vectorEqual(a []float32, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+
+func (g *hnswGraph) insert(key string, vec []float32) {
+	/* Same vector as the deleted node of key, bring it back */
+	if node, ok := g.removed[key]; ok == true {
+		delete(g.removed, key)
+		if vectorEqual(node.vec, vec) == true {
+			node.deleted = false
+			g.deleted--
+			g.nodes[key] = node
+			return
+		}
+	}
+
+	level := int(-math.Log(1 - rand.Float64()) * g.ml)
+
+	node := &hnswNode{key: key, vec: vec, links: make([][]*hnswNode, level + 1)}
+	g.nodes[key] = node
+
+	if g.entry == nil {
+		g.entry = node
+		g.maxLevel = level
+		return
+	}
+
+	eps := []hnswCand{{node: g.entry, dist: g.dist(vec, g.entry.vec)}}
+
+	for l := g.maxLevel; l > level; l-- {
+		eps = g.searchLayer(vec, eps, 1, l)
+	}
+
+	top := level
+	if g.maxLevel < top {
+		top = g.maxLevel
+	}
+
+	for l := top; l >= 0; l-- {
+		w := g.searchLayer(vec, eps, g.efConstruction, l)
+
+		maxM := g.m
+		if l == 0 {
+			maxM = g.m0
+		}
+
+		node.links[l] = g.selectNeighbors(w, g.m)
+
+		/* Link back, a neighbor with too many links keeps the best of them */
+		for _, n := range node.links[l] {
+			n.links[l] = append(n.links[l], node)
+
+			if len(n.links[l]) > maxM {
+				cands := make([]hnswCand, len(n.links[l]))
+				for i, x := range n.links[l] {
+					cands[i] = hnswCand{node: x, dist: g.dist(n.vec, x.vec)}
+				}
+				sort.Slice(cands, func(i, j int) bool { return cands[i].dist < cands[j].dist })
+				n.links[l] = g.selectNeighbors(cands, maxM)
+			}
+		}
+
+		eps = w
+	}
+
+	if level > g.maxLevel {
+		g.entry = node
+		g.maxLevel = level
+	}
+}
+
+
+/* Mark the node of key deleted, the graph is built again once deleted nodes outnumber the live ones */
+func (g *hnswGraph) remove(key string) {
+	node, ok := g.nodes[key]
+	if ok == false {
+		return
+	}
+
+	delete(g.nodes, key)
+	node.deleted = true
+	g.deleted++
+	g.removed[key] = node
+
+	if g.deleted >= hnswMinRebuild && g.deleted > len(g.nodes) || len(g.nodes) == 0 {
+		nodes := g.nodes
+
+		g.entry = nil
+		g.maxLevel = 0
+		g.nodes = make(map[string]*hnswNode)
+		g.removed = make(map[string]*hnswNode)
+		g.deleted = 0
+
+		for k, n := range nodes {
+			g.insert(k, n.vec)
+		}
+	}
+}
+
+
+/* k closest live nodes to q, exploring ef candidates on layer 0 */
+func (g *hnswGraph) search(q []float32, k int, ef int) []ftKNNResult {
+	if g.entry == nil {
+		return []ftKNNResult{}
+	}
+
+	if ef < k {
+		ef = k
+	}
+
+	/* Deleted nodes take places of the candidates */
+	if len(g.nodes) > 0 {
+		ef = ef + ef * g.deleted / len(g.nodes)
+	}
+
+	eps := []hnswCand{{node: g.entry, dist: g.dist(q, g.entry.vec)}}
+
+	for l := g.maxLevel; l > 0; l-- {
+		eps = g.searchLayer(q, eps, 1, l)
+	}
+
+	results := []ftKNNResult{}
+	for _, c := range g.searchLayer(q, eps, ef, 0) {
+		if c.node.deleted == false && len(results) < k {
+			results = append(results, ftKNNResult{key: c.node.key, dist: c.dist})
+		}
+	}
+
+	return results
+}
+
+
+func newFTVectorIndex(f *ftField) *ftVectorIndex {
+	vi := &ftVectorIndex{
+		field: f,
+		vectors: make(map[string][]float32),
+	}
+
+	if f.algorithm == "HNSW" {
+		vi.graph = newHNSWGraph(f.m, f.efConstruction, vi.dist)
+	}
+
+	return vi
+}
+
+
+func (vi *ftVectorIndex) dist(a []float32, b []float32) float64 {
+	if vi.field.metric == "L2" {
+		return vectorL2(a, b)
+	}
+	return vectorIP(a, b)
+}
+
+
+func (vi *ftVectorIndex) add(key string, vec []float32) {
+	if vi.field.metric == "COSINE" {
+		vec = vectorNormalize(vec)
+	}
+
+	vi.remove(key)
+	vi.vectors[key] = vec
+
+	if vi.graph != nil {
+		vi.graph.insert(key, vec)
+	}
+}
+
+
+func (vi *ftVectorIndex) remove(key string) {
+	if _, ok := vi.vectors[key]; ok == false {
+		return
+	}
+
+	delete(vi.vectors, key)
+
+	if vi.graph != nil {
+		vi.graph.remove(key)
+	}
+}
+
+
+/* k closest vectors to q, closest first. Only the keys of filter if not nil, compared exactly */
+func (vi *ftVectorIndex) knn(q []float32, k int, ef int, filter map[string]float64) []ftKNNResult {
+	if k == 0 {
+		return []ftKNNResult{}
+	}
+
+	if vi.field.metric == "COSINE" {
+		q = vectorNormalize(q)
+	}
+
+	if vi.graph != nil && filter == nil {
+		return vi.graph.search(q, k, ef)
+	}
+
+	/* Brute force, keep the k closest in a max heap */
+	closest := &hnswMaxHeap{}
+
+	consider := func(key string, vec []float32) {
+		d := vi.dist(q, vec)
+		if closest.Len() < k {
+			heap.Push(closest, hnswCand{node: &hnswNode{key: key}, dist: d})
+		} else if d < (*closest)[0].dist {
+			heap.Pop(closest)
+			heap.Push(closest, hnswCand{node: &hnswNode{key: key}, dist: d})
+		}
+	}
+
+	if filter == nil {
+		for key, vec := range vi.vectors {
+			consider(key, vec)
+		}
+	} else {
+		for key := range filter {
+			if vec, ok := vi.vectors[key]; ok == true {
+				consider(key, vec)
+			}
+		}
+	}
+
+	results := make([]ftKNNResult, closest.Len())
+	for i := len(results) - 1; i >= 0; i-- {
+		c := heap.Pop(closest).(hnswCand)
+		results[i] = ftKNNResult{key: c.node.key, dist: c.dist}
+	}
+
+	/* Ties by key, so the results are stable */
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].dist < results[j].dist || (results[i].dist == results[j].dist && results[i].key < results[j].key)
+	})
+
+	return results
+}
+
+
+/*
+  Parse the options of a VECTOR field of FT.CREATE, after FLAT|HNSW count:
+  TYPE FLOAT32 DIM dim DISTANCE_METRIC L2|IP|COSINE, HNSW takes [M m] [EF_CONSTRUCTION ef] [EF_RUNTIME ef]
+*/
+func parseVectorFieldArgs(f *ftField, args []string) error {
+	if len(args) % 2 != 0 {
+		return errors.New(fmt.Sprint("Bad arguments for vector field `", f.name, "`"))
+	}
+
+	f.m = hnswDefaultM
+	f.efConstruction = hnswDefaultEFConstruction
+	f.efRuntime = hnswDefaultEFRuntime
+
+	for i := 0; i + 1 < len(args); i = i + 2 {
+		opt := strings.ToUpper(args[i])
+		val := strings.ToUpper(args[i+1])
+		n, err := strconv.Atoi(args[i+1])
+
+		switch {
+		case opt == "TYPE":
+			if val != "FLOAT32" {
+				return errors.New("Only FLOAT32 vectors are supported")
+			}
+			f.vecType = val
+
+		case opt == "DIM" && err == nil && n > 0:
+			f.dim = n
+
+		case opt == "DISTANCE_METRIC" && (val == "L2" || val == "IP" || val == "COSINE"):
+			f.metric = val
+
+		case opt == "M" && f.algorithm == "HNSW" && err == nil && n >= 2:
+			f.m = n
+
+		case opt == "EF_CONSTRUCTION" && f.algorithm == "HNSW" && err == nil && n > 0:
+			f.efConstruction = n
+
+		case opt == "EF_RUNTIME" && f.algorithm == "HNSW" && err == nil && n > 0:
+			f.efRuntime = n
+
+		case (opt == "INITIAL_CAP" || opt == "BLOCK_SIZE") && err == nil && n >= 0:
+			/* Accepted for compatibility, vectors are kept in a map */
+
+		default:
+			return errors.New(fmt.Sprint("Bad arguments for vector field `", f.name, "`: ", args[i], " ", args[i+1]))
+		}
+	}
+
+	if f.vecType == "" || f.dim == 0 || f.metric == "" {
+		return errors.New(fmt.Sprint("Vector field `", f.name, "` needs TYPE, DIM and DISTANCE_METRIC"))
+	}
+
+	return nil
+}
+
+
+/*
+  Split the FT.SEARCH query at =>, the KNN clause after it is
+  [KNN k @field $param [EF_RUNTIME ef] [AS name]]. nil query if there is no KNN clause
+*/
+func parseFTKNN(query string) (string, *ftKNNQuery, error) {
+	i := strings.Index(query, "=>")
+	if i < 0 {
+		return query, nil, nil
+	}
+
+	filter := strings.TrimSpace(query[:i])
+	clause := strings.TrimSpace(query[i+2:])
+
+	if filter == "" {
+		return "", nil, errors.New("No filter query before =>, use * for every document")
+	}
+
+	if strings.HasPrefix(clause, "[") == false || strings.HasSuffix(clause, "]") == false {
+		return "", nil, errors.New("KNN clause should be [KNN k @field $param]")
+	}
+
+	args := strings.Fields(clause[1 : len(clause)-1])
+	if len(args) < 4 || strings.ToUpper(args[0]) != "KNN" || strings.HasPrefix(args[2], "@") == false || strings.HasPrefix(args[3], "$") == false {
+		return "", nil, errors.New("KNN clause should be [KNN k @field $param]")
+	}
+
+	k, err := strconv.Atoi(args[1])
+	if err != nil || k < 0 {
+		return "", nil, errors.New("KNN k should be a non negative integer")
+	}
+
+	knn := &ftKNNQuery{k: k, field: args[2][1:], param: args[3][1:]}
+	knn.scoreField = "__" + knn.field + "_score"
+
+	for i := 4; i < len(args); i = i + 2 {
+		if i + 1 >= len(args) {
+			return "", nil, errors.New(fmt.Sprint("Bad KNN argument `", args[i], "`"))
+		}
+
+		opt := strings.ToUpper(args[i])
+
+		if opt == "EF_RUNTIME" {
+			ef, err := strconv.Atoi(args[i+1])
+			if err != nil || ef < 1 {
+				return "", nil, errors.New("Bad EF_RUNTIME")
+			}
+			knn.efRuntime = ef
+		} else if opt == "AS" {
+			knn.scoreField = args[i+1]
+		} else {
+			return "", nil, errors.New(fmt.Sprint("Bad KNN argument `", args[i], "`"))
+		}
+	}
+
+	return filter, knn, nil
+}
+
+
+/* k nearest documents to the vector of the KNN query among the documents matching filter, closest first */
+func (idx *ftIndex) knnSearch(filter string, knn *ftKNNQuery, params map[string]string) ([]ftResult, error) {
+	f, ok := idx.field(knn.field)
+	if ok == false || f.kind != "VECTOR" {
+		return nil, errors.New(fmt.Sprint("Field `", knn.field, "` is not a vector field"))
+	}
+
+	blob, ok := params[knn.param]
+	if ok == false {
+		return nil, errors.New(fmt.Sprint("No such parameter `", knn.param, "`"))
+	}
+
+	vec, err := parseVector(blob, f.dim)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Every document needs no filter, HNSW searches the graph */
+	var matches map[string]float64
+	if filter != "*" {
+		matches, err = idx.query(filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ef := knn.efRuntime
+	if ef == 0 {
+		ef = f.efRuntime
+	}
+
+	results := []ftResult{}
+	for _, r := range idx.vectors[f.name].knn(vec, knn.k, ef, matches) {
+		results = append(results, ftResult{key: r.key, score: r.dist})
+	}
+
+	return results, nil
+}
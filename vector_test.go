@@ -0,0 +1,171 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+
+func vectorHex(v []float32) string {
+	blob := make([]byte, 4 * len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(blob[4*i:], math.Float32bits(x))
+	}
+	return hex.EncodeToString(blob)
+}
+
+
+func randomVector(r *rand.Rand, dim int) []float32 {
+	v := make([]float32, dim)
+	for i := range v {
+		v[i] = float32(r.NormFloat64())
+	}
+	return v
+}
+
+
+func TestParseVector(t *testing.T) {
+	want := []float32{1, -2.5, 0}
+
+	vec, err := parseVector(vectorHex(want), 3)
+	if err != nil || vectorEqual(vec, want) == false {
+		t.Fatalf("parseVector %v %v", vec, err)
+	}
+
+	for _, bad := range []string{"", "zz", vectorHex(want[:2]), vectorHex([]float32{float32(math.NaN()), 0, 0})} {
+		if _, err := parseVector(bad, 3); err == nil {
+			t.Fatalf("parseVector accepted %q", bad)
+		}
+	}
+}
+
+
+/* HNSW finds most of the exact nearest neighbours of FLAT, for every metric */
+func TestHNSWRecall(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for _, metric := range []string{"L2", "IP", "COSINE"} {
+		flat := newFTVectorIndex(&ftField{name: "v", algorithm: "FLAT", dim: 16, metric: metric})
+		hnsw := newFTVectorIndex(&ftField{name: "v", algorithm: "HNSW", dim: 16, metric: metric, m: 16, efConstruction: 100})
+
+		for i := 0; i < 2000; i++ {
+			v := randomVector(r, 16)
+			flat.add(strconv.Itoa(i), v)
+			hnsw.add(strconv.Itoa(i), v)
+		}
+
+		hits, total := 0, 0
+		for q := 0; q < 50; q++ {
+			v := randomVector(r, 16)
+
+			exact := make(map[string]bool)
+			for _, res := range flat.knn(v, 10, 0, nil) {
+				exact[res.key] = true
+			}
+
+			for _, res := range hnsw.knn(v, 10, 100, nil) {
+				if exact[res.key] == true {
+					hits++
+				}
+			}
+			total += len(exact)
+		}
+
+		if recall := float64(hits) / float64(total); recall < 0.9 {
+			t.Fatalf("%v recall@10 %v", metric, recall)
+		}
+	}
+}
+
+
+/* Removed vectors are never returned, the graph is built again once deleted nodes outnumber live ones */
+func TestHNSWRemove(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	vi := newFTVectorIndex(&ftField{name: "v", algorithm: "HNSW", dim: 8, metric: "L2", m: 8, efConstruction: 50})
+
+	vecs := make(map[string][]float32)
+	for i := 0; i < 500; i++ {
+		key := strconv.Itoa(i)
+		vecs[key] = randomVector(r, 8)
+		vi.add(key, vecs[key])
+	}
+
+	/* Same vector again brings the deleted node back */
+	vi.remove("0")
+	vi.add("0", vecs["0"])
+	if vi.graph.deleted != 0 || len(vi.graph.nodes) != 500 {
+		t.Fatalf("node not brought back, %v deleted", vi.graph.deleted)
+	}
+
+	for i := 0; i < 400; i++ {
+		vi.remove(strconv.Itoa(i))
+	}
+
+	if vi.graph.deleted > len(vi.graph.nodes) {
+		t.Fatalf("%v deleted nodes for %v live ones", vi.graph.deleted, len(vi.graph.nodes))
+	}
+
+	results := vi.knn(randomVector(r, 8), 100, 200, nil)
+	if len(results) != 100 {
+		t.Fatalf("%v results of 100 live vectors", len(results))
+	}
+	for _, res := range results {
+		if n, _ := strconv.Atoi(res.key); n < 400 {
+			t.Fatalf("removed vector %v returned", res.key)
+		}
+	}
+
+	for i := 400; i < 500; i++ {
+		vi.remove(strconv.Itoa(i))
+	}
+	if results := vi.knn(randomVector(r, 8), 10, 10, nil); len(results) != 0 || vi.graph.entry != nil {
+		t.Fatalf("empty index returned %v", results)
+	}
+}
+
+
+func TestKNNFilter(t *testing.T) {
+	vi := newFTVectorIndex(&ftField{name: "v", algorithm: "HNSW", dim: 2, metric: "L2", m: 4, efConstruction: 10})
+
+	vi.add("a", []float32{0, 0})
+	vi.add("b", []float32{1, 0})
+	vi.add("c", []float32{3, 0})
+
+	results := vi.knn([]float32{0, 0}, 2, 10, map[string]float64{"b": 0, "c": 0, "missing": 0})
+	if len(results) != 2 || results[0].key != "b" || results[0].dist != 1 || results[1].key != "c" || results[1].dist != 9 {
+		t.Fatalf("filtered knn %v", results)
+	}
+
+	if results := vi.knn([]float32{0, 0}, 0, 10, nil); len(results) != 0 {
+		t.Fatalf("knn of 0 returned %v", results)
+	}
+}
+
+
+/* The HNSW graph is built again by Load, KNN finds the same nearest key */
+func TestVectorSaveLoad(t *testing.T) {
+	store := newDB()
+
+	must(t, store.FTCREATE("idx", []string{"doc:"}, []ftField{
+		{name: "v", kind: "VECTOR", weight: 1, separator: ftDefaultSeparator, algorithm: "HNSW", vecType: "FLOAT32", dim: 2, metric: "L2", m: 4, efConstruction: 10, efRuntime: 10},
+	}))
+	store.HSET("doc:1", []string{"v", vectorHex([]float32{0, 0})})
+	store.HSET("doc:2", []string{"v", vectorHex([]float32{1, 1})})
+
+	loaded := saveLoad(t, store)
+
+	opts := &ftSearchOptions{num: 10, noContent: true, params: map[string]string{"q": vectorHex([]float32{1, 1})}}
+	total, results, err := loaded.FTSEARCH("idx", "*=>[KNN 1 @v $q]", opts)
+	if err != nil || total != 1 || results[0].key != "doc:2" || results[0].score != 0 {
+		t.Fatalf("KNN %v %v %v", total, results, err)
+	}
+}
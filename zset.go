@@ -0,0 +1,1185 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+
+const (
+	// Max level of the sorted set skiplist, enough for 2^64 elements
+	zskiplistMaxLevel = 32
+
+	// Skiplist P = 1/4
+	zskiplistP = 0.25
+
+	// Max -count of ZRANDMEMBER, repeated members make the reply larger than the set
+	zsetMaxRandCount = 1 << 20
+)
+
+
+/* ZADD flags */
+const (
+	// Only add new members
+	zaddNX = 1 << iota
+
+	// Only update existing members
+	zaddXX
+
+	// Only update when new score is greater than current score
+	zaddGT
+
+	// Only update when new score is less than current score
+	zaddLT
+
+	// Reply with number of members added or updated
+	zaddCH
+
+	// Increment the score like ZINCRBY
+	zaddINCR
+)
+
+
+/* Validate ZADD flags combination for the given number of score member pairs */
+func checkZaddFlags(flags int, pairs int) error {
+	if flags & zaddNX != 0 && flags & zaddXX != 0 {
+		return errors.New("XX and NX options at the same time are not compatible")
+	}
+
+	if (flags & zaddGT != 0 && flags & zaddNX != 0) ||
+		(flags & zaddLT != 0 && flags & zaddNX != 0) ||
+		(flags & zaddGT != 0 && flags & zaddLT != 0) {
+		return errors.New("GT, LT, and/or NX options at the same time are not compatible")
+	}
+
+	if flags & zaddINCR != 0 && pairs > 1 {
+		return errors.New("INCR option supports a single increment-element pair")
+	}
+
+	return nil
+}
+
+
+/*
+  Sorted set skiplist, same layout as the redis zskiplist
+
+  Nodes are ordered by score and then lexicographically by member.
+  Every level keeps the span (number of level 0 nodes crossed) of its
+  forward link, which allows rank based lookups in O(log n).
+*/
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span int
+}
+
+type zskiplistNode struct {
+	member string
+	score float64
+	backward *zskiplistNode
+	level []zskiplistLevel
+}
+
+type zskiplist struct {
+	header *zskiplistNode
+	tail *zskiplistNode
+	length int
+	level int
+}
+
+
+func newZskiplistNode(level int, score float64, member string) *zskiplistNode {
+	return &zskiplistNode{
+		member: member,
+		score: score,
+		level: make([]zskiplistLevel, level),
+	}
+}
+
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		header: newZskiplistNode(zskiplistMaxLevel, 0, ""),
+		level: 1,
+	}
+}
+
+
+/* Returns a random level for the new node, powerlaw-alike distribution with lower levels more likely */
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+
+/* Returns true if node x sorts before the score member pair */
+func zslLess(x *zskiplistNode, score float64, member string) bool {
+	return x.score < score || (x.score == score && x.member < member)
+}
+
+
+/* Insert a new node, caller makes sure the member is not already present */
+func (zsl *zskiplist) insert(score float64, member string) *zskiplistNode {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		/* rank is the number of nodes crossed to reach the insert position */
+		if i != zsl.level - 1 {
+			rank[i] = rank[i+1]
+		}
+
+		for x.level[i].forward != nil && zslLess(x.level[i].forward, score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = newZskiplistNode(level, score, member)
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		/* Update span covered by update[i] as x is inserted here */
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+
+	/* Increment span for untouched levels */
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+
+	zsl.length++
+	return x
+}
+
+
+/* Unlink node x, update holds the last node before x at every level */
+func (zsl *zskiplist) deleteNode(x *zskiplistNode, update []*zskiplistNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span -= 1
+		}
+	}
+
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+
+	zsl.length--
+}
+
+
+/* Delete the node with matching score and member, returns false if not found */
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && zslLess(x.level[i].forward, score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x != nil && x.score == score && x.member == member {
+		zsl.deleteNode(x, update)
+		return true
+	}
+
+	return false
+}
+
+
+/* Move member from curScore to newScore */
+func (zsl *zskiplist) updateScore(curScore float64, member string, newScore float64) *zskiplistNode {
+	zsl.delete(curScore, member)
+	return zsl.insert(newScore, member)
+}
+
+
+/* Delete all the nodes with score in range, members are removed from dict as well */
+func (zsl *zskiplist) deleteRangeByScore(spec *zrangespec, dict map[string]float64) int {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+	removed := 0
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && spec.gteMin(x.level[i].forward.score) == false {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	/* Current node is the last with score < or <= min */
+	x = x.level[0].forward
+
+	for x != nil && spec.lteMax(x.score) {
+		next := x.level[0].forward
+		zsl.deleteNode(x, update)
+		delete(dict, x.member)
+		removed++
+		x = next
+	}
+
+	return removed
+}
+
+
+/* Delete all the nodes with member in lex range, members are removed from dict as well */
+func (zsl *zskiplist) deleteRangeByLex(spec *zlexrangespec, dict map[string]float64) int {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+	removed := 0
+
+	if spec.empty() {
+		return 0
+	}
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && spec.gteMin(x.level[i].forward.member) == false {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+
+	for x != nil && spec.lteMax(x.member) {
+		next := x.level[0].forward
+		zsl.deleteNode(x, update)
+		delete(dict, x.member)
+		removed++
+		x = next
+	}
+
+	return removed
+}
+
+
+/* Delete all the nodes with 1-based rank between start and end inclusive, members are removed from dict as well */
+func (zsl *zskiplist) deleteRangeByRank(start int, end int, dict map[string]float64) int {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+	traversed := 0
+	removed := 0
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (traversed + x.level[i].span) < start {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	traversed++
+	x = x.level[0].forward
+
+	for x != nil && traversed <= end {
+		next := x.level[0].forward
+		zsl.deleteNode(x, update)
+		delete(dict, x.member)
+		removed++
+		traversed++
+		x = next
+	}
+
+	return removed
+}
+
+
+/* Returns the first node of the skiplist, nil if empty */
+func (zsl *zskiplist) first() *zskiplistNode {
+	return zsl.header.level[0].forward
+}
+
+
+/*
+  Score helpers
+
+  Scores are IEEE-754 doubles, -inf and +inf are valid scores while NaN is not.
+*/
+
+/* Parse a score argument, accepts inf, +inf, -inf in any case */
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+
+	if err != nil {
+		/* ParseFloat reports out of range values as error with +-Inf result, redis rejects them as well */
+		return 0, errors.New("value is not a valid float")
+	}
+
+	if math.IsNaN(score) {
+		return 0, errors.New("value is not a valid float")
+	}
+
+	return score, nil
+}
+
+
+/* Format a score for replies, the shortest representation that parses back to the same double */
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	}
+
+	if math.IsInf(score, -1) {
+		return "-inf"
+	}
+
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+
+/* Returns the 1-based rank of the score member pair, 0 if not found */
+func (zsl *zskiplist) getRank(score float64, member string) int {
+	rank := 0
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (zslLess(x.level[i].forward, score, member) ||
+			(x.level[i].forward.score == score && x.level[i].forward.member == member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		/* x might be equal to header, so test if it is the node */
+		if x != zsl.header && x.score == score && x.member == member {
+			return rank
+		}
+	}
+
+	return 0
+}
+
+
+/* Returns the node at the 1-based rank, nil if out of range */
+func (zsl *zskiplist) getElementByRank(rank int) *zskiplistNode {
+	traversed := 0
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (traversed + x.level[i].span) <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		if traversed == rank {
+			return x
+		}
+	}
+
+	return nil
+}
+
+
+/*
+  Score range, min and max are inclusive unless minex / maxex is set
+  Parsed from the redis form "1.5", "(1.5", "-inf", "+inf"
+*/
+
+type zrangespec struct {
+	min float64
+	max float64
+	minex bool
+	maxex bool
+}
+
+
+func parseScoreBound(s string) (float64, bool, error) {
+	if len(s) > 0 && s[0] == '(' {
+		score, err := parseScore(s[1:])
+		return score, true, err
+	}
+
+	score, err := parseScore(s)
+	return score, false, err
+}
+
+
+func parseRangeSpec(min string, max string) (*zrangespec, error) {
+	var spec zrangespec
+	var err error
+
+	spec.min, spec.minex, err = parseScoreBound(min)
+	if err != nil {
+		return nil, errors.New("min or max is not a float")
+	}
+
+	spec.max, spec.maxex, err = parseScoreBound(max)
+	if err != nil {
+		return nil, errors.New("min or max is not a float")
+	}
+
+	return &spec, nil
+}
+
+
+func (spec *zrangespec) gteMin(score float64) bool {
+	if spec.minex {
+		return score > spec.min
+	}
+	return score >= spec.min
+}
+
+
+func (spec *zrangespec) lteMax(score float64) bool {
+	if spec.maxex {
+		return score < spec.max
+	}
+	return score <= spec.max
+}
+
+
+/* Returns true if the range can not contain any element */
+func (spec *zrangespec) empty() bool {
+	return spec.min > spec.max || (spec.min == spec.max && (spec.minex || spec.maxex))
+}
+
+
+/* Returns true if some part of the skiplist is in range */
+func (zsl *zskiplist) isInRange(spec *zrangespec) bool {
+	if spec.empty() {
+		return false
+	}
+
+	if zsl.tail == nil || spec.gteMin(zsl.tail.score) == false {
+		return false
+	}
+
+	x := zsl.first()
+	if x == nil || spec.lteMax(x.score) == false {
+		return false
+	}
+
+	return true
+}
+
+
+/* Returns the first node in the score range, nil if none */
+func (zsl *zskiplist) firstInRange(spec *zrangespec) *zskiplistNode {
+	if zsl.isInRange(spec) == false {
+		return nil
+	}
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		/* Go forward while *OUT* of range */
+		for x.level[i].forward != nil && spec.gteMin(x.level[i].forward.score) == false {
+			x = x.level[i].forward
+		}
+	}
+
+	/* This is an inner range, so the next node cannot be nil */
+	x = x.level[0].forward
+	if spec.lteMax(x.score) == false {
+		return nil
+	}
+
+	return x
+}
+
+
+/* Returns the last node in the score range, nil if none */
+func (zsl *zskiplist) lastInRange(spec *zrangespec) *zskiplistNode {
+	if zsl.isInRange(spec) == false {
+		return nil
+	}
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		/* Go forward while *IN* range */
+		for x.level[i].forward != nil && spec.lteMax(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+
+	/* This is an inner range, so this node cannot be nil */
+	if spec.gteMin(x.score) == false {
+		return nil
+	}
+
+	return x
+}
+
+
+/*
+  Lexicographic range, parsed from the redis form "[a", "(a", "-", "+"
+  inf is -1 for "-" (smaller than any member) and 1 for "+" (greater than any member)
+*/
+
+type zlexbound struct {
+	val string
+	ex bool
+	inf int
+}
+
+type zlexrangespec struct {
+	min zlexbound
+	max zlexbound
+}
+
+
+func parseLexBound(s string) (zlexbound, error) {
+	if s == "-" {
+		return zlexbound{inf: -1}, nil
+	}
+
+	if s == "+" {
+		return zlexbound{inf: 1}, nil
+	}
+
+	if len(s) > 0 && s[0] == '(' {
+		return zlexbound{val: s[1:], ex: true}, nil
+	}
+
+	if len(s) > 0 && s[0] == '[' {
+		return zlexbound{val: s[1:]}, nil
+	}
+
+	return zlexbound{}, errors.New("min or max not valid string range item")
+}
+
+
+func parseLexRangeSpec(min string, max string) (*zlexrangespec, error) {
+	var spec zlexrangespec
+	var err error
+
+	spec.min, err = parseLexBound(min)
+	if err != nil {
+		return nil, err
+	}
+
+	spec.max, err = parseLexBound(max)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spec, nil
+}
+
+
+func (spec *zlexrangespec) gteMin(member string) bool {
+	if spec.min.inf != 0 {
+		return spec.min.inf < 0
+	}
+
+	if spec.min.ex {
+		return member > spec.min.val
+	}
+	return member >= spec.min.val
+}
+
+
+func (spec *zlexrangespec) lteMax(member string) bool {
+	if spec.max.inf != 0 {
+		return spec.max.inf > 0
+	}
+
+	if spec.max.ex {
+		return member < spec.max.val
+	}
+	return member <= spec.max.val
+}
+
+
+/* Returns true if the range can not contain any element */
+func (spec *zlexrangespec) empty() bool {
+	if spec.min.inf > 0 || spec.max.inf < 0 {
+		return true
+	}
+
+	if spec.min.inf < 0 || spec.max.inf > 0 {
+		return false
+	}
+
+	return spec.min.val > spec.max.val || (spec.min.val == spec.max.val && (spec.min.ex || spec.max.ex))
+}
+
+
+/* Returns true if some part of the skiplist is in lex range */
+func (zsl *zskiplist) isInLexRange(spec *zlexrangespec) bool {
+	if spec.empty() {
+		return false
+	}
+
+	if zsl.tail == nil || spec.gteMin(zsl.tail.member) == false {
+		return false
+	}
+
+	x := zsl.first()
+	if x == nil || spec.lteMax(x.member) == false {
+		return false
+	}
+
+	return true
+}
+
+
+/* Returns the first node in the lex range, nil if none */
+func (zsl *zskiplist) firstInLexRange(spec *zlexrangespec) *zskiplistNode {
+	if zsl.isInLexRange(spec) == false {
+		return nil
+	}
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && spec.gteMin(x.level[i].forward.member) == false {
+			x = x.level[i].forward
+		}
+	}
+
+	x = x.level[0].forward
+	if spec.lteMax(x.member) == false {
+		return nil
+	}
+
+	return x
+}
+
+
+/* Returns the last node in the lex range, nil if none */
+func (zsl *zskiplist) lastInLexRange(spec *zlexrangespec) *zskiplistNode {
+	if zsl.isInLexRange(spec) == false {
+		return nil
+	}
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && spec.lteMax(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+
+	if spec.gteMin(x.member) == false {
+		return nil
+	}
+
+	return x
+}
+
+
+/*
+  Range query on the skiplist, shared by ZRANGE, ZREVRANGE, ZRANGESTORE and the BYSCORE / BYLEX variants
+*/
+
+const (
+	zrangeRank = iota
+	zrangeScore
+	zrangeLex
+)
+
+type zrangeArgs struct {
+	rangeType int
+	start int
+	stop int
+	score *zrangespec
+	lex *zlexrangespec
+	rev bool
+	offset int
+	count int
+}
+
+type zsetItem struct {
+	member string
+	score float64
+}
+
+
+/*
+  Parse ZRANGE style arguments : min max [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+  When rev is set by the command (ZREVRANGE...) the REV option is implied
+*/
+func parseZrangeArgs(cmdName string, args []string, rangeType int, rev bool) (*zrangeArgs, bool, error) {
+	var withScores bool
+	var limit bool
+
+	zargs := &zrangeArgs{rangeType: rangeType, rev: rev, count: -1}
+
+	/* BYSCORE, BYLEX and REV are options of the generic forms only */
+	generic := cmdName == "ZRANGE" || cmdName == "ZRANGESTORE"
+
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+
+		switch {
+		case opt == "WITHSCORES" && (generic || zargs.rangeType != zrangeLex):
+			withScores = true
+		case opt == "BYSCORE" && generic && zargs.rangeType == zrangeRank:
+			zargs.rangeType = zrangeScore
+		case opt == "BYLEX" && generic && zargs.rangeType == zrangeRank:
+			zargs.rangeType = zrangeLex
+		case opt == "REV" && generic:
+			zargs.rev = true
+		case opt == "LIMIT" && (i+2) < len(args):
+			offset, errOffset := strconv.Atoi(args[i+1])
+			count, errCount := strconv.Atoi(args[i+2])
+			if errOffset != nil || errCount != nil {
+				return nil, false, errors.New("value is not an integer or out of range")
+			}
+			zargs.offset = offset
+			zargs.count = count
+			limit = true
+			i = i + 2
+		default:
+			return nil, false, errors.New("syntax error")
+		}
+	}
+
+	if limit == true && zargs.rangeType == zrangeRank {
+		return nil, false, errors.New("syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+
+	if withScores == true && zargs.rangeType == zrangeLex {
+		return nil, false, errors.New("syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	/* With REV the range is given as max min for score and lex ranges */
+	min, max := args[0], args[1]
+	if zargs.rev == true && zargs.rangeType != zrangeRank {
+		min, max = args[1], args[0]
+	}
+
+	var err error
+	switch zargs.rangeType {
+	case zrangeRank:
+		zargs.start, err = strconv.Atoi(args[0])
+		if err == nil {
+			zargs.stop, err = strconv.Atoi(args[1])
+		}
+		if err != nil {
+			return nil, false, errors.New("value is not an integer or out of range")
+		}
+	case zrangeScore:
+		zargs.score, err = parseRangeSpec(min, max)
+	case zrangeLex:
+		zargs.lex, err = parseLexRangeSpec(min, max)
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return zargs, withScores, nil
+}
+
+
+/* Returns the items selected by args in reply order */
+func (zsl *zskiplist) rangeByArgs(args *zrangeArgs) []zsetItem {
+	items := []zsetItem{}
+
+	if args.rangeType == zrangeRank {
+		llen := zsl.length
+		start, end := args.start, args.stop
+
+		/* Sanitize indexes, negative index is counted from the end */
+		if start < 0 {
+			start = llen + start
+		}
+		if end < 0 {
+			end = llen + end
+		}
+		if start < 0 {
+			start = 0
+		}
+
+		if start > end || start >= llen {
+			return items
+		}
+		if end >= llen {
+			end = llen - 1
+		}
+
+		var x *zskiplistNode
+		if args.rev == true {
+			x = zsl.getElementByRank(llen - start)
+		} else {
+			x = zsl.getElementByRank(start + 1)
+		}
+
+		for rangelen := end - start + 1; rangelen > 0 && x != nil; rangelen-- {
+			items = append(items, zsetItem{member: x.member, score: x.score})
+			if args.rev == true {
+				x = x.backward
+			} else {
+				x = x.level[0].forward
+			}
+		}
+
+		return items
+	}
+
+	/* Negative offset returns empty range, negative count returns all elements from offset */
+	if args.offset < 0 {
+		return items
+	}
+
+	inRange := func(x *zskiplistNode) bool {
+		if args.rangeType == zrangeScore {
+			if args.rev == true {
+				return args.score.gteMin(x.score)
+			}
+			return args.score.lteMax(x.score)
+		}
+
+		if args.rev == true {
+			return args.lex.gteMin(x.member)
+		}
+		return args.lex.lteMax(x.member)
+	}
+
+	var x *zskiplistNode
+	switch {
+	case args.rangeType == zrangeScore && args.rev == true:
+		x = zsl.lastInRange(args.score)
+	case args.rangeType == zrangeScore:
+		x = zsl.firstInRange(args.score)
+	case args.rev == true:
+		x = zsl.lastInLexRange(args.lex)
+	default:
+		x = zsl.firstInLexRange(args.lex)
+	}
+
+	next := func(x *zskiplistNode) *zskiplistNode {
+		if args.rev == true {
+			return x.backward
+		}
+		return x.level[0].forward
+	}
+
+	/* Skip offset elements, then collect count elements while in range */
+	for offset := args.offset; x != nil && offset > 0; offset-- {
+		x = next(x)
+	}
+
+	for count := args.count; x != nil && count != 0 && inRange(x); count-- {
+		items = append(items, zsetItem{member: x.member, score: x.score})
+		x = next(x)
+	}
+
+	return items
+}
+
+
+/*
+  Sorted set algebra, shared by ZUNION, ZINTER, ZDIFF, their STORE variants and ZINTERCARD
+*/
+
+const (
+	zsetOpUnion = iota
+	zsetOpInter
+	zsetOpDiff
+)
+
+const (
+	zaggregateSum = iota
+	zaggregateMin
+	zaggregateMax
+)
+
+type zsetOpArgs struct {
+	op int
+	keys []string
+	weights []float64
+	aggregate int
+	limit int
+}
+
+
+/*
+  Parse numkeys key [key ...] followed by the options of the command
+  WEIGHTS and AGGREGATE for union and inter, WITHSCORES for the non store variants, LIMIT for ZINTERCARD
+*/
+func parseZsetOpArgs(cmdName string, op int, args []string) (*zsetOpArgs, bool, error) {
+	var withScores bool
+
+	numkeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, false, errors.New("numkeys is not an integer or out of range")
+	}
+
+	if numkeys < 1 {
+		return nil, false, errors.New("at least 1 input key is needed")
+	}
+
+	if numkeys > len(args) - 1 {
+		return nil, false, errors.New("syntax error")
+	}
+
+	zargs := &zsetOpArgs{op: op, keys: args[1 : numkeys+1], weights: make([]float64, numkeys)}
+	for i := range zargs.weights {
+		zargs.weights[i] = 1
+	}
+
+	store := strings.HasSuffix(cmdName, "STORE")
+
+	for i := numkeys + 1; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+
+		switch {
+		case opt == "WEIGHTS" && op != zsetOpDiff && cmdName != "ZINTERCARD" && (i + numkeys) < len(args):
+			for j := 0; j < numkeys; j++ {
+				zargs.weights[j], err = parseScore(args[i+1+j])
+				if err != nil {
+					return nil, false, errors.New("weight value is not a float")
+				}
+			}
+			i = i + numkeys
+		case opt == "AGGREGATE" && op != zsetOpDiff && cmdName != "ZINTERCARD" && (i + 1) < len(args):
+			switch strings.ToUpper(args[i+1]) {
+			case "SUM":
+				zargs.aggregate = zaggregateSum
+			case "MIN":
+				zargs.aggregate = zaggregateMin
+			case "MAX":
+				zargs.aggregate = zaggregateMax
+			default:
+				return nil, false, errors.New("syntax error")
+			}
+			i = i + 1
+		case opt == "WITHSCORES" && store == false && cmdName != "ZINTERCARD":
+			withScores = true
+		case opt == "LIMIT" && cmdName == "ZINTERCARD" && (i + 1) < len(args):
+			zargs.limit, err = strconv.Atoi(args[i+1])
+			if err != nil || zargs.limit < 0 {
+				return nil, false, errors.New("LIMIT can't be negative")
+			}
+			i = i + 1
+		default:
+			return nil, false, errors.New("syntax error")
+		}
+	}
+
+	return zargs, withScores, nil
+}
+
+
+/* Aggregate val into target, NaN from inf - inf is taken as 0 like redis */
+func zaggregate(target float64, val float64, aggregate int) float64 {
+	switch aggregate {
+	case zaggregateMin:
+		if val < target {
+			return val
+		}
+		return target
+	case zaggregateMax:
+		if val > target {
+			return val
+		}
+		return target
+	}
+
+	target = target + val
+	if math.IsNaN(target) {
+		return 0
+	}
+	return target
+}
+
+
+/* Weighted score, NaN from inf * 0 is taken as 0 like redis */
+func zweight(score float64, weight float64) float64 {
+	val := score * weight
+	if math.IsNaN(val) {
+		return 0
+	}
+	return val
+}
+
+
+/*
+  Compute union, inter or diff of the sets, nil set is a missing key
+  Caller holds the read lock of every set, result is ordered by score then member
+  limit stops an inter after limit members, 0 is unlimited
+*/
+func zsetOperation(args *zsetOpArgs, sets []*setmapData) []zsetItem {
+	result := make(map[string]float64)
+
+	switch args.op {
+	case zsetOpUnion:
+		for i, set := range sets {
+			if set == nil {
+				continue
+			}
+
+			for x := set.zsl.first(); x != nil; x = x.level[0].forward {
+				score := zweight(x.score, args.weights[i])
+				if cur, found := result[x.member]; found == true {
+					result[x.member] = zaggregate(cur, score, args.aggregate)
+				} else {
+					result[x.member] = score
+				}
+			}
+		}
+
+	case zsetOpInter:
+		/* Iterate the smallest set and lookup the member in all the others */
+		smallest := -1
+		for i, set := range sets {
+			if set == nil {
+				return []zsetItem{}
+			}
+
+			if smallest == -1 || set.zsl.length < sets[smallest].zsl.length {
+				smallest = i
+			}
+		}
+
+		for x := sets[smallest].zsl.first(); x != nil; x = x.level[0].forward {
+			var score float64
+			var found bool = true
+
+			for i, set := range sets {
+				var memberScore float64
+				memberScore, found = set.dict[x.member]
+				if found == false {
+					break
+				}
+
+				if i == 0 {
+					score = zweight(memberScore, args.weights[i])
+				} else {
+					score = zaggregate(score, zweight(memberScore, args.weights[i]), args.aggregate)
+				}
+			}
+
+			if found == true {
+				result[x.member] = score
+				if args.limit > 0 && len(result) >= args.limit {
+					break
+				}
+			}
+		}
+
+	case zsetOpDiff:
+		if sets[0] == nil {
+			return []zsetItem{}
+		}
+
+		for x := sets[0].zsl.first(); x != nil; x = x.level[0].forward {
+			var found bool = false
+
+			for _, set := range sets[1:] {
+				if set == nil {
+					continue
+				}
+				if _, found = set.dict[x.member]; found == true {
+					break
+				}
+			}
+
+			if found == false {
+				result[x.member] = x.score
+			}
+		}
+	}
+
+	items := make([]zsetItem, 0, len(result))
+	for member, score := range result {
+		items = append(items, zsetItem{member: member, score: score})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].score < items[j].score || (items[i].score == items[j].score && items[i].member < items[j].member)
+	})
+
+	return items
+}
+
+
+/* Parse numkeys key [key ...] MIN|MAX [COUNT count] of ZMPOP and BZMPOP */
+func parseZmpopArgs(args []string) ([]string, bool, int, error) {
+	var max bool
+	var count int = 1
+
+	numkeys, err := strconv.Atoi(args[0])
+	if err != nil || numkeys < 1 {
+		return nil, false, 0, errors.New("numkeys should be greater than 0")
+	}
+
+	if numkeys + 2 > len(args) {
+		return nil, false, 0, errors.New("syntax error")
+	}
+
+	keys := args[1 : numkeys+1]
+
+	switch strings.ToUpper(args[numkeys+1]) {
+	case "MIN":
+		max = false
+	case "MAX":
+		max = true
+	default:
+		return nil, false, 0, errors.New("syntax error")
+	}
+
+	rest := args[numkeys+2:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(rest[0]) != "COUNT" {
+			return nil, false, 0, errors.New("syntax error")
+		}
+
+		count, err = strconv.Atoi(rest[1])
+		if err != nil || count < 1 {
+			return nil, false, 0, errors.New("count should be greater than 0")
+		}
+	}
+
+	return keys, max, count, nil
+}
+
+
+/* Reply lines of the pop commands, key followed by member score pairs */
+func zpopReply(key string, items []zsetItem) []string {
+	vals := []string{key}
+
+	for _, item := range items {
+		vals = append(vals, item.member, formatScore(item.score))
+	}
+
+	return vals
+}
@@ -0,0 +1,664 @@
+/*
+	Copyright 2016 Deepak Agarwal
+	Author : Deepak Agarwal
+*/
+
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+
+/* Sorted set items of score member pairs */
+func zitems(t *testing.T, pairs ...string) []zsetItem {
+	t.Helper()
+
+	items := []zsetItem{}
+	for i := 0; i + 1 < len(pairs); i = i + 2 {
+		score, err := parseScore(pairs[i])
+		must(t, err)
+		items = append(items, zsetItem{member: pairs[i+1], score: score})
+	}
+	return items
+}
+
+
+/* Items as member:score, comma separated */
+func zformat(items []zsetItem) string {
+	vals := []string{}
+	for _, item := range items {
+		vals = append(vals, item.member + ":" + formatScore(item.score))
+	}
+	return strings.Join(vals, ",")
+}
+
+
+/* Fail unless the range command on key with args returns want */
+func checkZrange(t *testing.T, store *db, cmdName string, rangeType int, rev bool, key string, args string, want string) {
+	t.Helper()
+
+	zargs, _, err := parseZrangeArgs(cmdName, strings.Fields(args), rangeType, rev)
+	if err != nil {
+		t.Fatalf("%v %v: %v", cmdName, args, err)
+	}
+
+	items, _ := store.ZRANGE(key, zargs)
+	if got := zformat(items); got != want {
+		t.Fatalf("%v %v is %v, want %v", cmdName, args, got, want)
+	}
+}
+
+
+/* Adds to an existing key only hold the global read lock, run with -race */
+func TestZaddConcurrent(t *testing.T) {
+	store := newDB()
+	store.ZADD("z", []zsetItem{{member: "first", score: 0}}, 0)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				store.ZADD("z", []zsetItem{{member: strconv.Itoa(g * 1000 + i), score: float64(i)}}, 0)
+				store.ZCARD("z")
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if n, _ := store.ZCARD("z"); n != 1601 {
+		t.Fatalf("ZCARD %v after concurrent adds", n)
+	}
+}
+
+
+func TestZsetParseScore(t *testing.T) {
+	for _, arg := range []string{"inf", "+inf", "-INF", "1e308", "-0.5", "3"} {
+		if _, err := parseScore(arg); err != nil {
+			t.Fatalf("parseScore rejected %q", arg)
+		}
+	}
+	for _, arg := range []string{"nan", "1e400", "abc", ""} {
+		if _, err := parseScore(arg); err == nil {
+			t.Fatalf("parseScore accepted %q", arg)
+		}
+	}
+
+	for _, score := range []float64{math.Inf(1), math.Inf(-1), 0.1, 1e21, -3, 1.0 / 3} {
+		back, err := parseScore(formatScore(score))
+		if err != nil || back != score {
+			t.Fatalf("formatScore %v parses back to %v %v", score, back, err)
+		}
+	}
+}
+
+
+/* Infinite scores order before and after every finite one, same scores by member */
+func TestZsetInfScores(t *testing.T) {
+	store := newDB()
+
+	store.ZADD("z", zitems(t, "+inf", "top", "0", "b", "-inf", "bottom", "0", "a", "1e308", "big"), 0)
+
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "0 -1", "bottom:-inf,a:0,b:0,big:1e+308,top:inf")
+
+	spec, _ := parseRangeSpec("-inf", "+inf")
+	if n, _ := store.ZCOUNT("z", spec); n != 5 {
+		t.Fatalf("ZCOUNT -inf +inf %v", n)
+	}
+	spec, _ = parseRangeSpec("(-inf", "(+inf")
+	if n, _ := store.ZCOUNT("z", spec); n != 3 {
+		t.Fatalf("ZCOUNT (-inf (+inf %v", n)
+	}
+}
+
+
+func TestZsetSaveLoad(t *testing.T) {
+	store := newDB()
+	store.ZADD("z", zitems(t, "1.5", "a", "-2", "b", "inf", "c"), 0)
+
+	loaded := saveLoad(t, store)
+
+	checkZrange(t, loaded, "ZRANGE", zrangeRank, false, "z", "0 -1", "b:-2,a:1.5,c:inf")
+	if scores, found, _ := loaded.ZMSCORE("z", []string{"a", "c"}); found[0] == false || scores[0] != 1.5 || scores[1] != math.Inf(1) {
+		t.Fatalf("ZMSCORE after load %v %v", scores, found)
+	}
+}
+
+
+func TestZrange(t *testing.T) {
+	store := newDB()
+	store.ZADD("z", zitems(t, "1", "a", "2", "b", "3", "c", "4", "d", "5", "e"), 0)
+
+	/* Index ranges, negative indexes count from the end, out of range stops are clamped */
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "0 1", "a:1,b:2")
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "-2 -1", "d:4,e:5")
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "3 100", "d:4,e:5")
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "-100 0", "a:1")
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "3 1", "")
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "5 10", "")
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "0 1 REV", "e:5,d:4")
+	checkZrange(t, store, "ZREVRANGE", zrangeRank, true, "z", "0 1", "e:5,d:4")
+
+	/* BYSCORE and BYLEX take max min with REV, LIMIT offset count */
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "2 4 BYSCORE", "b:2,c:3,d:4")
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "4 2 BYSCORE REV", "d:4,c:3,b:2")
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "-inf +inf BYSCORE LIMIT 1 2", "b:2,c:3")
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "-inf +inf BYSCORE LIMIT 3 -1", "d:4,e:5")
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "+inf -inf BYSCORE REV LIMIT 0 1", "e:5")
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "- + BYLEX", "a:1,b:2,c:3,d:4,e:5")
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "[d (b BYLEX REV", "d:4,c:3")
+
+	for _, args := range []string{"0 1 LIMIT 0 1", "a b BYLEX", "- + BYLEX WITHSCORES", "0 1 BYSCORE BYLEX", "0 x", "0 1 NOPE"} {
+		if _, _, err := parseZrangeArgs("ZRANGE", strings.Fields(args), zrangeRank, false); err == nil {
+			t.Fatalf("ZRANGE accepted %v", args)
+		}
+	}
+	if _, _, err := parseZrangeArgs("ZREVRANGE", strings.Fields("0 1 BYSCORE"), zrangeRank, true); err == nil {
+		t.Fatalf("ZREVRANGE accepted BYSCORE")
+	}
+}
+
+
+func TestZrangestore(t *testing.T) {
+	store := newDB()
+	store.ZADD("z", zitems(t, "1", "a", "2", "b", "3", "c"), 0)
+	store.ZADD("dst", zitems(t, "9", "old"), 0)
+
+	zargs, _, _ := parseZrangeArgs("ZRANGESTORE", strings.Fields("2 +inf BYSCORE"), zrangeRank, false)
+	if n, _ := store.ZRANGESTORE("dst", "z", zargs); n != 2 {
+		t.Fatalf("ZRANGESTORE stored %v", n)
+	}
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "dst", "0 -1", "b:2,c:3")
+
+	/* An empty range deletes dst */
+	zargs, _, _ = parseZrangeArgs("ZRANGESTORE", strings.Fields("10 20 BYSCORE"), zrangeRank, false)
+	store.ZRANGESTORE("dst", "z", zargs)
+	if _, ok := store.setmapEntry["dst"]; ok == true {
+		t.Fatalf("empty ZRANGESTORE kept dst")
+	}
+}
+
+
+/* Exclusive bounds with '(' for ZCOUNT, ZRANGEBYSCORE and ZREVRANGEBYSCORE */
+func TestZsetScoreRange(t *testing.T) {
+	store := newDB()
+	store.ZADD("z", zitems(t, "1", "a", "2", "b", "2", "c", "3", "d"), 0)
+
+	counts := map[string]int{"1 3": 4, "(1 3": 3, "1 (3": 3, "(1 (3": 2, "(2 (2": 0, "2 2": 2, "3 1": 0, "-inf (2": 1}
+	for args, want := range counts {
+		f := strings.Fields(args)
+		spec, err := parseRangeSpec(f[0], f[1])
+		must(t, err)
+		if n, _ := store.ZCOUNT("z", spec); n != want {
+			t.Fatalf("ZCOUNT %v is %v, want %v", args, n, want)
+		}
+	}
+
+	checkZrange(t, store, "ZRANGEBYSCORE", zrangeScore, false, "z", "(1 3", "b:2,c:2,d:3")
+	checkZrange(t, store, "ZRANGEBYSCORE", zrangeScore, false, "z", "(1 (3", "b:2,c:2")
+	checkZrange(t, store, "ZRANGEBYSCORE", zrangeScore, false, "z", "-inf +inf LIMIT 1 2", "b:2,c:2")
+	checkZrange(t, store, "ZREVRANGEBYSCORE", zrangeScore, true, "z", "(3 1", "c:2,b:2,a:1")
+	checkZrange(t, store, "ZREVRANGEBYSCORE", zrangeScore, true, "z", "+inf (2", "d:3")
+
+	for _, bound := range []string{"(", "((1", "x", "(nan"} {
+		if _, err := parseRangeSpec(bound, "1"); err == nil {
+			t.Fatalf("parseRangeSpec accepted %q", bound)
+		}
+	}
+}
+
+
+/* Remove with ZREMRANGEBY{RANK,SCORE,LEX} as the commands parse their arguments */
+func zremrange(t *testing.T, store *db, cmdName string, rangeType int, key string, args string) int {
+	t.Helper()
+
+	zargs, _, err := parseZrangeArgs(cmdName, strings.Fields(args), rangeType, false)
+	must(t, err)
+
+	removed, _ := store.ZREMRANGE(key, zargs)
+	return removed
+}
+
+
+func TestZrem(t *testing.T) {
+	store := newDB()
+	store.ZADD("z", zitems(t, "1", "a", "2", "b", "3", "c", "4", "d", "5", "e", "6", "f", "7", "g"), 0)
+
+	if n, _ := store.ZREM("z", []string{"a", "missing", "a"}); n != 1 {
+		t.Fatalf("ZREM removed %v", n)
+	}
+	if n := zremrange(t, store, "ZREMRANGEBYSCORE", zrangeScore, "z", "(2 3"); n != 1 {
+		t.Fatalf("ZREMRANGEBYSCORE removed %v", n)
+	}
+	if n := zremrange(t, store, "ZREMRANGEBYRANK", zrangeRank, "z", "-2 -1"); n != 2 {
+		t.Fatalf("ZREMRANGEBYRANK removed %v", n)
+	}
+	if n := zremrange(t, store, "ZREMRANGEBYLEX", zrangeLex, "z", "(b [d"); n != 1 {
+		t.Fatalf("ZREMRANGEBYLEX removed %v", n)
+	}
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "0 -1", "b:2,e:5")
+
+	/* The removed members are gone from the lookups as well */
+	if _, found, _ := store.ZMSCORE("z", []string{"a", "c", "d", "f"}); found[0] || found[1] || found[2] || found[3] {
+		t.Fatalf("removed member still found %v", found)
+	}
+
+	if n := zremrange(t, store, "ZREMRANGEBYRANK", zrangeRank, "z", "5 10"); n != 0 {
+		t.Fatalf("ZREMRANGEBYRANK out of range removed %v", n)
+	}
+
+	/* Sorted set emptied by a remove is deleted */
+	zremrange(t, store, "ZREMRANGEBYSCORE", zrangeScore, "z", "-inf +inf")
+	if _, ok := store.setmapEntry["z"]; ok == true {
+		t.Fatalf("empty sorted set not deleted")
+	}
+
+	store.ZADD("z", zitems(t, "1", "a"), 0)
+	store.ZREM("z", []string{"a"})
+	if _, ok := store.setmapEntry["z"]; ok == true {
+		t.Fatalf("empty sorted set not deleted by ZREM")
+	}
+}
+
+
+func TestZsetLookups(t *testing.T) {
+	store := newDB()
+	store.ZADD("z", zitems(t, "1", "a", "2", "b", "2", "c", "-inf", "d"), 0)
+
+	scores, found, err := store.ZMSCORE("z", []string{"a", "missing", "d"})
+	if err != nil || found[0] == false || found[1] == true || found[2] == false || scores[0] != 1 || scores[2] != math.Inf(-1) {
+		t.Fatalf("ZMSCORE %v %v %v", scores, found, err)
+	}
+
+	/* Same scores rank by member */
+	ranks := map[string][2]int{"d": {0, 3}, "a": {1, 2}, "b": {2, 1}, "c": {3, 0}}
+	for member, want := range ranks {
+		rank, _, _ := store.ZRANK("z", member, false)
+		revRank, _, _ := store.ZRANK("z", member, true)
+		if rank != want[0] || revRank != want[1] {
+			t.Fatalf("ZRANK %v is %v %v, want %v", member, rank, revRank, want)
+		}
+	}
+
+	/* WITHSCORE reply */
+	if _, score, _ := store.ZRANK("z", "c", true); score != 2 {
+		t.Fatalf("ZREVRANK score %v", score)
+	}
+
+	if _, _, err := store.ZRANK("z", "missing", false); err == nil {
+		t.Fatalf("ZRANK of missing member")
+	}
+	if _, _, err := store.ZRANK("nokey", "a", false); err == nil {
+		t.Fatalf("ZRANK of missing key")
+	}
+	if _, found, _ := store.ZMSCORE("nokey", []string{"a"}); len(found) != 0 && found[0] == true {
+		t.Fatalf("ZMSCORE of missing key found %v", found)
+	}
+}
+
+
+func TestZaddFlags(t *testing.T) {
+	store := newDB()
+	store.ZADD("z", zitems(t, "1", "a", "5", "b"), 0)
+
+	/* NX adds new members only, XX updates existing ones only */
+	if n, _, _ := store.ZADD("z", zitems(t, "9", "a", "3", "c"), zaddNX); n != 1 {
+		t.Fatalf("ZADD NX added %v", n)
+	}
+	if n, _, _ := store.ZADD("z", zitems(t, "2", "a", "4", "d"), zaddXX); n != 0 {
+		t.Fatalf("ZADD XX added %v", n)
+	}
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "0 -1", "a:2,c:3,b:5")
+
+	/* GT and LT update in one direction only, new members are still added */
+	store.ZADD("z", zitems(t, "1", "a", "6", "b", "0", "e"), zaddGT)
+	store.ZADD("z", zitems(t, "4", "c", "1", "a"), zaddLT)
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z", "0 -1", "e:0,a:1,c:3,b:6")
+
+	/* CH counts the updated members with the added ones, same score is not an update */
+	if n, _, _ := store.ZADD("z", zitems(t, "6", "b", "7", "c", "8", "f"), zaddCH); n != 2 {
+		t.Fatalf("ZADD CH returned %v", n)
+	}
+
+	/* INCR replies with the new score, a skipped increment processes nothing */
+	if n, score, _ := store.ZADD("z", zitems(t, "2.5", "a"), zaddINCR); n != 1 || score != 3.5 {
+		t.Fatalf("ZADD INCR %v %v", n, score)
+	}
+	if n, _, _ := store.ZADD("z", zitems(t, "-1", "a"), zaddINCR | zaddGT); n != 0 {
+		t.Fatalf("ZADD INCR GT lowered the score")
+	}
+	if n, score, _ := store.ZADD("z", zitems(t, "5", "new"), zaddINCR); n != 1 || score != 5 {
+		t.Fatalf("ZINCRBY of a new member %v %v", n, score)
+	}
+
+	/* inf + -inf is NaN, the score is left as it was */
+	store.ZADD("z", zitems(t, "inf", "x"), 0)
+	if _, _, err := store.ZADD("z", zitems(t, "-inf", "x"), zaddINCR); err == nil {
+		t.Fatalf("ZINCRBY to NaN accepted")
+	}
+	if scores, _, _ := store.ZMSCORE("z", []string{"x"}); scores[0] != math.Inf(1) {
+		t.Fatalf("score after NaN increment %v", scores[0])
+	}
+
+	/* XX never creates the key, a new key is created only with members */
+	store.ZADD("none", zitems(t, "1", "a"), zaddXX)
+	if _, ok := store.setmapEntry["none"]; ok == true {
+		t.Fatalf("ZADD XX created the key")
+	}
+}
+
+
+func TestZaddFlagsCheck(t *testing.T) {
+	for _, flags := range []int{zaddNX | zaddXX, zaddNX | zaddGT, zaddNX | zaddLT, zaddGT | zaddLT} {
+		if checkZaddFlags(flags, 1) == nil {
+			t.Fatalf("flags %b accepted", flags)
+		}
+	}
+	if checkZaddFlags(zaddINCR, 2) == nil {
+		t.Fatalf("INCR with 2 pairs accepted")
+	}
+	if checkZaddFlags(zaddXX | zaddGT | zaddCH | zaddINCR, 1) != nil {
+		t.Fatalf("XX GT CH INCR rejected")
+	}
+}
+
+
+/* Run a set operation command on args as the command parses them, returns the result */
+func zsetop(t *testing.T, store *db, cmdName string, op int, args string) string {
+	t.Helper()
+
+	zargs, _, err := parseZsetOpArgs(cmdName, op, strings.Fields(args))
+	if err != nil {
+		t.Fatalf("%v %v: %v", cmdName, args, err)
+	}
+
+	items, _ := store.ZSETOP(zargs)
+	return zformat(items)
+}
+
+
+func TestZsetOperations(t *testing.T) {
+	store := newDB()
+	store.ZADD("z1", zitems(t, "1", "a", "2", "b", "3", "c"), 0)
+	store.ZADD("z2", zitems(t, "10", "b", "20", "c", "30", "d"), 0)
+
+	cases := []struct {
+		cmdName string
+		op int
+		args string
+		want string
+	}{
+		{"ZUNION", zsetOpUnion, "2 z1 z2", "a:1,b:12,c:23,d:30"},
+		{"ZUNION", zsetOpUnion, "2 z1 z2 WEIGHTS 2 0.5", "a:2,b:9,d:15,c:16"},
+		{"ZUNION", zsetOpUnion, "2 z1 z2 AGGREGATE MIN", "a:1,b:2,c:3,d:30"},
+		{"ZUNION", zsetOpUnion, "2 z1 z2 AGGREGATE MAX", "a:1,b:10,c:20,d:30"},
+		{"ZUNION", zsetOpUnion, "3 z1 missing z2", "a:1,b:12,c:23,d:30"},
+		{"ZINTER", zsetOpInter, "2 z1 z2", "b:12,c:23"},
+		{"ZINTER", zsetOpInter, "2 z1 z2 WEIGHTS 1 -1 AGGREGATE SUM", "c:-17,b:-8"},
+		{"ZINTER", zsetOpInter, "2 z1 missing", ""},
+		{"ZDIFF", zsetOpDiff, "2 z1 z2", "a:1"},
+		{"ZDIFF", zsetOpDiff, "2 z2 z1", "d:30"},
+	}
+	for _, c := range cases {
+		if got := zsetop(t, store, c.cmdName, c.op, c.args); got != c.want {
+			t.Fatalf("%v %v is %v, want %v", c.cmdName, c.args, got, c.want)
+		}
+	}
+
+	/* inf * 0 and inf - inf are taken as 0 */
+	store.ZADD("inf", zitems(t, "inf", "a"), 0)
+	store.ZADD("ninf", zitems(t, "-inf", "a"), 0)
+	if got := zsetop(t, store, "ZUNION", zsetOpUnion, "1 inf WEIGHTS 0"); got != "a:0" {
+		t.Fatalf("inf * 0 is %v", got)
+	}
+	if got := zsetop(t, store, "ZUNION", zsetOpUnion, "2 inf ninf"); got != "a:0" {
+		t.Fatalf("inf + -inf is %v", got)
+	}
+
+	for _, args := range []string{"0 z1", "3 z1 z2", "x z1", "2 z1 z2 WEIGHTS 1", "2 z1 z2 AGGREGATE AVG", "1 z1 LIMIT 1"} {
+		if _, _, err := parseZsetOpArgs("ZUNION", zsetOpUnion, strings.Fields(args)); err == nil {
+			t.Fatalf("ZUNION accepted %v", args)
+		}
+	}
+	if _, _, err := parseZsetOpArgs("ZDIFF", zsetOpDiff, strings.Fields("2 z1 z2 WEIGHTS 1 1")); err == nil {
+		t.Fatalf("ZDIFF accepted WEIGHTS")
+	}
+}
+
+
+func TestZsetOperationStore(t *testing.T) {
+	store := newDB()
+	store.ZADD("z1", zitems(t, "1", "a", "2", "b"), 0)
+	store.ZADD("z2", zitems(t, "3", "b", "4", "c"), 0)
+
+	/* dst may be one of the sources */
+	zargs, _, _ := parseZsetOpArgs("ZINTERSTORE", zsetOpInter, strings.Fields("2 z1 z2 AGGREGATE MAX"))
+	if n, _ := store.ZSETOPSTORE("z1", zargs); n != 1 {
+		t.Fatalf("ZINTERSTORE stored %v", n)
+	}
+	checkZrange(t, store, "ZRANGE", zrangeRank, false, "z1", "0 -1", "b:3")
+
+	zargs, _, _ = parseZsetOpArgs("ZDIFFSTORE", zsetOpDiff, strings.Fields("2 z1 z2"))
+	if n, _ := store.ZSETOPSTORE("z1", zargs); n != 0 {
+		t.Fatalf("empty ZDIFFSTORE stored %v", n)
+	}
+	if _, ok := store.setmapEntry["z1"]; ok == true {
+		t.Fatalf("empty ZDIFFSTORE kept dst")
+	}
+
+	/* ZINTERCARD stops at LIMIT */
+	store.ZADD("z3", zitems(t, "1", "b", "1", "c"), 0)
+	zargs, _, _ = parseZsetOpArgs("ZINTERCARD", zsetOpInter, strings.Fields("2 z2 z3 LIMIT 1"))
+	if items, _ := store.ZSETOP(zargs); len(items) != 1 {
+		t.Fatalf("ZINTERCARD LIMIT 1 counted %v", len(items))
+	}
+}
+
+
+/* Lex ranges on members of the same score, '[' inclusive, '(' exclusive, - and + unbounded */
+func TestZsetLexRange(t *testing.T) {
+	store := newDB()
+	store.ZADD("z", zitems(t, "0", "a", "0", "b", "0", "c", "0", "d", "0", "e"), 0)
+
+	checkZrange(t, store, "ZRANGEBYLEX", zrangeLex, false, "z", "- +", "a:0,b:0,c:0,d:0,e:0")
+	checkZrange(t, store, "ZRANGEBYLEX", zrangeLex, false, "z", "[b (d", "b:0,c:0")
+	checkZrange(t, store, "ZRANGEBYLEX", zrangeLex, false, "z", "(b +", "c:0,d:0,e:0")
+	checkZrange(t, store, "ZRANGEBYLEX", zrangeLex, false, "z", "- + LIMIT 1 2", "b:0,c:0")
+	checkZrange(t, store, "ZRANGEBYLEX", zrangeLex, false, "z", "[d [b", "")
+	checkZrange(t, store, "ZREVRANGEBYLEX", zrangeLex, true, "z", "+ (c", "e:0,d:0")
+	checkZrange(t, store, "ZREVRANGEBYLEX", zrangeLex, true, "z", "[c - LIMIT 0 2", "c:0,b:0")
+
+	counts := map[string]int{"- +": 5, "[b [d": 3, "(b (d": 1, "(a [a": 0, "+ -": 0, "[bb +": 3}
+	for args, want := range counts {
+		f := strings.Fields(args)
+		spec, err := parseLexRangeSpec(f[0], f[1])
+		must(t, err)
+		if n, _ := store.ZLEXCOUNT("z", spec); n != want {
+			t.Fatalf("ZLEXCOUNT %v is %v, want %v", args, n, want)
+		}
+	}
+
+	for _, bound := range []string{"a", "", "*"} {
+		if _, err := parseLexRangeSpec(bound, "+"); err == nil {
+			t.Fatalf("parseLexRangeSpec accepted %q", bound)
+		}
+	}
+	if _, _, err := parseZrangeArgs("ZRANGEBYLEX", strings.Fields("- + WITHSCORES"), zrangeLex, false); err == nil {
+		t.Fatalf("ZRANGEBYLEX accepted WITHSCORES")
+	}
+}
+
+
+func TestZpop(t *testing.T) {
+	store := newDB()
+	store.ZADD("z", zitems(t, "1", "a", "2", "b", "3", "c", "4", "d"), 0)
+
+	if items, _ := store.ZPOP("z", 2, false); zformat(items) != "a:1,b:2" {
+		t.Fatalf("ZPOPMIN 2 %v", zformat(items))
+	}
+	if items, _ := store.ZPOP("z", 1, true); zformat(items) != "d:4" {
+		t.Fatalf("ZPOPMAX %v", zformat(items))
+	}
+	if items, _ := store.ZPOP("z", 10, false); zformat(items) != "c:3" {
+		t.Fatalf("ZPOPMIN past the size %v", zformat(items))
+	}
+	if _, ok := store.setmapEntry["z"]; ok == true {
+		t.Fatalf("popped sorted set not deleted")
+	}
+
+	/* ZMPOP pops from the first non empty key */
+	store.ZADD("z2", zitems(t, "1", "x", "2", "y"), 0)
+	if key, items, err := store.ZMPOP([]string{"z", "z2"}, 5, true); err != nil || key != "z2" || zformat(items) != "y:2,x:1" {
+		t.Fatalf("ZMPOP %v %v %v", key, zformat(items), err)
+	}
+	if _, _, err := store.ZMPOP([]string{"z", "z2"}, 1, false); err == nil {
+		t.Fatalf("ZMPOP of empty keys")
+	}
+
+	keys, max, count, err := parseZmpopArgs(strings.Fields("2 a b MAX COUNT 3"))
+	if err != nil || len(keys) != 2 || max == false || count != 3 {
+		t.Fatalf("parseZmpopArgs %v %v %v %v", keys, max, count, err)
+	}
+	for _, args := range []string{"0 a MIN", "2 a MIN", "1 a AVG", "1 a MIN COUNT 0", "1 a MIN COUNT"} {
+		if _, _, _, err := parseZmpopArgs(strings.Fields(args)); err == nil {
+			t.Fatalf("parseZmpopArgs accepted %v", args)
+		}
+	}
+}
+
+
+/* Block on keys as BZPOPMIN does */
+func blockZpop(t *testing.T, store *db, keys []string) *blockedClient {
+	t.Helper()
+
+	serve := func() ([]string, bool) {
+		key, items, err := store.ZMPOP(keys, 1, false)
+		if err != nil {
+			return nil, false
+		}
+		return zpopReply(key, items), true
+	}
+
+	w, _, ok := store.blockOn(blockZset, keys, serve)
+	if ok == true {
+		t.Fatalf("BZPOPMIN on %v served without members", keys)
+	}
+	return w
+}
+
+
+/* Clients blocked on a sorted set are served by ZADD in FIFO order, one member each */
+func TestBZpop(t *testing.T) {
+	store := newDB()
+
+	first := blockZpop(t, store, []string{"z", "other"})
+	second := blockZpop(t, store, []string{"z"})
+	third := blockZpop(t, store, []string{"z"})
+
+	store.ZADD("z", zitems(t, "2", "b", "1", "a"), 0)
+
+	for i, w := range []*blockedClient{first, second} {
+		select {
+		case reply := <-w.reply:
+			if strings.Join(reply, ",") != []string{"z,a,1", "z,b,2"}[i] {
+				t.Fatalf("BZPOPMIN waiter %v reply %v", i, reply)
+			}
+		default:
+			t.Fatalf("BZPOPMIN waiter %v not served", i)
+		}
+	}
+
+	/* Nothing left for the third, a timeout removes it from the queue */
+	if reply, ok := store.unblock(third); ok == true {
+		t.Fatalf("BZPOPMIN of empty set served %v", reply)
+	}
+	if len(store.blockedEntry) != 0 {
+		t.Fatalf("blocked clients left queued %v", store.blockedEntry)
+	}
+	if _, ok := store.setmapEntry["z"]; ok == true {
+		t.Fatalf("popped sorted set not deleted")
+	}
+}
+
+
+/* A full scan returns every member once in score order */
+func TestZscan(t *testing.T) {
+	store := newDB()
+	items := []zsetItem{}
+	for i := 0; i < 100; i++ {
+		items = append(items, zsetItem{member: "m" + strconv.Itoa(i), score: float64(i)})
+	}
+	store.ZADD("z", items, 0)
+
+	got := []zsetItem{}
+	cursor := 0
+	for {
+		var page []zsetItem
+		cursor, page, _ = store.ZSCAN("z", cursor, "", 7)
+		got = append(got, page...)
+		if cursor == 0 {
+			break
+		}
+	}
+	if zformat(got) != zformat(items) {
+		t.Fatalf("ZSCAN returned %v members", len(got))
+	}
+
+	if _, page, _ := store.ZSCAN("z", 0, "m1?", 1000); len(page) != 10 {
+		t.Fatalf("ZSCAN MATCH m1? returned %v", zformat(page))
+	}
+}
+
+
+func TestZrandmember(t *testing.T) {
+	store := newDB()
+	items := []zsetItem{}
+	for i := 0; i < 100; i++ {
+		items = append(items, zsetItem{member: "m" + strconv.Itoa(i), score: float64(i)})
+	}
+	store.ZADD("z", items, 0)
+	scores := store.setmapEntry["z"].dict
+
+	/* Random ranks, ranks to leave out, the whole set */
+	for _, count := range []int{5, 50, 100, 200} {
+		got, _ := store.ZRANDMEMBER("z", count)
+
+		seen := make(map[string]bool)
+		for _, item := range got {
+			if seen[item.member] == true || scores[item.member] != item.score {
+				t.Fatalf("ZRANDMEMBER %v returned %v twice or with a wrong score", count, item)
+			}
+			seen[item.member] = true
+		}
+		if (count <= 100 && len(got) != count) || (count > 100 && len(got) != 100) {
+			t.Fatalf("ZRANDMEMBER %v returned %v members", count, len(got))
+		}
+	}
+
+	if got, _ := store.ZRANDMEMBER("z", -300); len(got) != 300 {
+		t.Fatalf("ZRANDMEMBER -300 returned %v members", len(got))
+	}
+}
+
+
+func TestZrandmemberOfEmptiedSet(t *testing.T) {
+	store := newDB()
+	store.ZADD("z", zitems(t, "1", "a"), 0)
+
+	/* Emptied but still in the map, as between a pop and the delete of the key */
+	entry := store.setmapEntry["z"]
+	entry.zsl.delete(1, "a")
+	delete(entry.dict, "a")
+
+	for _, count := range []int{-3, 3} {
+		if got, err := store.ZRANDMEMBER("z", count); err != nil || len(got) != 0 {
+			t.Fatalf("ZRANDMEMBER %v of empty set %v %v", count, got, err)
+		}
+	}
+}